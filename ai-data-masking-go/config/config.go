@@ -2,12 +2,19 @@ package config
 
 import (
 	"regexp"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/higress-group/wasm-go/pkg/wrapper"
 )
 
 const (
 	DEFAULT_MAX_BODY_BYTES         uint32 = 100 * 1024 * 1024
 	DefaultMaxBufferChunkCount     uint32 = 30
 	DefaultMaxStreamChunkBufferLen uint32 = 2048
+	DefaultRawPartMaxBytes         int    = 1024 * 1024
+	DefaultStreamHoldbackMaxDelay  uint32 = 1000
 )
 
 const (
@@ -19,11 +26,10 @@ const (
 	FINISH_REASON_STOP = "stop"
 )
 
-var MaxSensitiveWordLength int = 0 // 最长敏感词长度（字节数），在配置解析时计算
-
 // AiDataMaskingConfig 插件配置
 type AiDataMaskingConfig struct {
 	DenyOpenAI              bool             `json:"deny_openai"`
+	DenyAnthropic           bool             `json:"deny_anthropic"` // 按 Anthropic Messages 协议处理 /v1/messages 请求
 	DenyRaw                 bool             `json:"deny_raw"`
 	DenyJSONPath            []string         `json:"deny_jsonpath"`
 	SystemDeny              bool             `json:"system_deny"`
@@ -32,24 +38,205 @@ type AiDataMaskingConfig struct {
 	DenyRawMessage          string           `json:"deny_raw_message"`
 	DenyContentType         string           `json:"deny_content_type"`
 	DenyWords               []string         `json:"deny_words"`         // 敏感词列表
-	ResponseDenyPlot        ResponseDenyPlot `json:"response_deny_plot"` // 响应拒绝处理方式
+	DenyWordEntries         []DenyWordEntry  `json:"deny_word_entries"`  // 敏感词字典项，与 DenyWords 下标一致
+	AllowWords              []string         `json:"allow_words"`        // 白名单词列表，被白名单完整覆盖的敏感词命中将被忽略
+	DenyPatterns            []string         `json:"deny_patterns"`      // 拦截正则列表（支持 GROK），命中后与敏感词一样拦截
+	AllowPatterns           []string         `json:"allow_patterns"`     // 白名单正则列表
+	ResponseDenyPlot        DenyPlot         `json:"response_deny_plot"` // 响应拒绝处理方式
+	RequestDenyPlot         DenyPlot         `json:"request_deny_plot"`  // 请求命中拦截词时的处理方式，replace 时替换后转发
 	ReplaceRoles            []Rule           `json:"replace_roles"`
 	StreamBuffer            uint32           `json:"stream_buffer"`
 	MaxBufferChunkCount     uint32           `json:"max_buffer_chunk_count"`      // 最长敏感词检测chunk个数
 	MaxStreamChunkBufferLen uint32           `json:"max_stream_chunk_buffer_len"` // 最长敏感词检测chunk大小
+	MaxSensitiveWordLength  int              `json:"-"`                           // 自定义敏感词和拦截正则的重叠边界长度（字节数），在配置解析时计算
+	Normalize               NormalizeConfig  `json:"normalize"`                   // 敏感词匹配前的文本归一化
+	SystemDenySource        SystemDenySource `json:"system_deny_source"`          // 系统敏感词库远程来源
+	// 自定义 GROK 规则，同名时覆盖内置规则
+	GrokPatterns map[string]string `json:"grok_patterns"`
+	// hmac、fpe 规则使用的密钥，不随配置输出到日志
+	MaskSecret string `json:"-"`
+	// 请求中图片内容的拦截策略：none、data_uri 或 all
+	DenyImage string `json:"deny_image"`
+	// 按 Gemini 协议处理 generateContent / streamGenerateContent 请求
+	DenyGemini bool `json:"deny_gemini"`
+	// NDJSON 流式响应中每行增量文本字段的路径（gjson 语法）
+	StreamNDJSONPath string `json:"stream_ndjson_path"`
+	// 请求阶段移除 accept-encoding，要求上游返回未压缩的响应
+	StripAcceptEncoding bool `json:"strip_accept_encoding"`
+	// deny_raw 处理 multipart 请求时，单个文本 part 的最大检查字节数，超过时跳过
+	RawPartMaxBytes int `json:"raw_part_max_bytes"`
+	// 流式响应拦截时只暂缓输出末尾可能组成跨 chunk 敏感词的文本，其余文本立即输出
+	StreamHoldback bool `json:"stream_holdback"`
+	// holdback 模式下 chunk 暂缓输出的最长时间（毫秒），0 表示不限制
+	// 只在收到新的 chunk 时检查，上游停止发送数据时暂缓的文本会一直等到下一个 chunk 或流结束
+	StreamHoldbackMaxDelay uint32 `json:"stream_holdback_max_delay"`
+	// 请求未按 LLM 协议识别时，响应中需要检查的字段路径（gjson 语法），流式响应为各事件中的增量文本字段
+	ResponseJSONPath []string `json:"response_jsonpath"`
+	// 编译后的拦截正则表达式，与 DenyPatterns 下标一致
+	CompiledDenyPatterns []*regexp.Regexp `json:"-"`
+	// 编译后的白名单正则表达式
+	CompiledAllowPatterns []*regexp.Regexp `json:"-"`
+}
+
+// NormalizeConfig 敏感词匹配前的文本归一化配置，各步骤可单独开启
+type NormalizeConfig struct {
+	NFKC                    bool `json:"nfkc"`                      // Unicode NFKC 兼容分解后再组合
+	CaseFold                bool `json:"case_fold"`                 // 大小写折叠（统一转小写）
+	FullWidth               bool `json:"full_width"`                // 全角字符转半角
+	TraditionalToSimplified bool `json:"traditional_to_simplified"` // 繁体转简体
+	StripSeparators         bool `json:"strip_separators"`          // 去除空白、标点、符号等分隔字符
+	StripZeroWidth          bool `json:"strip_zero_width"`          // 去除零宽字符及其他格式控制字符
+}
+
+// Enabled 是否开启了任意一个归一化步骤
+func (n NormalizeConfig) Enabled() bool {
+	return n.NFKC || n.CaseFold || n.FullWidth || n.TraditionalToSimplified || n.StripSeparators || n.StripZeroWidth
+}
+
+// DenyWordEntry 敏感词字典项：分类、严重级别和命中后的动作
+type DenyWordEntry struct {
+	Word     string `json:"word"`
+	Category string `json:"category"` // 分类，如 politics、abuse、ads、pii
+	Severity int    `json:"severity"` // 严重级别，数值越大越严重
+	Action   string `json:"action"`   // block, replace, log，默认 block
+}
+
+const (
+	DenyActionBlock   = "block"   // 命中后按拒绝策略处理
+	DenyActionReplace = "replace" // 命中后静默替换为掩码，不拒绝
+	DenyActionLog     = "log"     // 命中后仅记录日志
+)
+
+// IsValidDenyAction 检查动作是否为有效值
+func IsValidDenyAction(action string) bool {
+	return action == DenyActionBlock || action == DenyActionReplace || action == DenyActionLog
+}
+
+// DenyWordEntryAt 返回第 idx 个自定义敏感词的字典项，未配置字典项时按 block 处理
+func (c *AiDataMaskingConfig) DenyWordEntryAt(idx int) DenyWordEntry {
+	if idx >= 0 && idx < len(c.DenyWordEntries) {
+		return c.DenyWordEntries[idx]
+	}
+	entry := DenyWordEntry{Action: DenyActionBlock}
+	if idx >= 0 && idx < len(c.DenyWords) {
+		entry.Word = c.DenyWords[idx]
+	}
+	return entry
+}
+
+// HasDenyAction 是否有自定义敏感词配置了指定动作
+func (c *AiDataMaskingConfig) HasDenyAction(action string) bool {
+	for _, entry := range c.DenyWordEntries {
+		if entry.Action == action {
+			return true
+		}
+	}
+	return false
+}
+
+// 图片拦截策略
+const (
+	DenyImageNone    = "none"     // 不拦截图片（默认）
+	DenyImageDataURI = "data_uri" // 拦截内联的 data URI / base64 图片，允许外链图片
+	DenyImageAll     = "all"      // 拦截所有图片
+)
+
+// DenyCategoryImage 图片被拦截时记录的分类
+const DenyCategoryImage = "image"
+
+// DenyCategoryEncoding 响应使用不支持的压缩编码或解压失败、无法检查时记录的分类
+const DenyCategoryEncoding = "encoding"
+
+// IsValidDenyImage 检查图片拦截策略是否为有效值
+func IsValidDenyImage(policy string) bool {
+	return policy == DenyImageNone || policy == DenyImageDataURI || policy == DenyImageAll
+}
+
+// DenyImageURL 按图片拦截策略判断图片是否需要拦截，内联图片以 data URI 表示
+func (c *AiDataMaskingConfig) DenyImageURL(url string) bool {
+	switch c.DenyImage {
+	case DenyImageAll:
+		return true
+	case DenyImageDataURI:
+		return len(url) >= 5 && strings.EqualFold(url[:5], "data:")
+	}
+	return false
+}
+
+// 系统敏感词库格式
+const (
+	SystemDenyFormatText = "text" // 纯文本，每行一个词，忽略空行和 # 开头的注释行
+	SystemDenyFormatJSON = "json" // JSON，词列表位于 json_path 指定的数组
+)
+
+// SystemDenySource 系统敏感词库远程来源，插件启动时拉取并按 RefreshInterval 定时刷新
+type SystemDenySource struct {
+	URL             string `json:"url"`              // 词库地址，配置后服务名、端口、Host 和路径默认按 url 解析
+	ServiceName     string `json:"service_name"`     // 服务名（FQDN），如 dict.static
+	ServicePort     int64  `json:"service_port"`     // 服务端口
+	ServiceHost     string `json:"service_host"`     // 请求使用的 Host，为空时使用服务名
+	Path            string `json:"path"`             // 词库请求路径
+	Format          string `json:"format"`           // text 或 json，默认 text
+	JSONPath        string `json:"json_path"`        // JSON 格式时词列表所在路径，为空时要求响应为顶层数组
+	RefreshInterval int64  `json:"refresh_interval"` // 刷新间隔（毫秒）
+	Timeout         uint32 `json:"timeout"`          // 请求超时（毫秒）
+	// 访问词库服务的客户端，未配置来源时为 nil
+	Client wrapper.HttpClient `json:"-"`
+}
+
+// Enabled 是否配置了远程词库来源
+func (s *SystemDenySource) Enabled() bool {
+	return s.Client != nil
 }
 
-type ResponseDenyPlot struct {
+// DenyPlot 命中拦截词时的处理方式，请求（request_deny_plot）和响应（response_deny_plot）共用
+type DenyPlot struct {
 	Plot  string `json:"plot"`  // replace, stop,默认stop
 	Value string `json:"value"` // 如果是 replace，则替换为value，如果是stop，则返回deny_message
+	Type  string `json:"type"`  // replace 时的替换方式：value（默认，使用 value 填充）或 mask（部分掩码）
+	MaskOptions
 }
 
+// 响应替换方式
+const (
+	DenyPlotTypeValue = "value"
+	DenyPlotTypeMask  = "mask"
+)
+
+// MaskOptions 部分掩码配置，保留左右两端的字符，其余替换为掩码字符，按字符（rune）计数
+type MaskOptions struct {
+	KeepLeft  int    `json:"keep_left"`  // 左侧保留的字符数
+	KeepRight int    `json:"keep_right"` // 右侧保留的字符数
+	MaskChar  string `json:"mask_char"`  // 掩码字符，默认 *
+}
+
+// 替换规则类型
+const (
+	RuleTypeReplace = "replace" // 按 value 替换
+	RuleTypeHash    = "hash"    // 替换为 SHA256 哈希
+	RuleTypeMask    = "mask"    // 部分掩码，保留左右两端的字符
+	RuleTypeHMAC    = "hmac"    // 替换为以 mask_secret 为密钥的 HMAC-SHA256，可截断并加类型前缀
+	RuleTypeToken   = "token"   // 替换为按请求编号的类型化占位符，如 <PHONE_1>
+	RuleTypeFPE     = "fpe"     // 格式保留加密，保持长度和字符类别（数字、大小写字母）
+	// 以下为带校验的识别器，regex 可省略（使用内置候选正则），只有通过校验的值才按 value 替换
+	// 未配置 value 时按掩码配置做部分掩码
+	RuleTypeIDCard   = "idcard"   // 居民身份证号（行政区划、出生日期、ISO 7064 校验码）
+	RuleTypeBankCard = "bankcard" // 银行卡号（BIN 范围、Luhn 校验）
+	RuleTypeMobile   = "mobile"   // 中国大陆手机号（号段校验）
+	RuleTypeIPv4     = "ipv4"     // IPv4 地址（范围校验）
+	RuleTypeIPv6     = "ipv6"     // IPv6 地址
+	RuleTypeIP       = "ip"       // IPv4 或 IPv6 地址
+)
+
 // Rule 替换规则
 type Rule struct {
 	Regex   string `json:"regex"`
-	Type    string `json:"type"` // "replace" or "hash"
+	Type    string `json:"type"` // replace、hash、hmac、token、fpe、mask 或带校验的识别器类型（idcard、bankcard、mobile、ipv4、ipv6、ip）
 	Restore bool   `json:"restore"`
 	Value   string `json:"value"`
+	Label   string `json:"label"`  // 占位符类型，token 生成 <LABEL_n>，hmac 生成 <LABEL_hash>
+	Length  int    `json:"length"` // hash、hmac 结果截断后保留的字符数，0 表示不截断
+	MaskOptions
 	// 编译后的正则表达式
 	CompiledRegex *regexp.Regexp
 }
@@ -58,19 +245,28 @@ type Rule struct {
 type PluginContext struct {
 	Config                 *AiDataMaskingConfig
 	MaskMap                map[string]*string // hash值 -> 原始值，用于还原
+	TokenMap               map[string]string  // 占位符类型+原始值 -> 类型化占位符，同一请求内相同的值复用占位符
+	TokenCount             map[string]int     // 占位符类型 -> 已分配的编号
 	OpenAIRequest          *OpenAIRequest     // OpenAI请求参数
 	RequestDenyModifyType  DenyModifyType     // 请求拒绝类型
 	ResponseDenyModifyType DenyModifyType     // 响应拒绝类型
 	RespIsSSE              bool               // 响应是否是SSE,返回头阶段判断，如果是sse，则分块处理
+	RespIsNDJSON           bool               // 响应是否是NDJSON，返回头阶段判断，转换为SSE事件后分块处理
+	Protocol               APIProtocol        // 请求使用的 API 协议，请求头阶段按路径判断
+	RequestContentType     string             // 请求的 Content-Type，deny_raw 按类型解析表单与 multipart 请求体
+	RequestID              string             // 请求头 x-request-id，拒绝消息模板中的 ${request_id}
 	// deny
 	IsRequestDeny  bool // 是否是请求阶段拒绝
 	IsResponseDeny bool // 是否是响应阶段拒绝
 	IsDeny         bool // 是否被拒绝
 	// modify
-	IsRequestModified  bool // 是否是请求阶段修改，暂时保留，请求阶段不做替换处理
+	IsRequestModified  bool // 请求阶段是否按 request_deny_plot 将拦截词替换后转发
 	IsResponseModified bool // 是否是响应阶段修改
 	IsModified         bool // 是否拒绝敏感词后被修改
 	Step               Step // 处理步骤
+	// 命中的敏感词分类信息（取严重级别最高的拦截命中）
+	DenyCategory string // 命中敏感词的分类
+	DenySeverity int    // 命中敏感词的严重级别
 
 	MaxBufferChunkCount     uint32 // 最长敏感词检测chunk个数
 	MaxStreamChunkBufferLen uint32 // 最长敏感词检测chunk大小
@@ -79,10 +275,64 @@ type PluginContext struct {
 	StreamReasoningBuffer       string // reasoning 缓冲区（用于敏感词检查）
 	StreamContentBufferOffset   int    // content 缓冲区的偏移量（用于处理跨窗口边界）
 	StreamReasoningBufferOffset int    // reasoning 缓冲区的偏移量（用于处理跨窗口边界）
+	StreamToolCallBuffer        string // tool_calls 参数缓冲区（用于敏感词检查）
+	StreamToolCallBufferOffset  int    // tool_calls 参数缓冲区的偏移量（用于处理跨窗口边界）
 	StreamDenied                bool   // 是否已拒绝（用于标记后续不再处理）
+	// 流式响应还原
+	StreamRestorePending   map[string]string // choice 下标+字段 -> 可能是占位符前缀、暂未输出的尾部文本
+	StreamRestoreLastEvent string            // 最近一个 SSE 事件的 JSON，用于在流结束时构造补发事件
+	// 补发暂存内容时使用的事件模板，键与 StreamRestorePending 一致
+	StreamRestoreTemplates map[string]string
+	// 已发送给客户端的 Anthropic 流式事件状态
+	AnthropicStream AnthropicStreamState
+	// 已发送给客户端的 Responses API 流式事件状态
+	ResponsesStream ResponsesStreamState
+	// Gemini streamGenerateContent 未指定 alt=sse，响应为整体缓冲处理的 JSON 数组
+	GeminiArrayStream bool
+	// NDJSON 流式响应中被 chunk 拆分、尚不完整的最后一行
+	StreamNDJSONPending string
+	// 响应的压缩编码（gzip、deflate、br），解压后检查，输出时移除 content-encoding
+	RespContentEncoding string
+	// 流式压缩响应的解压器，在 chunk 之间保留解压状态
+	StreamDecoder StreamDecoder
+	// 流式压缩响应解压失败，已以拒绝事件结束
+	StreamDecodeFailed bool
 	// 流式响应 chunk 缓冲区
 	StreamChunkBuffer     []StreamChunk // 存储所有 chunk，等待缓冲区满或 [DONE] 时处理
 	StreamChunkBufferSize int           // 当前缓冲区大小（字节数）
+	// 请求 n>1 时各 choice 独立缓冲与检查，处理某个 choice 时将其状态换入上面的流式缓冲区字段
+	StreamChoices       map[int64]*StreamChoiceState
+	StreamInChoice      bool   // 是否正在处理单个 choice
+	StreamChoiceIndex   int64  // 正在处理的 choice 下标
+	StreamChoiceTrailer string // 没有 choice 的事件（如 usage），在所有 choice 输出之后与流结束标记一起发送
+	// 流式响应被截断后转发 usage 的状态
+	StreamCut StreamCutState
+}
+
+// StreamCutState OpenAI 流式响应被截断（拒绝）后的状态，截断后仍需转发上游的 usage 事件用于计费
+type StreamCutState struct {
+	LastUsage  string // 最近一次出现的 usage（JSON），上游没有再返回 usage 时据此补发
+	Template   string // 拒绝事件的 JSON，补发 usage 事件时沿用其中的 id、model、created
+	AwaitUsage bool   // 是否在等待上游的 usage 事件，收到流结束标记后再结束流
+}
+
+// StreamChoiceState 单个 choice 的流式缓冲区状态，字段与 PluginContext 中的流式缓冲区一一对应
+type StreamChoiceState struct {
+	ChunkBuffer           []StreamChunk
+	ChunkBufferSize       int
+	ContentBuffer         string
+	ReasoningBuffer       string
+	ToolCallBuffer        string
+	ContentBufferOffset   int
+	ReasoningBufferOffset int
+	ToolCallBufferOffset  int
+	Denied                bool // 该 choice 是否已拒绝
+}
+
+// StreamDecoder 流式响应解压器，每次只处理新收到的压缩数据
+type StreamDecoder interface {
+	// Decode 追加压缩数据，返回新解压出的内容；数据不完整时等待后续数据，final 为 true 时压缩流仍未结束则返回错误
+	Decode(data []byte, final bool) ([]byte, error)
 }
 
 // StreamChunk 流式响应 chunk 结构
@@ -92,21 +342,68 @@ type StreamChunk struct {
 	ContentEnd     int    // 在 StreamContentBuffer 中的结束位置
 	ReasoningStart int    // 在 StreamReasoningBuffer 中的起始位置
 	ReasoningEnd   int    // 在 StreamReasoningBuffer 中的结束位置
+	ToolCallStart  int    // 在 StreamToolCallBuffer 中的起始位置
+	ToolCallEnd    int    // 在 StreamToolCallBuffer 中的结束位置
 	IsDone         bool   // 是否是 [DONE] 标记
+	ReceivedAt     int64  // 进入缓冲区的时间（毫秒），holdback 模式据此判断是否超过最长延迟
+}
+
+// AnthropicStreamState 已发送给客户端的 Anthropic 流式事件状态，中途拒绝时据此补齐事件序列
+type AnthropicStreamState struct {
+	MessageStarted bool // 是否已发送 message_start
+	BlockOpen      bool // 是否有已开始但未结束的内容块
+	NextBlock      int  // 下一个内容块的下标
+}
+
+// ResponsesStreamState 已发送给客户端的 Responses API 流式事件状态，中途拒绝时据此补齐事件序列
+type ResponsesStreamState struct {
+	Created      bool   // 是否已发送 response.created
+	ResponseID   string // 原始响应 id，拒绝时 response.completed 沿用
+	NextOutput   int    // 下一个输出项的下标
+	NextSequence int64  // 下一个事件的 sequence_number
 }
 
 type DenyModifyType string
 
 const (
-	DenyModifyTypeOpenAI   DenyModifyType = "OpenAI"
-	DenyModifyTypeJSONPath DenyModifyType = "JSONPath"
-	DenyModifyTypeRaw      DenyModifyType = "Raw"
+	DenyModifyTypeOpenAI    DenyModifyType = "OpenAI"
+	DenyModifyTypeAnthropic DenyModifyType = "Anthropic"
+	DenyModifyTypeResponses DenyModifyType = "OpenAIResponses"
+	DenyModifyTypeGemini    DenyModifyType = "Gemini"
+	DenyModifyTypeJSONPath  DenyModifyType = "JSONPath"
+	DenyModifyTypeRaw       DenyModifyType = "Raw"
+)
+
+// APIProtocol 请求使用的 API 协议
+type APIProtocol string
+
+const (
+	APIProtocolOpenAI    APIProtocol = "openai"    // OpenAI Chat Completions（默认）
+	APIProtocolAnthropic APIProtocol = "anthropic" // Anthropic Messages（/v1/messages）
+	APIProtocolResponses APIProtocol = "responses" // OpenAI Responses（/v1/responses）
+	APIProtocolGemini    APIProtocol = "gemini"    // Gemini generateContent / streamGenerateContent
+	// OpenAI 旧版 Completions（/v1/completions）与 Embeddings（/v1/embeddings），与 Chat Completions 一起由 deny_openai 控制
+	APIProtocolCompletions APIProtocol = "completions"
+	APIProtocolEmbeddings  APIProtocol = "embeddings"
 )
 
+// HasAdapter 是否需要由协议适配器处理（Chat Completions 以外的协议）
+func (p APIProtocol) HasAdapter() bool {
+	return p == APIProtocolAnthropic || p == APIProtocolResponses || p == APIProtocolGemini
+}
+
+// IsOpenAILegacy 是否为 Chat Completions 以外、按 OpenAI 请求处理的接口（Completions、Embeddings）
+func (p APIProtocol) IsOpenAILegacy() bool {
+	return p == APIProtocolCompletions || p == APIProtocolEmbeddings
+}
+
 type OpenAIRequest struct {
-	Model    string
-	Stream   bool
-	Messages []OpenAIMessage
+	Model       string
+	Stream      bool
+	ChoiceCount int // 响应中 choice 的数量：n，旧版 Completions 的 prompt 为数组时再乘以 prompt 个数
+	// stream_options.include_usage，流式响应被截断时等待上游的 usage 事件后再结束流
+	IncludeUsage bool
+	Messages     []OpenAIMessage
 }
 
 // Step 处理步骤枚举
@@ -131,10 +428,31 @@ func (s Step) IsValid() bool {
 		s == StepRespBody || s == StepStreamRespBody
 }
 
-var (
-	// 系统敏感词库（简化版，实际应该从文件加载）
-	SystemDenyWords = []string{
-		// 这里可以添加系统敏感词
-		// 实际实现应该从资源文件加载
+// systemDenyWords 系统敏感词库，由 system_deny_source 远程加载，刷新时整体替换
+var systemDenyWords atomic.Pointer[[]string]
+
+// GetSystemDenyWords 返回当前生效的系统敏感词库，返回的切片不可修改
+func GetSystemDenyWords() []string {
+	if words := systemDenyWords.Load(); words != nil {
+		return *words
 	}
-)
+	return nil
+}
+
+// SetSystemDenyWords 替换系统敏感词库，调用后不得再修改 words
+func SetSystemDenyWords(words []string) {
+	maxLength := 0
+	for _, word := range words {
+		maxLength = max(maxLength, utf8.RuneCountInString(word))
+	}
+	systemDenyWordMaxLength.Store(int64(maxLength))
+	systemDenyWords.Store(&words)
+}
+
+// systemDenyWordMaxLength 系统敏感词库中最长词的字符数，替换词库时计算
+var systemDenyWordMaxLength atomic.Int64
+
+// GetSystemDenyWordMaxLength 返回当前系统敏感词库中最长词的字符数
+func GetSystemDenyWordMaxLength() int {
+	return int(systemDenyWordMaxLength.Load())
+}
@@ -58,16 +58,26 @@ type OpenAIUsage struct {
 	TotalTokens      int `json:"total_tokens,omitempty"`
 }
 
-// JSONPath 返回拒绝响应结构体
-type JSONPathResponse struct {
-	Code    uint32                 `json:"code"`
-	Message string                 `json:"message"`
-	Data    map[string]interface{} `json:"data"`
+// Anthropic Messages 非流式响应结构体
+type AnthropicMessageResponse struct {
+	Id           string                  `json:"id"`
+	Type         string                  `json:"type"`
+	Role         string                  `json:"role"`
+	Model        string                  `json:"model"`
+	Content      []AnthropicContentBlock `json:"content"`
+	StopReason   *string                 `json:"stop_reason"`
+	StopSequence *string                 `json:"stop_sequence"`
+	Usage        AnthropicUsage          `json:"usage"`
 }
 
-// Raw 返回拒绝响应结构体
-type RawResponse struct {
-	Code    uint32                 `json:"code"`
-	Message string                 `json:"message"`
-	Data    map[string]interface{} `json:"data"`
+// Anthropic 内容块，拒绝响应中只使用文本块
+type AnthropicContentBlock struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Anthropic Usage 结构体
+type AnthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
 }
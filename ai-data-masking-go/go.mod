@@ -5,12 +5,14 @@ go 1.24.1
 toolchain go1.24.4
 
 require (
+	github.com/andybalholm/brotli v1.2.0
 	github.com/cloudflare/ahocorasick v0.0.0-20240916140611-054963ec9396
 	github.com/google/uuid v1.6.0
 	github.com/higress-group/proxy-wasm-go-sdk v0.0.0-20251103120604-77e9cce339d2
 	github.com/higress-group/wasm-go v1.0.6
 	github.com/tidwall/gjson v1.18.0
 	github.com/tidwall/sjson v1.2.5
+	golang.org/x/text v0.28.0
 )
 
 require (
@@ -0,0 +1,171 @@
+package lib
+
+import (
+	"ai-data-masking/config"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/cloudflare/ahocorasick"
+)
+
+var (
+	// 白名单匹配器缓存，与敏感词匹配器共用 cacheMutex
+	allowMatcherCache    *ahocorasick.Matcher
+	allowWordsCache      []string
+	allowNormalizedCache []string
+	allowNormalizeCache  config.NormalizeConfig
+)
+
+// hasAllowList 是否配置了白名单
+func hasAllowList(cfg *config.AiDataMaskingConfig) bool {
+	return len(cfg.AllowWords) > 0 || len(cfg.CompiledAllowPatterns) > 0
+}
+
+// filterAllowedMatches 过滤掉被白名单片段完整覆盖的敏感词命中
+// 白名单词在归一化文本上匹配并映射回原文位置，白名单正则直接在原文上匹配
+func filterAllowedMatches(text string, normalized *NormalizedText, matches []MatchResult, cfg *config.AiDataMaskingConfig) []MatchResult {
+	if len(matches) == 0 || !hasAllowList(cfg) {
+		return matches
+	}
+
+	spans := findAllowSpans(text, normalized, cfg)
+	if len(spans) == 0 {
+		return matches
+	}
+
+	filtered := matches[:0]
+	for _, match := range matches {
+		if isCoveredBySpans(match, spans) {
+			continue
+		}
+		filtered = append(filtered, match)
+	}
+	return filtered
+}
+
+// findAllowSpans 查找文本中所有白名单片段的原文位置
+func findAllowSpans(text string, normalized *NormalizedText, cfg *config.AiDataMaskingConfig) [][2]int {
+	spans := make([][2]int, 0, 4)
+
+	if len(cfg.AllowWords) > 0 {
+		matcher, normalizedWords := getOrBuildAllowMatcher(cfg.AllowWords, &cfg.Normalize)
+		for _, match := range appendWordMatches(nil, []byte(normalized.Text), normalized, matcher, cfg.AllowWords, normalizedWords, nil) {
+			spans = append(spans, [2]int{match.StartPos, match.EndPos})
+		}
+	}
+
+	for _, re := range cfg.CompiledAllowPatterns {
+		for _, loc := range re.FindAllStringIndex(text, -1) {
+			spans = append(spans, [2]int{loc[0], loc[1]})
+		}
+	}
+
+	return spans
+}
+
+// isCoveredBySpans 判断命中位置是否被某个白名单片段完整覆盖
+func isCoveredBySpans(match MatchResult, spans [][2]int) bool {
+	for _, span := range spans {
+		if span[0] <= match.StartPos && match.EndPos <= span[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// IsMatchPendingAllow 流式场景下判断一个命中是否可能在后续数据到达后被白名单词覆盖
+// 即命中之前的文本是某个白名单词的前半部分，且从命中开始到文本末尾是该白名单词剩余部分的真前缀
+// 这种情况下不应立即拒绝，而应等待更多数据
+func IsMatchPendingAllow(text string, match MatchResult, cfg *config.AiDataMaskingConfig) bool {
+	if len(cfg.AllowWords) == 0 || match.StartPos < 0 || match.EndPos <= match.StartPos || match.EndPos > len(text) {
+		return false
+	}
+
+	// 使用原文中命中的片段：拦截正则命中时 MatchedWord 是正则规则而不是命中的文本
+	_, normalizedAllowWords := getOrBuildAllowMatcher(cfg.AllowWords, &cfg.Normalize)
+	normalizedWord := NormalizeWord(text[match.StartPos:match.EndPos], &cfg.Normalize)
+	tail := NormalizeText(text[match.StartPos:], &cfg.Normalize).Text
+
+	// 命中之前只需要看最长白名单词长度范围内的文本，按字符边界截取
+	maxAllowLen := 0
+	for _, word := range normalizedAllowWords {
+		if len(word) > maxAllowLen {
+			maxAllowLen = len(word)
+		}
+	}
+	headStart := match.StartPos - maxAllowLen*4
+	if headStart < 0 {
+		headStart = 0
+	}
+	for headStart > 0 && !utf8.RuneStart(text[headStart]) {
+		headStart--
+	}
+	head := NormalizeText(text[headStart:match.StartPos], &cfg.Normalize).Text
+
+	for _, allowWord := range normalizedAllowWords {
+		offset := 0
+		for {
+			idx := strings.Index(allowWord[offset:], normalizedWord)
+			if idx < 0 {
+				break
+			}
+			k := offset + idx
+			rest := allowWord[k:]
+			if strings.HasSuffix(head, allowWord[:k]) && len(tail) < len(rest) && strings.HasPrefix(rest, tail) {
+				return true
+			}
+			offset = k + 1
+			if offset >= len(allowWord) {
+				break
+			}
+		}
+	}
+	return false
+}
+
+// getOrBuildAllowMatcher 获取或构建白名单匹配器
+func getOrBuildAllowMatcher(words []string, opts *config.NormalizeConfig) (*ahocorasick.Matcher, []string) {
+	cacheMutex.RLock()
+	if allowMatcherCache != nil && allowNormalizeCache == *opts && wordsEqual(allowWordsCache, words) {
+		matcher, normalizedWords := allowMatcherCache, allowNormalizedCache
+		cacheMutex.RUnlock()
+		return matcher, normalizedWords
+	}
+	cacheMutex.RUnlock()
+
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+
+	// 双重检查
+	if allowMatcherCache != nil && allowNormalizeCache == *opts && wordsEqual(allowWordsCache, words) {
+		return allowMatcherCache, allowNormalizedCache
+	}
+
+	normalizedWords := normalizeWords(words, opts)
+	matcher := ahocorasick.NewStringMatcher(normalizedWords)
+	allowMatcherCache = matcher
+	allowWordsCache = make([]string, len(words))
+	copy(allowWordsCache, words)
+	allowNormalizedCache = normalizedWords
+	allowNormalizeCache = *opts
+
+	return matcher, normalizedWords
+}
+
+// dropPendingAllowMatches 去掉流式缓冲区中可能被后续数据补全为白名单词的命中
+// 返回剩余的命中以及是否存在待定的命中
+func dropPendingAllowMatches(text string, matches []MatchResult, cfg *config.AiDataMaskingConfig) ([]MatchResult, bool) {
+	if len(matches) == 0 || len(cfg.AllowWords) == 0 {
+		return matches, false
+	}
+	pending := false
+	remaining := matches[:0]
+	for _, match := range matches {
+		if IsMatchPendingAllow(text, match, cfg) {
+			pending = true
+			continue
+		}
+		remaining = append(remaining, match)
+	}
+	return remaining, pending
+}
@@ -0,0 +1,100 @@
+package lib
+
+import (
+	"ai-data-masking/config"
+	"regexp"
+	"testing"
+)
+
+// TestFindSensitiveWordMatches_AllowWords 测试白名单覆盖的命中被忽略
+func TestFindSensitiveWordMatches_AllowWords(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.AllowWords = []string{"不良信息举报中心"}
+	cfg.CompiledAllowPatterns = []*regexp.Regexp{regexp.MustCompile(`禁止词汇表\d+`)}
+
+	tests := []struct {
+		name          string
+		text          string
+		expectedCount int
+	}{
+		{name: "白名单词覆盖", text: "请联系不良信息举报中心", expectedCount: 0},
+		{name: "白名单正则覆盖", text: "参见禁止词汇表12", expectedCount: 0},
+		{name: "未被覆盖", text: "这是不良信息", expectedCount: 1},
+		{name: "部分覆盖", text: "不良信息举报中心收到不良信息", expectedCount: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := FindSensitiveWordMatches(tt.text, cfg, nil)
+			if len(results) != tt.expectedCount {
+				t.Errorf("期望 %d 个命中, 实际 %d 个: %+v", tt.expectedCount, len(results), results)
+			}
+		})
+	}
+}
+
+// TestIsMatchPendingAllow 测试流式场景下可能被白名单补全的命中
+func TestIsMatchPendingAllow(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.AllowWords = []string{"反不良信息举报中心"}
+
+	tests := []struct {
+		name     string
+		text     string
+		expected bool
+	}{
+		{name: "白名单词尚未完整", text: "请联系反不良信息举报", expected: true},
+		{name: "前缀不匹配", text: "请联系不良信息举报", expected: false},
+		{name: "后续内容已不匹配", text: "请联系反不良信息很多", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := FindSensitiveWordMatches(tt.text, cfg, nil)
+			if len(results) != 1 {
+				t.Fatalf("期望 1 个命中, 实际 %d 个", len(results))
+			}
+			if got := IsMatchPendingAllow(tt.text, results[0], cfg); got != tt.expected {
+				t.Errorf("期望 %v, 实际 %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestIsMatchPendingAllow_Pattern 测试拦截正则的命中按原文片段判断是否可能被白名单补全
+func TestIsMatchPendingAllow_Pattern(t *testing.T) {
+	cfg := createTestConfig(withDenyPatterns(`sk-[0-9a-z]{8,}`))
+	cfg.AllowWords = []string{"示例密钥sk-abcdef123456仅供演示"}
+
+	tests := []struct {
+		name     string
+		text     string
+		expected bool
+	}{
+		{name: "白名单词尚未完整", text: "这是示例密钥sk-abcdef123456仅供", expected: true},
+		{name: "前缀不匹配", text: "这是密钥sk-abcdef123456仅供", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := FindSensitiveWordMatches(tt.text, cfg, nil)
+			if len(results) != 1 || !results[0].Pattern {
+				t.Fatalf("期望 1 个拦截正则命中, 实际 %+v", results)
+			}
+			if got := IsMatchPendingAllow(tt.text, results[0], cfg); got != tt.expected {
+				t.Errorf("期望 %v, 实际 %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestAllowWords_Normalize 测试白名单同样经过归一化
+func TestAllowWords_Normalize(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.Normalize = config.NormalizeConfig{TraditionalToSimplified: true, StripSeparators: true}
+	cfg.AllowWords = []string{"不良信息举报"}
+
+	if results := FindSensitiveWordMatches("不良 信息 舉報", cfg, nil); len(results) != 0 {
+		t.Errorf("期望被白名单覆盖, 实际命中 %+v", results)
+	}
+}
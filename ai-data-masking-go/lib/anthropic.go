@@ -0,0 +1,209 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"ai-data-masking/config"
+
+	"github.com/google/uuid"
+	"github.com/higress-group/wasm-go/pkg/wrapper"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// anthropicMessagesPath Anthropic Messages 接口路径
+const anthropicMessagesPath = "/v1/messages"
+
+// anthropicStopReason 拒绝响应使用的 stop_reason
+const anthropicStopReason = "end_turn"
+
+// IsAnthropicPath 判断请求路径是否为 Anthropic Messages 接口，忽略查询参数
+func IsAnthropicPath(path string) bool {
+	path, _, _ = strings.Cut(path, "?")
+	return strings.HasSuffix(strings.TrimSuffix(path, "/"), anthropicMessagesPath)
+}
+
+// ProcessAnthropicRequest 处理 Anthropic Messages 请求，检查 system 以及 messages[].content 中的文本块
+// 返回处理后的请求体、是否修改、是否拒绝
+func ProcessAnthropicRequest(ctx wrapper.HttpContext, pluginCtx *config.PluginContext, body []byte) ([]byte, bool, bool) {
+	bodyStr := string(body)
+	root := gjson.Parse(bodyStr)
+	if !root.Get("messages").IsArray() {
+		return body, false, false
+	}
+
+	// 初始化 OpenAIRequest（如果为 nil），模型与是否流式与 OpenAI 请求共用
+	if pluginCtx.OpenAIRequest == nil {
+		pluginCtx.OpenAIRequest = &config.OpenAIRequest{}
+	}
+	pluginCtx.OpenAIRequest.Stream = root.Get("stream").Bool()
+	pluginCtx.OpenAIRequest.Model = root.Get("model").String()
+
+	fields, images := anthropicRequestFields(root)
+	if denyImages(pluginCtx, images) {
+		return body, false, true
+	}
+
+	bodyStr, modified, denied := processRequestFields(pluginCtx, bodyStr, fields)
+	return []byte(bodyStr), modified, denied
+}
+
+// ProcessAnthropicResponse 处理 Anthropic Messages 非流式响应，检查 content 中的文本块和 thinking 块
+// 返回处理后的响应体、是否修改、是否拒绝
+func ProcessAnthropicResponse(ctx wrapper.HttpContext, pluginCtx *config.PluginContext, bodyStr string, body []byte) ([]byte, bool, bool) {
+	root := gjson.Parse(bodyStr)
+	if root.Get("type").String() != "message" || !root.Get("content").IsArray() {
+		return body, false, false
+	}
+
+	newBodyStr, modified, denied := processResponseFields(pluginCtx, bodyStr, anthropicResponseFields(root))
+	if denied {
+		return body, modified, denied
+	}
+	return []byte(newBodyStr), modified, denied
+}
+
+// anthropicRequestFields 返回 Anthropic 请求中 system 与 messages[].content 的文本字段以及图片 URL
+func anthropicRequestFields(root gjson.Result) ([]textField, []string) {
+	fields, images := appendAnthropicContent(nil, nil, "system", root.Get("system"))
+	root.Get("messages").ForEach(func(key, message gjson.Result) bool {
+		fields, images = appendAnthropicContent(fields, images, fmt.Sprintf("messages.%d.content", key.Int()), message.Get("content"))
+		return true
+	})
+	return fields, images
+}
+
+// appendAnthropicContent content 可以是字符串或内容块数组，数组中只处理文本块，图片块只做策略检查
+// base64 图片以 data URI 前缀表示
+func appendAnthropicContent(fields []textField, images []string, path string, content gjson.Result) ([]textField, []string) {
+	if content.Type == gjson.String {
+		return append(fields, textField{path: path, text: content.String()}), images
+	}
+	if !content.IsArray() {
+		return fields, images
+	}
+	content.ForEach(func(key, block gjson.Result) bool {
+		switch block.Get("type").String() {
+		case "text":
+			fields = append(fields, textField{path: fmt.Sprintf("%s.%d.text", path, key.Int()), text: block.Get("text").String()})
+		case "image":
+			source := block.Get("source")
+			if source.Get("type").String() == "base64" {
+				images = append(images, "data:"+source.Get("media_type").String()+";base64,")
+			} else {
+				images = append(images, source.Get("url").String())
+			}
+		}
+		return true
+	})
+	return fields, images
+}
+
+// anthropicResponseFields 返回 Anthropic 响应 content 中的文本块与 thinking 块
+func anthropicResponseFields(root gjson.Result) []textField {
+	var fields []textField
+	root.Get("content").ForEach(func(key, block gjson.Result) bool {
+		switch block.Get("type").String() {
+		case "text":
+			fields = append(fields, textField{path: fmt.Sprintf("content.%d.text", key.Int()), text: block.Get("text").String()})
+		case "thinking":
+			fields = append(fields, textField{path: fmt.Sprintf("content.%d.thinking", key.Int()), text: block.Get("thinking").String(), signed: true})
+		}
+		return true
+	})
+	return fields
+}
+
+// anthropicEventDeltas 提取 content_block_delta 事件中的文本增量与 thinking 增量
+func anthropicEventDeltas(root gjson.Result) (string, string) {
+	if root.Get("type").String() != "content_block_delta" {
+		return "", ""
+	}
+	return root.Get("delta.text").String(), root.Get("delta.thinking").String()
+}
+
+// anthropicMessageStart 构造 message_start 事件的数据
+func anthropicMessageStart(model string) string {
+	message, _ := json.Marshal(config.AnthropicMessageResponse{
+		Id:      "msg_" + strings.ReplaceAll(uuid.New().String(), "-", ""),
+		Type:    "message",
+		Role:    "assistant",
+		Model:   model,
+		Content: []config.AnthropicContentBlock{},
+	})
+	data, _ := sjson.SetRaw(`{"type":"message_start"}`, "message", string(message))
+	return data
+}
+
+// anthropicDenyEvents 构造 Anthropic 流式拒绝事件序列：在已发送的事件之后补齐 message_start、
+// 关闭未结束的内容块，再以一个新的文本块输出拒绝消息，最后以 message_delta、message_stop 结束
+// messageStart 为尚未发送的原始 message_start 事件，为空时按 model 构造
+func anthropicDenyEvents(model, message string, state config.AnthropicStreamState, messageStart string) string {
+	var result strings.Builder
+	if !state.MessageStarted {
+		if messageStart == "" {
+			messageStart = typedSSEEvent(anthropicMessageStart(model))
+		}
+		result.WriteString(messageStart)
+	}
+	if state.BlockOpen {
+		result.WriteString(typedSSEEvent(fmt.Sprintf(`{"type":"content_block_stop","index":%d}`, state.NextBlock-1)))
+	}
+
+	index := state.NextBlock
+	result.WriteString(typedSSEEvent(fmt.Sprintf(`{"type":"content_block_start","index":%d,"content_block":{"type":"text","text":""}}`, index)))
+	result.WriteString(anthropicTextDeltaEvent(int64(index), message))
+	result.WriteString(typedSSEEvent(fmt.Sprintf(`{"type":"content_block_stop","index":%d}`, index)))
+	result.WriteString(typedSSEEvent(`{"type":"message_delta","delta":{"stop_reason":"` + anthropicStopReason + `","stop_sequence":null},"usage":{"output_tokens":0}}`))
+	result.WriteString(typedSSEEvent(`{"type":"message_stop"}`))
+	return result.String()
+}
+
+// AnthropicDenyResponse 构造 Anthropic Messages 协议的拒绝响应，stream 为 true 时返回完整的 SSE 事件序列
+func AnthropicDenyResponse(model, message string, stream bool) []byte {
+	if stream {
+		return []byte(anthropicDenyEvents(model, message, config.AnthropicStreamState{}, ""))
+	}
+	stopReason := anthropicStopReason
+	response := config.AnthropicMessageResponse{
+		Id:         "msg_" + strings.ReplaceAll(uuid.New().String(), "-", ""),
+		Type:       "message",
+		Role:       "assistant",
+		Model:      model,
+		Content:    []config.AnthropicContentBlock{{Type: "text", Text: message}},
+		StopReason: &stopReason,
+	}
+	responseJson, _ := json.Marshal(response)
+	return responseJson
+}
+
+// anthropicStreamDenyEvents 响应阶段中途拒绝时的事件序列，缓冲区中尚未发送的 message_start 原样输出
+func anthropicStreamDenyEvents(pluginCtx *config.PluginContext, message string) string {
+	var messageStart string
+	for _, streamChunk := range pluginCtx.StreamChunkBuffer {
+		if gjson.Get(sseEventData(string(streamChunk.Data)), "type").String() == "message_start" {
+			messageStart = string(streamChunk.Data)
+			break
+		}
+	}
+	return anthropicDenyEvents(pluginCtx.OpenAIRequest.Model, message, pluginCtx.AnthropicStream, messageStart)
+}
+
+// trackAnthropicStream 根据已发送给客户端的缓冲区事件更新 Anthropic 流式状态
+func trackAnthropicStream(pluginCtx *config.PluginContext) {
+	state := &pluginCtx.AnthropicStream
+	for _, streamChunk := range pluginCtx.StreamChunkBuffer {
+		root := gjson.Parse(sseEventData(string(streamChunk.Data)))
+		switch root.Get("type").String() {
+		case "message_start":
+			state.MessageStarted = true
+		case "content_block_start":
+			state.BlockOpen = true
+			state.NextBlock = int(root.Get("index").Int()) + 1
+		case "content_block_stop":
+			state.BlockOpen = false
+		}
+	}
+}
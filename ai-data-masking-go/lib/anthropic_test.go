@@ -0,0 +1,210 @@
+package lib
+
+import (
+	"ai-data-masking/config"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+// collectAnthropicText 拼接 SSE 输出中各 content_block_delta 事件的文本增量
+func collectAnthropicText(output string) string {
+	var builder strings.Builder
+	for _, event := range strings.Split(output, "\n\n") {
+		data := gjson.Parse(sseEventData(event))
+		if data.Get("type").String() == "content_block_delta" {
+			builder.WriteString(data.Get("delta.text").String())
+		}
+	}
+	return builder.String()
+}
+
+// TestIsAnthropicPath 测试 Anthropic Messages 接口路径识别
+func TestIsAnthropicPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected bool
+	}{
+		{path: "/v1/messages", expected: true},
+		{path: "/anthropic/v1/messages?beta=true", expected: true},
+		{path: "/v1/messages/", expected: true},
+		{path: "/v1/chat/completions", expected: false},
+		{path: "/v1/messages/count_tokens", expected: false},
+	}
+	for _, tt := range tests {
+		if got := IsAnthropicPath(tt.path); got != tt.expected {
+			t.Errorf("%s: 期望 %v, 实际 %v", tt.path, tt.expected, got)
+		}
+	}
+}
+
+// TestProcessAnthropicRequest 测试 system 与 messages 中文本块的脱敏，非文本块保持不变
+func TestProcessAnthropicRequest(t *testing.T) {
+	pluginCtx := &config.PluginContext{
+		Config: &config.AiDataMaskingConfig{
+			ReplaceRoles: []config.Rule{{Type: config.RuleTypeReplace, Value: "****", CompiledRegex: regexp.MustCompile(`1\d{10}`)}},
+		},
+		MaskMap: make(map[string]*string),
+	}
+	body := `{"model":"claude","stream":true,"system":[{"type":"text","text":"客服电话 13812345678"}],` +
+		`"messages":[{"role":"user","content":"我的电话 13900001111"},` +
+		`{"role":"user","content":[{"type":"image","source":{"type":"base64","data":"13700002222"}},{"type":"text","text":"备用 13700002222"}]}]}`
+
+	newBody, modified, denied := ProcessAnthropicRequest(nil, pluginCtx, []byte(body))
+	if denied || !modified {
+		t.Fatalf("modified=%v, denied=%v", modified, denied)
+	}
+	expected := map[string]string{
+		"system.0.text":                    "客服电话 ****",
+		"messages.0.content":               "我的电话 ****",
+		"messages.1.content.1.text":        "备用 ****",
+		"messages.1.content.0.source.data": "13700002222",
+	}
+	for path, want := range expected {
+		if got := gjson.GetBytes(newBody, path).String(); got != want {
+			t.Errorf("%s: 期望 %q, 实际 %q", path, want, got)
+		}
+	}
+	if !pluginCtx.OpenAIRequest.Stream || pluginCtx.OpenAIRequest.Model != "claude" {
+		t.Errorf("请求信息不正确: %+v", pluginCtx.OpenAIRequest)
+	}
+}
+
+// TestProcessAnthropicResponse_Restore 测试非流式响应还原文本块，thinking 块不还原
+func TestProcessAnthropicResponse_Restore(t *testing.T) {
+	pluginCtx := createTestPluginContext(withMaskMap(map[string]string{"<PHONE_1>": "13812345678"}))
+	pluginCtx.Protocol = config.APIProtocolAnthropic
+	body := `{"type":"message","content":[{"type":"thinking","thinking":"用户电话 <PHONE_1>","signature":"sig"},{"type":"text","text":"已记录 <PHONE_1>"}]}`
+
+	newBody, modified, denied := ProcessAnthropicResponse(nil, pluginCtx, body, []byte(body))
+	if denied || !modified {
+		t.Fatalf("modified=%v, denied=%v", modified, denied)
+	}
+	if got := gjson.GetBytes(newBody, "content.1.text").String(); got != "已记录 13812345678" {
+		t.Errorf("还原结果不正确: %q", got)
+	}
+	if got := gjson.GetBytes(newBody, "content.0.thinking").String(); got != "用户电话 <PHONE_1>" {
+		t.Errorf("thinking 块不应还原: %q", got)
+	}
+}
+
+// TestAnthropicDenyResponse 测试 Anthropic 协议的拒绝响应
+func TestAnthropicDenyResponse(t *testing.T) {
+	t.Run("非流式", func(t *testing.T) {
+		response := gjson.ParseBytes(AnthropicDenyResponse("claude", "已屏蔽", false))
+		if response.Get("type").String() != "message" || response.Get("role").String() != "assistant" ||
+			response.Get("model").String() != "claude" || response.Get("stop_reason").String() != "end_turn" ||
+			response.Get("content.0.type").String() != "text" || response.Get("content.0.text").String() != "已屏蔽" {
+			t.Errorf("拒绝响应格式不正确: %s", response.Raw)
+		}
+	})
+
+	t.Run("流式", func(t *testing.T) {
+		output := string(AnthropicDenyResponse("claude", "已屏蔽", true))
+		var types []string
+		for _, event := range strings.Split(strings.TrimSpace(output), "\n\n") {
+			eventType := gjson.Get(sseEventData(event), "type").String()
+			if !strings.HasPrefix(event, "event: "+eventType+"\n") {
+				t.Errorf("event 行与 data 类型不一致: %s", event)
+			}
+			types = append(types, eventType)
+		}
+		expected := "message_start,content_block_start,content_block_delta,content_block_stop,message_delta,message_stop"
+		if got := strings.Join(types, ","); got != expected {
+			t.Errorf("事件序列不正确: %s", got)
+		}
+		if got := collectAnthropicText(output); got != "已屏蔽" {
+			t.Errorf("拒绝消息不正确: %q", got)
+		}
+	})
+}
+
+// TestAnthropicStreamDenyEvents 测试流式中途拒绝时补齐事件序列
+func TestAnthropicStreamDenyEvents(t *testing.T) {
+	pluginCtx := &config.PluginContext{
+		Protocol:      config.APIProtocolAnthropic,
+		OpenAIRequest: &config.OpenAIRequest{Model: "claude"},
+	}
+	for _, data := range []string{
+		`{"type":"message_start","message":{"id":"msg_1"}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"thinking","thinking":""}}`,
+		`{"type":"content_block_stop","index":0}`,
+		`{"type":"content_block_start","index":1,"content_block":{"type":"text","text":""}}`,
+	} {
+		pluginCtx.StreamChunkBuffer = append(pluginCtx.StreamChunkBuffer, config.StreamChunk{Data: []byte(typedSSEEvent(data))})
+	}
+	trackAnthropicStream(pluginCtx)
+	if state := pluginCtx.AnthropicStream; !state.MessageStarted || !state.BlockOpen || state.NextBlock != 2 {
+		t.Fatalf("流式状态不正确: %+v", state)
+	}
+
+	output := anthropicStreamDenyEvents(pluginCtx, "已屏蔽")
+	events := strings.Split(strings.TrimSpace(output), "\n\n")
+	if first := gjson.Parse(sseEventData(events[0])); first.Get("type").String() != "content_block_stop" || first.Get("index").Int() != 1 {
+		t.Errorf("应先关闭未结束的内容块: %s", events[0])
+	}
+	if second := gjson.Parse(sseEventData(events[1])); second.Get("type").String() != "content_block_start" || second.Get("index").Int() != 2 {
+		t.Errorf("拒绝消息应使用新的内容块: %s", events[1])
+	}
+	if strings.Contains(output, "message_start") {
+		t.Errorf("已发送的 message_start 不应重复: %s", output)
+	}
+
+	t.Run("message_start 未发送", func(t *testing.T) {
+		pluginCtx := &config.PluginContext{
+			Protocol:          config.APIProtocolAnthropic,
+			OpenAIRequest:     &config.OpenAIRequest{Model: "claude"},
+			StreamChunkBuffer: []config.StreamChunk{{Data: []byte(typedSSEEvent(`{"type":"message_start","message":{"id":"msg_origin"}}`))}},
+		}
+		output := anthropicStreamDenyEvents(pluginCtx, "已屏蔽")
+		if !strings.HasPrefix(output, "event: message_start\n") || !strings.Contains(output, "msg_origin") {
+			t.Errorf("应输出缓冲区中原始的 message_start: %s", output)
+		}
+	})
+}
+
+// TestRestoreStreamResponse_Anthropic 测试 Anthropic 流式响应的还原，占位符前缀在内容块结束前补发
+func TestRestoreStreamResponse_Anthropic(t *testing.T) {
+	pluginCtx := createTestPluginContext(withMaskMap(map[string]string{"<PHONE_1>": "13812345678"}))
+	pluginCtx.Protocol = config.APIProtocolAnthropic
+	chunks := []string{
+		typedSSEEvent(`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`),
+		typedSSEEvent(`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"请拨打 <PHO"}}`),
+		typedSSEEvent(`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"NE_1> 或 <PH"}}`),
+		typedSSEEvent(`{"type":"content_block_stop","index":0}`) + typedSSEEvent(`{"type":"message_stop"}`),
+	}
+	var output strings.Builder
+	for i, chunk := range chunks {
+		output.WriteString(string(RestoreStreamResponse(pluginCtx, []byte(chunk), i == len(chunks)-1)))
+	}
+	if got := collectAnthropicText(output.String()); got != "请拨打 13812345678 或 <PH" {
+		t.Errorf("还原结果不正确: %q", got)
+	}
+	// 补发的增量必须位于 content_block_stop 之前
+	if strings.Index(output.String(), "或 <PH") > strings.Index(output.String(), "content_block_stop") {
+		t.Errorf("补发位置不正确: %s", output.String())
+	}
+	if !strings.HasSuffix(output.String(), "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n") {
+		t.Errorf("message_stop 丢失: %s", output.String())
+	}
+}
+
+// TestIsStreamEndEvent 测试流结束标记识别
+func TestIsStreamEndEvent(t *testing.T) {
+	tests := []struct {
+		event    string
+		expected bool
+	}{
+		{event: "data: [DONE]", expected: true},
+		{event: "event: message_stop\ndata: {\"type\":\"message_stop\"}", expected: true},
+		{event: "event: message_delta\ndata: {\"type\":\"message_delta\"}", expected: false},
+		{event: `data: {"choices":[]}`, expected: false},
+	}
+	for _, tt := range tests {
+		if got := isStreamEndEvent(tt.event); got != tt.expected {
+			t.Errorf("%q: 期望 %v, 实际 %v", tt.event, tt.expected, got)
+		}
+	}
+}
@@ -12,18 +12,28 @@ const pluginName = "ai-data-masking"
 
 var (
 	// 缓存已构建的匹配器，避免重复构建
-	customMatcherCache *ahocorasick.Matcher
-	customWordsCache   []string
-	systemMatcherCache *ahocorasick.Matcher
-	systemWordsCache   []string
-	cacheMutex         sync.RWMutex
+	customMatcherCache    *ahocorasick.Matcher
+	customWordsCache      []string
+	customNormalizedCache []string               // 与 customWordsCache 下标一致的归一化词
+	customNormalizeCache  config.NormalizeConfig // 构建匹配器时使用的归一化配置
+	systemMatcherCache    *ahocorasick.Matcher
+	systemWordsCache      []string
+	systemNormalizedCache []string
+	systemNormalizeCache  config.NormalizeConfig
+	cacheMutex            sync.RWMutex
 )
 
-// CheckMessage 检查消息中是否包含敏感词
+// CheckMessage 检查消息中是否包含需要拦截的敏感词
 // isStream: true 表示流式处理，false 表示非流式处理
 func CheckMessage(message string, config *config.AiDataMaskingConfig, systemDenyWords []string, isStream bool) bool {
+	return CheckMessageMatch(message, config, systemDenyWords, isStream) != nil
+}
+
+// CheckMessageMatch 检查消息中是否包含需要拦截的敏感词，返回严重级别最高的拦截命中，未命中返回 nil
+// 动作为 replace/log 的字典项不会导致拦截
+func CheckMessageMatch(message string, config *config.AiDataMaskingConfig, systemDenyWords []string, isStream bool) *MatchResult {
 	if message == "" {
-		return false
+		return nil
 	}
 
 	// 非流式处理：直接匹配完整文本
@@ -36,69 +46,174 @@ func CheckMessage(message string, config *config.AiDataMaskingConfig, systemDeny
 }
 
 // checkNonStream 非流式处理：一次性匹配完整文本
-func checkNonStream(message string, config *config.AiDataMaskingConfig, systemDenyWords []string) bool {
-	messageBytes := []byte(message)
+func checkNonStream(message string, config *config.AiDataMaskingConfig, systemDenyWords []string) *MatchResult {
+	// 配置了白名单时需要命中位置来判断是否被白名单覆盖
+	if hasAllowList(config) {
+		match := selectBlockingMatch(FindSensitiveWordMatches(message, config, systemDenyWords))
+		if match != nil {
+			wlog.LogWithLine("[%s] checkNonStream deny word %s (category=%s) matched from %s", pluginName, match.MatchedWord, match.Category, message)
+		}
+		return match
+	}
+
+	// 归一化后再匹配，避免全角、大小写、插入分隔符等方式绕过
+	messageBytes := []byte(NormalizeText(message, &config.Normalize).Text)
 
 	// 检查自定义敏感词
 	if len(config.DenyWords) > 0 {
-		matcher := getOrBuildCustomMatcher(config.DenyWords)
+		matcher, _ := getOrBuildCustomMatcher(config.DenyWords, &config.Normalize)
 		matches := matcher.Match(messageBytes)
 		if len(matches) > 0 {
 			// matches 返回的是匹配的字典索引，我们需要找到对应的敏感词
-			matchedWord := config.DenyWords[matches[0]]
-			wlog.LogWithLine("[%s] checkNonStream custom deny word %s matched from %s", pluginName, matchedWord, message)
-			return true
+			if match := selectBlockingEntry(matches, config); match != nil {
+				wlog.LogWithLine("[%s] checkNonStream custom deny word %s (category=%s) matched from %s", pluginName, match.MatchedWord, match.Category, message)
+				return match
+			}
 		}
 	}
 
 	// 检查系统敏感词
 	if config.SystemDeny && len(systemDenyWords) > 0 {
-		matcher := getOrBuildSystemMatcher(systemDenyWords)
+		matcher, _ := getOrBuildSystemMatcher(systemDenyWords, &config.Normalize)
 		matches := matcher.Match(messageBytes)
 		if len(matches) > 0 {
 			// matches 返回的是匹配的字典索引
 			matchedWord := systemDenyWords[matches[0]]
 			wlog.LogWithLine("[%s] system deny word %s matched from %s", pluginName, matchedWord, message)
-			return true
+			return systemWordMatch(matchedWord)
 		}
 	}
 
-	return false
+	// 检查拦截正则
+	if match := findPatternMatch(message, config); match != nil {
+		wlog.LogWithLine("[%s] checkNonStream deny pattern %s matched from %s", pluginName, match.MatchedWord, message)
+		return match
+	}
+
+	return nil
 }
 
 // checkStream 流式处理：支持增量匹配
 // 对于流式数据，我们需要检查当前 chunk 以及可能跨越 chunk 的敏感词
-func checkStream(chunk string, config *config.AiDataMaskingConfig, systemDenyWords []string) bool {
+func checkStream(chunk string, config *config.AiDataMaskingConfig, systemDenyWords []string) *MatchResult {
 	// 流式处理时，直接检查当前 chunk
 	// 注意：如果敏感词可能跨越多个 chunk，需要在调用方维护缓冲区
 	// 这里假设每个 chunk 都是相对完整的文本片段
-	chunkBytes := []byte(chunk)
+	if hasAllowList(config) {
+		match := selectBlockingMatch(FindSensitiveWordMatches(chunk, config, systemDenyWords))
+		if match != nil {
+			wlog.LogWithLine("[%s] [stream] deny word %s (category=%s) matched from chunk: %s", pluginName, match.MatchedWord, match.Category, chunk)
+		}
+		return match
+	}
+
+	chunkBytes := []byte(NormalizeText(chunk, &config.Normalize).Text)
 
 	// 检查自定义敏感词
 	if len(config.DenyWords) > 0 {
-		matcher := getOrBuildCustomMatcher(config.DenyWords)
+		matcher, _ := getOrBuildCustomMatcher(config.DenyWords, &config.Normalize)
 		matches := matcher.Match(chunkBytes)
 		if len(matches) > 0 {
 			// matches 返回的是匹配的字典索引
-			matchedWord := config.DenyWords[matches[0]]
-			wlog.LogWithLine("[%s] [stream] custom deny word %s matched from chunk: %s", pluginName, matchedWord, chunk)
-			return true
+			if match := selectBlockingEntry(matches, config); match != nil {
+				wlog.LogWithLine("[%s] [stream] custom deny word %s (category=%s) matched from chunk: %s", pluginName, match.MatchedWord, match.Category, chunk)
+				return match
+			}
 		}
 	}
 
 	// 检查系统敏感词
 	if config.SystemDeny && len(systemDenyWords) > 0 {
-		matcher := getOrBuildSystemMatcher(systemDenyWords)
+		matcher, _ := getOrBuildSystemMatcher(systemDenyWords, &config.Normalize)
 		matches := matcher.Match(chunkBytes)
 		if len(matches) > 0 {
 			// matches 返回的是匹配的字典索引
 			matchedWord := systemDenyWords[matches[0]]
 			wlog.LogWithLine("[%s] [stream] system deny word %s matched from chunk: %s", pluginName, matchedWord, chunk)
-			return true
+			return systemWordMatch(matchedWord)
 		}
 	}
 
-	return false
+	// 检查拦截正则
+	if match := findPatternMatch(chunk, config); match != nil {
+		wlog.LogWithLine("[%s] [stream] deny pattern %s matched from chunk: %s", pluginName, match.MatchedWord, chunk)
+		return match
+	}
+
+	return nil
+}
+
+// selectBlockingEntry 从匹配器返回的字典索引中选出严重级别最高的拦截项
+// 动作为 log 的字典项只记录日志，动作为 replace 的字典项交由 MaskReplaceActionWords 处理
+func selectBlockingEntry(wordIndices []int, cfg *config.AiDataMaskingConfig) *MatchResult {
+	var selected *MatchResult
+	for _, idx := range wordIndices {
+		entry := cfg.DenyWordEntryAt(idx)
+		switch entry.Action {
+		case config.DenyActionLog:
+			wlog.LogWithLine("[%s] log-only deny word %s (category=%s) matched", pluginName, entry.Word, entry.Category)
+			continue
+		case config.DenyActionReplace:
+			continue
+		}
+		if selected == nil || entry.Severity > selected.Severity {
+			selected = &MatchResult{
+				MatchedWord: entry.Word,
+				StartPos:    -1,
+				EndPos:      -1,
+				Category:    entry.Category,
+				Severity:    entry.Severity,
+				Action:      config.DenyActionBlock,
+			}
+		}
+	}
+	return selected
+}
+
+// systemWordMatch 系统敏感词的命中结果，系统词库一律按 block 处理
+func systemWordMatch(word string) *MatchResult {
+	return &MatchResult{MatchedWord: word, StartPos: -1, EndPos: -1, Action: config.DenyActionBlock}
+}
+
+// selectBlockingMatch 从命中结果中选出严重级别最高的拦截命中
+func selectBlockingMatch(matches []MatchResult) *MatchResult {
+	var selected *MatchResult
+	for i := range matches {
+		if matches[i].Action != config.DenyActionBlock {
+			continue
+		}
+		if selected == nil || matches[i].Severity > selected.Severity {
+			selected = &matches[i]
+		}
+	}
+	return selected
+}
+
+// BlockingMatches 过滤出需要拦截的命中
+func BlockingMatches(matches []MatchResult) []MatchResult {
+	return filterMatchAction(matches, config.DenyActionBlock)
+}
+
+// filterMatchAction 过滤出指定动作的命中
+func filterMatchAction(matches []MatchResult, action string) []MatchResult {
+	filtered := make([]MatchResult, 0, len(matches))
+	for _, match := range matches {
+		if match.Action == action {
+			filtered = append(filtered, match)
+		}
+	}
+	return filtered
+}
+
+// maskableMatches 去掉仅记录日志的命中，剩余命中需要替换为掩码
+func maskableMatches(matches []MatchResult) []MatchResult {
+	filtered := make([]MatchResult, 0, len(matches))
+	for _, match := range matches {
+		if match.Action != config.DenyActionLog {
+			filtered = append(filtered, match)
+		}
+	}
+	return filtered
 }
 
 // MatchResult 敏感词匹配结果
@@ -106,10 +221,14 @@ type MatchResult struct {
 	MatchedWord string // 匹配到的敏感词
 	StartPos    int    // 匹配开始位置（字节位置）
 	EndPos      int    // 匹配结束位置（字节位置）
+	Category    string // 敏感词分类
+	Severity    int    // 严重级别
+	Action      string // 命中后的动作：block, replace, log
+	Pattern     bool   // 是否由拦截正则命中，此时 MatchedWord 为正则规则
 }
 
 // FindSensitiveWordMatches 查找文本中所有敏感词匹配的位置
-// 返回所有匹配的位置信息（按字节位置）
+// 匹配在归一化文本上进行，返回的位置已映射回原始文本（按字节位置）
 func FindSensitiveWordMatches(text string, config *config.AiDataMaskingConfig, systemDenyWords []string) []MatchResult {
 	if text == "" {
 		return nil
@@ -117,82 +236,73 @@ func FindSensitiveWordMatches(text string, config *config.AiDataMaskingConfig, s
 
 	// 预分配结果切片，减少内存分配
 	results := make([]MatchResult, 0, 16)
-	textBytes := []byte(text)
+	normalized := NormalizeText(text, &config.Normalize)
+	textBytes := []byte(normalized.Text)
 
 	// 检查自定义敏感词
 	if len(config.DenyWords) > 0 {
-		matcher := getOrBuildCustomMatcher(config.DenyWords)
-		matches := matcher.Match(textBytes)
-		if len(matches) > 0 {
-			// 使用 map 去重，避免重复处理同一个敏感词，预分配容量
-			processedWords := make(map[int]bool, len(matches))
-			for _, wordIdx := range matches {
-				if processedWords[wordIdx] {
-					continue
-				}
-				processedWords[wordIdx] = true
-				matchedWord := config.DenyWords[wordIdx]
-				// 使用优化的搜索方法
-				wordBytes := []byte(matchedWord)
-				wordLen := len(wordBytes)
-				start := 0
-				for {
-					pos := findBytesOptimized(textBytes[start:], wordBytes)
-					if pos == -1 {
-						break
-					}
-					actualPos := start + pos
-					results = append(results, MatchResult{
-						MatchedWord: matchedWord,
-						StartPos:    actualPos,
-						EndPos:      actualPos + wordLen,
-					})
-					start = actualPos + 1
-					if start >= len(textBytes) {
-						break
-					}
-				}
-			}
-		}
+		matcher, normalizedWords := getOrBuildCustomMatcher(config.DenyWords, &config.Normalize)
+		results = appendWordMatches(results, textBytes, normalized, matcher, config.DenyWords, normalizedWords, config.DenyWordEntryAt)
 	}
 
 	// 检查系统敏感词
 	if config.SystemDeny && len(systemDenyWords) > 0 {
-		matcher := getOrBuildSystemMatcher(systemDenyWords)
-		matches := matcher.Match(textBytes)
-		if len(matches) > 0 {
-			// 使用 map 去重，避免重复处理同一个敏感词，预分配容量
-			processedWords := make(map[int]bool, len(matches))
-			for _, wordIdx := range matches {
-				if processedWords[wordIdx] {
-					continue
-				}
-				processedWords[wordIdx] = true
-				matchedWord := systemDenyWords[wordIdx]
-				// 使用优化的搜索方法
-				wordBytes := []byte(matchedWord)
-				wordLen := len(wordBytes)
-				start := 0
-				for {
-					pos := findBytesOptimized(textBytes[start:], wordBytes)
-					if pos == -1 {
-						break
-					}
-					actualPos := start + pos
-					results = append(results, MatchResult{
-						MatchedWord: matchedWord,
-						StartPos:    actualPos,
-						EndPos:      actualPos + wordLen,
-					})
-					start = actualPos + 1
-					if start >= len(textBytes) {
-						break
-					}
-				}
+		matcher, normalizedWords := getOrBuildSystemMatcher(systemDenyWords, &config.Normalize)
+		results = appendWordMatches(results, textBytes, normalized, matcher, systemDenyWords, normalizedWords, nil)
+	}
+
+	// 检查拦截正则，正则直接在原始文本上匹配
+	results = appendPatternMatches(results, text, config)
+
+	// 去掉被白名单完整覆盖的命中
+	return filterAllowedMatches(text, normalized, results, config)
+}
+
+// appendWordMatches 使用字典匹配器查找所有命中的词，并定位每一次出现的位置
+// words 为原始字典（用于返回 MatchedWord），normalizedWords 为与之下标一致的归一化字典
+// entryAt 返回字典项的分类和动作，为 nil 时按 block 处理
+func appendWordMatches(results []MatchResult, textBytes []byte, normalized *NormalizedText, matcher *ahocorasick.Matcher, words, normalizedWords []string, entryAt func(int) config.DenyWordEntry) []MatchResult {
+	matches := matcher.Match(textBytes)
+	if len(matches) == 0 {
+		return results
+	}
+	// 使用 map 去重，避免重复处理同一个敏感词，预分配容量
+	processedWords := make(map[int]bool, len(matches))
+	for _, wordIdx := range matches {
+		if processedWords[wordIdx] {
+			continue
+		}
+		processedWords[wordIdx] = true
+		entry := config.DenyWordEntry{Action: config.DenyActionBlock}
+		if entryAt != nil {
+			entry = entryAt(wordIdx)
+		}
+		// 使用优化的搜索方法
+		wordBytes := []byte(normalizedWords[wordIdx])
+		wordLen := len(wordBytes)
+		start := 0
+		for {
+			pos := findBytesOptimized(textBytes[start:], wordBytes)
+			if pos == -1 {
+				break
+			}
+			actualPos := start + pos
+			// 映射回原始文本位置
+			origStart, origEnd := normalized.OriginalRange(actualPos, actualPos+wordLen)
+			results = append(results, MatchResult{
+				MatchedWord: words[wordIdx],
+				StartPos:    origStart,
+				EndPos:      origEnd,
+				Category:    entry.Category,
+				Severity:    entry.Severity,
+				Action:      entry.Action,
+			})
+			start = actualPos + 1
+			if start >= len(textBytes) {
+				break
 			}
 		}
 	}
-
 	return results
 }
 
@@ -253,12 +363,13 @@ func findBytesOptimized(haystack, needle []byte) int {
 }
 
 // getOrBuildCustomMatcher 获取或构建自定义敏感词匹配器
-func getOrBuildCustomMatcher(words []string) *ahocorasick.Matcher {
+// 匹配器基于归一化后的词构建，同时返回与原词下标一致的归一化词列表
+func getOrBuildCustomMatcher(words []string, opts *config.NormalizeConfig) (*ahocorasick.Matcher, []string) {
 	cacheMutex.RLock()
-	if customMatcherCache != nil && wordsEqual(customWordsCache, words) {
-		matcher := customMatcherCache
+	if customMatcherCache != nil && customNormalizeCache == *opts && wordsEqual(customWordsCache, words) {
+		matcher, normalizedWords := customMatcherCache, customNormalizedCache
 		cacheMutex.RUnlock()
-		return matcher
+		return matcher, normalizedWords
 	}
 	cacheMutex.RUnlock()
 
@@ -266,26 +377,30 @@ func getOrBuildCustomMatcher(words []string) *ahocorasick.Matcher {
 	defer cacheMutex.Unlock()
 
 	// 双重检查
-	if customMatcherCache != nil && wordsEqual(customWordsCache, words) {
-		return customMatcherCache
+	if customMatcherCache != nil && customNormalizeCache == *opts && wordsEqual(customWordsCache, words) {
+		return customMatcherCache, customNormalizedCache
 	}
 
 	// 构建新的匹配器
-	matcher := ahocorasick.NewStringMatcher(words)
+	normalizedWords := normalizeWords(words, opts)
+	matcher := ahocorasick.NewStringMatcher(normalizedWords)
 	customMatcherCache = matcher
 	customWordsCache = make([]string, len(words))
 	copy(customWordsCache, words)
+	customNormalizedCache = normalizedWords
+	customNormalizeCache = *opts
 
-	return matcher
+	return matcher, normalizedWords
 }
 
 // getOrBuildSystemMatcher 获取或构建系统敏感词匹配器
-func getOrBuildSystemMatcher(words []string) *ahocorasick.Matcher {
+// 匹配器基于归一化后的词构建，同时返回与原词下标一致的归一化词列表
+func getOrBuildSystemMatcher(words []string, opts *config.NormalizeConfig) (*ahocorasick.Matcher, []string) {
 	cacheMutex.RLock()
-	if systemMatcherCache != nil && wordsEqual(systemWordsCache, words) {
-		matcher := systemMatcherCache
+	if systemMatcherCache != nil && systemNormalizeCache == *opts && wordsEqual(systemWordsCache, words) {
+		matcher, normalizedWords := systemMatcherCache, systemNormalizedCache
 		cacheMutex.RUnlock()
-		return matcher
+		return matcher, normalizedWords
 	}
 	cacheMutex.RUnlock()
 
@@ -293,17 +408,35 @@ func getOrBuildSystemMatcher(words []string) *ahocorasick.Matcher {
 	defer cacheMutex.Unlock()
 
 	// 双重检查
-	if systemMatcherCache != nil && wordsEqual(systemWordsCache, words) {
-		return systemMatcherCache
+	if systemMatcherCache != nil && systemNormalizeCache == *opts && wordsEqual(systemWordsCache, words) {
+		return systemMatcherCache, systemNormalizedCache
 	}
 
 	// 构建新的匹配器
-	matcher := ahocorasick.NewStringMatcher(words)
+	// 系统词库整体替换、不会原地修改，直接引用即可，避免大词库的复制
+	normalizedWords := normalizeWords(words, opts)
+	matcher := ahocorasick.NewStringMatcher(normalizedWords)
 	systemMatcherCache = matcher
-	systemWordsCache = make([]string, len(words))
-	copy(systemWordsCache, words)
+	systemWordsCache = words
+	systemNormalizedCache = normalizedWords
+	systemNormalizeCache = *opts
 
-	return matcher
+	return matcher, normalizedWords
+}
+
+// rebuildSystemMatcher 使用新词库构建系统敏感词匹配器，构建完成后与词库一起原子替换
+// 构建期间请求仍使用旧的匹配器和词库
+func rebuildSystemMatcher(words []string, opts *config.NormalizeConfig) {
+	normalizedWords := normalizeWords(words, opts)
+	matcher := ahocorasick.NewStringMatcher(normalizedWords)
+
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	systemMatcherCache = matcher
+	systemWordsCache = words
+	systemNormalizedCache = normalizedWords
+	systemNormalizeCache = *opts
+	config.SetSystemDenyWords(words)
 }
 
 // wordsEqual 比较两个字符串切片是否相等
@@ -311,6 +444,10 @@ func wordsEqual(a, b []string) bool {
 	if len(a) != len(b) {
 		return false
 	}
+	// 同一个底层数组（系统词库未刷新）时无需逐个比较
+	if len(a) > 0 && &a[0] == &b[0] {
+		return true
+	}
 	for i := range a {
 		if a[i] != b[i] {
 			return false
@@ -2,6 +2,7 @@ package lib
 
 import (
 	"ai-data-masking/config"
+	"regexp"
 	"runtime"
 	"testing"
 )
@@ -50,14 +51,100 @@ func generateLongText(length int) string {
 	return result[:length]
 }
 
-// createTestConfig 创建测试配置
-func createTestConfig() *config.AiDataMaskingConfig {
-	return &config.AiDataMaskingConfig{
+// testConfigOption 覆盖测试配置默认值的选项
+type testConfigOption func(*config.AiDataMaskingConfig)
+
+// createTestConfig 创建测试配置，按顺序应用选项
+func createTestConfig(opts ...testConfigOption) *config.AiDataMaskingConfig {
+	cfg := &config.AiDataMaskingConfig{
 		DenyWords:    testDenyWords,
 		SystemDeny:   true,
 		DenyMessage:  "检测到敏感词",
 		StreamBuffer: 10 * 1024, // 10KB
 	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	return cfg
+}
+
+// withDenyWordEntries 以带分类、严重级别、动作的词条替换默认敏感词
+func withDenyWordEntries(entries ...config.DenyWordEntry) testConfigOption {
+	return func(cfg *config.AiDataMaskingConfig) {
+		cfg.DenyWords = nil
+		for _, entry := range entries {
+			cfg.DenyWords = append(cfg.DenyWords, entry.Word)
+		}
+		cfg.DenyWordEntries = entries
+	}
+}
+
+// withDenyPatterns 添加拦截正则
+func withDenyPatterns(patterns ...string) testConfigOption {
+	return func(cfg *config.AiDataMaskingConfig) {
+		for _, pattern := range patterns {
+			cfg.DenyPatterns = append(cfg.DenyPatterns, pattern)
+			cfg.CompiledDenyPatterns = append(cfg.CompiledDenyPatterns, regexp.MustCompile(pattern))
+		}
+	}
+}
+
+// withReplaceRoles 设置脱敏规则
+func withReplaceRoles(rules ...config.Rule) testConfigOption {
+	return func(cfg *config.AiDataMaskingConfig) {
+		cfg.ReplaceRoles = rules
+	}
+}
+
+// withRawPartMaxBytes 设置 multipart 文本 part 的最大检查字节数
+func withRawPartMaxBytes(maxBytes int) testConfigOption {
+	return func(cfg *config.AiDataMaskingConfig) {
+		cfg.RawPartMaxBytes = maxBytes
+	}
+}
+
+// withStreamHoldback 开启流式响应暂缓输出，设置最长暂缓时间（毫秒）
+func withStreamHoldback(maxDelay uint32) testConfigOption {
+	return func(cfg *config.AiDataMaskingConfig) {
+		cfg.StreamHoldback = true
+		cfg.StreamHoldbackMaxDelay = maxDelay
+	}
+}
+
+// withMaxSensitiveWordLength 设置流式处理的重叠边界长度（字节数）
+func withMaxSensitiveWordLength(length int) testConfigOption {
+	return func(cfg *config.AiDataMaskingConfig) {
+		cfg.MaxSensitiveWordLength = length
+	}
+}
+
+// withDenyMessage 设置拒绝消息模板
+func withDenyMessage(message string) testConfigOption {
+	return func(cfg *config.AiDataMaskingConfig) {
+		cfg.DenyMessage = message
+	}
+}
+
+// withDenyRawMessage 设置非 LLM 请求的拒绝响应模板及其 Content-Type
+func withDenyRawMessage(template, contentType string) testConfigOption {
+	return func(cfg *config.AiDataMaskingConfig) {
+		cfg.DenyRawMessage = template
+		cfg.DenyContentType = contentType
+	}
+}
+
+// withResponseJSONPath 设置非 LLM 请求检查的响应字段
+func withResponseJSONPath(paths ...string) testConfigOption {
+	return func(cfg *config.AiDataMaskingConfig) {
+		cfg.ResponseJSONPath = paths
+	}
+}
+
+// withDenyRaw 设置是否检查非 LLM 请求的整个请求体和响应体
+func withDenyRaw(enabled bool) testConfigOption {
+	return func(cfg *config.AiDataMaskingConfig) {
+		cfg.DenyRaw = enabled
+	}
 }
 
 // BenchmarkCheckMessage_NonStream 测试非流式检测性能
@@ -0,0 +1,196 @@
+package lib
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"ai-data-masking/config"
+
+	"github.com/higress-group/wasm-go/pkg/wrapper"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// requestChoiceCount 返回请求对应的响应中 choice 的数量：n（默认 1），旧版 Completions 的多个 prompt 各自生成 n 个 choice
+func requestChoiceCount(root gjson.Result) int {
+	count := 1
+	if n := root.Get("n").Int(); n > 1 {
+		count = int(n)
+	}
+	if prompt := root.Get("prompt"); prompt.IsArray() {
+		// 字符串数组或 token 数组的数组为多个 prompt，单个 token 数组只是一个 prompt
+		if first := prompt.Get("0"); first.Type == gjson.String || first.IsArray() {
+			count *= len(prompt.Array())
+		}
+	}
+	return count
+}
+
+// streamByChoice 是否按 choice 拆分流式响应：OpenAI 与旧版 Completions 请求多个 choice 时，
+// 各 choice 的增量交错出现在同一个流中，需要各自缓冲与检查，避免把不同 choice 的文本拼接后误判
+func streamByChoice(pluginCtx *config.PluginContext) bool {
+	if pluginCtx.RespIsNDJSON || pluginCtx.Protocol.HasAdapter() || pluginCtx.Protocol == config.APIProtocolEmbeddings {
+		return false
+	}
+	return pluginCtx.OpenAIRequest != nil && pluginCtx.OpenAIRequest.ChoiceCount > 1
+}
+
+// processChoiceStreams 将 chunk 中的事件按 choice 拆分为只包含单个 choice 的事件，换入各 choice 的缓冲区状态后分别交给 process 处理
+// choice 结束（finish_reason 不为空）或流结束时该 choice 的缓冲区全部输出；没有 choice 的事件（如 usage）与流结束标记在所有 choice 之后输出
+// 返回处理后的 chunk 以及本次是否有 choice 被拒绝，所有 choice 都被拒绝时结束整个流
+func processChoiceStreams(pluginCtx *config.PluginContext, chunk []byte, isLastChunk bool, process func([]byte, bool) ([]byte, bool)) ([]byte, bool) {
+	if pluginCtx.StreamChoices == nil {
+		pluginCtx.StreamChoices = make(map[int64]*config.StreamChoiceState)
+	}
+
+	var result strings.Builder
+	choiceEvents := make(map[int64]*strings.Builder)
+	choiceEnded := make(map[int64]bool)
+	streamEnded := isLastChunk
+	endEvent := ""
+	for _, eventStr := range strings.Split(strings.TrimSpace(string(wrapper.UnifySSEChunk(chunk))), "\n\n") {
+		if eventStr == "" {
+			continue
+		}
+		if isStreamEndEvent(eventStr) {
+			streamEnded = true
+			endEvent = eventStr + "\n\n"
+			break
+		}
+		jsonStr := sseEventData(eventStr)
+		choices := gjson.Get(jsonStr, "choices")
+		if !choices.IsArray() {
+			// 注释、错误等不属于任何 choice 的事件直接输出
+			result.WriteString(eventStr + "\n\n")
+			continue
+		}
+		items := choices.Array()
+		if len(items) == 0 {
+			pluginCtx.StreamChoiceTrailer += eventStr + "\n\n"
+			continue
+		}
+		for i, choice := range items {
+			index := int64(i)
+			if choice.Get("index").Exists() {
+				index = choice.Get("index").Int()
+			}
+			event, err := sjson.SetRaw(jsonStr, "choices", "["+choice.Raw+"]")
+			if err != nil {
+				continue
+			}
+			if i < len(items)-1 {
+				// usage 只保留在最后一个 choice 拆分出的事件中
+				event, _ = sjson.Delete(event, "usage")
+			}
+			if choiceEvents[index] == nil {
+				choiceEvents[index] = &strings.Builder{}
+			}
+			choiceEvents[index].WriteString(replaceSSEData(eventStr, event))
+			if choice.Get("finish_reason").String() != "" {
+				choiceEnded[index] = true
+			}
+		}
+	}
+
+	// 流结束时所有已知 choice 都需要输出剩余的缓冲区
+	indices := make([]int64, 0, len(pluginCtx.StreamChoices)+len(choiceEvents))
+	for index := range choiceEvents {
+		indices = append(indices, index)
+	}
+	if streamEnded {
+		for index := range pluginCtx.StreamChoices {
+			if choiceEvents[index] == nil {
+				indices = append(indices, index)
+			}
+		}
+	}
+	sort.Slice(indices, func(i, j int) bool { return indices[i] < indices[j] })
+
+	denied := false
+	for _, index := range indices {
+		state := pluginCtx.StreamChoices[index]
+		if state == nil {
+			state = &config.StreamChoiceState{}
+			pluginCtx.StreamChoices[index] = state
+		}
+		if state.Denied {
+			continue
+		}
+		var data []byte
+		if events := choiceEvents[index]; events != nil {
+			data = []byte(events.String())
+		}
+
+		loadChoiceState(pluginCtx, index, state)
+		output, choiceDenied := process(data, streamEnded || choiceEnded[index])
+		saveChoiceState(pluginCtx, state)
+
+		result.Write(output)
+		if choiceDenied && state.Denied {
+			denied = true
+			// 被拒绝的 choice 不再补发请求还原暂存的内容
+			prefix := fmt.Sprintf("%d.", index)
+			for pendingKey := range pluginCtx.StreamRestorePending {
+				if strings.HasPrefix(pendingKey, prefix) {
+					delete(pluginCtx.StreamRestorePending, pendingKey)
+				}
+			}
+		}
+	}
+
+	// 所有 choice 都被拒绝时结束整个流，之后的 chunk 不再处理
+	pluginCtx.StreamDenied = len(pluginCtx.StreamChoices) >= pluginCtx.OpenAIRequest.ChoiceCount
+	for _, state := range pluginCtx.StreamChoices {
+		if !state.Denied {
+			pluginCtx.StreamDenied = false
+			break
+		}
+	}
+	if pluginCtx.StreamDenied {
+		// 转发已收到的 usage，等待或补发 usage 后结束流
+		for _, eventStr := range strings.Split(pluginCtx.StreamChoiceTrailer, "\n\n") {
+			recordStreamUsage(pluginCtx, gjson.Parse(sseEventData(eventStr)))
+		}
+		pluginCtx.StreamChoiceTrailer = ""
+		result.WriteString(streamDenyEnd(pluginCtx, streamEnded))
+	} else if streamEnded {
+		result.WriteString(pluginCtx.StreamChoiceTrailer)
+		result.WriteString(endEvent)
+		pluginCtx.StreamChoiceTrailer = ""
+	}
+
+	if result.Len() == 0 {
+		return nil, denied
+	}
+	return []byte(result.String()), denied
+}
+
+// loadChoiceState 将 choice 的缓冲区状态换入 PluginContext 的流式缓冲区字段
+func loadChoiceState(pluginCtx *config.PluginContext, index int64, state *config.StreamChoiceState) {
+	pluginCtx.StreamInChoice = true
+	pluginCtx.StreamChoiceIndex = index
+	pluginCtx.StreamChunkBuffer = state.ChunkBuffer
+	pluginCtx.StreamChunkBufferSize = state.ChunkBufferSize
+	pluginCtx.StreamContentBuffer = state.ContentBuffer
+	pluginCtx.StreamReasoningBuffer = state.ReasoningBuffer
+	pluginCtx.StreamToolCallBuffer = state.ToolCallBuffer
+	pluginCtx.StreamContentBufferOffset = state.ContentBufferOffset
+	pluginCtx.StreamReasoningBufferOffset = state.ReasoningBufferOffset
+	pluginCtx.StreamToolCallBufferOffset = state.ToolCallBufferOffset
+	pluginCtx.StreamDenied = state.Denied
+}
+
+// saveChoiceState 将 PluginContext 的流式缓冲区字段保存回 choice 的状态
+func saveChoiceState(pluginCtx *config.PluginContext, state *config.StreamChoiceState) {
+	state.ChunkBuffer = pluginCtx.StreamChunkBuffer
+	state.ChunkBufferSize = pluginCtx.StreamChunkBufferSize
+	state.ContentBuffer = pluginCtx.StreamContentBuffer
+	state.ReasoningBuffer = pluginCtx.StreamReasoningBuffer
+	state.ToolCallBuffer = pluginCtx.StreamToolCallBuffer
+	state.ContentBufferOffset = pluginCtx.StreamContentBufferOffset
+	state.ReasoningBufferOffset = pluginCtx.StreamReasoningBufferOffset
+	state.ToolCallBufferOffset = pluginCtx.StreamToolCallBufferOffset
+	state.Denied = pluginCtx.StreamDenied
+	pluginCtx.StreamInChoice = false
+}
@@ -0,0 +1,150 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"ai-data-masking/config"
+
+	"github.com/tidwall/gjson"
+)
+
+// TestRequestChoiceCount 测试按 n 与 prompt 个数计算 choice 数量
+func TestRequestChoiceCount(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected int
+	}{
+		{name: "未指定 n", body: `{"messages":[]}`, expected: 1},
+		{name: "n 为 3", body: `{"n":3,"messages":[]}`, expected: 3},
+		{name: "多个字符串 prompt", body: `{"n":2,"prompt":["a","b"]}`, expected: 4},
+		{name: "单个 token 数组 prompt", body: `{"n":2,"prompt":[1,2,3]}`, expected: 2},
+		{name: "多个 token 数组 prompt", body: `{"prompt":[[1],[2],[3]]}`, expected: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := requestChoiceCount(gjson.Parse(tt.body)); got != tt.expected {
+				t.Errorf("期望 %d, 实际 %d", tt.expected, got)
+			}
+		})
+	}
+}
+
+// choiceStreamRequest 请求两个 choice 的流式请求
+var choiceStreamRequest = config.OpenAIRequest{Model: "gpt", Stream: true, ChoiceCount: 2}
+
+// choiceProcessor 模拟流式处理：各 choice 的增量累加到 content 缓冲区，流或 choice 结束时输出，
+// 缓冲区中出现“敏感”时以 streamDenyEvent 拒绝该 choice
+func choiceProcessor(pluginCtx *config.PluginContext, calls *[]string) func([]byte, bool) ([]byte, bool) {
+	return func(data []byte, ended bool) ([]byte, bool) {
+		var output strings.Builder
+		for _, eventStr := range strings.Split(strings.TrimSpace(string(data)), "\n\n") {
+			if eventStr == "" {
+				continue
+			}
+			content, _, _ := streamEventDeltas(pluginCtx, gjson.Parse(sseEventData(eventStr)))
+			pluginCtx.StreamContentBuffer += content
+			pluginCtx.StreamChunkBuffer = append(pluginCtx.StreamChunkBuffer, config.StreamChunk{Data: []byte(eventStr + "\n\n")})
+		}
+		*calls = append(*calls, fmt.Sprintf("%d:%s", pluginCtx.StreamChoiceIndex, pluginCtx.StreamContentBuffer))
+		if strings.Contains(pluginCtx.StreamContentBuffer, "敏感") {
+			pluginCtx.StreamDenied = true
+			return []byte(streamDenyEvent(pluginCtx, "已屏蔽")), true
+		}
+		if !ended {
+			return nil, false
+		}
+		for _, streamChunk := range pluginCtx.StreamChunkBuffer {
+			output.Write(streamChunk.Data)
+		}
+		pluginCtx.StreamChunkBuffer = nil
+		return []byte(output.String()), false
+	}
+}
+
+// TestProcessChoiceStreams 测试多个 choice 的事件拆分后独立缓冲，不会把不同 choice 的文本拼接在一起
+func TestProcessChoiceStreams(t *testing.T) {
+	pluginCtx := createTestPluginContext(withRequest(choiceStreamRequest))
+	var calls []string
+	process := choiceProcessor(pluginCtx, &calls)
+
+	chunk := `data: {"id":"c1","model":"gpt","choices":[{"index":0,"delta":{"content":"敏"}},{"index":1,"delta":{"content":"你好"}}]}` + "\n\n" +
+		`data: {"id":"c1","model":"gpt","choices":[{"index":1,"delta":{"content":"感"}}]}` + "\n\n"
+	output, denied := processChoiceStreams(pluginCtx, []byte(chunk), false, process)
+	if denied || output != nil {
+		t.Fatalf("跨 choice 拼接的文本不应命中: output=%q, denied=%v", output, denied)
+	}
+	if strings.Join(calls, "|") != "0:敏|1:你好感" {
+		t.Errorf("各 choice 的缓冲区不正确: %v", calls)
+	}
+
+	chunk = `data: {"id":"c1","model":"gpt","choices":[{"index":0,"delta":{"content":"世界"},"finish_reason":"stop"}]}` + "\n\n" +
+		`data: {"id":"c1","model":"gpt","choices":[],"usage":{"total_tokens":9}}` + "\n\n" +
+		"data: [DONE]\n\n"
+	output, denied = processChoiceStreams(pluginCtx, []byte(chunk), false, process)
+	if denied || pluginCtx.StreamDenied {
+		t.Fatalf("不应拒绝")
+	}
+	events := strings.Split(strings.TrimSpace(string(output)), "\n\n")
+	if len(events) != 6 {
+		t.Fatalf("期望 6 个事件, 实际: %q", output)
+	}
+	// 按 choice 顺序输出，usage 与 [DONE] 在最后
+	for i, expected := range []string{`"index":0,"delta":{"content":"敏"}`, `"index":0,"delta":{"content":"世界"}`, `"index":1,"delta":{"content":"你好"}`, `"index":1,"delta":{"content":"感"}`, `"usage"`, "[DONE]"} {
+		if !strings.Contains(events[i], expected) {
+			t.Errorf("第 %d 个事件应包含 %s, 实际: %s", i, expected, events[i])
+		}
+	}
+	if gjson.Get(sseEventData(events[0]), "choices.#").Int() != 1 || gjson.Get(sseEventData(events[0]), "id").String() != "c1" {
+		t.Errorf("拆分后的事件只包含一个 choice 并保留其他字段: %s", events[0])
+	}
+}
+
+// TestProcessChoiceStreams_Deny 测试只拒绝命中的 choice，所有 choice 都被拒绝后才结束整个流
+func TestProcessChoiceStreams_Deny(t *testing.T) {
+	for name, protocol := range map[string]config.APIProtocol{"Chat Completions": "", "旧版 Completions": config.APIProtocolCompletions} {
+		t.Run(name, func(t *testing.T) {
+			pluginCtx := createTestPluginContext(withRequest(choiceStreamRequest), withProtocol(protocol))
+			pluginCtx.StreamRestorePending = map[string]string{"0.content": "__MASK", "1.content": "__MA"}
+			var calls []string
+			process := choiceProcessor(pluginCtx, &calls)
+
+			event := func(index int, text string) string {
+				if protocol == config.APIProtocolCompletions {
+					return fmt.Sprintf(`data: {"id":"c2","object":"text_completion","model":"gpt","choices":[{"index":%d,"text":"%s"}]}`+"\n\n", index, text)
+				}
+				return fmt.Sprintf(`data: {"id":"c2","object":"chat.completion.chunk","model":"gpt","choices":[{"index":%d,"delta":{"content":"%s"}}]}`+"\n\n", index, text)
+			}
+
+			output, denied := processChoiceStreams(pluginCtx, []byte(event(1, "敏感")+event(0, "正常")), false, process)
+			if !denied || pluginCtx.StreamDenied {
+				t.Fatalf("应只拒绝 choice 1: denied=%v, streamDenied=%v", denied, pluginCtx.StreamDenied)
+			}
+			data := sseEventData(string(output))
+			textPath := "choices.0.delta.content"
+			if protocol == config.APIProtocolCompletions {
+				textPath = "choices.0.text"
+			}
+			if gjson.Get(data, "choices.0.index").Int() != 1 || gjson.Get(data, "choices.0.finish_reason").String() != "content_filter" ||
+				gjson.Get(data, textPath).String() != "已屏蔽" || gjson.Get(data, "id").String() != "c2" {
+				t.Errorf("拒绝事件不正确: %s", output)
+			}
+			if _, ok := pluginCtx.StreamRestorePending["1.content"]; ok || pluginCtx.StreamRestorePending["0.content"] == "" {
+				t.Errorf("只应丢弃被拒绝 choice 暂存的内容: %v", pluginCtx.StreamRestorePending)
+			}
+
+			// 被拒绝的 choice 后续事件丢弃
+			calls = nil
+			if output, _ = processChoiceStreams(pluginCtx, []byte(event(1, "后续")), false, process); output != nil || len(calls) != 0 {
+				t.Errorf("被拒绝的 choice 不应继续处理: %q, %v", output, calls)
+			}
+
+			output, denied = processChoiceStreams(pluginCtx, []byte(event(0, "敏感")), false, process)
+			if !denied || !pluginCtx.StreamDenied || !strings.HasSuffix(string(output), "data: [DONE]\n\n") {
+				t.Errorf("所有 choice 都被拒绝后应结束流: %q, streamDenied=%v", output, pluginCtx.StreamDenied)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"ai-data-masking/config"
+
+	"github.com/google/uuid"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+const (
+	// completionsPath OpenAI 旧版 Completions 接口路径
+	completionsPath = "/v1/completions"
+	// embeddingsPath OpenAI Embeddings 接口路径
+	embeddingsPath = "/v1/embeddings"
+)
+
+// IsCompletionsPath 判断请求路径是否为 OpenAI 旧版 Completions 接口（不包括 /v1/chat/completions），忽略查询参数
+func IsCompletionsPath(path string) bool {
+	path, _, _ = strings.Cut(path, "?")
+	return strings.HasSuffix(strings.TrimSuffix(path, "/"), completionsPath)
+}
+
+// IsEmbeddingsPath 判断请求路径是否为 OpenAI Embeddings 接口，忽略查询参数
+func IsEmbeddingsPath(path string) bool {
+	path, _, _ = strings.Cut(path, "?")
+	return strings.HasSuffix(strings.TrimSuffix(path, "/"), embeddingsPath)
+}
+
+// processLegacyOpenAIRequest 处理 Completions 的 prompt、suffix 与 Embeddings 的 input
+// 字符串数组逐个元素处理，token 数组保持不变
+// 返回处理后的请求体、是否修改、是否拒绝
+func processLegacyOpenAIRequest(pluginCtx *config.PluginContext, bodyStr string, root gjson.Result) ([]byte, bool, bool) {
+	field := "prompt"
+	if pluginCtx.Protocol == config.APIProtocolEmbeddings {
+		field = "input"
+	}
+	if !root.Get(field).Exists() {
+		return []byte(bodyStr), false, false
+	}
+
+	// 初始化 OpenAIRequest（如果为 nil），模型与是否流式与 OpenAI 请求共用
+	if pluginCtx.OpenAIRequest == nil {
+		pluginCtx.OpenAIRequest = &config.OpenAIRequest{}
+	}
+	pluginCtx.OpenAIRequest.Stream = root.Get("stream").Bool()
+	pluginCtx.OpenAIRequest.Model = root.Get("model").String()
+	pluginCtx.OpenAIRequest.ChoiceCount = requestChoiceCount(root)
+	pluginCtx.OpenAIRequest.IncludeUsage = root.Get("stream_options.include_usage").Bool()
+
+	fields := appendStringFields(nil, field, root.Get(field))
+	if pluginCtx.Protocol == config.APIProtocolCompletions {
+		fields = appendStringFields(fields, "suffix", root.Get("suffix"))
+	}
+
+	bodyStr, modified, denied := processRequestFields(pluginCtx, bodyStr, fields)
+	return []byte(bodyStr), modified, denied
+}
+
+// appendStringFields 添加字符串字段，或字符串数组中的各个字符串元素
+func appendStringFields(fields []textField, path string, value gjson.Result) []textField {
+	if value.Type == gjson.String {
+		return append(fields, textField{path: path, text: value.String()})
+	}
+	if value.IsArray() {
+		value.ForEach(func(key, item gjson.Result) bool {
+			if item.Type == gjson.String {
+				fields = append(fields, textField{path: fmt.Sprintf("%s.%d", path, key.Int()), text: item.String()})
+			}
+			return true
+		})
+	}
+	return fields
+}
+
+// processCompletionsResponse 处理旧版 Completions 非流式响应，检查各 choice 的 text
+// 返回处理后的响应体、是否修改、是否拒绝
+func processCompletionsResponse(pluginCtx *config.PluginContext, bodyStr string, body []byte) ([]byte, bool, bool) {
+	root := gjson.Parse(bodyStr)
+	if !root.Get("choices.0.text").Exists() {
+		return body, false, false
+	}
+
+	newBodyStr, modified, denied := processResponseFields(pluginCtx, bodyStr, completionsResponseFields(root))
+	if denied {
+		return body, modified, denied
+	}
+	return []byte(newBodyStr), modified, denied
+}
+
+// completionsResponseFields 返回旧版 Completions 响应中各 choice 的 text 字段
+func completionsResponseFields(root gjson.Result) []textField {
+	var fields []textField
+	root.Get("choices").ForEach(func(key, choice gjson.Result) bool {
+		fields = append(fields, textField{path: fmt.Sprintf("choices.%d.text", key.Int()), text: choice.Get("text").String()})
+		return true
+	})
+	return fields
+}
+
+// completionsEventDeltas 累加旧版 Completions 流式事件中各 choice 的 text 增量
+func completionsEventDeltas(root gjson.Result) string {
+	var content string
+	root.Get("choices").ForEach(func(_, choice gjson.Result) bool {
+		content += choice.Get("text").String()
+		return true
+	})
+	return content
+}
+
+// completionsDenyBody 构造内容为拒绝消息的 text_completion 对象，流式事件与非流式响应共用
+func completionsDenyBody(model, message string) string {
+	response, _ := sjson.Set(`{"object":"text_completion"}`, "id", "cmpl-"+strings.ReplaceAll(uuid.New().String(), "-", ""))
+	response, _ = sjson.Set(response, "created", time.Now().Unix())
+	response, _ = sjson.Set(response, "model", model)
+	response, _ = sjson.SetRaw(response, "choices", `[{"text":"","index":0,"logprobs":null,"finish_reason":"stop"}]`)
+	response, _ = sjson.Set(response, "choices.0.text", message)
+	return response
+}
+
+// CompletionsDenyResponse 构造旧版 Completions 的拒绝响应，stream 为 true 时返回以 [DONE] 结束的 SSE 事件
+func CompletionsDenyResponse(model, message string, stream bool) []byte {
+	data := completionsDenyBody(model, message)
+	if stream {
+		return []byte("data: " + data + "\n\ndata: [DONE]\n\n")
+	}
+	data, _ = sjson.SetRaw(data, "usage", `{"prompt_tokens":0,"completion_tokens":0,"total_tokens":0}`)
+	return []byte(data)
+}
+
+// EmbeddingsDenyResponse 构造 Embeddings 的拒绝响应：没有可以承载拒绝消息的文本字段，按 OpenAI 错误格式返回
+func EmbeddingsDenyResponse(message string) []byte {
+	response, _ := sjson.Set(`{"error":{"type":"invalid_request_error","param":"input","code":"content_filter"}}`, "error.message", message)
+	return []byte(response)
+}
@@ -0,0 +1,200 @@
+package lib
+
+import (
+	"ai-data-masking/config"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+// TestIsCompletionsPath 测试旧版 Completions 与 Embeddings 接口路径识别
+func TestIsCompletionsPath(t *testing.T) {
+	tests := []struct {
+		path        string
+		completions bool
+		embeddings  bool
+	}{
+		{path: "/v1/completions", completions: true},
+		{path: "/openai/v1/completions?api-version=1", completions: true},
+		{path: "/v1/chat/completions"},
+		{path: "/v1/embeddings", embeddings: true},
+		{path: "/v1/embeddings/", embeddings: true},
+		{path: "/v1/responses"},
+	}
+	for _, tt := range tests {
+		if got := IsCompletionsPath(tt.path); got != tt.completions {
+			t.Errorf("IsCompletionsPath(%s): 期望 %v, 实际 %v", tt.path, tt.completions, got)
+		}
+		if got := IsEmbeddingsPath(tt.path); got != tt.embeddings {
+			t.Errorf("IsEmbeddingsPath(%s): 期望 %v, 实际 %v", tt.path, tt.embeddings, got)
+		}
+	}
+}
+
+// TestProcessOpenAIRequest_Legacy 测试 Completions 的 prompt、suffix 与 Embeddings 的 input 脱敏，token 数组保持不变
+func TestProcessOpenAIRequest_Legacy(t *testing.T) {
+	tests := []struct {
+		name     string
+		protocol config.APIProtocol
+		body     string
+		expected map[string]string
+	}{
+		{
+			name:     "prompt 字符串与 suffix",
+			protocol: config.APIProtocolCompletions,
+			body:     `{"model":"gpt-3.5-turbo-instruct","stream":true,"prompt":"回拨 13812345678","suffix":"抄送 13900001111"}`,
+			expected: map[string]string{"prompt": "回拨 ****", "suffix": "抄送 ****"},
+		},
+		{
+			name:     "prompt 数组",
+			protocol: config.APIProtocolCompletions,
+			body:     `{"model":"gpt-3.5-turbo-instruct","prompt":["回拨 13812345678",[1,2,3]]}`,
+			expected: map[string]string{"prompt.0": "回拨 ****", "prompt.1": "[1,2,3]"},
+		},
+		{
+			name:     "embeddings input 字符串",
+			protocol: config.APIProtocolEmbeddings,
+			body:     `{"model":"text-embedding-3-small","input":"客户电话 13812345678"}`,
+			expected: map[string]string{"input": "客户电话 ****"},
+		},
+		{
+			name:     "embeddings input 数组",
+			protocol: config.APIProtocolEmbeddings,
+			body:     `{"model":"text-embedding-3-small","input":["客户电话 13812345678","无敏感信息"]}`,
+			expected: map[string]string{"input.0": "客户电话 ****", "input.1": "无敏感信息"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pluginCtx := &config.PluginContext{
+				Config: &config.AiDataMaskingConfig{
+					ReplaceRoles: []config.Rule{{Type: config.RuleTypeReplace, Value: "****", CompiledRegex: regexp.MustCompile(`1\d{10}`)}},
+				},
+				MaskMap:  make(map[string]*string),
+				Protocol: tt.protocol,
+			}
+			newBody, modified, denied := ProcessOpenAIRequest(nil, pluginCtx, []byte(tt.body))
+			if denied || !modified {
+				t.Fatalf("modified=%v, denied=%v", modified, denied)
+			}
+			for path, want := range tt.expected {
+				value := gjson.GetBytes(newBody, path)
+				got := value.String()
+				if value.IsArray() {
+					got = value.Raw
+				}
+				if got != want {
+					t.Errorf("%s: 期望 %q, 实际 %q", path, want, got)
+				}
+			}
+			if pluginCtx.OpenAIRequest.Model != gjson.Get(tt.body, "model").String() ||
+				pluginCtx.OpenAIRequest.Stream != gjson.Get(tt.body, "stream").Bool() {
+				t.Errorf("请求信息不正确: %+v", pluginCtx.OpenAIRequest)
+			}
+		})
+	}
+}
+
+// TestProcessOpenAIResponse_Completions 测试旧版 Completions 响应 text 的还原，Embeddings 响应保持不变
+func TestProcessOpenAIResponse_Completions(t *testing.T) {
+	pluginCtx := createTestPluginContext(withMaskMap(map[string]string{"<PHONE_1>": "13812345678"}))
+	pluginCtx.Protocol = config.APIProtocolCompletions
+	body := `{"object":"text_completion","choices":[{"text":"已记录 <PHONE_1>","index":0,"finish_reason":"stop"},{"text":"备用 <PHONE_1>","index":1}]}`
+	newBody, modified, denied := ProcessOpenAIResponse(nil, pluginCtx, body, []byte(body))
+	if denied || !modified {
+		t.Fatalf("modified=%v, denied=%v", modified, denied)
+	}
+	if got := gjson.GetBytes(newBody, "choices.1.text").String(); got != "备用 13812345678" {
+		t.Errorf("还原不正确: %q", got)
+	}
+
+	pluginCtx.Protocol = config.APIProtocolEmbeddings
+	body = `{"object":"list","data":[{"object":"embedding","index":0,"embedding":[0.1,0.2]}]}`
+	if _, modified, denied := ProcessOpenAIResponse(nil, pluginCtx, body, []byte(body)); modified || denied {
+		t.Errorf("Embeddings 响应不应处理: modified=%v, denied=%v", modified, denied)
+	}
+}
+
+// TestWriteReplacedChunks_Completions 测试旧版 Completions 流式 text 增量的提取与回写
+func TestWriteReplacedChunks_Completions(t *testing.T) {
+	pluginCtx := &config.PluginContext{Config: &config.AiDataMaskingConfig{}, Protocol: config.APIProtocolCompletions}
+	events := []string{
+		`{"object":"text_completion","choices":[{"text":"机","index":0,"finish_reason":null}]}`,
+		`{"object":"text_completion","choices":[{"text":"密号码","index":0,"finish_reason":null}]}`,
+	}
+	for _, event := range events {
+		contentStart := len(pluginCtx.StreamContentBuffer)
+		content, _, _ := streamEventDeltas(pluginCtx, gjson.Parse(event))
+		pluginCtx.StreamContentBuffer += content
+		pluginCtx.StreamChunkBuffer = append(pluginCtx.StreamChunkBuffer, config.StreamChunk{
+			Data:         []byte("data: " + event + "\n\n"),
+			ContentStart: contentStart,
+			ContentEnd:   len(pluginCtx.StreamContentBuffer),
+		})
+	}
+	if pluginCtx.StreamContentBuffer != "机密号码" {
+		t.Fatalf("增量提取不正确: %q", pluginCtx.StreamContentBuffer)
+	}
+
+	var result strings.Builder
+	writeReplacedChunks(&result, pluginCtx, "**号码", "", "")
+	var text strings.Builder
+	for _, event := range strings.Split(strings.TrimSpace(result.String()), "\n\n") {
+		text.WriteString(gjson.Get(sseEventData(event), "choices.0.text").String())
+	}
+	if text.String() != "**号码" {
+		t.Errorf("替换回写不正确: %q", text.String())
+	}
+}
+
+// TestRestoreStreamResponse_Completions 测试旧版 Completions 流式响应中被拆分的占位符在 [DONE] 之前补发
+func TestRestoreStreamResponse_Completions(t *testing.T) {
+	pluginCtx := createTestPluginContext(withMaskMap(map[string]string{"<PHONE_1>": "13812345678"}))
+	pluginCtx.Protocol = config.APIProtocolCompletions
+	chunks := []string{
+		"data: {\"object\":\"text_completion\",\"choices\":[{\"text\":\"请拨打 <PHO\",\"index\":0,\"finish_reason\":null}]}\n\n",
+		"data: {\"object\":\"text_completion\",\"choices\":[{\"text\":\"NE_1> 或 <PH\",\"index\":0,\"finish_reason\":null}]}\n\n",
+		"data: [DONE]\n\n",
+	}
+	var output strings.Builder
+	for i, chunk := range chunks {
+		output.Write(RestoreStreamResponse(pluginCtx, []byte(chunk), i == len(chunks)-1))
+	}
+	var text strings.Builder
+	for _, event := range strings.Split(strings.TrimSpace(output.String()), "\n\n") {
+		text.WriteString(gjson.Get(sseEventData(event), "choices.0.text").String())
+	}
+	if text.String() != "请拨打 13812345678 或 <PH" {
+		t.Errorf("还原不正确: %q", text.String())
+	}
+	if !strings.HasSuffix(output.String(), "data: [DONE]\n\n") {
+		t.Errorf("应以 [DONE] 结束: %s", output.String())
+	}
+}
+
+// TestLegacyDenyResponse 测试旧版 Completions 与 Embeddings 的拒绝响应
+func TestLegacyDenyResponse(t *testing.T) {
+	pluginCtx := &config.PluginContext{
+		Protocol:      config.APIProtocolCompletions,
+		OpenAIRequest: &config.OpenAIRequest{Model: "gpt-3.5-turbo-instruct"},
+	}
+	response := gjson.ParseBytes(ProtocolDenyResponse(pluginCtx, "已屏蔽", false))
+	if response.Get("object").String() != "text_completion" || response.Get("choices.0.text").String() != "已屏蔽" ||
+		response.Get("model").String() != "gpt-3.5-turbo-instruct" || !response.Get("usage").Exists() {
+		t.Errorf("Completions 拒绝响应格式不正确: %s", response.Raw)
+	}
+
+	stream := string(ProtocolDenyResponse(pluginCtx, "已屏蔽", true))
+	events := strings.Split(strings.TrimSpace(stream), "\n\n")
+	if len(events) != 2 || gjson.Get(sseEventData(events[0]), "choices.0.text").String() != "已屏蔽" || events[1] != "data: [DONE]" {
+		t.Errorf("Completions 流式拒绝响应格式不正确: %s", stream)
+	}
+
+	pluginCtx.Protocol = config.APIProtocolEmbeddings
+	response = gjson.ParseBytes(ProtocolDenyResponse(pluginCtx, "已屏蔽", false))
+	if response.Get("error.message").String() != "已屏蔽" || response.Get("error.code").String() != "content_filter" {
+		t.Errorf("Embeddings 拒绝响应格式不正确: %s", response.Raw)
+	}
+}
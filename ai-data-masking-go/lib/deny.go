@@ -0,0 +1,122 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"ai-data-masking/config"
+
+	"github.com/google/uuid"
+)
+
+// denyTemplateVariable 拒绝消息模板中的变量：${name}
+var denyTemplateVariable = regexp.MustCompile(`\$\{(\w+)\}`)
+
+// denyTemplateVars 返回拒绝消息模板可以引用的变量：请求 ID、命中分类、处理阶段、模型、时间戳（秒）
+func denyTemplateVars(pluginCtx *config.PluginContext) map[string]string {
+	model := ""
+	if pluginCtx.OpenAIRequest != nil {
+		model = pluginCtx.OpenAIRequest.Model
+	}
+	return map[string]string{
+		"request_id": pluginCtx.RequestID,
+		"category":   pluginCtx.DenyCategory,
+		"step":       pluginCtx.Step.String(),
+		"model":      model,
+		"timestamp":  strconv.FormatInt(time.Now().Unix(), 10),
+	}
+}
+
+// renderDenyTemplate 替换模板中的变量，escape 不为空时变量值先经过 escape 处理；未知的变量保持原样
+func renderDenyTemplate(template string, vars map[string]string, escape func(string) string) string {
+	return denyTemplateVariable.ReplaceAllStringFunc(template, func(variable string) string {
+		value, ok := vars[variable[2:len(variable)-1]]
+		if !ok {
+			return variable
+		}
+		if escape != nil {
+			value = escape(value)
+		}
+		return value
+	})
+}
+
+// RenderDenyMessage 渲染 deny_message 中的变量，返回纯文本，由各协议的拒绝响应按 JSON 编码写入
+func RenderDenyMessage(pluginCtx *config.PluginContext) string {
+	message := pluginCtx.Config.DenyMessage
+	if message == "" {
+		message = "提问或回答中包含敏感词，已被屏蔽"
+	}
+	return renderDenyTemplate(message, denyTemplateVars(pluginCtx), nil)
+}
+
+// RenderDenyRawMessage 渲染 JSONPath、Raw 模式拦截时返回的 deny_raw_message，模板中还可以通过 ${message} 引用渲染后的 deny_message
+// deny_content_type 为 JSON 时变量值按 JSON 字符串内容转义，无论变量位于字符串中还是作为数字使用，结果都是合法的 JSON
+func RenderDenyRawMessage(pluginCtx *config.PluginContext) []byte {
+	vars := denyTemplateVars(pluginCtx)
+	vars["message"] = RenderDenyMessage(pluginCtx)
+	var escape func(string) string
+	if isJSONContentType(pluginCtx.Config.DenyContentType) {
+		escape = func(value string) string {
+			quoted := jsonQuote(value)
+			return quoted[1 : len(quoted)-1]
+		}
+	}
+	return []byte(renderDenyTemplate(pluginCtx.Config.DenyRawMessage, vars, escape))
+}
+
+// DenyContentType 返回拒绝响应的 Content-Type：JSONPath、Raw 模式使用 deny_content_type，其他协议的拒绝响应为 JSON
+func DenyContentType(pluginCtx *config.PluginContext, modifyType config.DenyModifyType) string {
+	if modifyType == config.DenyModifyTypeJSONPath || modifyType == config.DenyModifyTypeRaw {
+		return pluginCtx.Config.DenyContentType
+	}
+	return "application/json"
+}
+
+// isJSONContentType 判断 Content-Type 是否为 JSON（application/json 或 +json 后缀）
+func isJSONContentType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(strings.ToLower(contentType), ";")
+	mediaType = strings.TrimSpace(mediaType)
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// OpenAIDenyResponse 构造 OpenAI Chat Completions 的拒绝响应，stream 为 true 时返回以 [DONE] 结束的 SSE 事件
+func OpenAIDenyResponse(model, message string, stream bool) []byte {
+	id := "chatcmpl-" + strings.ReplaceAll(uuid.New().String(), "-", "")
+	created := time.Now().Unix()
+	if stream {
+		streamJson, _ := json.Marshal(config.OpenAIStreamCompletionResponse{
+			Id:      id,
+			Object:  "chat.completion.chunk",
+			Created: created,
+			Model:   model,
+			Choices: []config.OpenAIStreamChoice{
+				{
+					Index:        0,
+					Delta:        &config.OpenAIMessage{Role: "assistant", Content: message},
+					FinishReason: config.FINISH_REASON_STOP,
+				},
+			},
+		})
+		return []byte(fmt.Sprintf("data: %s\n\ndata: [DONE]\n\n", streamJson))
+	}
+	responseJson, _ := json.Marshal(config.OpenAICompletionResponse{
+		Id:      id,
+		Object:  "chat.completion",
+		Created: created,
+		Model:   model,
+		Choices: []config.OpenAICompletionChoice{
+			{
+				Index:        0,
+				Message:      &config.OpenAIMessage{Role: "assistant", Content: message},
+				FinishReason: config.FINISH_REASON_STOP,
+			},
+		},
+		Usage: &config.OpenAIUsage{},
+	})
+	return responseJson
+}
@@ -0,0 +1,103 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"ai-data-masking/config"
+
+	"github.com/tidwall/gjson"
+)
+
+// denyTestRequest 模型名中带有需要转义的引号
+var denyTestRequest = config.OpenAIRequest{Model: `gpt-"4o"`}
+
+// TestRenderDenyMessage 测试 deny_message 中的变量替换
+func TestRenderDenyMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		message  string
+		expected string
+	}{
+		{name: "默认消息", message: "", expected: "提问或回答中包含敏感词，已被屏蔽"},
+		{name: "请求 ID 与分类", message: "请求 ${request_id} 命中 ${category}", expected: "请求 req-1 命中 政治"},
+		{name: "模型", message: "${model} 拒绝回答", expected: `gpt-"4o" 拒绝回答`},
+		{name: "未知变量保持原样", message: "${unknown} ${request_id}", expected: "${unknown} req-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pluginCtx := createTestPluginContext(withConfig(createTestConfig(withDenyMessage(tt.message))),
+				withRequest(denyTestRequest), withDenyInfo("req-1", "政治"))
+			if got := RenderDenyMessage(pluginCtx); got != tt.expected {
+				t.Errorf("期望 %q, 实际 %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestRenderDenyRawMessage 测试 deny_raw_message 按 deny_content_type 转义变量值
+func TestRenderDenyRawMessage(t *testing.T) {
+	template := `{"code":403,"msg":"${message}","model":"${model}","request_id":"${request_id}","time":${timestamp}}`
+
+	t.Run("JSON 转义", func(t *testing.T) {
+		pluginCtx := createTestPluginContext(withConfig(createTestConfig(withDenyMessage("包含\"引号\"和\\反斜杠\n换行"), withDenyRawMessage(template, "application/json; charset=utf-8"))),
+			withRequest(denyTestRequest), withDenyInfo("req-1", "政治"))
+		body := string(RenderDenyRawMessage(pluginCtx))
+		if !gjson.Valid(body) {
+			t.Fatalf("不是合法的 JSON: %s", body)
+		}
+		if gjson.Get(body, "msg").String() != "包含\"引号\"和\\反斜杠\n换行" || gjson.Get(body, "model").String() != `gpt-"4o"` ||
+			gjson.Get(body, "request_id").String() != "req-1" {
+			t.Errorf("变量值不正确: %s", body)
+		}
+		if timestamp := gjson.Get(body, "time").Int(); timestamp < time.Now().Unix()-60 {
+			t.Errorf("时间戳不正确: %d", timestamp)
+		}
+	})
+
+	t.Run("非 JSON 不转义", func(t *testing.T) {
+		pluginCtx := createTestPluginContext(withConfig(createTestConfig(withDenyMessage(`"已屏蔽"`), withDenyRawMessage("拒绝：${message}", "text/plain"))),
+			withRequest(denyTestRequest), withDenyInfo("req-1", "政治"))
+		if got := string(RenderDenyRawMessage(pluginCtx)); got != `拒绝："已屏蔽"` {
+			t.Errorf("实际 %q", got)
+		}
+	})
+}
+
+// TestDenyContentType 测试拒绝响应的 Content-Type
+func TestDenyContentType(t *testing.T) {
+	pluginCtx := createTestPluginContext(withConfig(createTestConfig(withDenyRawMessage("", "text/plain"))),
+		withRequest(denyTestRequest), withDenyInfo("req-1", "政治"))
+	if got := DenyContentType(pluginCtx, config.DenyModifyTypeRaw); got != "text/plain" {
+		t.Errorf("Raw 模式应使用 deny_content_type: %q", got)
+	}
+	if got := DenyContentType(pluginCtx, config.DenyModifyTypeOpenAI); got != "application/json" {
+		t.Errorf("OpenAI 模式应为 application/json: %q", got)
+	}
+}
+
+// TestOpenAIDenyResponse 测试 OpenAI 拒绝响应为合法的 JSON，并使用真实的创建时间
+func TestOpenAIDenyResponse(t *testing.T) {
+	message := `包含"引号"的消息`
+	body := string(OpenAIDenyResponse(`gpt-"4o"`, message, false))
+	if !gjson.Valid(body) {
+		t.Fatalf("不是合法的 JSON: %s", body)
+	}
+	if gjson.Get(body, "choices.0.message.content").String() != message || gjson.Get(body, "model").String() != `gpt-"4o"` {
+		t.Errorf("响应不正确: %s", body)
+	}
+	if created := gjson.Get(body, "created").Int(); created < time.Now().Unix()-60 {
+		t.Errorf("创建时间不正确: %d", created)
+	}
+
+	stream := string(OpenAIDenyResponse("gpt", message, true))
+	events := strings.Split(strings.TrimSpace(stream), "\n\n")
+	if len(events) != 2 || events[1] != "data: [DONE]" {
+		t.Fatalf("流式响应不正确: %q", stream)
+	}
+	data := sseEventData(events[0])
+	if !gjson.Valid(data) || gjson.Get(data, "choices.0.delta.content").String() != message || gjson.Get(data, "object").String() != "chat.completion.chunk" {
+		t.Errorf("流式事件不正确: %s", data)
+	}
+}
@@ -0,0 +1,123 @@
+package lib
+
+import (
+	"ai-data-masking/config"
+	"testing"
+)
+
+// testDenyWordEntries 带分类、严重级别、动作的敏感词
+var testDenyWordEntries = []config.DenyWordEntry{
+	{Word: "违规内容", Category: "illegal", Severity: 1, Action: config.DenyActionBlock},
+	{Word: "不良信息", Category: "porn", Severity: 3, Action: config.DenyActionBlock},
+	{Word: "内部代号", Category: "internal", Action: config.DenyActionReplace},
+	{Word: "观察词", Category: "watch", Action: config.DenyActionLog},
+}
+
+// TestFindSensitiveWordMatches_Entry 测试命中结果携带分类、严重级别和动作
+func TestFindSensitiveWordMatches_Entry(t *testing.T) {
+	cfg := createTestConfig(withDenyWordEntries(testDenyWordEntries...))
+
+	tests := []struct {
+		name     string
+		text     string
+		category string
+		severity int
+		action   string
+	}{
+		{name: "拦截", text: "这是不良信息", category: "porn", severity: 3, action: config.DenyActionBlock},
+		{name: "替换", text: "项目内部代号", category: "internal", action: config.DenyActionReplace},
+		{name: "仅记录", text: "一个观察词", category: "watch", action: config.DenyActionLog},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := FindSensitiveWordMatches(tt.text, cfg, nil)
+			if len(results) != 1 {
+				t.Fatalf("期望命中 1 个敏感词，实际 %d 个", len(results))
+			}
+			got := results[0]
+			if got.Category != tt.category || got.Severity != tt.severity || got.Action != tt.action {
+				t.Errorf("期望 (%s, %d, %s), 实际 (%s, %d, %s)",
+					tt.category, tt.severity, tt.action, got.Category, got.Severity, got.Action)
+			}
+		})
+	}
+}
+
+// TestSelectBlockingMatch 测试同时命中多个词时选择严重级别最高的拦截词
+func TestSelectBlockingMatch(t *testing.T) {
+	cfg := createTestConfig(withDenyWordEntries(testDenyWordEntries...))
+
+	results := FindSensitiveWordMatches("违规内容、不良信息、内部代号和观察词", cfg, nil)
+	if blocking := BlockingMatches(results); len(blocking) != 2 {
+		t.Fatalf("期望 2 个拦截命中，实际 %d 个", len(blocking))
+	}
+	selected := selectBlockingMatch(results)
+	if selected == nil || selected.Category != "porn" {
+		t.Errorf("期望选择分类 porn, 实际 %+v", selected)
+	}
+
+	if selectBlockingMatch(FindSensitiveWordMatches("内部代号和观察词", cfg, nil)) != nil {
+		t.Errorf("replace/log 动作的命中不应拦截")
+	}
+}
+
+// TestMaskReplaceActionWords 测试只替换动作为 replace 的敏感词
+func TestMaskReplaceActionWords(t *testing.T) {
+	cfg := createTestConfig(withDenyWordEntries(testDenyWordEntries...))
+
+	got := MaskReplaceActionWords("内部代号和观察词", cfg, nil)
+	if got != "****和观察词" {
+		t.Errorf("默认掩码替换结果不正确: %q", got)
+	}
+
+	cfg.ResponseDenyPlot.Value = "#"
+	got = MaskReplaceActionWords("内部代号和观察词", cfg, nil)
+	if got != "####和观察词" {
+		t.Errorf("自定义掩码替换结果不正确: %q", got)
+	}
+}
+
+// TestReplaceSensitiveWordsWithValue_LogAction 测试替换模式下仅记录日志的词不被替换
+func TestReplaceSensitiveWordsWithValue_LogAction(t *testing.T) {
+	cfg := createTestConfig(withDenyWordEntries(testDenyWordEntries...))
+
+	got := ReplaceSensitiveWordsWithValue("违规内容和观察词", cfg, nil, "*")
+	if got != "****和观察词" {
+		t.Errorf("替换结果不正确: %q", got)
+	}
+}
+
+// TestReplaceRequestDenyWords 测试 request_deny_plot 为 replace 时只替换拦截词，replace 动作的词仍按 deny_plot 掩码
+func TestReplaceRequestDenyWords(t *testing.T) {
+	cfg := createTestConfig(withDenyWordEntries(testDenyWordEntries...))
+	cfg.ResponseDenyPlot.Value = "#"
+	cfg.RequestDenyPlot = config.DenyPlot{Plot: "replace", Type: config.DenyPlotTypeValue}
+
+	got := ReplaceRequestDenyWords("违规内容、内部代号和观察词", cfg, nil)
+	if got != "****、内部代号和观察词" {
+		t.Errorf("默认掩码替换结果不正确: %q", got)
+	}
+
+	cfg.RequestDenyPlot.Value = "X"
+	got = ReplaceRequestDenyWords("违规内容、内部代号和观察词", cfg, nil)
+	if got != "XXXX、内部代号和观察词" {
+		t.Errorf("自定义掩码替换结果不正确: %q", got)
+	}
+
+	cfg.RequestDenyPlot.Type = config.DenyPlotTypeMask
+	cfg.RequestDenyPlot.MaskOptions = config.MaskOptions{KeepLeft: 1}
+	got = ReplaceRequestDenyWords("这是不良信息", cfg, nil)
+	if got != "这是不***" {
+		t.Errorf("部分掩码结果不正确: %q", got)
+	}
+
+	pluginCtx := &config.PluginContext{Config: cfg, IsRequestModified: true}
+	if got = maskMessage("违规内容和内部代号", pluginCtx); got != "违***和####" {
+		t.Errorf("请求改写后的脱敏结果不正确: %q", got)
+	}
+	pluginCtx.IsRequestModified = false
+	if got = maskMessage("违规内容和内部代号", pluginCtx); got != "违规内容和####" {
+		t.Errorf("未改写时不应替换拦截词: %q", got)
+	}
+}
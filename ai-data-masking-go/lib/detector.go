@@ -0,0 +1,198 @@
+package lib
+
+import (
+	"ai-data-masking/config"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// detector 带校验的敏感信息识别器：先用候选正则找出可能的值，再通过校验过滤误报
+type detector struct {
+	pattern  string            // 候选正则，规则未配置 regex 时使用
+	validate func(string) bool // 校验命中的值是否真实有效
+}
+
+// detectors 按规则类型注册的识别器
+var detectors = map[string]detector{
+	config.RuleTypeIDCard:   {pattern: `\b(?:\d{17}[0-9Xx]|\d{15})\b`, validate: ValidateIDCard},
+	config.RuleTypeBankCard: {pattern: `\b\d{14,19}\b`, validate: ValidateBankCard},
+	config.RuleTypeMobile:   {pattern: `(?:\+86[- ]?|\b)1\d{10}\b`, validate: ValidateMobile},
+	config.RuleTypeIPv4:     {pattern: `\b\d{1,3}(?:\.\d{1,3}){3}\b`, validate: ValidateIPv4},
+	config.RuleTypeIPv6:     {pattern: ipv6CandidatePattern, validate: ValidateIPv6},
+	config.RuleTypeIP:       {pattern: ipv6CandidatePattern + `|\b\d{1,3}(?:\.\d{1,3}){3}\b`, validate: ValidateIP},
+}
+
+// ipv6CandidatePattern IPv6 候选：至少包含两个冒号的十六进制串，末尾允许内嵌 IPv4
+const ipv6CandidatePattern = `(?:[0-9A-Fa-f]{0,4}:){2,7}(?:\d{1,3}(?:\.\d{1,3}){3}|[0-9A-Fa-f]{1,4})?`
+
+// DetectorPattern 返回规则类型对应识别器的候选正则，非识别器类型返回空字符串
+func DetectorPattern(ruleType string) string {
+	return detectors[ruleType].pattern
+}
+
+// detectorValidator 返回规则类型对应的校验函数，非识别器类型返回 nil
+func detectorValidator(ruleType string) func(string) bool {
+	return detectors[ruleType].validate
+}
+
+// idCardRegions 身份证号前两位的省级行政区划代码
+var idCardRegions = map[string]bool{
+	"11": true, "12": true, "13": true, "14": true, "15": true,
+	"21": true, "22": true, "23": true,
+	"31": true, "32": true, "33": true, "34": true, "35": true, "36": true, "37": true,
+	"41": true, "42": true, "43": true, "44": true, "45": true, "46": true,
+	"50": true, "51": true, "52": true, "53": true, "54": true,
+	"61": true, "62": true, "63": true, "64": true, "65": true,
+	"71": true, "81": true, "82": true, "83": true, "91": true,
+}
+
+// idCardWeights ISO 7064 MOD 11-2 前 17 位的加权因子
+var idCardWeights = [17]int{7, 9, 10, 5, 8, 4, 2, 1, 6, 3, 7, 9, 10, 5, 8, 4, 2}
+
+// idCardCheckCodes 加权和模 11 对应的校验码
+const idCardCheckCodes = "10X98765432"
+
+// ValidateIDCard 校验居民身份证号：行政区划代码、出生日期，18 位号码还需校验 ISO 7064 MOD 11-2 校验码
+func ValidateIDCard(value string) bool {
+	var birth string
+	switch {
+	case len(value) == 18 && isDigits(value[:17]):
+		birth = value[6:14]
+	case len(value) == 15 && isDigits(value):
+		birth = "19" + value[6:12]
+	default:
+		return false
+	}
+	if !idCardRegions[value[:2]] {
+		return false
+	}
+	date, err := time.Parse("20060102", birth)
+	if err != nil || date.Year() < 1900 {
+		return false
+	}
+	// 15 位旧号码没有校验码
+	if len(value) == 15 {
+		return true
+	}
+
+	sum := 0
+	for i := 0; i < 17; i++ {
+		sum += int(value[i]-'0') * idCardWeights[i]
+	}
+	return strings.ToUpper(value[17:]) == string(idCardCheckCodes[sum%11])
+}
+
+// ValidateBankCard 校验银行卡号：发卡行识别码（BIN）范围与 Luhn 校验
+func ValidateBankCard(value string) bool {
+	if len(value) < 14 || len(value) > 19 || !isDigits(value) {
+		return false
+	}
+	return isKnownCardBIN(value) && luhnValid(value)
+}
+
+// isKnownCardBIN 判断卡号前缀是否属于已知卡组织的 BIN 范围及其对应长度
+func isKnownCardBIN(number string) bool {
+	prefix2 := atoiPrefix(number, 2)
+	prefix3 := atoiPrefix(number, 3)
+	prefix4 := atoiPrefix(number, 4)
+	length := len(number)
+
+	switch {
+	case prefix2 == 62: // 银联
+		return length >= 16
+	case number[0] == '4': // Visa
+		return length == 16 || length == 19
+	case prefix2 >= 51 && prefix2 <= 55, prefix4 >= 2221 && prefix4 <= 2720: // Mastercard
+		return length == 16
+	case prefix2 == 34 || prefix2 == 37: // American Express
+		return length == 15
+	case prefix4 >= 3528 && prefix4 <= 3589: // JCB
+		return length >= 16
+	case prefix4 == 6011, prefix2 == 65, prefix3 >= 644 && prefix3 <= 649: // Discover
+		return length >= 16
+	case prefix2 == 36, prefix2 == 38, prefix3 >= 300 && prefix3 <= 305: // Diners Club
+		return length >= 14
+	}
+	return false
+}
+
+// luhnValid Luhn 校验
+func luhnValid(number string) bool {
+	sum := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		d := int(number[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// ValidateMobile 校验中国大陆手机号号段，允许 +86 前缀
+func ValidateMobile(value string) bool {
+	number := strings.TrimPrefix(value, "+86")
+	number = strings.TrimLeft(number, "- ")
+	if len(number) != 11 || !isDigits(number) || number[0] != '1' {
+		return false
+	}
+	second, third := number[1], number[2]
+	switch second {
+	case '3', '8':
+		return true
+	case '4':
+		return third >= '5' && third <= '9'
+	case '5', '9':
+		return third != '4'
+	case '6':
+		return third == '2' || (third >= '5' && third <= '7')
+	case '7':
+		return third <= '8'
+	}
+	return false
+}
+
+// ValidateIPv4 校验 IPv4 地址，每段在 0-255 范围内且不含前导零
+func ValidateIPv4(value string) bool {
+	addr, err := netip.ParseAddr(value)
+	return err == nil && addr.Is4()
+}
+
+// ValidateIPv6 校验 IPv6 地址
+func ValidateIPv6(value string) bool {
+	addr, err := netip.ParseAddr(value)
+	return err == nil && addr.Is6() && addr.Zone() == ""
+}
+
+// ValidateIP 校验 IPv4 或 IPv6 地址
+func ValidateIP(value string) bool {
+	return ValidateIPv4(value) || ValidateIPv6(value)
+}
+
+// isDigits 是否全部为 ASCII 数字
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// atoiPrefix 将数字串的前 n 位转换为整数，调用方保证全部为数字
+func atoiPrefix(s string, n int) int {
+	if len(s) < n {
+		return -1
+	}
+	v := 0
+	for i := 0; i < n; i++ {
+		v = v*10 + int(s[i]-'0')
+	}
+	return v
+}
@@ -0,0 +1,105 @@
+package lib
+
+import (
+	"ai-data-masking/config"
+	"regexp"
+	"testing"
+)
+
+// TestValidators 测试各识别器的校验逻辑
+func TestValidators(t *testing.T) {
+	tests := []struct {
+		name     string
+		validate func(string) bool
+		value    string
+		expected bool
+	}{
+		{name: "身份证-有效", validate: ValidateIDCard, value: "11010519491231002X", expected: true},
+		{name: "身份证-小写x", validate: ValidateIDCard, value: "11010519491231002x", expected: true},
+		{name: "身份证-校验码错误", validate: ValidateIDCard, value: "110105194912310021", expected: false},
+		{name: "身份证-行政区划错误", validate: ValidateIDCard, value: "99010519491231002X", expected: false},
+		{name: "身份证-日期错误", validate: ValidateIDCard, value: "110105194913310028", expected: false},
+		{name: "身份证-15位", validate: ValidateIDCard, value: "110105491231002", expected: true},
+		{name: "银行卡-银联", validate: ValidateBankCard, value: "6222021234567890128", expected: true},
+		{name: "银行卡-Visa", validate: ValidateBankCard, value: "4111111111111111", expected: true},
+		{name: "银行卡-Luhn错误", validate: ValidateBankCard, value: "4111111111111112", expected: false},
+		{name: "银行卡-未知BIN", validate: ValidateBankCard, value: "1234567890123452", expected: false},
+		{name: "手机号-有效", validate: ValidateMobile, value: "13812345678", expected: true},
+		{name: "手机号-带区号", validate: ValidateMobile, value: "+86 19912345678", expected: true},
+		{name: "手机号-无效号段", validate: ValidateMobile, value: "12012345678", expected: false},
+		{name: "手机号-154号段", validate: ValidateMobile, value: "15412345678", expected: false},
+		{name: "IPv4-有效", validate: ValidateIPv4, value: "192.168.1.1", expected: true},
+		{name: "IPv4-越界", validate: ValidateIPv4, value: "256.1.1.1", expected: false},
+		{name: "IPv4-前导零", validate: ValidateIPv4, value: "01.1.1.1", expected: false},
+		{name: "IPv6-有效", validate: ValidateIPv6, value: "2001:db8::1", expected: true},
+		{name: "IPv6-时间", validate: ValidateIPv6, value: "12:30:45", expected: false},
+		{name: "IP-IPv4", validate: ValidateIP, value: "10.0.0.1", expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.validate(tt.value); got != tt.expected {
+				t.Errorf("%s: 期望 %v, 实际 %v", tt.value, tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestReplaceMessage_Detector 测试识别器类型的规则只改写通过校验的值
+func TestReplaceMessage_Detector(t *testing.T) {
+	tests := []struct {
+		name     string
+		ruleType string
+		text     string
+		expected string
+	}{
+		{
+			name:     "身份证",
+			ruleType: config.RuleTypeIDCard,
+			text:     "证件 11010519491231002X，订单 110105194912310021",
+			expected: "证件 ****，订单 110105194912310021",
+		},
+		{
+			name:     "银行卡",
+			ruleType: config.RuleTypeBankCard,
+			text:     "卡号 6222021234567890128，时间戳 1700000000000000",
+			expected: "卡号 ****，时间戳 1700000000000000",
+		},
+		{
+			name:     "手机号",
+			ruleType: config.RuleTypeMobile,
+			text:     "电话 13812345678，编号 12012345678",
+			expected: "电话 ****，编号 12012345678",
+		},
+		{
+			name:     "相同子串未通过校验时保持原样",
+			ruleType: config.RuleTypeMobile,
+			text:     "手机13812345678，订单号913812345678000",
+			expected: "手机****，订单号913812345678000",
+		},
+		{
+			name:     "IP",
+			ruleType: config.RuleTypeIP,
+			text:     "来自 10.0.0.1 和 2001:db8::1，版本 300.1.1.1，时间 12:30:45",
+			expected: "来自 **** 和 ****，版本 300.1.1.1，时间 12:30:45",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pluginCtx := &config.PluginContext{
+				Config: &config.AiDataMaskingConfig{
+					ReplaceRoles: []config.Rule{{
+						Type:          tt.ruleType,
+						Value:         "****",
+						CompiledRegex: regexp.MustCompile(DetectorPattern(tt.ruleType)),
+					}},
+				},
+				MaskMap: make(map[string]*string),
+			}
+			if got := ReplaceMessage(tt.text, pluginCtx); got != tt.expected {
+				t.Errorf("期望 %q, 实际 %q", tt.expected, got)
+			}
+		})
+	}
+}
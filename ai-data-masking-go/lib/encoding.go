@@ -0,0 +1,254 @@
+package lib
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/adler32"
+	"hash/crc32"
+	"io"
+	"strings"
+
+	"ai-data-masking/config"
+
+	"github.com/andybalholm/brotli"
+)
+
+// errDecodedTooLarge 解压后的内容超过响应体大小限制
+var errDecodedTooLarge = fmt.Errorf("decoded data exceeds %d bytes", config.DEFAULT_MAX_BODY_BYTES)
+
+// NormalizeContentEncoding 返回响应使用的压缩编码：gzip、deflate、br 返回规范名称，其他编码（如 zstd）返回小写名称，解压时返回错误；
+// 未压缩（identity）时返回空字符串
+func NormalizeContentEncoding(contentEncoding string) string {
+	encoding := strings.ToLower(strings.TrimSpace(contentEncoding))
+	switch encoding {
+	case "gzip", "x-gzip":
+		return "gzip"
+	case "", "identity":
+		return ""
+	}
+	return encoding
+}
+
+// newDecoder 按压缩编码构造解压 reader
+// deflate 按规范应为 zlib 格式，部分服务端直接返回原始 deflate 数据，zlib 头校验失败时按原始 deflate 解压
+func newDecoder(encoding string, data []byte) (io.Reader, error) {
+	switch encoding {
+	case "gzip":
+		return gzip.NewReader(bytes.NewReader(data))
+	case "deflate":
+		if reader, err := zlib.NewReader(bytes.NewReader(data)); !errors.Is(err, zlib.ErrHeader) {
+			return reader, err
+		}
+		return flate.NewReader(bytes.NewReader(data)), nil
+	case "br":
+		return brotli.NewReader(bytes.NewReader(data)), nil
+	}
+	return nil, fmt.Errorf("unsupported content-encoding: %s", encoding)
+}
+
+// DecodeBody 解压缓冲的完整响应体，解压后超过 DEFAULT_MAX_BODY_BYTES 时返回错误，避免压缩炸弹占满内存
+func DecodeBody(encoding string, body []byte) ([]byte, error) {
+	reader, err := newDecoder(encoding, body)
+	if err != nil {
+		return nil, err
+	}
+	decoded, err := io.ReadAll(io.LimitReader(reader, int64(config.DEFAULT_MAX_BODY_BYTES)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(decoded) > int(config.DEFAULT_MAX_BODY_BYTES) {
+		return nil, errDecodedTooLarge
+	}
+	return decoded, nil
+}
+
+// newStreamDecoder 按压缩编码构造流式响应的解压器
+func newStreamDecoder(encoding string) (config.StreamDecoder, error) {
+	switch encoding {
+	case "gzip":
+		return &deflateStreamDecoder{format: "gzip"}, nil
+	case "deflate":
+		return &deflateStreamDecoder{format: "deflate"}, nil
+	case "br":
+		decoder := &brotliStreamDecoder{}
+		decoder.reader = brotli.NewReader(&decoder.pending)
+		return decoder, nil
+	}
+	return nil, fmt.Errorf("unsupported content-encoding: %s", encoding)
+}
+
+// DecodeStreamChunk 解压流式响应的 chunk，返回本次新增的解压内容
+// 解压器保留状态，每个 chunk 只处理新收到的压缩数据；chunk 边界与服务端刷新位置不一致时，不完整的部分等待后续 chunk
+func DecodeStreamChunk(pluginCtx *config.PluginContext, chunk []byte, isLastChunk bool) ([]byte, error) {
+	if pluginCtx.StreamDecoder == nil {
+		decoder, err := newStreamDecoder(pluginCtx.RespContentEncoding)
+		if err != nil {
+			return nil, err
+		}
+		pluginCtx.StreamDecoder = decoder
+	}
+	return pluginCtx.StreamDecoder.Decode(chunk, isLastChunk)
+}
+
+// deflateStreamDecoder gzip、deflate（zlib 格式或原始 deflate 数据）流式解压器
+type deflateStreamDecoder struct {
+	format     string // gzip 或 deflate
+	headerDone bool
+	checksum   hash.Hash32 // gzip 为 CRC-32，zlib 为 Adler-32，原始 deflate 数据没有校验
+	inflater
+}
+
+// Decode 解析 gzip/zlib 头后解压 DEFLATE 数据，压缩流结束后校验尾部的校验和
+func (d *deflateStreamDecoder) Decode(data []byte, final bool) ([]byte, error) {
+	if !d.headerDone {
+		d.in = append(d.in, data...)
+		data = nil
+		headerLen, err := d.headerLen()
+		if err != nil {
+			return nil, err
+		}
+		if headerLen < 0 {
+			if final {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, nil
+		}
+		d.in = d.in[headerLen:]
+		d.headerDone = true
+	}
+	decoded, err := d.inflate(data, int(config.DEFAULT_MAX_BODY_BYTES))
+	if d.checksum != nil {
+		d.checksum.Write(decoded)
+	}
+	if err == nil && d.state == inflateDone {
+		err = d.verifyTrailer(final)
+	} else if err == nil && final {
+		err = io.ErrUnexpectedEOF
+	}
+	return decoded, err
+}
+
+// verifyTrailer 校验 gzip（CRC-32、长度）或 zlib（Adler-32）尾部，数据不足时等待后续数据
+func (d *deflateStreamDecoder) verifyTrailer(final bool) error {
+	if d.checksum == nil {
+		return nil
+	}
+	trailer := d.in[d.pos:]
+	if d.format == "gzip" {
+		if len(trailer) < 8 {
+			return unexpectedEOFIf(final)
+		}
+		if binary.LittleEndian.Uint32(trailer) != d.checksum.Sum32() {
+			return gzip.ErrChecksum
+		}
+	} else {
+		if len(trailer) < 4 {
+			return unexpectedEOFIf(final)
+		}
+		if binary.BigEndian.Uint32(trailer) != d.checksum.Sum32() {
+			return zlib.ErrChecksum
+		}
+	}
+	// 校验通过，之后的数据不再处理
+	d.checksum = nil
+	return nil
+}
+
+// unexpectedEOFIf 压缩流已经结束（final）时数据不完整返回 io.ErrUnexpectedEOF，否则等待后续数据
+func unexpectedEOFIf(final bool) error {
+	if final {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// headerLen 返回 gzip/zlib 头的长度，数据不足时返回 -1；deflate 的 zlib 头校验失败时按原始 deflate 数据处理
+func (d *deflateStreamDecoder) headerLen() (int, error) {
+	in := d.in
+	if d.format == "deflate" {
+		if len(in) < 2 {
+			return -1, nil
+		}
+		if in[0]&0x0f == 8 && in[1]&0x20 == 0 && (int(in[0])<<8|int(in[1]))%31 == 0 {
+			d.checksum = adler32.New()
+			return 2, nil
+		}
+		return 0, nil
+	}
+
+	if len(in) < 10 {
+		return -1, nil
+	}
+	if in[0] != 0x1f || in[1] != 0x8b || in[2] != 8 {
+		return 0, gzip.ErrHeader
+	}
+	flags := in[3]
+	n := 10
+	if flags&0x04 != 0 { // FEXTRA
+		if len(in) < n+2 {
+			return -1, nil
+		}
+		n += 2 + (int(in[n]) | int(in[n+1])<<8)
+	}
+	for _, flag := range []byte{0x08, 0x10} { // FNAME、FCOMMENT，以 0 结尾
+		if flags&flag == 0 {
+			continue
+		}
+		if n >= len(in) {
+			return -1, nil
+		}
+		end := bytes.IndexByte(in[n:], 0)
+		if end < 0 {
+			return -1, nil
+		}
+		n += end + 1
+	}
+	if flags&0x02 != 0 { // FHCRC
+		n += 2
+	}
+	if n > len(in) {
+		return -1, nil
+	}
+	d.checksum = crc32.NewIEEE()
+	return n, nil
+}
+
+// brotliStreamDecoder brotli 流式解压器
+// brotli.Reader 在输入不足时返回 io.ErrUnexpectedEOF，解压状态保持不变，收到后续数据后可以继续读取
+type brotliStreamDecoder struct {
+	pending bytes.Buffer // 已收到、尚未被 reader 读取的压缩数据
+	reader  *brotli.Reader
+	done    bool
+}
+
+// Decode 追加压缩数据并读取已能解压的内容
+func (d *brotliStreamDecoder) Decode(data []byte, final bool) ([]byte, error) {
+	d.pending.Write(data)
+	var decoded bytes.Buffer
+	buf := make([]byte, 32*1024)
+	for !d.done {
+		n, err := d.reader.Read(buf)
+		decoded.Write(buf[:n])
+		if decoded.Len() > int(config.DEFAULT_MAX_BODY_BYTES) {
+			return nil, errDecodedTooLarge
+		}
+		switch {
+		case err == io.EOF:
+			d.done = true
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			if final {
+				return decoded.Bytes(), err
+			}
+			return decoded.Bytes(), nil
+		case err != nil:
+			return decoded.Bytes(), err
+		}
+	}
+	return decoded.Bytes(), nil
+}
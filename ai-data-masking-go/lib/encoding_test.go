@@ -0,0 +1,195 @@
+package lib
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strings"
+	"testing"
+
+	"ai-data-masking/config"
+
+	"github.com/andybalholm/brotli"
+)
+
+// compressParts 按编码压缩各段数据，每段之后刷新，返回完整的压缩数据以及各段结束的位置
+func compressParts(t *testing.T, encoding string, parts []string) ([]byte, []int) {
+	var buf bytes.Buffer
+	var writer interface {
+		io.WriteCloser
+		Flush() error
+	}
+	switch encoding {
+	case "gzip":
+		writer = gzip.NewWriter(&buf)
+	case "deflate":
+		writer = zlib.NewWriter(&buf)
+	case "raw-deflate":
+		writer, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	case "br":
+		writer = brotli.NewWriter(&buf)
+	}
+	var offsets []int
+	for _, part := range parts {
+		if _, err := writer.Write([]byte(part)); err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		offsets = append(offsets, buf.Len())
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), offsets
+}
+
+// TestNormalizeContentEncoding 测试压缩编码识别
+func TestNormalizeContentEncoding(t *testing.T) {
+	tests := []struct {
+		contentEncoding string
+		expected        string
+	}{
+		{contentEncoding: "gzip", expected: "gzip"},
+		{contentEncoding: "X-GZIP", expected: "gzip"},
+		{contentEncoding: "deflate", expected: "deflate"},
+		{contentEncoding: " br ", expected: "br"},
+		{contentEncoding: "zstd", expected: "zstd"},
+		{contentEncoding: "identity", expected: ""},
+		{contentEncoding: "", expected: ""},
+	}
+	for _, tt := range tests {
+		if got := NormalizeContentEncoding(tt.contentEncoding); got != tt.expected {
+			t.Errorf("%q: 期望 %q, 实际 %q", tt.contentEncoding, tt.expected, got)
+		}
+	}
+}
+
+// TestDecodeBody 测试各编码完整响应体的解压，deflate 同时支持 zlib 格式与原始 deflate 数据
+func TestDecodeBody(t *testing.T) {
+	body := `{"choices":[{"message":{"role":"assistant","content":"项目内部代号"}}]}`
+	tests := []struct {
+		name     string
+		encoding string
+		compress string
+	}{
+		{name: "gzip", encoding: "gzip", compress: "gzip"},
+		{name: "deflate zlib 格式", encoding: "deflate", compress: "deflate"},
+		{name: "deflate 原始数据", encoding: "deflate", compress: "raw-deflate"},
+		{name: "brotli", encoding: "br", compress: "br"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, _ := compressParts(t, tt.compress, []string{body})
+			decoded, err := DecodeBody(tt.encoding, data)
+			if err != nil || string(decoded) != body {
+				t.Errorf("解压不正确: %q, err=%v", decoded, err)
+			}
+			if _, err := DecodeBody(tt.encoding, data[:len(data)/2]); err == nil {
+				t.Errorf("不完整的数据应返回错误")
+			}
+		})
+	}
+
+	if _, err := DecodeBody("zstd", []byte("data")); err == nil {
+		t.Errorf("不支持的编码应返回错误")
+	}
+}
+
+// TestDecodeStreamChunk 测试流式压缩响应逐个 chunk 解压，chunk 边界与刷新位置不一致时延后输出
+func TestDecodeStreamChunk(t *testing.T) {
+	parts := []string{"data: {\"a\":1}\n\n", "data: {\"b\":2}\n\n", "data: [DONE]\n\n"}
+	for _, encoding := range []string{"gzip", "deflate", "br"} {
+		t.Run(encoding, func(t *testing.T) {
+			data, offsets := compressParts(t, encoding, parts)
+			// 第一段按刷新位置拆分，第二段从中间拆分
+			boundaries := []int{offsets[0], offsets[1] - 2, offsets[1], len(data)}
+			pluginCtx := &config.PluginContext{RespContentEncoding: encoding}
+			var output strings.Builder
+			start := 0
+			for i, end := range boundaries {
+				decoded, err := DecodeStreamChunk(pluginCtx, data[start:end], i == len(boundaries)-1)
+				if err != nil {
+					t.Fatalf("chunk %d 解压失败: %v", i, err)
+				}
+				if i == 0 && string(decoded) != parts[0] {
+					t.Errorf("刷新位置的 chunk 应完整输出: %q", decoded)
+				}
+				output.Write(decoded)
+				start = end
+			}
+			if output.String() != strings.Join(parts, "") {
+				t.Errorf("解压结果不正确: %q", output.String())
+			}
+		})
+	}
+
+	t.Run("逐字节输入", func(t *testing.T) {
+		// 较长且重复的文本，覆盖动态霍夫曼块与长度/距离引用
+		var longParts []string
+		for i := 0; i < 200; i++ {
+			longParts = append(longParts, "data: {\"choices\":[{\"delta\":{\"content\":\"第"+strings.Repeat("段", i%7)+"内容\"}}]}\n\n")
+		}
+		for _, encoding := range []string{"gzip", "deflate", "raw-deflate", "br"} {
+			data, _ := compressParts(t, encoding, longParts)
+			contentEncoding := encoding
+			if encoding == "raw-deflate" {
+				contentEncoding = "deflate"
+			}
+			pluginCtx := &config.PluginContext{RespContentEncoding: contentEncoding}
+			var output strings.Builder
+			for i := range data {
+				decoded, err := DecodeStreamChunk(pluginCtx, data[i:i+1], i == len(data)-1)
+				if err != nil {
+					t.Fatalf("%s 第 %d 字节解压失败: %v", encoding, i, err)
+				}
+				output.Write(decoded)
+			}
+			if output.String() != strings.Join(longParts, "") {
+				t.Errorf("%s 解压结果不正确", encoding)
+			}
+		}
+	})
+
+	t.Run("未压缩块", func(t *testing.T) {
+		var buf bytes.Buffer
+		writer, _ := flate.NewWriter(&buf, flate.NoCompression)
+		writer.Write([]byte(strings.Join(parts, "")))
+		writer.Close()
+		data := buf.Bytes()
+		pluginCtx := &config.PluginContext{RespContentEncoding: "deflate"}
+		first, err := DecodeStreamChunk(pluginCtx, data[:len(data)/2], false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rest, err := DecodeStreamChunk(pluginCtx, data[len(data)/2:], true)
+		if err != nil || string(first)+string(rest) != strings.Join(parts, "") {
+			t.Errorf("解压结果不正确: %q%q, err=%v", first, rest, err)
+		}
+	})
+
+	t.Run("不支持的编码", func(t *testing.T) {
+		pluginCtx := &config.PluginContext{RespContentEncoding: "zstd"}
+		if _, err := DecodeStreamChunk(pluginCtx, []byte("data"), false); err == nil {
+			t.Errorf("不支持的编码应返回错误")
+		}
+	})
+
+	t.Run("超过解压大小限制", func(t *testing.T) {
+		data, _ := compressParts(t, "raw-deflate", []string{strings.Repeat("a", 1000)})
+		if _, err := (&inflater{}).inflate(data, 100); err != errDecodedTooLarge {
+			t.Errorf("期望返回 errDecodedTooLarge, 实际 %v", err)
+		}
+	})
+
+	t.Run("最后一个 chunk 不完整", func(t *testing.T) {
+		data, _ := compressParts(t, "gzip", parts)
+		pluginCtx := &config.PluginContext{RespContentEncoding: "gzip"}
+		if _, err := DecodeStreamChunk(pluginCtx, data[:len(data)-4], true); err == nil {
+			t.Errorf("不完整的压缩流应返回错误")
+		}
+	})
+}
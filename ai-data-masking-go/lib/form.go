@@ -0,0 +1,124 @@
+package lib
+
+import (
+	"bufio"
+	"mime"
+	"net/textproto"
+	"net/url"
+	"strings"
+
+	"ai-data-masking/config"
+)
+
+const (
+	formContentType      = "application/x-www-form-urlencoded"
+	multipartContentType = "multipart/form-data"
+)
+
+// processFormRequest 处理 application/x-www-form-urlencoded 请求体，逐个检查 URL 解码后的值
+// 脱敏后的值重新编码，未修改的键值对保持原样
+// 返回处理后的请求体、是否修改、是否拒绝
+func processFormRequest(pluginCtx *config.PluginContext, bodyStr string) ([]byte, bool, bool) {
+	pairs := strings.Split(bodyStr, "&")
+	modified := false
+	for i, pair := range pairs {
+		key, value, found := strings.Cut(pair, "=")
+		if !found || value == "" {
+			continue
+		}
+		decoded, err := url.QueryUnescape(value)
+		if err != nil {
+			// 编码不合法的值按原始文本处理
+			decoded = value
+		}
+		if checkRequestDeny(pluginCtx, decoded) {
+			return []byte(bodyStr), modified, true
+		}
+		if masked := maskMessage(decoded, pluginCtx); masked != decoded {
+			pairs[i] = key + "=" + url.QueryEscape(masked)
+			modified = true
+		}
+	}
+	if !modified {
+		return []byte(bodyStr), false, false
+	}
+	return []byte(strings.Join(pairs, "&")), true, false
+}
+
+// processMultipartRequest 处理 multipart/form-data 请求体，只检查文本类型且不超过 raw_part_max_bytes 的 part
+// 各 part 的头部与分隔符保持原样，只替换脱敏后的内容
+// 返回处理后的请求体、是否修改、是否拒绝
+func processMultipartRequest(pluginCtx *config.PluginContext, bodyStr, boundary string) ([]byte, bool, bool) {
+	delimiter := "--" + boundary
+	// segments[0] 为前导内容，之后每段以 part 头部开始，以分隔符之前的换行结束；以 "--" 开始的段为结束分隔符之后的内容
+	segments := strings.Split(bodyStr, delimiter)
+	modified := false
+	for i := 1; i < len(segments); i++ {
+		segment := segments[i]
+		if strings.HasPrefix(segment, "--") {
+			break
+		}
+		header, content, newline, ok := splitMultipartSegment(segment)
+		if !ok || !isTextPart(header, len(content), pluginCtx.Config.RawPartMaxBytes) {
+			continue
+		}
+		if checkRequestDeny(pluginCtx, content) {
+			return []byte(bodyStr), modified, true
+		}
+		if masked := maskMessage(content, pluginCtx); masked != content {
+			segments[i] = segment[:len(segment)-len(content)-len(newline)] + masked + newline
+			modified = true
+		}
+	}
+	if !modified {
+		return []byte(bodyStr), false, false
+	}
+	return []byte(strings.Join(segments, delimiter)), true, false
+}
+
+// splitMultipartSegment 拆分 part 的头部与内容，同时兼容 CRLF 与 LF 换行，返回头部、内容以及内容之后属于分隔符的换行
+func splitMultipartSegment(segment string) (textproto.MIMEHeader, string, string, bool) {
+	newline := "\r\n"
+	headerEnd := strings.Index(segment, "\r\n\r\n")
+	if headerEnd < 0 {
+		newline = "\n"
+		headerEnd = strings.Index(segment, "\n\n")
+	}
+	if headerEnd < 0 {
+		return nil, "", "", false
+	}
+	content := segment[headerEnd+2*len(newline):]
+	if !strings.HasSuffix(content, newline) {
+		return nil, "", "", false
+	}
+	content = strings.TrimSuffix(content, newline)
+
+	// 分隔符行之后的内容（包括分隔符所在行的换行）即为头部
+	rawHeader := strings.TrimLeft(segment[:headerEnd], " \t")
+	rawHeader = strings.TrimPrefix(strings.TrimPrefix(rawHeader, "\r"), "\n")
+	header, err := textproto.NewReader(bufio.NewReader(strings.NewReader(rawHeader + "\r\n\r\n"))).ReadMIMEHeader()
+	if err != nil {
+		return nil, "", "", false
+	}
+	return header, content, newline, true
+}
+
+// isTextPart 判断 part 是否为需要检查的文本：未声明 Content-Type 或为文本类型、没有传输编码且不超过大小限制
+func isTextPart(header textproto.MIMEHeader, size, maxBytes int) bool {
+	if maxBytes > 0 && size > maxBytes {
+		return false
+	}
+	if encoding := strings.ToLower(header.Get("Content-Transfer-Encoding")); encoding != "" && encoding != "7bit" && encoding != "8bit" && encoding != "binary" {
+		return false
+	}
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") || mediaType == "application/json" || mediaType == "application/xml" ||
+		mediaType == formContentType || strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+}
@@ -0,0 +1,94 @@
+package lib
+
+import (
+	"ai-data-masking/config"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// formTestConfig 将手机号替换为 ****，multipart 文本 part 最多检查 64 字节
+var formTestConfig = []testConfigOption{
+	withReplaceRoles(config.Rule{Type: config.RuleTypeReplace, Value: "****", CompiledRegex: regexp.MustCompile(`1\d{10}`)}),
+	withRawPartMaxBytes(64),
+}
+
+// TestProcessRawRequest_Form 测试表单请求按 URL 解码后的值脱敏，未修改的键值对保持原样
+func TestProcessRawRequest_Form(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+		modified bool
+	}{
+		{
+			name:     "百分号编码的值",
+			body:     "name=%E5%BC%A0%E4%B8%89&phone=%31%33%38%31%32%33%34%35%36%37%38&note=a+b",
+			expected: "name=%E5%BC%A0%E4%B8%89&phone=%2A%2A%2A%2A&note=a+b",
+			modified: true,
+		},
+		{
+			name:     "加号表示空格",
+			body:     "q=%E7%94%B5%E8%AF%9D+13812345678",
+			expected: "q=%E7%94%B5%E8%AF%9D+%2A%2A%2A%2A",
+			modified: true,
+		},
+		{
+			name:     "没有需要脱敏的值",
+			body:     "a=1&b&c=",
+			expected: "a=1&b&c=",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pluginCtx := createTestPluginContext(withConfig(createTestConfig(formTestConfig...)), withRequestContentType("application/x-www-form-urlencoded; charset=utf-8"))
+			newBody, modified, denied := ProcessRawRequest(nil, pluginCtx, []byte(tt.body))
+			if denied || modified != tt.modified || string(newBody) != tt.expected {
+				t.Errorf("期望 %q (modified=%v), 实际 %q (modified=%v, denied=%v)", tt.expected, tt.modified, newBody, modified, denied)
+			}
+		})
+	}
+}
+
+// TestProcessRawRequest_Multipart 测试 multipart 请求只处理文本 part，头部与分隔符保持原样
+func TestProcessRawRequest_Multipart(t *testing.T) {
+	large := strings.Repeat("x", 64) + "13812345678"
+	body := "--BOUNDARY\r\n" +
+		"Content-Disposition: form-data; name=\"phone\"\r\n\r\n" +
+		"电话 13812345678\r\n" +
+		"--BOUNDARY\r\n" +
+		"Content-Disposition: form-data; name=\"file\"; filename=\"note.txt\"\r\nContent-Type: text/plain\r\n\r\n" +
+		"备用 13900001111\r\n" +
+		"--BOUNDARY\r\n" +
+		"Content-Disposition: form-data; name=\"image\"; filename=\"a.png\"\r\nContent-Type: image/png\r\n\r\n" +
+		"13700002222\r\n" +
+		"--BOUNDARY\r\n" +
+		"Content-Disposition: form-data; name=\"large\"\r\n\r\n" +
+		large + "\r\n" +
+		"--BOUNDARY--\r\n"
+	expected := strings.Replace(strings.Replace(body, "13812345678\r\n", "****\r\n", 1), "13900001111", "****", 1)
+
+	pluginCtx := createTestPluginContext(withConfig(createTestConfig(formTestConfig...)), withRequestContentType(`multipart/form-data; boundary="BOUNDARY"`))
+	newBody, modified, denied := ProcessRawRequest(nil, pluginCtx, []byte(body))
+	if denied || !modified {
+		t.Fatalf("modified=%v, denied=%v", modified, denied)
+	}
+	if string(newBody) != expected {
+		t.Errorf("期望:\n%q\n实际:\n%q", expected, newBody)
+	}
+
+	t.Run("LF 换行", func(t *testing.T) {
+		body := "--B\nContent-Disposition: form-data; name=\"a\"\n\n13812345678\n--B--\n"
+		newBody, modified, _ := ProcessRawRequest(nil, createTestPluginContext(withConfig(createTestConfig(formTestConfig...)), withRequestContentType("multipart/form-data; boundary=B")), []byte(body))
+		if !modified || string(newBody) != "--B\nContent-Disposition: form-data; name=\"a\"\n\n****\n--B--\n" {
+			t.Errorf("LF 换行处理不正确: %q", newBody)
+		}
+	})
+
+	t.Run("缺少 boundary 按原始 body 处理", func(t *testing.T) {
+		newBody, modified, _ := ProcessRawRequest(nil, createTestPluginContext(withConfig(createTestConfig(formTestConfig...)), withRequestContentType("multipart/form-data")), []byte("13812345678"))
+		if !modified || string(newBody) != "****" {
+			t.Errorf("原始 body 处理不正确: %q", newBody)
+		}
+	})
+}
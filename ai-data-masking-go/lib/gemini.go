@@ -0,0 +1,310 @@
+package lib
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"ai-data-masking/config"
+
+	"github.com/higress-group/wasm-go/pkg/wrapper"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+const (
+	// geminiGenerateMethod Gemini 非流式生成接口的方法后缀
+	geminiGenerateMethod = ":generateContent"
+	// geminiStreamMethod Gemini 流式生成接口的方法后缀
+	geminiStreamMethod = ":streamGenerateContent"
+)
+
+// geminiDenyFinishReason 拒绝响应使用的 finishReason
+const geminiDenyFinishReason = "SAFETY"
+
+// IsGeminiPath 判断请求路径是否为 Gemini generateContent / streamGenerateContent 接口，忽略查询参数
+func IsGeminiPath(path string) bool {
+	path, _, _ = strings.Cut(path, "?")
+	return strings.HasSuffix(path, geminiGenerateMethod) || strings.HasSuffix(path, geminiStreamMethod)
+}
+
+// SetGeminiRequestInfo 按请求路径设置模型与响应格式：streamGenerateContent 指定 alt=sse 时为 SSE 流，
+// 否则响应是整体缓冲处理的 JSON 数组
+func SetGeminiRequestInfo(pluginCtx *config.PluginContext, path string) {
+	path, query, _ := strings.Cut(path, "?")
+	stream := strings.HasSuffix(path, geminiStreamMethod)
+	sse := false
+	if values, err := url.ParseQuery(query); err == nil {
+		sse = values.Get("alt") == "sse"
+	}
+
+	// 路径形如 /v1beta/models/{model}:generateContent
+	name := path[:strings.LastIndex(path, ":")]
+	if pluginCtx.OpenAIRequest == nil {
+		pluginCtx.OpenAIRequest = &config.OpenAIRequest{}
+	}
+	pluginCtx.OpenAIRequest.Model = name[strings.LastIndex(name, "/")+1:]
+	pluginCtx.OpenAIRequest.Stream = stream && sse
+	pluginCtx.GeminiArrayStream = stream && !sse
+}
+
+// ProcessGeminiRequest 处理 Gemini 请求，检查 systemInstruction 以及 contents[].parts 中的文本、函数调用参数和函数返回
+// 返回处理后的请求体、是否修改、是否拒绝
+func ProcessGeminiRequest(ctx wrapper.HttpContext, pluginCtx *config.PluginContext, body []byte) ([]byte, bool, bool) {
+	bodyStr := string(body)
+	root := gjson.Parse(bodyStr)
+	if !root.Get("contents").IsArray() {
+		return body, false, false
+	}
+
+	// 模型与是否流式在请求头阶段按路径设置
+	if pluginCtx.OpenAIRequest == nil {
+		pluginCtx.OpenAIRequest = &config.OpenAIRequest{}
+	}
+
+	fields, images := geminiRequestFields(root)
+	if denyImages(pluginCtx, images) {
+		return body, false, true
+	}
+
+	bodyStr, modified, denied := processRequestFields(pluginCtx, bodyStr, fields)
+	return []byte(bodyStr), modified, denied
+}
+
+// ProcessGeminiResponse 处理 Gemini 非流式响应，检查各候选 content.parts 中的文本与函数调用参数
+// 未指定 alt=sse 的 streamGenerateContent 响应是 JSON 数组，占位符可能被拆分到相邻元素中，按流式事件依次还原
+// 返回处理后的响应体、是否修改、是否拒绝
+func ProcessGeminiResponse(ctx wrapper.HttpContext, pluginCtx *config.PluginContext, bodyStr string, body []byte) ([]byte, bool, bool) {
+	root := gjson.Parse(bodyStr)
+	if !root.IsArray() {
+		if !root.Get("candidates").IsArray() {
+			return body, false, false
+		}
+		newBodyStr, modified, denied := processResponseFields(pluginCtx, bodyStr, geminiResponseFields(root))
+		if denied {
+			return body, modified, denied
+		}
+		return []byte(newBodyStr), modified, denied
+	}
+
+	fields := geminiResponseFields(root)
+	for _, field := range fields {
+		if checkDeny(pluginCtx, field.checkText(), false) {
+			return body, false, true
+		}
+	}
+
+	// 动作为 replace 的敏感词替换为掩码
+	modified := false
+	for _, field := range fields {
+		newText := field.apply(func(text string) string {
+			return MaskReplaceActionWords(text, pluginCtx.Config, config.GetSystemDenyWords())
+		})
+		if newText == field.text {
+			continue
+		}
+		if updated, err := field.set(bodyStr, newText); err == nil {
+			bodyStr = updated
+			modified = true
+		}
+	}
+	if len(pluginCtx.MaskMap) == 0 {
+		return []byte(bodyStr), modified, false
+	}
+
+	var events strings.Builder
+	var elements []string
+	gjson.Parse(bodyStr).ForEach(func(_, element gjson.Result) bool {
+		data := gjson.Get(element.Raw, "@ugly").Raw
+		elements = append(elements, data)
+		events.WriteString("data: " + data + "\n\n")
+		return true
+	})
+	var restored []string
+	for _, event := range strings.Split(strings.TrimSpace(string(RestoreStreamResponse(pluginCtx, []byte(events.String()), true))), "\n\n") {
+		if data := sseEventData(event); data != "" {
+			restored = append(restored, data)
+		}
+	}
+	if strings.Join(restored, ",") == strings.Join(elements, ",") {
+		return []byte(bodyStr), modified, false
+	}
+	return []byte("[" + strings.Join(restored, ",") + "]"), true, false
+}
+
+// geminiField 返回 camelCase 字段以及字段名，不存在时返回 snake_case 字段（Gemini REST 接口两种写法都接受）
+func geminiField(value gjson.Result, camel, snake string) (gjson.Result, string) {
+	if field := value.Get(camel); field.Exists() {
+		return field, camel
+	}
+	return value.Get(snake), snake
+}
+
+// geminiRequestFields 返回 Gemini 请求中 systemInstruction 与 contents[].parts 的文本字段以及图片 URL
+func geminiRequestFields(root gjson.Result) ([]textField, []string) {
+	var fields []textField
+	var images []string
+	if system, name := geminiField(root, "systemInstruction", "system_instruction"); system.Exists() {
+		fields, images = appendGeminiParts(fields, images, name+".parts", system.Get("parts"))
+	}
+	root.Get("contents").ForEach(func(key, content gjson.Result) bool {
+		fields, images = appendGeminiParts(fields, images, fmt.Sprintf("contents.%d.parts", key.Int()), content.Get("parts"))
+		return true
+	})
+	return fields, images
+}
+
+// geminiResponseFields 返回 Gemini 响应各候选 content.parts 中的文本字段，JSON 数组形式的流式响应逐个元素处理
+func geminiResponseFields(root gjson.Result) []textField {
+	var fields []textField
+	appendCandidates := func(prefix string, response gjson.Result) {
+		response.Get("candidates").ForEach(func(key, candidate gjson.Result) bool {
+			fields, _ = appendGeminiParts(fields, nil, fmt.Sprintf("%scandidates.%d.content.parts", prefix, key.Int()), candidate.Get("content.parts"))
+			return true
+		})
+	}
+	if root.IsArray() {
+		root.ForEach(func(key, response gjson.Result) bool {
+			appendCandidates(fmt.Sprintf("%d.", key.Int()), response)
+			return true
+		})
+	} else {
+		appendCandidates("", root)
+	}
+	return fields
+}
+
+// appendGeminiParts 添加 parts 数组中的文本、functionCall.args 与 functionResponse.response 字段
+// args、response 是 JSON 对象，只处理其中的字符串值；inlineData、fileData 中的图片只做策略检查，base64 图片以 data URI 前缀表示
+func appendGeminiParts(fields []textField, images []string, path string, parts gjson.Result) ([]textField, []string) {
+	parts.ForEach(func(key, part gjson.Result) bool {
+		partPath := fmt.Sprintf("%s.%d", path, key.Int())
+		if text := part.Get("text"); text.Type == gjson.String {
+			fields = append(fields, textField{path: partPath + ".text", text: text.String()})
+		}
+		if call, name := geminiField(part, "functionCall", "function_call"); call.Get("args").IsObject() {
+			fields = append(fields, textField{path: partPath + "." + name + ".args", text: call.Get("args").Raw, json: true, raw: true})
+		}
+		if response, name := geminiField(part, "functionResponse", "function_response"); response.Get("response").IsObject() {
+			fields = append(fields, textField{path: partPath + "." + name + ".response", text: response.Get("response").Raw, json: true, raw: true})
+		}
+		if data, _ := geminiField(part, "inlineData", "inline_data"); data.Exists() {
+			if mimeType, _ := geminiField(data, "mimeType", "mime_type"); strings.HasPrefix(mimeType.String(), "image/") {
+				images = append(images, "data:"+mimeType.String()+";base64,")
+			}
+		}
+		if data, _ := geminiField(part, "fileData", "file_data"); data.Exists() {
+			if mimeType, _ := geminiField(data, "mimeType", "mime_type"); strings.HasPrefix(mimeType.String(), "image/") {
+				fileURI, _ := geminiField(data, "fileUri", "file_uri")
+				images = append(images, fileURI.String())
+			}
+		}
+		return true
+	})
+	return fields, images
+}
+
+// geminiPartKind 返回文本 part 的类型：thought 为 true 的推理摘要为 thought，否则为 text
+func geminiPartKind(part gjson.Result) string {
+	if part.Get("thought").Bool() {
+		return "thought"
+	}
+	return "text"
+}
+
+// geminiEventDeltas 提取 Gemini 流式事件各候选中的文本、推理摘要与函数调用参数增量
+// Gemini 每个事件中的 functionCall 是完整的，参数以原始 JSON 加入缓冲区
+func geminiEventDeltas(root gjson.Result) (string, string, string) {
+	var content, reasoning, toolCall string
+	root.Get("candidates").ForEach(func(_, candidate gjson.Result) bool {
+		candidate.Get("content.parts").ForEach(func(_, part gjson.Result) bool {
+			if call, _ := geminiField(part, "functionCall", "function_call"); call.Get("args").IsObject() {
+				toolCall += call.Get("args").Raw
+			} else if geminiPartKind(part) == "thought" {
+				reasoning += part.Get("text").String()
+			} else {
+				content += part.Get("text").String()
+			}
+			return true
+		})
+		return true
+	})
+	return content, reasoning, toolCall
+}
+
+// setGeminiDeltas 将替换后的增量按事件中各 part 原有的字符数写回，顺序与 geminiEventDeltas 一致
+// 替换后的参数不是合法 JSON 时保持原样
+func setGeminiDeltas(jsonStr string, root gjson.Result, content, reasoning, toolCall string) string {
+	replaced := map[string][]rune{"text": []rune(content), "thought": []rune(reasoning), "args": []rune(toolCall)}
+	positions := make(map[string]int, len(replaced))
+	root.Get("candidates").ForEach(func(candidateKey, candidate gjson.Result) bool {
+		candidate.Get("content.parts").ForEach(func(partKey, part gjson.Result) bool {
+			path := fmt.Sprintf("candidates.%d.content.parts.%d", candidateKey.Int(), partKey.Int())
+			kind, original := geminiPartKind(part), part.Get("text").String()
+			if call, name := geminiField(part, "functionCall", "function_call"); call.Get("args").IsObject() {
+				kind, original, path = "args", call.Get("args").Raw, path+"."+name+".args"
+			} else {
+				path += ".text"
+			}
+
+			runes := replaced[kind]
+			start := positions[kind]
+			end := start + len([]rune(original))
+			positions[kind] = end
+			if len(runes) == 0 || end > len(runes) || original == "" {
+				return true
+			}
+			newText := string(runes[start:end])
+			if newText == original {
+				return true
+			}
+			var updated string
+			var err error
+			if kind == "args" {
+				if !gjson.Valid(newText) {
+					return true
+				}
+				updated, err = sjson.SetRaw(jsonStr, path, newText)
+			} else {
+				updated, err = sjson.Set(jsonStr, path, newText)
+			}
+			if err == nil {
+				jsonStr = updated
+			}
+			return true
+		})
+		return true
+	})
+	return jsonStr
+}
+
+// geminiDenyBody 构造 finishReason 为 SAFETY、内容为拒绝消息的 GenerateContentResponse
+func geminiDenyBody(model, message string) string {
+	response, _ := sjson.Set(`{"candidates":[{"content":{"role":"model","parts":[{"text":""}]},"index":0}]}`, "candidates.0.content.parts.0.text", message)
+	response, _ = sjson.Set(response, "candidates.0.finishReason", geminiDenyFinishReason)
+	response, _ = sjson.SetRaw(response, "usageMetadata", `{"promptTokenCount":0,"candidatesTokenCount":0,"totalTokenCount":0}`)
+	response, _ = sjson.Set(response, "modelVersion", model)
+	return response
+}
+
+// GeminiDenyResponse 构造 Gemini 协议的拒绝响应：stream 为 true 时返回 SSE 事件，
+// array 为 true 时返回 JSON 数组（未指定 alt=sse 的 streamGenerateContent）
+func GeminiDenyResponse(model, message string, stream, array bool) []byte {
+	data := geminiDenyBody(model, message)
+	if array {
+		return []byte("[" + data + "]")
+	}
+	if stream {
+		return []byte("data: " + data + "\n\n")
+	}
+	return []byte(data)
+}
+
+// geminiTextEvent 构造只包含一个文本 part 的 Gemini 流式事件
+func geminiTextEvent(index int64, kind, text string) string {
+	part, _ := sjson.Set(`{}`, "text", text)
+	if kind == "thought" {
+		part, _ = sjson.Set(part, "thought", true)
+	}
+	return fmt.Sprintf("data: {\"candidates\":[{\"content\":{\"role\":\"model\",\"parts\":[%s]},\"index\":%d}]}\n\n", part, index)
+}
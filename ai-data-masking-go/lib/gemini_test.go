@@ -0,0 +1,242 @@
+package lib
+
+import (
+	"ai-data-masking/config"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+// collectGeminiText 拼接 SSE 输出中各事件候选 0 的文本 part
+func collectGeminiText(output string) string {
+	var builder strings.Builder
+	for _, event := range strings.Split(strings.TrimSpace(output), "\n\n") {
+		gjson.Get(sseEventData(event), "candidates.0.content.parts").ForEach(func(_, part gjson.Result) bool {
+			if !part.Get("thought").Bool() {
+				builder.WriteString(part.Get("text").String())
+			}
+			return true
+		})
+	}
+	return builder.String()
+}
+
+// TestSetGeminiRequestInfo 测试 Gemini 接口路径识别以及按路径判断模型和响应格式
+func TestSetGeminiRequestInfo(t *testing.T) {
+	tests := []struct {
+		path   string
+		gemini bool
+		model  string
+		stream bool
+		array  bool
+	}{
+		{path: "/v1beta/models/gemini-2.0-flash:generateContent", gemini: true, model: "gemini-2.0-flash"},
+		{path: "/v1beta/models/gemini-2.0-flash:streamGenerateContent?alt=sse&key=abc", gemini: true, model: "gemini-2.0-flash", stream: true},
+		{path: "/v1beta/models/gemini-2.0-flash:streamGenerateContent", gemini: true, model: "gemini-2.0-flash", array: true},
+		{path: "/v1/projects/p/locations/us-central1/publishers/google/models/gemini-pro:generateContent", gemini: true, model: "gemini-pro"},
+		{path: "/v1beta/models/gemini-2.0-flash:countTokens", gemini: false},
+		{path: "/v1/chat/completions", gemini: false},
+	}
+	for _, tt := range tests {
+		if got := IsGeminiPath(tt.path); got != tt.gemini {
+			t.Errorf("%s: 期望 %v, 实际 %v", tt.path, tt.gemini, got)
+		}
+		if !tt.gemini {
+			continue
+		}
+		pluginCtx := &config.PluginContext{}
+		SetGeminiRequestInfo(pluginCtx, tt.path)
+		if pluginCtx.OpenAIRequest.Model != tt.model || pluginCtx.OpenAIRequest.Stream != tt.stream || pluginCtx.GeminiArrayStream != tt.array {
+			t.Errorf("%s: model=%q, stream=%v, array=%v", tt.path, pluginCtx.OpenAIRequest.Model, pluginCtx.OpenAIRequest.Stream, pluginCtx.GeminiArrayStream)
+		}
+	}
+}
+
+// TestProcessGeminiRequest 测试 systemInstruction 与 contents 中各类 part 的脱敏，非文本 part 保持不变
+func TestProcessGeminiRequest(t *testing.T) {
+	pluginCtx := &config.PluginContext{
+		Config: &config.AiDataMaskingConfig{
+			DenyImage:    config.DenyImageNone,
+			ReplaceRoles: []config.Rule{{Type: config.RuleTypeReplace, Value: "****", CompiledRegex: regexp.MustCompile(`1\d{10}`)}},
+		},
+		MaskMap:  make(map[string]*string),
+		Protocol: config.APIProtocolGemini,
+	}
+	body := `{"system_instruction":{"parts":[{"text":"客服电话 13812345678"}]},"contents":[` +
+		`{"role":"user","parts":[{"text":"我的电话 13900001111"},{"inlineData":{"mimeType":"image/png","data":"13700002222"}}]},` +
+		`{"role":"model","parts":[{"functionCall":{"name":"send_sms","args":{"to":"13812345678","retry":2}}}]},` +
+		`{"role":"user","parts":[{"functionResponse":{"name":"send_sms","response":{"result":"已发送到 13812345678"}}}]}]}`
+
+	newBody, modified, denied := ProcessProtocolRequest(nil, pluginCtx, []byte(body))
+	if denied || !modified {
+		t.Fatalf("modified=%v, denied=%v", modified, denied)
+	}
+	expected := map[string]string{
+		"system_instruction.parts.0.text":                     "客服电话 ****",
+		"contents.0.parts.0.text":                             "我的电话 ****",
+		"contents.0.parts.1.inlineData.data":                  "13700002222",
+		"contents.1.parts.0.functionCall.args.to":             "****",
+		"contents.1.parts.0.functionCall.args.retry":          "2",
+		"contents.2.parts.0.functionResponse.response.result": "已发送到 ****",
+	}
+	for path, want := range expected {
+		if got := gjson.GetBytes(newBody, path).String(); got != want {
+			t.Errorf("%s: 期望 %q, 实际 %q", path, want, got)
+		}
+	}
+	if !gjson.GetBytes(newBody, "contents.1.parts.0.functionCall.args").IsObject() {
+		t.Errorf("functionCall.args 应保持为对象: %s", newBody)
+	}
+
+	t.Run("拦截 inlineData 图片", func(t *testing.T) {
+		pluginCtx := &config.PluginContext{
+			Config:   &config.AiDataMaskingConfig{DenyImage: config.DenyImageDataURI},
+			MaskMap:  make(map[string]*string),
+			Protocol: config.APIProtocolGemini,
+		}
+		if _, _, denied := ProcessProtocolRequest(nil, pluginCtx, []byte(body)); !denied || pluginCtx.DenyCategory != config.DenyCategoryImage {
+			t.Errorf("denied=%v, category=%q", denied, pluginCtx.DenyCategory)
+		}
+	})
+}
+
+// TestProcessGeminiResponse_Restore 测试非流式响应与 JSON 数组形式流式响应的还原
+func TestProcessGeminiResponse_Restore(t *testing.T) {
+	t.Run("非流式", func(t *testing.T) {
+		pluginCtx := createTestPluginContext(withMaskMap(map[string]string{"<PHONE_1>": "13812345678"}))
+		pluginCtx.Protocol = config.APIProtocolGemini
+		body := `{"candidates":[{"content":{"role":"model","parts":[{"text":"已记录 <PHONE_1>"},{"functionCall":{"name":"send_sms","args":{"to":"<PHONE_1>"}}}]},"finishReason":"STOP","index":0}]}`
+
+		newBody, modified, denied := ProcessProtocolResponse(nil, pluginCtx, body, []byte(body))
+		if denied || !modified {
+			t.Fatalf("modified=%v, denied=%v", modified, denied)
+		}
+		if got := gjson.GetBytes(newBody, "candidates.0.content.parts.0.text").String(); got != "已记录 13812345678" {
+			t.Errorf("文本还原不正确: %q", got)
+		}
+		if got := gjson.GetBytes(newBody, "candidates.0.content.parts.1.functionCall.args.to").String(); got != "13812345678" {
+			t.Errorf("函数调用参数还原不正确: %q", got)
+		}
+	})
+
+	t.Run("JSON 数组中被拆分的占位符", func(t *testing.T) {
+		pluginCtx := createTestPluginContext(withMaskMap(map[string]string{"<PHONE_1>": "13812345678"}))
+		pluginCtx.Protocol = config.APIProtocolGemini
+		pluginCtx.GeminiArrayStream = true
+		body := "[{\n  \"candidates\": [{\"content\": {\"role\": \"model\", \"parts\": [{\"text\": \"请拨打 <PHO\"}]}, \"index\": 0}]\n}\n,\r\n" +
+			"{\n  \"candidates\": [{\"content\": {\"role\": \"model\", \"parts\": [{\"text\": \"NE_1> 谢谢\"}]}, \"finishReason\": \"STOP\", \"index\": 0}]\n}\n]"
+
+		newBody, modified, denied := ProcessProtocolResponse(nil, pluginCtx, body, []byte(body))
+		if denied || !modified {
+			t.Fatalf("modified=%v, denied=%v", modified, denied)
+		}
+		root := gjson.ParseBytes(newBody)
+		if !root.IsArray() || len(root.Array()) != 2 {
+			t.Fatalf("响应应保持为两个元素的 JSON 数组: %s", newBody)
+		}
+		var text strings.Builder
+		root.ForEach(func(_, element gjson.Result) bool {
+			text.WriteString(element.Get("candidates.0.content.parts.0.text").String())
+			return true
+		})
+		if text.String() != "请拨打 13812345678 谢谢" {
+			t.Errorf("还原结果不正确: %q", text.String())
+		}
+	})
+}
+
+// TestGeminiDenyResponse 测试 Gemini 协议各响应格式的拒绝响应
+func TestGeminiDenyResponse(t *testing.T) {
+	checkBody := func(t *testing.T, response gjson.Result) {
+		if response.Get("candidates.0.finishReason").String() != "SAFETY" || response.Get("candidates.0.content.role").String() != "model" ||
+			response.Get("candidates.0.content.parts.0.text").String() != "已屏蔽" || response.Get("modelVersion").String() != "gemini-pro" {
+			t.Errorf("拒绝响应格式不正确: %s", response.Raw)
+		}
+	}
+	t.Run("非流式", func(t *testing.T) {
+		checkBody(t, gjson.ParseBytes(GeminiDenyResponse("gemini-pro", "已屏蔽", false, false)))
+	})
+	t.Run("SSE", func(t *testing.T) {
+		output := string(GeminiDenyResponse("gemini-pro", "已屏蔽", true, false))
+		if !strings.HasPrefix(output, "data: ") || !strings.HasSuffix(output, "\n\n") {
+			t.Errorf("SSE 格式不正确: %q", output)
+		}
+		checkBody(t, gjson.Parse(sseEventData(output)))
+	})
+	t.Run("JSON 数组", func(t *testing.T) {
+		response := gjson.ParseBytes(GeminiDenyResponse("gemini-pro", "已屏蔽", false, true))
+		if !response.IsArray() {
+			t.Fatalf("应返回 JSON 数组: %s", response.Raw)
+		}
+		checkBody(t, response.Get("0"))
+	})
+}
+
+// TestStreamGeminiDeltas 测试 Gemini 流式事件增量的提取，以及替换结果按各 part 写回
+func TestStreamGeminiDeltas(t *testing.T) {
+	pluginCtx := &config.PluginContext{Config: &config.AiDataMaskingConfig{}, Protocol: config.APIProtocolGemini}
+	events := []string{
+		`{"candidates":[{"content":{"role":"model","parts":[{"text":"想一想","thought":true},{"text":"机密"}]},"index":0}]}`,
+		`{"candidates":[{"content":{"role":"model","parts":[{"text":"号码"},{"functionCall":{"name":"send_sms","args":{"to":"机密"}}}]},"index":0}]}`,
+	}
+	for _, event := range events {
+		contentStart := len(pluginCtx.StreamContentBuffer)
+		reasoningStart := len(pluginCtx.StreamReasoningBuffer)
+		toolCallStart := len(pluginCtx.StreamToolCallBuffer)
+		content, reasoning, toolCall := streamEventDeltas(pluginCtx, gjson.Parse(event))
+		pluginCtx.StreamContentBuffer += content
+		pluginCtx.StreamReasoningBuffer += reasoning
+		pluginCtx.StreamToolCallBuffer += toolCall
+		pluginCtx.StreamChunkBuffer = append(pluginCtx.StreamChunkBuffer, config.StreamChunk{
+			Data:           []byte("data: " + event + "\n\n"),
+			ContentStart:   contentStart,
+			ContentEnd:     len(pluginCtx.StreamContentBuffer),
+			ReasoningStart: reasoningStart,
+			ReasoningEnd:   len(pluginCtx.StreamReasoningBuffer),
+			ToolCallStart:  toolCallStart,
+			ToolCallEnd:    len(pluginCtx.StreamToolCallBuffer),
+		})
+	}
+	if pluginCtx.StreamContentBuffer != "机密号码" || pluginCtx.StreamReasoningBuffer != "想一想" || pluginCtx.StreamToolCallBuffer != `{"to":"机密"}` {
+		t.Fatalf("增量提取不正确: content=%q, reasoning=%q, toolCall=%q",
+			pluginCtx.StreamContentBuffer, pluginCtx.StreamReasoningBuffer, pluginCtx.StreamToolCallBuffer)
+	}
+
+	var result strings.Builder
+	writeReplacedChunks(&result, pluginCtx, "**号码", "想一想", `{"to":"**"}`)
+	output := result.String()
+	if got := collectGeminiText(output); got != "**号码" {
+		t.Errorf("文本替换回写不正确: %q", got)
+	}
+	second := gjson.Parse(sseEventData(strings.Split(output, "\n\n")[1]))
+	if got := second.Get("candidates.0.content.parts.1.functionCall.args.to").String(); got != "**" {
+		t.Errorf("函数调用参数替换回写不正确: %s", second.Raw)
+	}
+}
+
+// TestRestoreStreamResponse_Gemini 测试 Gemini 流式响应的还原，候选结束时补发暂存的内容
+func TestRestoreStreamResponse_Gemini(t *testing.T) {
+	pluginCtx := createTestPluginContext(withMaskMap(map[string]string{"<PHONE_1>": "13812345678"}))
+	pluginCtx.Protocol = config.APIProtocolGemini
+	chunks := []string{
+		`data: {"candidates":[{"content":{"role":"model","parts":[{"text":"请拨打 <PHO"}]},"index":0}]}` + "\r\n\r\n",
+		`data: {"candidates":[{"content":{"role":"model","parts":[{"text":"NE_1> 或 <PH"}]},"index":0}]}` + "\n\n",
+		`data: {"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"send_sms","args":{"to":"<PHONE_1>"}}}]},"finishReason":"STOP","index":0}]}` + "\n\n",
+	}
+	var output strings.Builder
+	for i, chunk := range chunks {
+		output.WriteString(string(RestoreStreamResponse(pluginCtx, []byte(chunk), i == len(chunks)-1)))
+	}
+	if got := collectGeminiText(output.String()); got != "请拨打 13812345678 或 <PH" {
+		t.Errorf("还原结果不正确: %q", got)
+	}
+	// 补发的文本必须位于带 finishReason 的事件之前
+	if strings.Index(output.String(), "或 <PH") > strings.Index(output.String(), "finishReason") {
+		t.Errorf("补发位置不正确: %s", output.String())
+	}
+	if !strings.Contains(output.String(), `"args":{"to":"13812345678"}`) {
+		t.Errorf("函数调用参数还原不正确: %s", output.String())
+	}
+}
@@ -0,0 +1,165 @@
+package lib
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// grokReference 匹配 %{NAME}、%{NAME:field} 以及 %{NAME:field:type}，type 仅为兼容 logstash 写法，不做类型转换
+var grokReference = regexp.MustCompile(`%\{([A-Za-z_][A-Za-z0-9_]*)(?::([A-Za-z0-9_.@\[\]-]+))?(?::[A-Za-z]+)?\}`)
+
+// grokFieldInvalid 捕获组名中不允许出现的字符
+var grokFieldInvalid = regexp.MustCompile(`[^A-Za-z0-9_]+`)
+
+// grokBasePatterns 内置 GROK 规则
+// 基础规则参考 logstash grok-patterns，改写为 RE2 语法（不支持环视和原子组，边界统一使用 \b）
+// RE2 的分支按从左到右优先匹配，IPV6 中 "::" 之后组数更多的写法需要排在前面
+// MOBILE、IDCARD 保持与早期版本一致的宽松定义，其余中国特有规则按国家标准的编码格式定义
+var grokBasePatterns = map[string]string{
+	// 通用
+	"USERNAME":       `[a-zA-Z0-9._-]+`,
+	"USER":           `%{USERNAME}`,
+	"EMAILLOCALPART": `[a-zA-Z0-9._%+-]+`,
+	"EMAILADDRESS":   `%{EMAILLOCALPART}@%{HOSTNAME}`,
+	"INT":            `[+-]?[0-9]+`,
+	"BASE10NUM":      `[+-]?(?:[0-9]+(?:\.[0-9]+)?|\.[0-9]+)`,
+	"NUMBER":         `%{BASE10NUM}`,
+	"BASE16NUM":      `[+-]?(?:0x)?[0-9A-Fa-f]+`,
+	"POSINT":         `\b[1-9][0-9]*\b`,
+	"NONNEGINT":      `\b[0-9]+\b`,
+	"WORD":           `\b\w+\b`,
+	"NOTSPACE":       `\S+`,
+	"SPACE":          `\s*`,
+	"DATA":           `.*?`,
+	"GREEDYDATA":     `.*`,
+	"QUOTEDSTRING":   `"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'|` + "`(?:[^`\\\\]|\\\\.)*`",
+	"UUID":           `[A-Fa-f0-9]{8}-(?:[A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}`,
+
+	// 网络
+	"CISCOMAC":   `(?:[A-Fa-f0-9]{4}\.){2}[A-Fa-f0-9]{4}`,
+	"WINDOWSMAC": `(?:[A-Fa-f0-9]{2}-){5}[A-Fa-f0-9]{2}`,
+	"COMMONMAC":  `(?:[A-Fa-f0-9]{2}:){5}[A-Fa-f0-9]{2}`,
+	"MAC":        `%{CISCOMAC}|%{WINDOWSMAC}|%{COMMONMAC}`,
+	"IPV4":       `\b(?:(?:25[0-5]|2[0-4][0-9]|1[0-9]{2}|[1-9]?[0-9])\.){3}(?:25[0-5]|2[0-4][0-9]|1[0-9]{2}|[1-9]?[0-9])\b`,
+	"IPV6": `(?:[0-9A-Fa-f]{1,4}:){6}%{IPV4}|::(?:[Ff]{4}(?::0{1,4})?:)?%{IPV4}|` +
+		`(?:[0-9A-Fa-f]{1,4}:){7}[0-9A-Fa-f]{1,4}|` +
+		`[0-9A-Fa-f]{1,4}:(?::[0-9A-Fa-f]{1,4}){1,6}|` +
+		`(?:[0-9A-Fa-f]{1,4}:){1,2}(?::[0-9A-Fa-f]{1,4}){1,5}|` +
+		`(?:[0-9A-Fa-f]{1,4}:){1,3}(?::[0-9A-Fa-f]{1,4}){1,4}|` +
+		`(?:[0-9A-Fa-f]{1,4}:){1,4}(?::[0-9A-Fa-f]{1,4}){1,3}|` +
+		`(?:[0-9A-Fa-f]{1,4}:){1,5}(?::[0-9A-Fa-f]{1,4}){1,2}|` +
+		`(?:[0-9A-Fa-f]{1,4}:){1,6}:[0-9A-Fa-f]{1,4}|` +
+		`(?:[0-9A-Fa-f]{1,4}:){1,7}:|` +
+		`:(?:(?::[0-9A-Fa-f]{1,4}){1,7}|:)`,
+	"IP":           `%{IPV6}|%{IPV4}`,
+	"HOSTNAME":     `\b[0-9A-Za-z][0-9A-Za-z-]{0,62}(?:\.[0-9A-Za-z][0-9A-Za-z-]{0,62})*\b`,
+	"IPORHOST":     `%{IP}|%{HOSTNAME}`,
+	"HOSTPORT":     `%{IPORHOST}:%{POSINT}`,
+	"UNIXPATH":     `(?:/[\w%!$@:.,+~-]*)+`,
+	"WINPATH":      `(?:[A-Za-z]+:|\\)(?:\\[^\\?*]*)+`,
+	"PATH":         `%{UNIXPATH}|%{WINPATH}`,
+	"URIPROTO":     `[A-Za-z][A-Za-z0-9+.-]+`,
+	"URIHOST":      `%{IPORHOST}(?::%{POSINT})?`,
+	"URIPATH":      `(?:/[A-Za-z0-9$.+!*'(){},~:;=@#%&_-]*)+`,
+	"URIQUERY":     `[A-Za-z0-9$.+!*'|(){},~@#%&/=:;_?\[\]<>-]*`,
+	"URIPARAM":     `\?%{URIQUERY}`,
+	"URIPATHPARAM": `%{URIPATH}(?:%{URIPARAM})?`,
+	"URI":          `%{URIPROTO}://(?:%{USER}(?::[^@]*)?@)?(?:%{URIHOST})?(?:%{URIPATHPARAM})?`,
+
+	// 日期时间
+	"MONTH":             `\b(?:[Jj]an(?:uary)?|[Ff]eb(?:ruary)?|[Mm]ar(?:ch)?|[Aa]pr(?:il)?|[Mm]ay|[Jj]un(?:e)?|[Jj]ul(?:y)?|[Aa]ug(?:ust)?|[Ss]ep(?:tember)?|[Oo]ct(?:ober)?|[Nn]ov(?:ember)?|[Dd]ec(?:ember)?)\b`,
+	"MONTHNUM":          `0?[1-9]|1[0-2]`,
+	"MONTHDAY":          `0[1-9]|[12][0-9]|3[01]|[1-9]`,
+	"DAY":               `Mon(?:day)?|Tue(?:sday)?|Wed(?:nesday)?|Thu(?:rsday)?|Fri(?:day)?|Sat(?:urday)?|Sun(?:day)?`,
+	"YEAR":              `(?:\d\d){1,2}`,
+	"HOUR":              `2[0123]|[01]?[0-9]`,
+	"MINUTE":            `[0-5][0-9]`,
+	"SECOND":            `(?:[0-5]?[0-9]|60)(?:[:.,][0-9]+)?`,
+	"TIME":              `%{HOUR}:%{MINUTE}(?::%{SECOND})?`,
+	"DATE_US":           `%{MONTHNUM}[/-]%{MONTHDAY}[/-]%{YEAR}`,
+	"DATE_EU":           `%{MONTHDAY}[./-]%{MONTHNUM}[./-]%{YEAR}`,
+	"DATE_CN":           `%{YEAR}年%{MONTHNUM}月%{MONTHDAY}日`,
+	"ISO8601_TIMEZONE":  `Z|[+-]%{HOUR}(?::?%{MINUTE})`,
+	"TIMESTAMP_ISO8601": `%{YEAR}-%{MONTHNUM}-%{MONTHDAY}[T ]%{HOUR}:?%{MINUTE}(?::?%{SECOND})?(?:%{ISO8601_TIMEZONE})?`,
+	"DATE":              `%{DATE_US}|%{DATE_EU}`,
+	"DATESTAMP":         `%{DATE}[- ]%{TIME}`,
+	"LOGLEVEL":          `[Aa]lert|ALERT|[Tt]race|TRACE|[Dd]ebug|DEBUG|[Nn]otice|NOTICE|[Ii]nfo|INFO|[Ww]arn(?:ing)?|WARN(?:ING)?|[Ee]rr(?:or)?|ERR(?:OR)?|[Cc]rit(?:ical)?|CRIT(?:ICAL)?|[Ff]atal|FATAL|[Ss]evere|SEVERE|[Ee]merg(?:ency)?|EMERG(?:ENCY)?`,
+
+	// 中国特有
+	"MOBILE":       `\d{8,11}`,
+	"CNMOBILE":     `\b1[3-9]\d{9}\b`,
+	"TELEPHONE":    `\b0\d{2,3}-?[1-9]\d{6,7}\b`,
+	"IDCARD":       `\d{17}[0-9xX]|\d{15}`,
+	"BANKCARD":     `\b[3-6]\d{15,18}\b`,
+	"PASSPORT":     `\b(?:[EG]\d{8}|E[A-HJ-NP-Z]\d{7}|[DSP]E?\d{7})\b`,
+	"LICENSEPLATE": `[京津沪渝冀豫云辽黑湘皖鲁新苏浙赣鄂桂甘晋蒙陕吉闽贵粤青藏川宁琼使领][A-HJ-NP-Z](?:[A-HJ-NP-Z0-9]{5,6}|[A-HJ-NP-Z0-9]{4}[挂学警港澳])`,
+	"USCC":         `\b[0-9A-HJ-NPQRTUWXY]{2}\d{6}[0-9A-HJ-NPQRTUWXY]{10}\b`,
+}
+
+// CompileGrok 展开 GROK 规则并编译为正则表达式
+// custom 为配置中的自定义规则，同名时覆盖内置规则
+func CompileGrok(pattern string, custom map[string]string) (*regexp.Regexp, error) {
+	expanded, err := ExpandGrok(pattern, custom)
+	if err != nil {
+		return nil, err
+	}
+	return regexp.Compile(expanded)
+}
+
+// ExpandGrok 递归展开 GROK 规则，不含 %{...} 的规则按原始正则返回
+// %{NAME:field} 展开为命名捕获组 (?P<field>...)，可在 replace_roles.value 中以 $field 或 ${field} 引用
+// 引用未定义的规则或规则之间循环引用时返回错误
+func ExpandGrok(pattern string, custom map[string]string) (string, error) {
+	return expandGrok(pattern, custom, nil)
+}
+
+// expandGrok 展开 pattern 中的 GROK 引用，stack 记录当前展开路径用于检测循环引用
+func expandGrok(pattern string, custom map[string]string, stack []string) (string, error) {
+	var expandErr error
+	result := grokReference.ReplaceAllStringFunc(pattern, func(reference string) string {
+		if expandErr != nil {
+			return reference
+		}
+		submatches := grokReference.FindStringSubmatch(reference)
+		name, field := submatches[1], submatches[2]
+
+		definition, ok := custom[name]
+		if !ok {
+			definition, ok = grokBasePatterns[name]
+		}
+		if !ok {
+			expandErr = fmt.Errorf("unknown grok pattern %s", name)
+			return reference
+		}
+		for _, visiting := range stack {
+			if visiting == name {
+				expandErr = fmt.Errorf("recursive grok pattern %s", strings.Join(append(stack, name), " -> "))
+				return reference
+			}
+		}
+
+		expanded, err := expandGrok(definition, custom, append(stack, name))
+		if err != nil {
+			expandErr = err
+			return reference
+		}
+		if field != "" {
+			return "(?P<" + grokFieldName(field) + ">" + expanded + ")"
+		}
+		return "(?:" + expanded + ")"
+	})
+	if expandErr != nil {
+		return "", expandErr
+	}
+	return result, nil
+}
+
+// grokFieldName 将 logstash 风格的字段名（如 [client][ip]、client.ip）转换为合法的捕获组名
+func grokFieldName(field string) string {
+	name := strings.Trim(grokFieldInvalid.ReplaceAllString(field, "_"), "_")
+	if name == "" {
+		return "field"
+	}
+	return name
+}
@@ -0,0 +1,118 @@
+package lib
+
+import (
+	"ai-data-masking/config"
+	"testing"
+)
+
+// TestCompileGrok_BuiltinPatterns 测试内置 GROK 规则
+func TestCompileGrok_BuiltinPatterns(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		text    string
+		want    string // 期望命中的片段，为空表示不应命中
+	}{
+		{name: "IPV4", pattern: "%{IPV4}", text: "来自 192.168.1.20 的请求", want: "192.168.1.20"},
+		{name: "IPV4 越界", pattern: "%{IPV4}", text: "版本 1.2.3.256", want: ""},
+		{name: "IPV6", pattern: "%{IP}", text: "地址 2001:db8::8a2e:370:7334 可用", want: "2001:db8::8a2e:370:7334"},
+		{name: "邮箱", pattern: "%{EMAILADDRESS}", text: "联系 foo.bar@example.com 。", want: "foo.bar@example.com"},
+		{name: "UUID", pattern: "%{UUID}", text: "id=123e4567-e89b-12d3-a456-426614174000", want: "123e4567-e89b-12d3-a456-426614174000"},
+		{name: "URI", pattern: "%{URI}", text: "访问 https://example.com:8080/a/b?c=d 即可", want: "https://example.com:8080/a/b?c=d"},
+		{name: "ISO8601 时间", pattern: "%{TIMESTAMP_ISO8601}", text: "at 2024-05-01T12:30:45Z", want: "2024-05-01T12:30:45Z"},
+		{name: "中文日期", pattern: "%{DATE_CN}", text: "生于1990年1月2日", want: "1990年1月2日"},
+		{name: "手机号", pattern: "%{CNMOBILE}", text: "电话13812345678", want: "13812345678"},
+		{name: "身份证", pattern: "%{IDCARD}", text: "证件11010519491231002X", want: "11010519491231002X"},
+		{name: "银行卡", pattern: "%{BANKCARD}", text: "卡号6222021234567890123", want: "6222021234567890123"},
+		{name: "护照", pattern: "%{PASSPORT}", text: "护照E12345678", want: "E12345678"},
+		{name: "车牌", pattern: "%{LICENSEPLATE}", text: "车牌京A12345停在", want: "京A12345"},
+		{name: "新能源车牌", pattern: "%{LICENSEPLATE}", text: "车牌沪AD12345", want: "沪AD12345"},
+		{name: "统一社会信用代码", pattern: "%{USCC}", text: "代码91350100M000100Y43", want: "91350100M000100Y43"},
+		{name: "原始正则", pattern: `sk-[0-9a-z]{4}`, text: "key sk-ab12", want: "sk-ab12"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			re, err := CompileGrok(tt.pattern, nil)
+			if err != nil {
+				t.Fatalf("编译失败: %v", err)
+			}
+			if got := re.FindString(tt.text); got != tt.want {
+				t.Errorf("期望命中 %q, 实际 %q", tt.want, got)
+			}
+		})
+	}
+}
+
+// TestExpandGrok_Custom 测试自定义规则、递归展开与错误处理
+func TestExpandGrok_Custom(t *testing.T) {
+	custom := map[string]string{
+		"EMPLOYEE_ID": `EMP-%{INT:num}`,
+		"TICKET":      `%{EMPLOYEE_ID}/%{WORD}`,
+		"MOBILE":      `1\d{10}`,
+		"LOOP_A":      `%{LOOP_B}`,
+		"LOOP_B":      `%{LOOP_A}`,
+	}
+
+	re, err := CompileGrok("%{TICKET:ticket}", custom)
+	if err != nil {
+		t.Fatalf("编译失败: %v", err)
+	}
+	match := re.FindStringSubmatch("工单 EMP-42/abc")
+	if match == nil || match[re.SubexpIndex("ticket")] != "EMP-42/abc" || match[re.SubexpIndex("num")] != "42" {
+		t.Errorf("命名捕获组不正确: %v", match)
+	}
+
+	// 自定义规则覆盖内置规则
+	if expanded, _ := ExpandGrok("%{MOBILE}", custom); expanded != `(?:1\d{10})` {
+		t.Errorf("自定义规则未覆盖内置规则: %s", expanded)
+	}
+
+	if _, err := ExpandGrok("%{NOT_EXIST}", custom); err == nil {
+		t.Errorf("引用未定义的规则应返回错误")
+	}
+	if _, err := ExpandGrok("%{LOOP_A}", custom); err == nil {
+		t.Errorf("循环引用应返回错误")
+	}
+}
+
+// TestGrokFieldName 测试字段名转换为合法的捕获组名
+func TestGrokFieldName(t *testing.T) {
+	tests := map[string]string{
+		"domain":       "domain",
+		"[client][ip]": "client_ip",
+		"client.ip":    "client_ip",
+		"[]":           "field",
+	}
+	for field, expected := range tests {
+		if got := grokFieldName(field); got != expected {
+			t.Errorf("%s: 期望 %s, 实际 %s", field, expected, got)
+		}
+	}
+}
+
+// TestReplaceMessage_NamedCapture 测试 replace_roles.value 引用 GROK 命名捕获组
+func TestReplaceMessage_NamedCapture(t *testing.T) {
+	re, err := CompileGrok("%{EMAILLOCALPART}@%{HOSTNAME:domain}", nil)
+	if err != nil {
+		t.Fatalf("编译失败: %v", err)
+	}
+
+	for _, restore := range []bool{false, true} {
+		pluginCtx := &config.PluginContext{
+			Config: &config.AiDataMaskingConfig{
+				ReplaceRoles: []config.Rule{{Type: "replace", Restore: restore, Value: "****@$domain", CompiledRegex: re}},
+			},
+			MaskMap: make(map[string]*string),
+		}
+		got := ReplaceMessage("请联系 alice@example.com 处理", pluginCtx)
+		if got != "请联系 ****@example.com 处理" {
+			t.Errorf("restore=%v: 替换结果不正确: %q", restore, got)
+		}
+		if restore {
+			if original := pluginCtx.MaskMap["****@example.com"]; original == nil || *original != "alice@example.com" {
+				t.Errorf("还原映射不正确: %v", pluginCtx.MaskMap)
+			}
+		}
+	}
+}
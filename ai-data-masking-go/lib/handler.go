@@ -3,7 +3,9 @@ package lib
 import (
 	"encoding/json"
 	"fmt"
+	"mime"
 	"strings"
+	"time"
 
 	"ai-data-masking/config"
 
@@ -16,14 +18,61 @@ import (
 	"github.com/tidwall/sjson"
 )
 
+// checkDeny 检查文本中是否包含需要拦截的敏感词，命中时将分类信息记录到插件上下文
+func checkDeny(pluginCtx *config.PluginContext, text string, isStream bool) bool {
+	match := CheckMessageMatch(text, pluginCtx.Config, config.GetSystemDenyWords(), isStream)
+	if match == nil {
+		return false
+	}
+	recordDenyMatch(pluginCtx, match)
+	return true
+}
+
+// recordDenyMatch 记录拦截命中的分类和严重级别
+func recordDenyMatch(pluginCtx *config.PluginContext, match *MatchResult) {
+	if match == nil {
+		return
+	}
+	pluginCtx.DenyCategory = match.Category
+	pluginCtx.DenySeverity = match.Severity
+}
+
+// checkRequestDeny 检查请求文本中是否包含需要拦截的敏感词，返回是否拒绝请求
+// request_deny_plot 为 replace 时命中不拒绝，标记请求被改写，由 maskMessage 将拦截词替换为掩码后转发
+func checkRequestDeny(pluginCtx *config.PluginContext, text string) bool {
+	if !checkDeny(pluginCtx, text, false) {
+		return false
+	}
+	if pluginCtx.Config.RequestDenyPlot.Plot != "replace" {
+		return true
+	}
+	pluginCtx.IsRequestModified = true
+	return false
+}
+
+// maskMessage 请求阶段的文本处理：先将动作为 replace 的敏感词替换为掩码，再执行 replace_roles 规则
+// 请求按 request_deny_plot 改写时，需要拦截的敏感词同样替换为掩码
+func maskMessage(text string, pluginCtx *config.PluginContext) string {
+	if pluginCtx.IsRequestModified {
+		text = ReplaceRequestDenyWords(text, pluginCtx.Config, config.GetSystemDenyWords())
+	}
+	return ReplaceMessage(MaskReplaceActionWords(text, pluginCtx.Config, config.GetSystemDenyWords()), pluginCtx)
+}
+
 // processOpenAIRequest 处理 OpenAI 格式请求（使用 gjson 解析）
-func ProcessOpenAIRequest(ctx wrapper.HttpContext, pluginCtx *config.PluginContext, body []byte) (bool, bool) {
+// 返回处理后的请求体、是否修改、是否拒绝
+func ProcessOpenAIRequest(ctx wrapper.HttpContext, pluginCtx *config.PluginContext, body []byte) ([]byte, bool, bool) {
 	bodyStr := string(body)
 
 	// 使用 gjson 解析基础字段
 	root := gjson.Parse(bodyStr)
 	if !root.Exists() {
-		return false, false
+		return body, false, false
+	}
+
+	// Completions、Embeddings 请求在请求头阶段按路径识别
+	if pluginCtx.Protocol.IsOpenAILegacy() {
+		return processLegacyOpenAIRequest(pluginCtx, bodyStr, root)
 	}
 
 	// 检查是否是 OpenAI 请求
@@ -31,8 +80,10 @@ func ProcessOpenAIRequest(ctx wrapper.HttpContext, pluginCtx *config.PluginConte
 
 	if !contentResult.Exists() {
 		// pluginCtx.RequestDenyType = config.DenyTypeOpenAI // 不是openai格式，不设置拒绝类型，返回false,false
-		return false, false
+		return body, false, false
 	}
+	// 响应阶段按 OpenAI 格式检查，不再按 response_jsonpath 或 deny_raw 处理
+	pluginCtx.Protocol = config.APIProtocolOpenAI
 
 	// 初始化 OpenAIRequest（如果为 nil）
 	if pluginCtx.OpenAIRequest == nil {
@@ -42,59 +93,26 @@ func ProcessOpenAIRequest(ctx wrapper.HttpContext, pluginCtx *config.PluginConte
 	stream := root.Get("stream").Bool()
 	pluginCtx.OpenAIRequest.Stream = stream
 	pluginCtx.OpenAIRequest.Model = root.Get("model").String()
+	pluginCtx.OpenAIRequest.ChoiceCount = requestChoiceCount(root)
+	pluginCtx.OpenAIRequest.IncludeUsage = root.Get("stream_options.include_usage").Bool()
 
 	messages := root.Get("messages")
 	if !messages.Exists() || messages.Type != gjson.JSON {
-		return false, false
+		return body, false, false
 	}
 
-	modified := false
-	denied := false
-
-	// 遍历 messages 数组
-	messages.ForEach(func(key, v gjson.Result) bool {
-		idx := key.Int()
-
-		content := v.Get("content").String()
-		reasoningContent := v.Get("reasoning_content").String()
-
-		// 先做命中检查（请求阶段，非流式）
-		if CheckMessage(content, pluginCtx.Config, config.SystemDenyWords, false) || CheckMessage(reasoningContent, pluginCtx.Config, config.SystemDenyWords, false) {
-			// 命中直接拒绝，不再继续遍历
-			denied = true
-			return false
-		}
-
-		// 替换敏感词
-		newContent := ReplaceMessage(content, pluginCtx)
-		newReasoningContent := ReplaceMessage(reasoningContent, pluginCtx)
-
-		// 如果有变更，用 sjson 回写
-		basePath := fmt.Sprintf("messages.%d.", idx)
-
-		if newContent != content {
-			var err error
-			bodyStr, err = sjson.Set(bodyStr, basePath+"content", newContent)
-			if err == nil {
-				modified = true
-			}
-		}
-		if newReasoningContent != reasoningContent {
-			var err error
-			bodyStr, err = sjson.Set(bodyStr, basePath+"reasoning_content", newReasoningContent)
-			if err == nil {
-				modified = true
-			}
-		}
-
-		return true
-	})
+	fields, images := openAIRequestFields(messages)
+	if denyImages(pluginCtx, images) {
+		return body, false, true
+	}
 
-	return modified, denied
+	bodyStr, modified, denied := processRequestFields(pluginCtx, bodyStr, fields)
+	return []byte(bodyStr), modified, denied
 }
 
 // processJSONPathRequest 处理 JSONPath 请求
-func ProcessJSONPathRequest(ctx wrapper.HttpContext, pluginCtx *config.PluginContext, body []byte) (bool, bool) {
+// 返回处理后的请求体、是否修改、是否拒绝
+func ProcessJSONPathRequest(ctx wrapper.HttpContext, pluginCtx *config.PluginContext, body []byte) ([]byte, bool, bool) {
 	// 简化实现：使用 gjson 解析 JSONPath
 	// 注意：这里需要完整的 JSONPath 实现
 	bodyStr := string(body)
@@ -109,12 +127,12 @@ func ProcessJSONPathRequest(ctx wrapper.HttpContext, pluginCtx *config.PluginCon
 		// 1) 直接是字符串
 		if result.Type == gjson.String {
 			content := result.String()
-			if CheckMessage(content, pluginCtx.Config, config.SystemDenyWords, false) {
+			if checkRequestDeny(pluginCtx, content) {
 				denied = true
-				return modified, denied
+				return []byte(bodyStr), modified, denied
 			}
 
-			newContent := ReplaceMessage(content, pluginCtx)
+			newContent := maskMessage(content, pluginCtx)
 			if newContent != content {
 				oldJson, _ := json.Marshal(content)
 				newJson, _ := json.Marshal(newContent)
@@ -131,12 +149,12 @@ func ProcessJSONPathRequest(ctx wrapper.HttpContext, pluginCtx *config.PluginCon
 					continue
 				}
 				content := item.String()
-				if CheckMessage(content, pluginCtx.Config, config.SystemDenyWords, false) {
+				if checkRequestDeny(pluginCtx, content) {
 					denied = true
-					return modified, denied
+					return []byte(bodyStr), modified, denied
 				}
 
-				newContent := ReplaceMessage(content, pluginCtx)
+				newContent := maskMessage(content, pluginCtx)
 				if newContent != content {
 					oldJson, _ := json.Marshal(content)
 					newJson, _ := json.Marshal(newContent)
@@ -147,126 +165,93 @@ func ProcessJSONPathRequest(ctx wrapper.HttpContext, pluginCtx *config.PluginCon
 		}
 	}
 
-	return modified, denied
+	return []byte(bodyStr), modified, denied
 }
 
 // processRawRequest 处理原始请求，示例：
-func ProcessRawRequest(ctx wrapper.HttpContext, pluginCtx *config.PluginContext, body []byte) (bool, bool) {
+// 表单与 multipart 请求按 Content-Type 解析后逐个检查解码后的值，其他请求按整个 body 处理
+// 返回处理后的请求体、是否修改、是否拒绝
+func ProcessRawRequest(ctx wrapper.HttpContext, pluginCtx *config.PluginContext, body []byte) ([]byte, bool, bool) {
 	bodyStr := string(body)
 	modified := false
 	denied := false
 
-	if CheckMessage(bodyStr, pluginCtx.Config, config.SystemDenyWords, false) {
+	mediaType, params, _ := mime.ParseMediaType(pluginCtx.RequestContentType)
+	switch {
+	case mediaType == formContentType:
+		return processFormRequest(pluginCtx, bodyStr)
+	case mediaType == multipartContentType && params["boundary"] != "":
+		return processMultipartRequest(pluginCtx, bodyStr, params["boundary"])
+	}
+
+	if checkRequestDeny(pluginCtx, bodyStr) {
 		denied = true
-		return modified, denied
+		return body, modified, denied
 	}
 
-	newBody := ReplaceMessage(bodyStr, pluginCtx)
+	newBody := maskMessage(bodyStr, pluginCtx)
 	if newBody != bodyStr {
 		modified = true
 	}
 
-	return modified, denied
+	return []byte(newBody), modified, denied
 }
 
-// ProcessOpenAIResponse 处理 OpenAI 非流式 JSON 响应，返回动作和新的 body 字符串
+// ProcessOpenAIResponse 处理 OpenAI 非流式 JSON 响应，返回处理后的响应体、是否修改、是否拒绝
 // 参考 ProcessOpenAIRequest 的实现，使用 gjson 和 sjson 进行精确的 JSON 处理
-func ProcessOpenAIResponse(ctx wrapper.HttpContext, pluginCtx *config.PluginContext, bodyStr string, body []byte) (bool, bool) {
+func ProcessOpenAIResponse(ctx wrapper.HttpContext, pluginCtx *config.PluginContext, bodyStr string, body []byte) ([]byte, bool, bool) {
 
 	modified := false
 	denied := false
 	// 使用 gjson 解析基础字段
 	root := gjson.Parse(bodyStr)
 	if !root.Exists() {
-		return false, false
+		return body, false, false
+	}
+	switch pluginCtx.Protocol {
+	case config.APIProtocolCompletions:
+		return processCompletionsResponse(pluginCtx, bodyStr, body)
+	case config.APIProtocolEmbeddings:
+		// Embeddings 响应只有向量，没有需要检查的文本
+		return body, false, false
 	}
 	// 检查是否是 OpenAI 响应格式
 	contentResult := gjson.Get(bodyStr, "choices.0.message")
 
 	if !contentResult.Exists() || contentResult.Type != gjson.JSON {
 		// pluginCtx.RequestDenyType = config.DenyTypeOpenAI // 不是openai格式，不设置拒绝类型，返回false,false
-		return false, false
+		return body, false, false
 	}
 
-	//
-
-	choices := gjson.Get(bodyStr, "choices")
-
-	// 遍历 choices 数组
-	choices.ForEach(func(key, choice gjson.Result) bool {
-		// idx := key.Int()
-
-		content := choice.Get("message.content").String()
-		reasoning := choice.Get("message.reasoning").String()
-
-		// 先做命中检查（响应阶段，非流式）
-		if CheckMessage(content, pluginCtx.Config, config.SystemDenyWords, false) ||
-			CheckMessage(reasoning, pluginCtx.Config, config.SystemDenyWords, false) {
-			// 命中直接拒绝，不再继续遍历
-			denied = true
-			return false // 停止遍历
-		}
-
-		// // 替换敏感词（与请求阶段保持一致，使用 ReplaceMessage）
-		// newContent := ReplaceMessage(content, pluginCtx)
-		// newReasoningContent := ReplaceMessage(reasoning, pluginCtx)
-
-		// 构建基础路径
-		// basePath := fmt.Sprintf("choices.%d.message.", idx)
-
-		// // 如果有变更，用 sjson 回写
-		// if newContent != content {
-		// 	var err error
-		// 	bodyStr, err = sjson.Set(bodyStr, basePath+"content", newContent)
-		// 	if err == nil {
-		// 		modified = true
-		// 	}
-		// }
-		// if newReasoningContent != reasoning {
-		// 	var err error
-		// 	bodyStr, err = sjson.Set(bodyStr, basePath+"reasoning", newReasoningContent)
-		// 	if err == nil {
-		// 		modified = true
-		// 	}
-		// }
-
-		return true // 继续处理下一个 choice
-	})
+	// 逐个 choice 检查 content 与 reasoning，未拒绝时脱敏并还原后回写
+	bodyStr, modified, denied = processResponseFields(pluginCtx, bodyStr, openAIResponseFields(root))
 
 	// 如果检测到敏感词，调用 DenyHandler
 	if denied {
 		wlog.LogWithLine("[%s] ProcessOpenAIResponse: sensitive word detected, calling deny() - isStream=%v",
 			pluginName, pluginCtx.OpenAIRequest.Stream)
 
-		return modified, denied
-	}
-
-	// 如有修改，写回响应体
-	if modified {
-		proxywasm.ReplaceHttpResponseBody([]byte(bodyStr))
+		return body, modified, denied
 	}
 
-	return modified, denied
+	return []byte(bodyStr), modified, denied
 }
 
-// handleRawResponse 处理非 OpenAI 的原始响应体
-func ProcessRawResponse(ctx wrapper.HttpContext, pluginCtx *config.PluginContext, bodyStr string) types.Action {
+// ProcessRawResponse 处理非 OpenAI 的原始响应体：检查整个响应体，未拒绝时脱敏并还原请求阶段脱敏的数据
+// 返回处理后的响应体、是否修改、是否拒绝
+func ProcessRawResponse(ctx wrapper.HttpContext, pluginCtx *config.PluginContext, bodyStr string, body []byte) ([]byte, bool, bool) {
 	// 命中敏感词直接拒绝（非流式响应）
-	if CheckMessage(bodyStr, pluginCtx.Config, config.SystemDenyWords, false) {
-		wlog.LogWithLine("[%s] ProcessRawResponse: sensitive word detected, calling deny() - isStream=%v, isOpenAI=%v",
-			pluginName, pluginCtx.OpenAIRequest.Stream, pluginCtx.RequestDenyModifyType)
-		action := DenyHandler(ctx, pluginCtx)
-		wlog.LogWithLine("[%s] ProcessRawResponse: deny() returned action=%v", pluginName, action)
-		return action
+	if checkDeny(pluginCtx, bodyStr, false) {
+		wlog.LogWithLine("[%s] ProcessRawResponse: sensitive word detected, isStream=%v", pluginName, pluginCtx.OpenAIRequest.Stream)
+		return body, false, true
 	}
 
-	// 还原脱敏数据
-	newBody := RestoreMessage(bodyStr, pluginCtx)
-	if newBody != bodyStr {
-		proxywasm.ReplaceHttpResponseBody([]byte(newBody))
+	// 替换动作为 replace 的敏感词，还原脱敏数据
+	newBody := RestoreMessage(MaskReplaceActionWords(bodyStr, pluginCtx.Config, config.GetSystemDenyWords()), pluginCtx)
+	if newBody == bodyStr {
+		return body, false, false
 	}
-
-	return types.ActionContinue
+	return []byte(newBody), true, false
 }
 
 // ProcessOpenAIStreamResponse 处理 OpenAI 流式 JSON 响应，使用滑动窗口缓冲区机制
@@ -277,6 +262,13 @@ func ProcessOpenAIStreamDenyResponse(ctx wrapper.HttpContext, pluginCtx *config.
 		return nil, true
 	}
 
+	// 多个 choice 时按 choice 拆分事件，各 choice 独立缓冲与检查
+	if streamByChoice(pluginCtx) && !pluginCtx.StreamInChoice {
+		return processChoiceStreams(pluginCtx, chunk, isLastChunk, func(data []byte, ended bool) ([]byte, bool) {
+			return ProcessOpenAIStreamDenyResponse(ctx, pluginCtx, data, ended)
+		})
+	}
+
 	// 初始化 chunk 缓冲区
 	if pluginCtx.StreamChunkBuffer == nil {
 		pluginCtx.StreamChunkBuffer = make([]config.StreamChunk, 0)
@@ -303,8 +295,8 @@ func ProcessOpenAIStreamDenyResponse(ctx wrapper.HttpContext, pluginCtx *config.
 			continue
 		}
 
-		// 检查是否是 [DONE] 标记
-		if strings.Contains(eventStr, "data: [DONE]") {
+		// 检查是否是流结束标记（OpenAI 的 [DONE] 或 Anthropic 的 message_stop）
+		if isStreamEndEvent(eventStr) {
 			streamEnded = true
 			// 添加 [DONE] chunk
 			pluginCtx.StreamChunkBuffer = append(pluginCtx.StreamChunkBuffer, config.StreamChunk{
@@ -319,6 +311,7 @@ func ProcessOpenAIStreamDenyResponse(ctx wrapper.HttpContext, pluginCtx *config.
 		lines := strings.Split(eventStr, "\n")
 		contentStart := len(pluginCtx.StreamContentBuffer)
 		reasoningStart := len(pluginCtx.StreamReasoningBuffer)
+		toolCallStart := len(pluginCtx.StreamToolCallBuffer)
 
 		for _, line := range lines {
 			line = strings.TrimSpace(line)
@@ -332,84 +325,92 @@ func ProcessOpenAIStreamDenyResponse(ctx wrapper.HttpContext, pluginCtx *config.
 			}
 			jsonStr := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
 
-			// 解析 JSON
-			root := gjson.Parse(jsonStr)
+			// 解析 JSON，deny_raw 按原始文本处理
+			root := streamEventRoot(pluginCtx, jsonStr)
 			if !root.Exists() {
 				continue
 			}
 
-			// 检查是否是 OpenAI 流式响应格式
-			choices := root.Get("choices")
-			if !choices.Exists() || !choices.IsArray() {
-				continue
-			}
-
-			// 处理每个 choice
-			choices.ForEach(func(key, choice gjson.Result) bool {
-				// 提取 delta 中的 content 和 reasoning 增量
-				delta := choice.Get("delta")
-				if !delta.Exists() {
-					return true
+			// 按请求协议提取 content、reasoning 和 tool_calls 参数增量
+			contentDelta, reasoningDelta, toolCallDelta := streamEventDeltas(pluginCtx, root)
+			recordStreamUsage(pluginCtx, root)
+
+			// 将增量添加到缓冲区（滑动窗口）
+			// 优化：使用 strings.Builder 减少内存分配（仅在需要时使用）
+			if contentDelta != "" {
+				oldLen := len(pluginCtx.StreamContentBuffer)
+				pluginCtx.StreamContentBuffer += contentDelta
+				newLen := len(pluginCtx.StreamContentBuffer)
+				// 限制缓冲区大小
+				if newLen > int(bufferSize) {
+					// 保留最新的 bufferSize 字节，滑动窗口
+					cutoff := newLen - int(bufferSize)
+					pluginCtx.StreamContentBuffer = pluginCtx.StreamContentBuffer[cutoff:]
+					// 调整所有 chunk 的 content 位置（优化：只调整受影响的 chunk）
+					for i := range pluginCtx.StreamChunkBuffer {
+						if pluginCtx.StreamChunkBuffer[i].ContentStart >= cutoff {
+							pluginCtx.StreamChunkBuffer[i].ContentStart -= cutoff
+							pluginCtx.StreamChunkBuffer[i].ContentEnd -= cutoff
+						} else {
+							pluginCtx.StreamChunkBuffer[i].ContentStart = 0
+							pluginCtx.StreamChunkBuffer[i].ContentEnd = 0
+						}
+					}
+					contentStart = newLen - int(bufferSize) - (oldLen - cutoff)
 				}
+			}
 
-				contentDelta := delta.Get("content").String()
-				reasoningDelta := delta.Get("reasoning").String()
-
-				// 将增量添加到缓冲区（滑动窗口）
-				// 优化：使用 strings.Builder 减少内存分配（仅在需要时使用）
-				if contentDelta != "" {
-					oldLen := len(pluginCtx.StreamContentBuffer)
-					pluginCtx.StreamContentBuffer += contentDelta
-					newLen := len(pluginCtx.StreamContentBuffer)
-					// 限制缓冲区大小
-					if newLen > int(bufferSize) {
-						// 保留最新的 bufferSize 字节，滑动窗口
-						cutoff := newLen - int(bufferSize)
-						pluginCtx.StreamContentBuffer = pluginCtx.StreamContentBuffer[cutoff:]
-						// 调整所有 chunk 的 content 位置（优化：只调整受影响的 chunk）
-						for i := range pluginCtx.StreamChunkBuffer {
-							if pluginCtx.StreamChunkBuffer[i].ContentStart >= cutoff {
-								pluginCtx.StreamChunkBuffer[i].ContentStart -= cutoff
-								pluginCtx.StreamChunkBuffer[i].ContentEnd -= cutoff
-							} else {
-								pluginCtx.StreamChunkBuffer[i].ContentStart = 0
-								pluginCtx.StreamChunkBuffer[i].ContentEnd = 0
-							}
+			if reasoningDelta != "" {
+				oldLen := len(pluginCtx.StreamReasoningBuffer)
+				pluginCtx.StreamReasoningBuffer += reasoningDelta
+				newLen := len(pluginCtx.StreamReasoningBuffer)
+				// 限制缓冲区大小
+				if newLen > int(bufferSize) {
+					// 保留最新的 bufferSize 字节，滑动窗口
+					cutoff := newLen - int(bufferSize)
+					pluginCtx.StreamReasoningBuffer = pluginCtx.StreamReasoningBuffer[cutoff:]
+					// 调整所有 chunk 的 reasoning 位置（优化：只调整受影响的 chunk）
+					for i := range pluginCtx.StreamChunkBuffer {
+						if pluginCtx.StreamChunkBuffer[i].ReasoningStart >= cutoff {
+							pluginCtx.StreamChunkBuffer[i].ReasoningStart -= cutoff
+							pluginCtx.StreamChunkBuffer[i].ReasoningEnd -= cutoff
+						} else {
+							pluginCtx.StreamChunkBuffer[i].ReasoningStart = 0
+							pluginCtx.StreamChunkBuffer[i].ReasoningEnd = 0
 						}
-						contentStart = newLen - int(bufferSize) - (oldLen - cutoff)
 					}
+					reasoningStart = newLen - int(bufferSize) - (oldLen - cutoff)
 				}
+			}
 
-				if reasoningDelta != "" {
-					oldLen := len(pluginCtx.StreamReasoningBuffer)
-					pluginCtx.StreamReasoningBuffer += reasoningDelta
-					newLen := len(pluginCtx.StreamReasoningBuffer)
-					// 限制缓冲区大小
-					if newLen > int(bufferSize) {
-						// 保留最新的 bufferSize 字节，滑动窗口
-						cutoff := newLen - int(bufferSize)
-						pluginCtx.StreamReasoningBuffer = pluginCtx.StreamReasoningBuffer[cutoff:]
-						// 调整所有 chunk 的 reasoning 位置（优化：只调整受影响的 chunk）
-						for i := range pluginCtx.StreamChunkBuffer {
-							if pluginCtx.StreamChunkBuffer[i].ReasoningStart >= cutoff {
-								pluginCtx.StreamChunkBuffer[i].ReasoningStart -= cutoff
-								pluginCtx.StreamChunkBuffer[i].ReasoningEnd -= cutoff
-							} else {
-								pluginCtx.StreamChunkBuffer[i].ReasoningStart = 0
-								pluginCtx.StreamChunkBuffer[i].ReasoningEnd = 0
-							}
+			if toolCallDelta != "" {
+				oldLen := len(pluginCtx.StreamToolCallBuffer)
+				pluginCtx.StreamToolCallBuffer += toolCallDelta
+				newLen := len(pluginCtx.StreamToolCallBuffer)
+				// 限制缓冲区大小
+				if newLen > int(bufferSize) {
+					// 保留最新的 bufferSize 字节，滑动窗口
+					cutoff := newLen - int(bufferSize)
+					pluginCtx.StreamToolCallBuffer = pluginCtx.StreamToolCallBuffer[cutoff:]
+					// 调整所有 chunk 的 tool_calls 参数位置
+					for i := range pluginCtx.StreamChunkBuffer {
+						if pluginCtx.StreamChunkBuffer[i].ToolCallStart >= cutoff {
+							pluginCtx.StreamChunkBuffer[i].ToolCallStart -= cutoff
+							pluginCtx.StreamChunkBuffer[i].ToolCallEnd -= cutoff
+						} else {
+							pluginCtx.StreamChunkBuffer[i].ToolCallStart = 0
+							pluginCtx.StreamChunkBuffer[i].ToolCallEnd = 0
 						}
-						reasoningStart = newLen - int(bufferSize) - (oldLen - cutoff)
 					}
+					toolCallStart = newLen - int(bufferSize) - (oldLen - cutoff)
 				}
-
-				return true
-			})
+			}
 		}
 
 		// 记录 chunk 信息
 		contentEnd := len(pluginCtx.StreamContentBuffer)
 		reasoningEnd := len(pluginCtx.StreamReasoningBuffer)
+		toolCallEnd := len(pluginCtx.StreamToolCallBuffer)
 
 		pluginCtx.StreamChunkBuffer = append(pluginCtx.StreamChunkBuffer, config.StreamChunk{
 			Data:           []byte(eventStr + "\n\n"),
@@ -417,13 +418,21 @@ func ProcessOpenAIStreamDenyResponse(ctx wrapper.HttpContext, pluginCtx *config.
 			ContentEnd:     contentEnd,
 			ReasoningStart: reasoningStart,
 			ReasoningEnd:   reasoningEnd,
+			ToolCallStart:  toolCallStart,
+			ToolCallEnd:    toolCallEnd,
 			IsDone:         false,
+			ReceivedAt:     time.Now().UnixMilli(),
 		})
 		pluginCtx.StreamChunkBufferSize += len(eventStr) + 2
 	}
 
-	// 检查是否需要处理缓冲区（缓冲区满或流结束）
-	shouldProcess := streamEnded || pluginCtx.StreamChunkBufferSize >= int(bufferSize)
+	// Gemini 等协议没有流结束标记，最后一个 chunk 同样视为流结束
+	if isLastChunk {
+		streamEnded = true
+	}
+
+	// 检查是否需要处理缓冲区（缓冲区满或流结束）；holdback 模式每个 chunk 都检查，尽早输出安全的文本
+	shouldProcess := streamEnded || pluginCtx.StreamChunkBufferSize >= int(bufferSize) || pluginCtx.Config.StreamHoldback
 
 	// 优化：即使缓冲区未满，也进行增量检测（检测新增部分）
 	// 这样可以更早发现敏感词，避免等待缓冲区满
@@ -434,7 +443,7 @@ func ProcessOpenAIStreamDenyResponse(ctx wrapper.HttpContext, pluginCtx *config.
 			// 检测新增的 content 部分
 			if lastChunk.ContentEnd > lastChunk.ContentStart {
 				newContent := pluginCtx.StreamContentBuffer[lastChunk.ContentStart:lastChunk.ContentEnd]
-				if CheckMessage(newContent, pluginCtx.Config, config.SystemDenyWords, false) {
+				if CheckMessage(newContent, pluginCtx.Config, config.GetSystemDenyWords(), false) {
 					// 发现敏感词，立即处理
 					shouldProcess = true
 				}
@@ -442,7 +451,15 @@ func ProcessOpenAIStreamDenyResponse(ctx wrapper.HttpContext, pluginCtx *config.
 			// 检测新增的 reasoning 部分
 			if !shouldProcess && lastChunk.ReasoningEnd > lastChunk.ReasoningStart {
 				newReasoning := pluginCtx.StreamReasoningBuffer[lastChunk.ReasoningStart:lastChunk.ReasoningEnd]
-				if CheckMessage(newReasoning, pluginCtx.Config, config.SystemDenyWords, false) {
+				if CheckMessage(newReasoning, pluginCtx.Config, config.GetSystemDenyWords(), false) {
+					// 发现敏感词，立即处理
+					shouldProcess = true
+				}
+			}
+			// 检测新增的 tool_calls 参数部分
+			if !shouldProcess && lastChunk.ToolCallEnd > lastChunk.ToolCallStart {
+				newToolCall := pluginCtx.StreamToolCallBuffer[lastChunk.ToolCallStart:lastChunk.ToolCallEnd]
+				if CheckMessage(newToolCall, pluginCtx.Config, config.GetSystemDenyWords(), false) {
 					// 发现敏感词，立即处理
 					shouldProcess = true
 				}
@@ -462,33 +479,53 @@ func ProcessOpenAIStreamDenyResponse(ctx wrapper.HttpContext, pluginCtx *config.
 
 	// 检查累积缓冲区中是否包含敏感词，并获取所有匹配的位置
 	// 这样可以识别跨越多个 chunk 的敏感词
-	contentMatches := FindSensitiveWordMatches(pluginCtx.StreamContentBuffer, pluginCtx.Config, config.SystemDenyWords)
-	reasoningMatches := FindSensitiveWordMatches(pluginCtx.StreamReasoningBuffer, pluginCtx.Config, config.SystemDenyWords)
+	contentMatches := FindSensitiveWordMatches(pluginCtx.StreamContentBuffer, pluginCtx.Config, config.GetSystemDenyWords())
+	reasoningMatches := FindSensitiveWordMatches(pluginCtx.StreamReasoningBuffer, pluginCtx.Config, config.GetSystemDenyWords())
+	toolCallMatches := FindSensitiveWordMatches(pluginCtx.StreamToolCallBuffer, pluginCtx.Config, config.GetSystemDenyWords())
+
+	// 动作为 replace 的命中不拒绝，在返回前替换为掩码；动作为 log 的命中忽略
+	contentReplaceMatches := filterMatchAction(contentMatches, config.DenyActionReplace)
+	reasoningReplaceMatches := filterMatchAction(reasoningMatches, config.DenyActionReplace)
+	toolCallReplaceMatches := filterMatchAction(toolCallMatches, config.DenyActionReplace)
+	contentMatches = BlockingMatches(contentMatches)
+	reasoningMatches = BlockingMatches(reasoningMatches)
+	toolCallMatches = BlockingMatches(toolCallMatches)
+
+	// 流未结束时，位于缓冲区末尾、可能被后续数据补全为白名单词的命中暂不处理，继续缓冲等待
+	if !streamEnded {
+		var contentPending, reasoningPending, toolCallPending bool
+		contentMatches, contentPending = dropPendingAllowMatches(pluginCtx.StreamContentBuffer, contentMatches, pluginCtx.Config)
+		reasoningMatches, reasoningPending = dropPendingAllowMatches(pluginCtx.StreamReasoningBuffer, reasoningMatches, pluginCtx.Config)
+		toolCallMatches, toolCallPending = dropPendingAllowMatches(pluginCtx.StreamToolCallBuffer, toolCallMatches, pluginCtx.Config)
+		if (contentPending || reasoningPending || toolCallPending) && len(contentMatches) == 0 && len(reasoningMatches) == 0 && len(toolCallMatches) == 0 {
+			wlog.LogWithLine("[%s] ProcessOpenAIStreamResponse: match may be covered by allow word, waiting for more data", pluginName)
+			return nil, false
+		}
+	}
 
 	// 优化：合并匹配结果，减少遍历次数
-	allMatches := make([]struct {
-		match     MatchResult
-		isContent bool
-	}, 0, len(contentMatches)+len(reasoningMatches))
+	type bufferMatch struct {
+		match  MatchResult
+		buffer string // content、reasoning 或 tool_calls
+	}
+	allMatches := make([]bufferMatch, 0, len(contentMatches)+len(reasoningMatches)+len(toolCallMatches))
 	for _, match := range contentMatches {
-		allMatches = append(allMatches, struct {
-			match     MatchResult
-			isContent bool
-		}{match, true})
+		allMatches = append(allMatches, bufferMatch{match, "content"})
 	}
 	for _, match := range reasoningMatches {
-		allMatches = append(allMatches, struct {
-			match     MatchResult
-			isContent bool
-		}{match, false})
+		allMatches = append(allMatches, bufferMatch{match, "reasoning"})
+	}
+	for _, match := range toolCallMatches {
+		allMatches = append(allMatches, bufferMatch{match, "tool_calls"})
 	}
 
 	// 优化：一次遍历标记所有涉及的 chunk
 	if len(allMatches) > 0 {
 		denied = true
+		// 记录严重级别最高的命中分类
+		recordDenyMatch(pluginCtx, selectBlockingMatch(append(append(contentMatches, reasoningMatches...), toolCallMatches...)))
 		for _, item := range allMatches {
 			match := item.match
-			isContent := item.isContent
 			// 找到所有与这个敏感词位置重叠的 chunk
 			for i, streamChunk := range pluginCtx.StreamChunkBuffer {
 				if streamChunk.IsDone {
@@ -496,12 +533,16 @@ func ProcessOpenAIStreamDenyResponse(ctx wrapper.HttpContext, pluginCtx *config.
 				}
 
 				var chunkStart, chunkEnd int
-				if isContent {
+				switch item.buffer {
+				case "content":
 					chunkStart = streamChunk.ContentStart
 					chunkEnd = streamChunk.ContentEnd
-				} else {
+				case "reasoning":
 					chunkStart = streamChunk.ReasoningStart
 					chunkEnd = streamChunk.ReasoningEnd
+				default:
+					chunkStart = streamChunk.ToolCallStart
+					chunkEnd = streamChunk.ToolCallEnd
 				}
 
 				// 检查敏感词位置是否与 chunk 位置重叠
@@ -511,7 +552,7 @@ func ProcessOpenAIStreamDenyResponse(ctx wrapper.HttpContext, pluginCtx *config.
 					(match.StartPos <= chunkStart && match.EndPos >= chunkEnd) {
 					deniedChunkIndices[i] = true
 					wlog.LogWithLine("[%s] ProcessOpenAIStreamResponse: sensitive word '%s' detected in %s, marking chunk %d (pos: %d-%d, chunk: %d-%d)",
-						pluginName, match.MatchedWord, map[bool]string{true: "content", false: "reasoning"}[isContent], i, match.StartPos, match.EndPos, chunkStart, chunkEnd)
+						pluginName, match.MatchedWord, item.buffer, i, match.StartPos, match.EndPos, chunkStart, chunkEnd)
 				}
 			}
 		}
@@ -524,25 +565,27 @@ func ProcessOpenAIStreamDenyResponse(ctx wrapper.HttpContext, pluginCtx *config.
 		pluginCtx.StreamDenied = true
 
 		// 构造拒绝消息的 SSE 事件，替换包含敏感词的chunk
-		denyMessage := pluginCtx.Config.DenyMessage
-		if denyMessage == "" {
-			denyMessage = "提问或回答中包含敏感词，已被屏蔽"
-		}
-
-		// 构造替换的 SSE 事件（替换第一个包含敏感词的chunk的位置）
-		replacementChunk := fmt.Sprintf("data: {\"id\":\"chatcmpl-deny\",\"object\":\"chat.completion.chunk\",\"created\":123,\"model\":\"%s\",\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\",\"content\":\"%s\"},\"finish_reason\":null}]}\n\n",
-			pluginCtx.OpenAIRequest.Model, denyMessage)
-		result.WriteString(replacementChunk)
-
-		// 检测到敏感词后，立即添加 [DONE] 标记，结束流
-		result.WriteString("data: [DONE]\n\n")
+		result.WriteString(streamDenyEvents(pluginCtx, streamEnded))
 		wlog.LogWithLine("[%s] ProcessOpenAIStreamResponse: sensitive word detected, result=%s",
 			pluginName, result.String())
+	} else if pluginCtx.Config.StreamHoldback && !streamEnded {
+		// holdback 模式：立即输出末尾暂缓部分之前的 chunk，其余 chunk 继续缓冲
+		replaceMatches := [3][]MatchResult{contentReplaceMatches, reasoningReplaceMatches, toolCallReplaceMatches}
+		releaseHoldbackChunks(&result, pluginCtx, holdbackReleaseCount(pluginCtx, replaceMatches, time.Now().UnixMilli()), replaceMatches)
+		if result.Len() == 0 {
+			return nil, false
+		}
+		return []byte(result.String()), false
+	} else if len(contentReplaceMatches) > 0 || len(reasoningReplaceMatches) > 0 || len(toolCallReplaceMatches) > 0 {
+		// 只有动作为 replace 的命中：替换为掩码后返回
+		writeReplaceActionChunks(&result, pluginCtx, contentReplaceMatches, reasoningReplaceMatches, toolCallReplaceMatches)
+		trackStreamEvents(pluginCtx)
 	} else {
 		// 没有敏感词：原样返回所有 chunk
 		for _, streamChunk := range pluginCtx.StreamChunkBuffer {
-			result.Write(streamChunk.Data)
+			writeStreamChunk(&result, pluginCtx, streamChunk.Data)
 		}
+		trackStreamEvents(pluginCtx)
 	}
 
 	// 清空缓冲区，准备处理下一批数据
@@ -561,10 +604,58 @@ func ProcessOpenAIStreamDenyResponse(ctx wrapper.HttpContext, pluginCtx *config.
 	return resultBytes, denied
 }
 
+// streamDenyEvents 按响应格式构造流式响应被拦截时的拒绝事件
+func streamDenyEvents(pluginCtx *config.PluginContext, streamEnded bool) string {
+	denyMessage := RenderDenyMessage(pluginCtx)
+	switch {
+	case isRawResponseMode(pluginCtx):
+		// response_jsonpath、deny_raw：以 deny_raw_message 作为最后一个事件
+		return rawStreamDenyEvent(pluginCtx)
+	case pluginCtx.RespIsNDJSON:
+		// NDJSON：以 done 为 true 的一行输出拒绝消息
+		return ndjsonDenyEvent(pluginCtx, denyMessage)
+	case pluginCtx.Protocol == config.APIProtocolAnthropic:
+		// Anthropic：补齐事件序列，以新的文本块输出拒绝消息并以 message_stop 结束
+		return anthropicStreamDenyEvents(pluginCtx, denyMessage)
+	case pluginCtx.Protocol == config.APIProtocolResponses:
+		// Responses API：以新的消息输出项输出拒绝消息并以 response.completed 结束
+		return responsesStreamDenyEvents(pluginCtx, denyMessage)
+	case pluginCtx.Protocol == config.APIProtocolGemini:
+		// Gemini：没有流结束标记，以 finishReason 为 SAFETY 的事件输出拒绝消息
+		return string(GeminiDenyResponse(pluginCtx.OpenAIRequest.Model, denyMessage, true, false))
+	}
+	// OpenAI 与旧版 Completions：沿用上游事件的 id、model、created，以 finish_reason 为 content_filter 的事件输出拒绝消息
+	events := streamDenyEvent(pluginCtx, denyMessage)
+	// 多个 choice 时只结束命中的 choice，其他 choice 继续输出，所有 choice 都被拒绝后再结束流
+	if !pluginCtx.StreamInChoice {
+		// 补发 usage 后以 [DONE] 结束流，请求需要 usage 且流未结束时等待上游的 usage
+		events += streamDenyEnd(pluginCtx, streamEnded)
+	}
+	return events
+}
+
+// DenyStream 结束无法检查的流式响应（如压缩数据无法解压）：丢弃缓冲的事件，输出拒绝事件
+func DenyStream(pluginCtx *config.PluginContext) []byte {
+	pluginCtx.StreamInChoice = false
+	events := streamDenyEvents(pluginCtx, true)
+	pluginCtx.StreamDenied = true
+	pluginCtx.StreamChunkBuffer = nil
+	pluginCtx.StreamChunkBufferSize = 0
+	return []byte(events)
+}
+
 // ProcessOpenAIStreamReplaceResponse 处理 OpenAI 流式 JSON 响应，使用固定数量缓冲区机制
 // 缓冲10个最近的chunk，检测到敏感词则替换后一次性返回，没有检测到敏感词则正常返回
 // 缓冲区满或没有敏感词则返回，并清空缓冲区
 func ProcessOpenAIStreamReplaceResponse(ctx wrapper.HttpContext, pluginCtx *config.PluginContext, chunk []byte, isLastChunk bool) []byte {
+	// 多个 choice 时按 choice 拆分事件，各 choice 独立缓冲与替换
+	if streamByChoice(pluginCtx) && !pluginCtx.StreamInChoice {
+		result, _ := processChoiceStreams(pluginCtx, chunk, isLastChunk, func(data []byte, ended bool) ([]byte, bool) {
+			return ProcessOpenAIStreamReplaceResponse(ctx, pluginCtx, data, ended), false
+		})
+		return result
+	}
+
 	bufferChunkCount := int(pluginCtx.Config.MaxBufferChunkCount)
 
 	// 初始化缓冲区
@@ -573,8 +664,10 @@ func ProcessOpenAIStreamReplaceResponse(ctx wrapper.HttpContext, pluginCtx *conf
 		pluginCtx.StreamChunkBufferSize = 0
 		pluginCtx.StreamContentBuffer = ""
 		pluginCtx.StreamReasoningBuffer = ""
+		pluginCtx.StreamToolCallBuffer = ""
 		pluginCtx.StreamContentBufferOffset = 0
 		pluginCtx.StreamReasoningBufferOffset = 0
+		pluginCtx.StreamToolCallBufferOffset = 0
 	}
 
 	// 获取替换值
@@ -597,8 +690,8 @@ func ProcessOpenAIStreamReplaceResponse(ctx wrapper.HttpContext, pluginCtx *conf
 			continue
 		}
 
-		// 检查是否是 [DONE] 标记
-		if strings.Contains(eventStr, "data: [DONE]") {
+		// 检查是否是流结束标记（OpenAI 的 [DONE] 或 Anthropic 的 message_stop）
+		if isStreamEndEvent(eventStr) {
 			streamEnded = true
 			// 添加 [DONE] chunk
 			pluginCtx.StreamChunkBuffer = append(pluginCtx.StreamChunkBuffer, config.StreamChunk{
@@ -613,6 +706,7 @@ func ProcessOpenAIStreamReplaceResponse(ctx wrapper.HttpContext, pluginCtx *conf
 		lines := strings.Split(eventStr, "\n")
 		contentStart := len(pluginCtx.StreamContentBuffer)
 		reasoningStart := len(pluginCtx.StreamReasoningBuffer)
+		toolCallStart := len(pluginCtx.StreamToolCallBuffer)
 
 		for _, line := range lines {
 			line = strings.TrimSpace(line)
@@ -626,45 +720,33 @@ func ProcessOpenAIStreamReplaceResponse(ctx wrapper.HttpContext, pluginCtx *conf
 			}
 			jsonStr := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
 
-			// 解析 JSON
-			root := gjson.Parse(jsonStr)
+			// 解析 JSON，deny_raw 按原始文本处理
+			root := streamEventRoot(pluginCtx, jsonStr)
 			if !root.Exists() {
 				continue
 			}
 
-			// 检查是否是 OpenAI 流式响应格式
-			choices := root.Get("choices")
-			if !choices.Exists() || !choices.IsArray() {
-				continue
-			}
-
-			// 处理每个 choice
-			choices.ForEach(func(key, choice gjson.Result) bool {
-				// 提取 delta 中的 content 和 reasoning 增量
-				delta := choice.Get("delta")
-				if !delta.Exists() {
-					return true
-				}
-
-				contentDelta := delta.Get("content").String()
-				reasoningDelta := delta.Get("reasoning").String()
+			// 按请求协议提取 content、reasoning 和 tool_calls 参数增量
+			contentDelta, reasoningDelta, toolCallDelta := streamEventDeltas(pluginCtx, root)
 
-				// 将增量添加到缓冲区
-				if contentDelta != "" {
-					pluginCtx.StreamContentBuffer += contentDelta
-				}
+			// 将增量添加到缓冲区
+			if contentDelta != "" {
+				pluginCtx.StreamContentBuffer += contentDelta
+			}
 
-				if reasoningDelta != "" {
-					pluginCtx.StreamReasoningBuffer += reasoningDelta
-				}
+			if reasoningDelta != "" {
+				pluginCtx.StreamReasoningBuffer += reasoningDelta
+			}
 
-				return true
-			})
+			if toolCallDelta != "" {
+				pluginCtx.StreamToolCallBuffer += toolCallDelta
+			}
 		}
 
 		// 记录 chunk 信息
 		contentEnd := len(pluginCtx.StreamContentBuffer)
 		reasoningEnd := len(pluginCtx.StreamReasoningBuffer)
+		toolCallEnd := len(pluginCtx.StreamToolCallBuffer)
 
 		pluginCtx.StreamChunkBuffer = append(pluginCtx.StreamChunkBuffer, config.StreamChunk{
 			Data:           []byte(eventStr + "\n\n"),
@@ -672,11 +754,18 @@ func ProcessOpenAIStreamReplaceResponse(ctx wrapper.HttpContext, pluginCtx *conf
 			ContentEnd:     contentEnd,
 			ReasoningStart: reasoningStart,
 			ReasoningEnd:   reasoningEnd,
+			ToolCallStart:  toolCallStart,
+			ToolCallEnd:    toolCallEnd,
 			IsDone:         false,
 		})
 		pluginCtx.StreamChunkBufferSize += len(eventStr) + 2
 	}
 
+	// Gemini 等协议没有流结束标记，最后一个 chunk 同样视为流结束
+	if isLastChunk {
+		streamEnded = true
+	}
+
 	// 检查是否需要处理缓冲区
 	// 1. 流结束
 	// 2. 缓冲区满（10个chunk）
@@ -684,18 +773,26 @@ func ProcessOpenAIStreamReplaceResponse(ctx wrapper.HttpContext, pluginCtx *conf
 	shouldProcess := streamEnded || len(pluginCtx.StreamChunkBuffer) >= int(bufferChunkCount)
 
 	// 检测累积缓冲区中是否包含敏感词
+	// 延伸到缓冲区末尾的正则命中可能随后续数据变长，不提前处理，避免只替换了前半部分
 	hasSensitiveWord := false
 	if !shouldProcess && len(pluginCtx.StreamChunkBuffer) > 0 {
 		// 检测 content 缓冲区
 		if len(pluginCtx.StreamContentBuffer) > 0 {
-			if CheckMessage(pluginCtx.StreamContentBuffer, pluginCtx.Config, config.SystemDenyWords, true) {
+			if HasSettledMatch(pluginCtx.StreamContentBuffer, pluginCtx.Config, config.GetSystemDenyWords()) {
 				hasSensitiveWord = true
 				shouldProcess = true
 			}
 		}
 		// 检测 reasoning 缓冲区
 		if !hasSensitiveWord && len(pluginCtx.StreamReasoningBuffer) > 0 {
-			if CheckMessage(pluginCtx.StreamReasoningBuffer, pluginCtx.Config, config.SystemDenyWords, true) {
+			if HasSettledMatch(pluginCtx.StreamReasoningBuffer, pluginCtx.Config, config.GetSystemDenyWords()) {
+				hasSensitiveWord = true
+				shouldProcess = true
+			}
+		}
+		// 检测 tool_calls 参数缓冲区
+		if !hasSensitiveWord && len(pluginCtx.StreamToolCallBuffer) > 0 {
+			if HasSettledMatch(pluginCtx.StreamToolCallBuffer, pluginCtx.Config, config.GetSystemDenyWords()) {
 				hasSensitiveWord = true
 				shouldProcess = true
 			}
@@ -709,14 +806,15 @@ func ProcessOpenAIStreamReplaceResponse(ctx wrapper.HttpContext, pluginCtx *conf
 
 	// 处理缓冲区：检测敏感词并替换
 	// 查找所有敏感词匹配的位置
-	contentMatches := FindSensitiveWordMatches(pluginCtx.StreamContentBuffer, pluginCtx.Config, config.SystemDenyWords)
-	reasoningMatches := FindSensitiveWordMatches(pluginCtx.StreamReasoningBuffer, pluginCtx.Config, config.SystemDenyWords)
+	contentMatches := FindSensitiveWordMatches(pluginCtx.StreamContentBuffer, pluginCtx.Config, config.GetSystemDenyWords())
+	reasoningMatches := FindSensitiveWordMatches(pluginCtx.StreamReasoningBuffer, pluginCtx.Config, config.GetSystemDenyWords())
+	toolCallMatches := FindSensitiveWordMatches(pluginCtx.StreamToolCallBuffer, pluginCtx.Config, config.GetSystemDenyWords())
 
 	// 更新敏感词检测结果
-	hasSensitiveWord = len(contentMatches) > 0 || len(reasoningMatches) > 0
+	hasSensitiveWord = len(contentMatches) > 0 || len(reasoningMatches) > 0 || len(toolCallMatches) > 0
 
-	wlog.LogWithLine("[%s] ProcessOpenAIStreamReplaceResponse: chunkCount=%d, hasSensitiveWord=%v, contentMatches=%d, reasoningMatches=%d",
-		pluginName, len(pluginCtx.StreamChunkBuffer), hasSensitiveWord, len(contentMatches), len(reasoningMatches))
+	wlog.LogWithLine("[%s] ProcessOpenAIStreamReplaceResponse: chunkCount=%d, hasSensitiveWord=%v, contentMatches=%d, reasoningMatches=%d, toolCallMatches=%d",
+		pluginName, len(pluginCtx.StreamChunkBuffer), hasSensitiveWord, len(contentMatches), len(reasoningMatches), len(toolCallMatches))
 
 	if len(contentMatches) > 0 {
 		for _, match := range contentMatches {
@@ -737,116 +835,15 @@ func ProcessOpenAIStreamReplaceResponse(ctx wrapper.HttpContext, pluginCtx *conf
 	if hasSensitiveWord {
 		// 有敏感词：替换后返回
 		// 替换完整文本中的敏感词
-		replacedContent := ReplaceSensitiveWordsWithValue(pluginCtx.StreamContentBuffer, pluginCtx.Config, config.SystemDenyWords, replaceValue)
-		replacedReasoning := ReplaceSensitiveWordsWithValue(pluginCtx.StreamReasoningBuffer, pluginCtx.Config, config.SystemDenyWords, replaceValue)
-
-		// 由于 ReplaceSensitiveWordsWithValue 保持字符数（rune）相等，但字节数可能不同
-		// 我们需要按字符位置（rune）来映射，而不是按字节位置
-		// 将替换后的文本转换为 rune 数组，以便按字符位置映射
-		replacedContentRunes := []rune(replacedContent)
-		replacedReasoningRunes := []rune(replacedReasoning)
+		replacedContent := ReplaceSensitiveWordsWithValue(pluginCtx.StreamContentBuffer, pluginCtx.Config, config.GetSystemDenyWords(), replaceValue)
+		replacedReasoning := ReplaceSensitiveWordsWithValue(pluginCtx.StreamReasoningBuffer, pluginCtx.Config, config.GetSystemDenyWords(), replaceValue)
+		replacedToolCall := ReplaceSensitiveWordsWithValue(pluginCtx.StreamToolCallBuffer, pluginCtx.Config, config.GetSystemDenyWords(), replaceValue)
 
-		for _, streamChunk := range pluginCtx.StreamChunkBuffer {
-			if streamChunk.IsDone {
-				// [DONE] 标记直接返回
-				result.Write(streamChunk.Data)
-				continue
-			}
-
-			// 提取当前 chunk 的原始 JSON
-			chunkDataStr := strings.TrimSpace(string(streamChunk.Data))
-			lines := strings.Split(chunkDataStr, "\n")
-			var jsonStr string
-			for _, line := range lines {
-				line = strings.TrimSpace(line)
-				if strings.HasPrefix(line, "data:") {
-					jsonStr = strings.TrimSpace(strings.TrimPrefix(line, "data:"))
-					break
-				}
-			}
-
-			if jsonStr == "" {
-				// 无法解析，直接返回原始数据
-				result.Write(streamChunk.Data)
-				continue
-			}
-
-			// 解析 JSON
-			root := gjson.Parse(jsonStr)
-			if !root.Exists() {
-				result.Write(streamChunk.Data)
-				continue
-			}
-
-			// 计算当前 chunk 对应的替换后的增量内容
-			contentStart := streamChunk.ContentStart
-			contentEnd := streamChunk.ContentEnd
-			reasoningStart := streamChunk.ReasoningStart
-			reasoningEnd := streamChunk.ReasoningEnd
-
-			// 获取替换后的增量内容（按字符位置）
-			var newContentDelta string
-			var newReasoningDelta string
-
-			if contentEnd > contentStart {
-				// 转换为字符位置
-				// 计算原始文本中 contentStart 之前的字符数
-				contentStartRunePos := len([]rune(pluginCtx.StreamContentBuffer[:contentStart]))
-				contentEndRunePos := len([]rune(pluginCtx.StreamContentBuffer[:contentEnd]))
-				// 从替换后的文本中提取对应的字符
-				if contentEndRunePos <= len(replacedContentRunes) {
-					newContentDelta = string(replacedContentRunes[contentStartRunePos:contentEndRunePos])
-				}
-			}
-
-			if reasoningEnd > reasoningStart {
-				// 转换为字符位置
-				// 计算原始文本中 reasoningStart 之前的字符数
-				reasoningStartRunePos := len([]rune(pluginCtx.StreamReasoningBuffer[:reasoningStart]))
-				reasoningEndRunePos := len([]rune(pluginCtx.StreamReasoningBuffer[:reasoningEnd]))
-				// 从替换后的文本中提取对应的字符
-				if reasoningEndRunePos <= len(replacedReasoningRunes) {
-					newReasoningDelta = string(replacedReasoningRunes[reasoningStartRunePos:reasoningEndRunePos])
-				}
-			}
-
-			// 更新 JSON 中的 delta.content 和 delta.reasoning
-			newJsonStr := jsonStr
-			if newContentDelta != "" {
-				// 更新 delta.content
-				deltaPath := "choices.0.delta.content"
-				oldContent := root.Get(deltaPath).String()
-				if oldContent != "" {
-					var err error
-					newJsonStr, err = sjson.Set(newJsonStr, deltaPath, newContentDelta)
-					if err != nil {
-						wlog.LogWithLine("[%s] ProcessOpenAIStreamReplaceResponse: failed to set content: %v", pluginName, err)
-						result.Write(streamChunk.Data)
-						continue
-					}
-				}
-			}
-
-			if newReasoningDelta != "" {
-				// 更新 delta.reasoning
-				deltaPath := "choices.0.delta.reasoning"
-				oldReasoning := root.Get(deltaPath).String()
-				if oldReasoning != "" {
-					var err error
-					newJsonStr, err = sjson.Set(newJsonStr, deltaPath, newReasoningDelta)
-					if err != nil {
-						wlog.LogWithLine("[%s] ProcessOpenAIStreamReplaceResponse: failed to set reasoning: %v", pluginName, err)
-					}
-				}
-			}
-
-			// 重新构建 SSE 事件
-			result.WriteString("data: " + newJsonStr + "\n\n")
-		}
+		writeReplacedChunks(&result, pluginCtx, replacedContent, replacedReasoning, replacedToolCall)
 	} else {
 		// 没有敏感词：直接返回所有 chunk 的原始数据
 		for _, streamChunk := range pluginCtx.StreamChunkBuffer {
-			result.Write(streamChunk.Data)
+			writeStreamChunk(&result, pluginCtx, streamChunk.Data)
 		}
 	}
 
@@ -859,30 +856,28 @@ func ProcessOpenAIStreamReplaceResponse(ctx wrapper.HttpContext, pluginCtx *conf
 	pluginCtx.StreamChunkBufferSize = 0
 
 	// 保留内容缓冲区的尾部数据，以便检测跨越窗口边界的敏感词
-	// 保留长度 = 最长敏感词的长度（字节数），在配置解析时已计算
-	maxSensitiveWordLen := config.MaxSensitiveWordLength
-
-	// 保留 StreamContentBuffer 的最后 maxSensitiveWordLen 个字节
-	if len(pluginCtx.StreamContentBuffer) > maxSensitiveWordLen {
-		keepStart := len(pluginCtx.StreamContentBuffer) - maxSensitiveWordLen
-		pluginCtx.StreamContentBuffer = pluginCtx.StreamContentBuffer[keepStart:]
-		// 记录保留的起始位置，用于调整后续 chunk 的位置索引
-		pluginCtx.StreamContentBufferOffset = keepStart
-	} else {
-		// 如果缓冲区长度小于等于保留长度，保留全部
-		pluginCtx.StreamContentBufferOffset = 0
-	}
-
-	// 保留 StreamReasoningBuffer 的最后 maxSensitiveWordLen 个字节
-	if len(pluginCtx.StreamReasoningBuffer) > maxSensitiveWordLen {
-		keepStart := len(pluginCtx.StreamReasoningBuffer) - maxSensitiveWordLen
-		pluginCtx.StreamReasoningBuffer = pluginCtx.StreamReasoningBuffer[keepStart:]
-		// 记录保留的起始位置，用于调整后续 chunk 的位置索引
-		pluginCtx.StreamReasoningBufferOffset = keepStart
-	} else {
-		// 如果缓冲区长度小于等于保留长度，保留全部
-		pluginCtx.StreamReasoningBufferOffset = 0
-	}
+	// 保留长度 = 最长敏感词的长度（字节数），在配置解析时已计算，系统词库刷新后随之变化
+	maxSensitiveWordLen := streamOverlapLength(pluginCtx.Config)
+	normalizeOpts := &pluginCtx.Config.Normalize
+	var keepStart int
+
+	// 保留 StreamContentBuffer 的最后 maxSensitiveWordLen 个字节（按归一化文本计算）
+	keepStart = streamKeepStart(pluginCtx.StreamContentBuffer, maxSensitiveWordLen, normalizeOpts)
+	pluginCtx.StreamContentBuffer = pluginCtx.StreamContentBuffer[keepStart:]
+	// 记录保留的起始位置，用于调整后续 chunk 的位置索引，保留全部时为 0
+	pluginCtx.StreamContentBufferOffset = keepStart
+
+	// 保留 StreamReasoningBuffer 的最后 maxSensitiveWordLen 个字节（按归一化文本计算）
+	keepStart = streamKeepStart(pluginCtx.StreamReasoningBuffer, maxSensitiveWordLen, normalizeOpts)
+	pluginCtx.StreamReasoningBuffer = pluginCtx.StreamReasoningBuffer[keepStart:]
+	// 记录保留的起始位置，用于调整后续 chunk 的位置索引，保留全部时为 0
+	pluginCtx.StreamReasoningBufferOffset = keepStart
+
+	// 保留 StreamToolCallBuffer 的最后 maxSensitiveWordLen 个字节（按归一化文本计算）
+	keepStart = streamKeepStart(pluginCtx.StreamToolCallBuffer, maxSensitiveWordLen, normalizeOpts)
+	pluginCtx.StreamToolCallBuffer = pluginCtx.StreamToolCallBuffer[keepStart:]
+	// 记录保留的起始位置，用于调整后续 chunk 的位置索引，保留全部时为 0
+	pluginCtx.StreamToolCallBufferOffset = keepStart
 
 	resultBytes := []byte(result.String())
 	if len(resultBytes) == 0 {
@@ -895,6 +890,161 @@ func ProcessOpenAIStreamReplaceResponse(ctx wrapper.HttpContext, pluginCtx *conf
 	return resultBytes
 }
 
+// writeReplaceActionChunks 将动作为 replace 的命中替换为掩码后输出缓冲区中的 chunk
+func writeReplaceActionChunks(result *strings.Builder, pluginCtx *config.PluginContext, contentMatches, reasoningMatches, toolCallMatches []MatchResult) {
+	replaceValue := pluginCtx.Config.ResponseDenyPlot.Value
+	if replaceValue == "" {
+		replaceValue = "*"
+	}
+	replacer := sensitiveWordReplacer(&pluginCtx.Config.ResponseDenyPlot, replaceValue)
+	replacedContent := replaceMatchSpans(pluginCtx.StreamContentBuffer, contentMatches, replacer)
+	replacedReasoning := replaceMatchSpans(pluginCtx.StreamReasoningBuffer, reasoningMatches, replacer)
+	replacedToolCall := replaceMatchSpans(pluginCtx.StreamToolCallBuffer, toolCallMatches, replacer)
+	writeReplacedChunks(result, pluginCtx, replacedContent, replacedReasoning, replacedToolCall)
+}
+
+// writeReplacedChunks 按缓冲区中各 chunk 的位置，将替换后的 content/reasoning/tool_calls 参数写回对应的 SSE 事件
+// replacedContent/replacedReasoning/replacedToolCall 必须与原缓冲区保持相同的字符数
+func writeReplacedChunks(result *strings.Builder, pluginCtx *config.PluginContext, replacedContent, replacedReasoning, replacedToolCall string) {
+	// 由于 ReplaceSensitiveWordsWithValue 保持字符数（rune）相等，但字节数可能不同
+	// 我们需要按字符位置（rune）来映射，而不是按字节位置
+	// 将替换后的文本转换为 rune 数组，以便按字符位置映射
+	replacedContentRunes := []rune(replacedContent)
+	replacedReasoningRunes := []rune(replacedReasoning)
+	replacedToolCallRunes := []rune(replacedToolCall)
+	for _, streamChunk := range pluginCtx.StreamChunkBuffer {
+		if streamChunk.IsDone {
+			// [DONE] 标记直接返回，Responses API 的 response.completed 携带完整文本，按完整文本处理
+			writeStreamChunk(result, pluginCtx, streamChunk.Data)
+			continue
+		}
+		if streamChunk.ContentEnd == streamChunk.ContentStart && streamChunk.ReasoningEnd == streamChunk.ReasoningStart &&
+			streamChunk.ToolCallEnd == streamChunk.ToolCallStart {
+			// 没有增量的事件（如 Responses API 的 *.done 事件）不需要按位置回写
+			writeStreamChunk(result, pluginCtx, streamChunk.Data)
+			continue
+		}
+
+		// 提取当前 chunk 的原始 JSON
+		jsonStr := sseEventData(string(streamChunk.Data))
+
+		if jsonStr == "" {
+			// 无法解析，直接返回原始数据
+			result.Write(streamChunk.Data)
+			continue
+		}
+
+		// 解析 JSON，deny_raw 按原始文本处理
+		root := streamEventRoot(pluginCtx, jsonStr)
+		if !root.Exists() {
+			result.Write(streamChunk.Data)
+			continue
+		}
+
+		// 计算当前 chunk 对应的替换后的增量内容
+		contentStart := streamChunk.ContentStart
+		contentEnd := streamChunk.ContentEnd
+		reasoningStart := streamChunk.ReasoningStart
+		reasoningEnd := streamChunk.ReasoningEnd
+
+		// 获取替换后的增量内容（按字符位置）
+		var newContentDelta string
+		var newReasoningDelta string
+
+		if contentEnd > contentStart {
+			// 转换为字符位置
+			// 计算原始文本中 contentStart 之前的字符数
+			contentStartRunePos := len([]rune(pluginCtx.StreamContentBuffer[:contentStart]))
+			contentEndRunePos := len([]rune(pluginCtx.StreamContentBuffer[:contentEnd]))
+			// 从替换后的文本中提取对应的字符
+			if contentEndRunePos <= len(replacedContentRunes) {
+				newContentDelta = string(replacedContentRunes[contentStartRunePos:contentEndRunePos])
+			}
+		}
+
+		if reasoningEnd > reasoningStart {
+			// 转换为字符位置
+			// 计算原始文本中 reasoningStart 之前的字符数
+			reasoningStartRunePos := len([]rune(pluginCtx.StreamReasoningBuffer[:reasoningStart]))
+			reasoningEndRunePos := len([]rune(pluginCtx.StreamReasoningBuffer[:reasoningEnd]))
+			// 从替换后的文本中提取对应的字符
+			if reasoningEndRunePos <= len(replacedReasoningRunes) {
+				newReasoningDelta = string(replacedReasoningRunes[reasoningStartRunePos:reasoningEndRunePos])
+			}
+		}
+
+		var newToolCallDelta string
+		if streamChunk.ToolCallEnd > streamChunk.ToolCallStart {
+			toolCallStartRunePos := len([]rune(pluginCtx.StreamToolCallBuffer[:streamChunk.ToolCallStart]))
+			toolCallEndRunePos := len([]rune(pluginCtx.StreamToolCallBuffer[:streamChunk.ToolCallEnd]))
+			if toolCallEndRunePos <= len(replacedToolCallRunes) {
+				newToolCallDelta = string(replacedToolCallRunes[toolCallStartRunePos:toolCallEndRunePos])
+			}
+		}
+
+		if ResponseModifyType(pluginCtx) == config.DenyModifyTypeRaw {
+			// deny_raw：替换后的文本即为新的 data
+			if newContentDelta != "" {
+				result.WriteString(replaceSSEData(string(streamChunk.Data), newContentDelta))
+			} else {
+				result.Write(streamChunk.Data)
+			}
+			continue
+		}
+
+		if pluginCtx.Protocol == config.APIProtocolGemini && !pluginCtx.RespIsNDJSON {
+			// Gemini 一个事件中可能包含多个 part，按各 part 的字符数写回
+			newJsonStr := setGeminiDeltas(jsonStr, root, newContentDelta, newReasoningDelta, newToolCallDelta)
+			result.WriteString(replaceSSEData(string(streamChunk.Data), newJsonStr))
+			continue
+		}
+
+		// 按请求协议更新 JSON 中的 content 和 reasoning 增量
+		newJsonStr := jsonStr
+		contentPath, reasoningPath := streamDeltaPaths(pluginCtx, root)
+		if newContentDelta != "" {
+			// 更新 content 增量
+			deltaPath := contentPath
+			oldContent := root.Get(deltaPath).String()
+			if oldContent != "" {
+				var err error
+				newJsonStr, err = sjson.Set(newJsonStr, deltaPath, newContentDelta)
+				if err != nil {
+					wlog.LogWithLine("[%s] writeReplacedChunks: failed to set content: %v", pluginName, err)
+					result.Write(streamChunk.Data)
+					continue
+				}
+			}
+		}
+
+		if newReasoningDelta != "" {
+			// 更新 reasoning 增量
+			deltaPath := reasoningPath
+			oldReasoning := root.Get(deltaPath).String()
+			if oldReasoning != "" {
+				var err error
+				newJsonStr, err = sjson.Set(newJsonStr, deltaPath, newReasoningDelta)
+				if err != nil {
+					wlog.LogWithLine("[%s] writeReplacedChunks: failed to set reasoning: %v", pluginName, err)
+				}
+			}
+		}
+
+		if newToolCallDelta != "" {
+			// 更新 tool_calls 参数增量，一个事件中可能包含多个参数片段
+			updated, err := setToolCallDeltas(pluginCtx, newJsonStr, root, newToolCallDelta)
+			if err != nil {
+				wlog.LogWithLine("[%s] writeReplacedChunks: failed to set tool_calls arguments: %v", pluginName, err)
+			} else {
+				newJsonStr = updated
+			}
+		}
+
+		// 重新构建 SSE 事件，保留 event 等其他行
+		result.WriteString(replaceSSEData(string(streamChunk.Data), newJsonStr))
+	}
+}
+
 // deny 拒绝请求/响应
 func DenyHandler(ctx wrapper.HttpContext, pluginCtx *config.PluginContext) types.Action {
 	cfg := pluginCtx.Config
@@ -911,7 +1061,7 @@ func DenyHandler(ctx wrapper.HttpContext, pluginCtx *config.PluginContext) types
 
 	if isRequestDeny {
 		// 根据是否为流式请求设置不同的 Content-Type
-		contentType := cfg.DenyContentType
+		contentType := DenyContentType(pluginCtx, pluginCtx.RequestDenyModifyType)
 		headers := [][2]string{
 			{"Content-Type", contentType},
 		}
@@ -944,6 +1094,11 @@ func DenyHandler(ctx wrapper.HttpContext, pluginCtx *config.PluginContext) types
 				headers = append(headers, [2]string{"deny_plot", denyPlotStr})
 			}
 		}
+		if denyCategoryAttr := ctx.GetUserAttribute("deny_category"); denyCategoryAttr != nil {
+			if denyCategoryStr, ok := denyCategoryAttr.(string); ok && denyCategoryStr != "" {
+				headers = append(headers, [2]string{"deny_category", denyCategoryStr})
+			}
+		}
 		denyMessage := ctx.GetUserAttribute("deny_message").([]byte)
 
 		wlog.LogWithLine("[%s] deny() -> Calling SendHttpResponse: code=%d, headers=%d, contentType=%s, body length=%d",
@@ -962,7 +1117,7 @@ func DenyHandler(ctx wrapper.HttpContext, pluginCtx *config.PluginContext) types
 		ctx.SetUserAttribute("response_denied", "true")
 		// 设置响应头（先移除再添加，确保覆盖）
 		proxywasm.RemoveHttpResponseHeader("content-type")
-		proxywasm.AddHttpResponseHeader("content-type", cfg.DenyContentType)
+		proxywasm.AddHttpResponseHeader("content-type", DenyContentType(pluginCtx, pluginCtx.ResponseDenyModifyType))
 		// 如果存在用户属性，添加到响应头
 		wlog.LogWithLine("[%s] deny() -> x-ai-data-masking=%s", pluginName, ctx.GetUserAttribute("x-ai-data-masking"))
 		if maskingAttr := ctx.GetUserAttribute("x-ai-data-masking"); maskingAttr != nil {
@@ -983,6 +1138,12 @@ func DenyHandler(ctx wrapper.HttpContext, pluginCtx *config.PluginContext) types
 				proxywasm.AddHttpResponseHeader("deny_plot", denyPlotStr)
 			}
 		}
+		if denyCategoryAttr := ctx.GetUserAttribute("deny_category"); denyCategoryAttr != nil {
+			if denyCategoryStr, ok := denyCategoryAttr.(string); ok && denyCategoryStr != "" {
+				proxywasm.RemoveHttpResponseHeader("deny_category")
+				proxywasm.AddHttpResponseHeader("deny_category", denyCategoryStr)
+			}
+		}
 		denyMessage := ctx.GetUserAttribute("deny_message").([]byte)
 		wlog.LogWithLine("[%s] deny() -> ReplaceHttpResponseBody called with denyMessage length=%d", pluginName, len(denyMessage))
 		proxywasm.ReplaceHttpResponseBody(denyMessage)
@@ -1028,46 +1189,46 @@ func DenyHandlerResponseReplaceNonStream(ctx wrapper.HttpContext, pluginCtx *con
 			proxywasm.AddHttpResponseHeader("deny_plot", denyPlotStr)
 		}
 	}
-	// 解析响应体，替换敏感词
+	if denyCategoryAttr := ctx.GetUserAttribute("deny_category"); denyCategoryAttr != nil {
+		if denyCategoryStr, ok := denyCategoryAttr.(string); ok && denyCategoryStr != "" {
+			proxywasm.RemoveHttpResponseHeader("deny_category")
+			proxywasm.AddHttpResponseHeader("deny_category", denyCategoryStr)
+		}
+	}
+	if pluginCtx.ResponseDenyModifyType == config.DenyModifyTypeRaw {
+		// deny_raw：整个响应体替换敏感词并还原
+		newBodyBytes := []byte(replaceRawResponse(pluginCtx, bodyStr, replaceValue))
+		proxywasm.ReplaceHttpResponseBody(newBodyBytes)
+		proxywasm.RemoveHttpResponseHeader("content-length")
+		proxywasm.AddHttpResponseHeader("content-length", fmt.Sprintf("%d", len(newBodyBytes)))
+		return types.ActionContinue
+	}
+
+	// 解析响应体，替换敏感词并还原请求阶段脱敏的数据
 	root := gjson.Parse(bodyStr)
 	if root.Exists() {
-		choices := gjson.Get(bodyStr, "choices")
 		newBodyStr := bodyStr
 
-		// 遍历 choices 数组，替换敏感词
-		choices.ForEach(func(key, choice gjson.Result) bool {
-			idx := key.Int()
-			content := choice.Get("message.content").String()
-			reasoning := choice.Get("message.reasoning").String()
-
-			// 替换 content 中的敏感词
-			if content != "" {
-				newContent := ReplaceSensitiveWordsWithValue(content, pluginCtx.Config, config.SystemDenyWords, replaceValue)
-				if newContent != content {
-					basePath := fmt.Sprintf("choices.%d.message.content", idx)
-					var err error
-					newBodyStr, err = sjson.Set(newBodyStr, basePath, newContent)
-					if err != nil {
-						wlog.LogWithLine("[%s] processNonStreamResponse: failed to set content: %v", pluginName, err)
-					}
-				}
+		// 按请求协议遍历文本字段，替换敏感词
+		for _, field := range responseTextFields(pluginCtx, root) {
+			if field.text == "" {
+				continue
 			}
-
-			// 替换 reasoning 中的敏感词
-			if reasoning != "" {
-				newReasoning := ReplaceSensitiveWordsWithValue(reasoning, pluginCtx.Config, config.SystemDenyWords, replaceValue)
-				if newReasoning != reasoning {
-					basePath := fmt.Sprintf("choices.%d.message.reasoning", idx)
-					var err error
-					newBodyStr, err = sjson.Set(newBodyStr, basePath, newReasoning)
-					if err != nil {
-						wlog.LogWithLine("[%s] processNonStreamResponse: failed to set reasoning: %v", pluginName, err)
-					}
+			newText := field.apply(func(text string) string {
+				text = ReplaceSensitiveWordsWithValue(text, pluginCtx.Config, config.GetSystemDenyWords(), replaceValue)
+				if !field.signed {
+					text = RestoreMessage(text, pluginCtx)
+				}
+				return text
+			})
+			if newText != field.text {
+				var err error
+				newBodyStr, err = field.set(newBodyStr, newText)
+				if err != nil {
+					wlog.LogWithLine("[%s] processNonStreamResponse: failed to set %s: %v", pluginName, field.path, err)
 				}
 			}
-
-			return true
-		})
+		}
 
 		// 替换响应体并更新 Content-Length
 		newBodyBytes := []byte(newBodyStr)
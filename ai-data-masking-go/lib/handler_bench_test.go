@@ -2,6 +2,7 @@ package lib
 
 import (
 	"ai-data-masking/config"
+	"fmt"
 	"runtime"
 	"testing"
 )
@@ -25,24 +26,101 @@ var (
 `)
 )
 
-// createTestPluginContext 创建测试用的 PluginContext
-func createTestPluginContext() *config.PluginContext {
-	cfg := &config.AiDataMaskingConfig{
-		DenyWords:    testDenyWords,
-		SystemDeny:   true,
-		DenyMessage:  "检测到敏感词",
-		StreamBuffer: 10 * 1024, // 10KB
-	}
+// testContextOption 调整测试用 PluginContext 的选项
+type testContextOption func(*config.PluginContext)
+
+// createTestPluginContext 创建测试用的 PluginContext，按顺序应用选项
+func createTestPluginContext(opts ...testContextOption) *config.PluginContext {
+	cfg := createTestConfig()
 
-	return &config.PluginContext{
+	pluginCtx := &config.PluginContext{
 		Config:                cfg,
 		OpenAIRequest:         &config.OpenAIRequest{Model: "gpt-3.5-turbo", Stream: true},
+		MaskMap:               make(map[string]*string),
 		StreamContentBuffer:   "",
 		StreamReasoningBuffer: "",
 		StreamDenied:          false,
 		StreamChunkBuffer:     make([]config.StreamChunk, 0),
 		StreamChunkBufferSize: 0,
 	}
+	for _, opt := range opts {
+		opt(pluginCtx)
+	}
+	return pluginCtx
+}
+
+// withConfig 使用指定的插件配置
+func withConfig(cfg *config.AiDataMaskingConfig) testContextOption {
+	return func(pluginCtx *config.PluginContext) {
+		pluginCtx.Config = cfg
+	}
+}
+
+// withRequest 使用指定的请求信息
+func withRequest(request config.OpenAIRequest) testContextOption {
+	return func(pluginCtx *config.PluginContext) {
+		pluginCtx.OpenAIRequest = &request
+	}
+}
+
+// withProtocol 设置请求的 API 协议
+func withProtocol(protocol config.APIProtocol) testContextOption {
+	return func(pluginCtx *config.PluginContext) {
+		pluginCtx.Protocol = protocol
+	}
+}
+
+// withRequestContentType 设置请求的 Content-Type
+func withRequestContentType(contentType string) testContextOption {
+	return func(pluginCtx *config.PluginContext) {
+		pluginCtx.RequestContentType = contentType
+	}
+}
+
+// withMaskMap 写入掩码到原文的映射
+func withMaskMap(mapping map[string]string) testContextOption {
+	return func(pluginCtx *config.PluginContext) {
+		for key, original := range mapping {
+			original := original
+			pluginCtx.MaskMap[key] = &original
+		}
+	}
+}
+
+// withContentDeltas 按各事件的 content 增量构造流式缓冲区，记录各 chunk 在文本缓冲区中的位置和接收时间
+func withContentDeltas(receivedAt int64, deltas ...string) testContextOption {
+	return func(pluginCtx *config.PluginContext) {
+		for _, delta := range deltas {
+			data := fmt.Sprintf(`data: {"choices":[{"index":0,"delta":{"content":%q}}]}`, delta) + "\n\n"
+			start := len(pluginCtx.StreamContentBuffer)
+			pluginCtx.StreamContentBuffer += delta
+			pluginCtx.StreamChunkBuffer = append(pluginCtx.StreamChunkBuffer, config.StreamChunk{
+				Data:         []byte(data),
+				ContentStart: start,
+				ContentEnd:   len(pluginCtx.StreamContentBuffer),
+				ReceivedAt:   receivedAt,
+			})
+			pluginCtx.StreamChunkBufferSize += len(data)
+		}
+	}
+}
+
+// withStreamChunks 将事件依次写入流式缓冲区
+func withStreamChunks(events ...string) testContextOption {
+	return func(pluginCtx *config.PluginContext) {
+		for _, event := range events {
+			pluginCtx.StreamChunkBuffer = append(pluginCtx.StreamChunkBuffer, config.StreamChunk{Data: []byte(event)})
+			pluginCtx.StreamChunkBufferSize += len(event)
+		}
+	}
+}
+
+// withDenyInfo 设置拒绝消息模板中使用的请求 ID 和命中分类
+func withDenyInfo(requestID, category string) testContextOption {
+	return func(pluginCtx *config.PluginContext) {
+		pluginCtx.RequestID = requestID
+		pluginCtx.DenyCategory = category
+	}
 }
 
 // TestAccuracy_ProcessOpenAIStreamResponse 测试流式处理准确率
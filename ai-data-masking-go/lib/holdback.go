@@ -0,0 +1,96 @@
+package lib
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"ai-data-masking/config"
+)
+
+// holdbackStart 返回文本末尾需要暂缓输出部分的起始位置：末尾最长敏感词长度（字节数）内的字符，
+// 后续增量可能与这部分文本组成跨 chunk 的敏感词；长度按归一化文本计算，被剔除的分隔符、零宽字符不占用暂缓长度
+func holdbackStart(text string, cfg *config.AiDataMaskingConfig) int {
+	start := streamKeepStart(text, streamOverlapLength(cfg), &cfg.Normalize)
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	return start
+}
+
+// holdbackReleaseCount 返回 holdback 模式下缓冲区中可以立即输出的 chunk 个数
+// chunk 的增量全部位于各缓冲区暂缓部分之前时可以输出；延伸到暂缓部分的替换命中可能随后续数据变长，从命中开始暂缓；
+// 在缓冲区中等待超过 stream_holdback_max_delay 毫秒的 chunk 不再等待
+func holdbackReleaseCount(pluginCtx *config.PluginContext, replaceMatches [3][]MatchResult, now int64) int {
+	buffers := [3]string{pluginCtx.StreamContentBuffer, pluginCtx.StreamReasoningBuffer, pluginCtx.StreamToolCallBuffer}
+	var boundaries [3]int
+	for i, buffer := range buffers {
+		boundaries[i] = holdbackStart(buffer, pluginCtx.Config)
+		for _, match := range replaceMatches[i] {
+			if match.StartPos < boundaries[i] && match.EndPos > boundaries[i] {
+				boundaries[i] = match.StartPos
+			}
+		}
+	}
+
+	maxDelay := int64(pluginCtx.Config.StreamHoldbackMaxDelay)
+	count := 0
+	for _, streamChunk := range pluginCtx.StreamChunkBuffer {
+		settled := streamChunk.ContentEnd <= boundaries[0] && streamChunk.ReasoningEnd <= boundaries[1] && streamChunk.ToolCallEnd <= boundaries[2]
+		expired := maxDelay > 0 && now-streamChunk.ReceivedAt >= maxDelay
+		// 按顺序输出，遇到需要继续等待的 chunk 即停止
+		if !settled && !expired {
+			break
+		}
+		count++
+	}
+	return count
+}
+
+// releaseHoldbackChunks 输出缓冲区中前 count 个 chunk（动作为 replace 的命中替换为掩码）并移出缓冲区
+func releaseHoldbackChunks(result *strings.Builder, pluginCtx *config.PluginContext, count int, replaceMatches [3][]MatchResult) {
+	if count == 0 {
+		return
+	}
+	chunks := pluginCtx.StreamChunkBuffer
+	pluginCtx.StreamChunkBuffer = chunks[:count]
+	if len(replaceMatches[0]) > 0 || len(replaceMatches[1]) > 0 || len(replaceMatches[2]) > 0 {
+		writeReplaceActionChunks(result, pluginCtx, replaceMatches[0], replaceMatches[1], replaceMatches[2])
+	} else {
+		for _, streamChunk := range pluginCtx.StreamChunkBuffer {
+			writeStreamChunk(result, pluginCtx, streamChunk.Data)
+		}
+	}
+	trackStreamEvents(pluginCtx)
+
+	pluginCtx.StreamChunkBuffer = append([]config.StreamChunk(nil), chunks[count:]...)
+	pluginCtx.StreamChunkBufferSize = 0
+	for _, streamChunk := range pluginCtx.StreamChunkBuffer {
+		pluginCtx.StreamChunkBufferSize += len(streamChunk.Data)
+	}
+	trimHoldbackBuffers(pluginCtx)
+}
+
+// trimHoldbackBuffers 裁剪已输出的文本：各缓冲区保留末尾暂缓部分以及未输出 chunk 的增量，继续用于检测跨 chunk 的敏感词
+func trimHoldbackBuffers(pluginCtx *config.PluginContext) {
+	contentCut := holdbackStart(pluginCtx.StreamContentBuffer, pluginCtx.Config)
+	reasoningCut := holdbackStart(pluginCtx.StreamReasoningBuffer, pluginCtx.Config)
+	toolCallCut := holdbackStart(pluginCtx.StreamToolCallBuffer, pluginCtx.Config)
+	if len(pluginCtx.StreamChunkBuffer) > 0 {
+		first := pluginCtx.StreamChunkBuffer[0]
+		contentCut = min(contentCut, first.ContentStart)
+		reasoningCut = min(reasoningCut, first.ReasoningStart)
+		toolCallCut = min(toolCallCut, first.ToolCallStart)
+	}
+	for i := range pluginCtx.StreamChunkBuffer {
+		streamChunk := &pluginCtx.StreamChunkBuffer[i]
+		streamChunk.ContentStart -= contentCut
+		streamChunk.ContentEnd -= contentCut
+		streamChunk.ReasoningStart -= reasoningCut
+		streamChunk.ReasoningEnd -= reasoningCut
+		streamChunk.ToolCallStart -= toolCallCut
+		streamChunk.ToolCallEnd -= toolCallCut
+	}
+	pluginCtx.StreamContentBuffer = pluginCtx.StreamContentBuffer[contentCut:]
+	pluginCtx.StreamReasoningBuffer = pluginCtx.StreamReasoningBuffer[reasoningCut:]
+	pluginCtx.StreamToolCallBuffer = pluginCtx.StreamToolCallBuffer[toolCallCut:]
+}
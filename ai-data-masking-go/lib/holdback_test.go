@@ -0,0 +1,97 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+
+	"ai-data-masking/config"
+)
+
+// TestHoldbackStart 测试暂缓部分按字符边界对齐
+func TestHoldbackStart(t *testing.T) {
+	cfg := createTestConfig(withMaxSensitiveWordLength(4))
+	tests := []struct {
+		text     string
+		expected int
+	}{
+		{text: "abcdefgh", expected: 4},
+		{text: "abc", expected: 0},
+		{text: "你好世界", expected: 6},
+	}
+	for _, tt := range tests {
+		if got := holdbackStart(tt.text, cfg); got != tt.expected {
+			t.Errorf("%q: 期望 %d, 实际 %d", tt.text, tt.expected, got)
+		}
+	}
+}
+
+// TestHoldbackReleaseCount 测试只输出暂缓部分之前的 chunk，替换命中与最长延迟对输出位置的影响
+func TestHoldbackReleaseCount(t *testing.T) {
+	tests := []struct {
+		name     string
+		matches  []MatchResult
+		now      int64
+		expected int
+	}{
+		{name: "末尾 3 字节暂缓", now: 100, expected: 1},
+		{name: "替换命中在暂缓部分之前开始", matches: []MatchResult{{StartPos: 2, EndPos: 6}}, now: 100, expected: 0},
+		{name: "替换命中在暂缓部分内", matches: []MatchResult{{StartPos: 6, EndPos: 8}}, now: 100, expected: 1},
+		{name: "超过最长延迟", now: 1000, expected: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pluginCtx := createTestPluginContext(withConfig(createTestConfig(withStreamHoldback(1000), withMaxSensitiveWordLength(3))), withContentDeltas(0, "abc", "def", "gh"))
+			if got := holdbackReleaseCount(pluginCtx, [3][]MatchResult{tt.matches}, tt.now); got != tt.expected {
+				t.Errorf("期望 %d, 实际 %d", tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestReleaseHoldbackChunks 测试输出的 chunk 移出缓冲区，文本缓冲区保留暂缓部分并调整位置
+func TestReleaseHoldbackChunks(t *testing.T) {
+
+	pluginCtx := createTestPluginContext(withConfig(createTestConfig(withStreamHoldback(1000), withMaxSensitiveWordLength(3))), withContentDeltas(0, "abc", "def", "gh"))
+	var result strings.Builder
+	releaseHoldbackChunks(&result, pluginCtx, 1, [3][]MatchResult{})
+	if result.String() != `data: {"choices":[{"index":0,"delta":{"content":"abc"}}]}`+"\n\n" {
+		t.Errorf("输出不正确: %q", result.String())
+	}
+	// 未输出的 chunk 从 "d" 开始，暂缓部分从 "f" 开始，保留较早的位置
+	if pluginCtx.StreamContentBuffer != "defgh" || len(pluginCtx.StreamChunkBuffer) != 2 {
+		t.Fatalf("缓冲区不正确: %q, %d 个 chunk", pluginCtx.StreamContentBuffer, len(pluginCtx.StreamChunkBuffer))
+	}
+	if first := pluginCtx.StreamChunkBuffer[0]; first.ContentStart != 0 || first.ContentEnd != 3 {
+		t.Errorf("位置未调整: %d-%d", first.ContentStart, first.ContentEnd)
+	}
+
+	// 全部输出后仍保留末尾文本用于检测跨 chunk 的敏感词，替换命中按掩码输出
+	pluginCtx.Config.ResponseDenyPlot.Value = "*"
+	result.Reset()
+	releaseHoldbackChunks(&result, pluginCtx, 2, [3][]MatchResult{{{StartPos: 1, EndPos: 2}}})
+	if !strings.Contains(result.String(), `"content":"d*f"`) || !strings.Contains(result.String(), `"content":"gh"`) {
+		t.Errorf("替换输出不正确: %q", result.String())
+	}
+	if pluginCtx.StreamContentBuffer != "fgh" || len(pluginCtx.StreamChunkBuffer) != 0 || pluginCtx.StreamChunkBufferSize != 0 {
+		t.Errorf("缓冲区不正确: %q, %d 个 chunk", pluginCtx.StreamContentBuffer, len(pluginCtx.StreamChunkBuffer))
+	}
+}
+
+// TestHoldbackReleaseCount_Normalize 测试暂缓部分按归一化文本计算：敏感词中间插入的分隔符比暂缓长度更长时，前半部分仍然暂缓
+func TestHoldbackReleaseCount_Normalize(t *testing.T) {
+	cfg := createTestConfig(withStreamHoldback(1000), withMaxSensitiveWordLength(2))
+	cfg.Normalize = config.NormalizeConfig{StripSeparators: true}
+	pluginCtx := createTestPluginContext(withConfig(cfg), withContentDeltas(0, "ab", "cd", strings.Repeat(" ", 20)))
+	if got := holdbackStart(pluginCtx.StreamContentBuffer, cfg); got != 2 {
+		t.Fatalf("暂缓部分应从 \"c\" 开始, 实际 %d", got)
+	}
+	if got := holdbackReleaseCount(pluginCtx, [3][]MatchResult{}, 100); got != 1 {
+		t.Fatalf("期望输出 1 个 chunk, 实际 %d", got)
+	}
+
+	var result strings.Builder
+	releaseHoldbackChunks(&result, pluginCtx, 1, [3][]MatchResult{})
+	if pluginCtx.StreamContentBuffer != "cd"+strings.Repeat(" ", 20) {
+		t.Errorf("缓冲区应保留暂缓部分: %q", pluginCtx.StreamContentBuffer)
+	}
+}
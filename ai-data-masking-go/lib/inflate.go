@@ -0,0 +1,424 @@
+package lib
+
+import (
+	"errors"
+)
+
+// DEFLATE（RFC 1951）的长度、距离码表
+var (
+	inflateLengthBase  = [29]uint16{3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31, 35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227, 258}
+	inflateLengthExtra = [29]uint8{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 0}
+	inflateDistBase    = [30]uint16{1, 2, 3, 4, 5, 7, 9, 13, 17, 25, 33, 49, 65, 97, 129, 193, 257, 385, 513, 769, 1025, 1537, 2049, 3073, 4097, 6145, 8193, 12289, 16385, 24577}
+	inflateDistExtra   = [30]uint8{0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13}
+	// 动态块中码长码的排列顺序
+	inflateCodeLengthOrder = [19]uint8{16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15}
+)
+
+const (
+	inflateWindowSize = 32 * 1024 // 长度/距离最多引用之前 32KB 的输出
+	inflateMaxBits    = 15        // 霍夫曼码的最大长度
+)
+
+var errInvalidDeflate = errors.New("invalid deflate data")
+
+// inflateState 解压器当前所处的解码单元
+type inflateState int
+
+const (
+	inflateBlockHeader inflateState = iota // 读取块头（动态块包括码表）
+	inflateStored                          // 复制未压缩块的数据
+	inflateCodes                           // 按霍夫曼码解码字面量与长度/距离
+	inflateDone                            // 已处理最后一个块
+)
+
+// inflateHuffman 规范霍夫曼码：各长度的码字数量以及按码字排列的符号
+type inflateHuffman struct {
+	count  [inflateMaxBits + 1]uint16
+	symbol []uint16
+	maxLen int // 最长的码长，0 表示码表为空
+}
+
+// newInflateHuffman 根据各符号的码长构造规范霍夫曼码
+// 与 compress/flate 一致，码长必须恰好分配完所有码字，只允许全部为 0 或只有一个长度为 1 的码这两种不完整的码表
+func newInflateHuffman(lengths []uint8) (*inflateHuffman, error) {
+	h := &inflateHuffman{symbol: make([]uint16, len(lengths))}
+	for _, length := range lengths {
+		h.count[length]++
+		h.maxLen = max(h.maxLen, int(length))
+	}
+	if h.maxLen == 0 {
+		return h, nil
+	}
+	left := 1
+	for length := 1; length <= h.maxLen; length++ {
+		left = left<<1 - int(h.count[length])
+		if left < 0 {
+			// 超额分配
+			return nil, errInvalidDeflate
+		}
+	}
+	if left > 0 && !(h.maxLen == 1 && h.count[1] == 1) {
+		// 码字没有分配完
+		return nil, errInvalidDeflate
+	}
+	var offsets [inflateMaxBits + 1]uint16
+	for length := 1; length < inflateMaxBits; length++ {
+		offsets[length+1] = offsets[length] + h.count[length]
+	}
+	for symbol, length := range lengths {
+		if length != 0 {
+			h.symbol[offsets[length]] = uint16(symbol)
+			offsets[length]++
+		}
+	}
+	return h, nil
+}
+
+// fixedInflateHuffman 固定霍夫曼码块使用的字面量/长度码表与距离码表
+func fixedInflateHuffman() (*inflateHuffman, *inflateHuffman) {
+	var lengths [288 + 32]uint8
+	for i := 0; i < 288; i++ {
+		switch {
+		case i < 144:
+			lengths[i] = 8
+		case i < 256:
+			lengths[i] = 9
+		case i < 280:
+			lengths[i] = 7
+		default:
+			lengths[i] = 8
+		}
+	}
+	// 距离码包括不会出现的 30、31，码表才是完整的
+	for i := 288; i < len(lengths); i++ {
+		lengths[i] = 5
+	}
+	lencode, _ := newInflateHuffman(lengths[:288])
+	distcode, _ := newInflateHuffman(lengths[288:])
+	return lencode, distcode
+}
+
+// inflater 可以分段输入的 DEFLATE 解压器
+// compress/flate 在输入不足时进入不可恢复的错误状态，流式响应只能每次从头解压；inflater 在输入不足时回退到当前解码单元
+// （块头、一段未压缩数据、一个霍夫曼符号）的开头，保留已解压的状态，收到后续数据后继续解压
+type inflater struct {
+	in     []byte // 已收到、尚未处理完的压缩数据
+	pos    int    // in 中下一个未读取的字节
+	bitBuf uint32 // 已读取、尚未使用的比特
+	bitCnt uint
+
+	window []byte // 最近的输出，供长度/距离引用
+	out    []byte // 本次调用解压出的内容
+	limit  int    // 本次调用最多输出的字节数
+
+	state      inflateState
+	final      bool
+	storedLeft int
+	lencode    *inflateHuffman
+	distcode   *inflateHuffman
+}
+
+// inflateCheckpoint 解码单元开始时的读取位置，输入不足时回退到这里
+type inflateCheckpoint struct {
+	pos    int
+	bitBuf uint32
+	bitCnt uint
+}
+
+func (f *inflater) checkpoint() inflateCheckpoint {
+	return inflateCheckpoint{pos: f.pos, bitBuf: f.bitBuf, bitCnt: f.bitCnt}
+}
+
+func (f *inflater) restore(cp inflateCheckpoint) {
+	f.pos, f.bitBuf, f.bitCnt = cp.pos, cp.bitBuf, cp.bitCnt
+}
+
+// bits 读取 n 个比特（低位在前），输入不足时返回 false
+func (f *inflater) bits(n uint) (uint32, bool) {
+	for f.bitCnt < n {
+		if f.pos >= len(f.in) {
+			return 0, false
+		}
+		f.bitBuf |= uint32(f.in[f.pos]) << f.bitCnt
+		f.pos++
+		f.bitCnt += 8
+	}
+	value := f.bitBuf & (1<<n - 1)
+	f.bitBuf >>= n
+	f.bitCnt -= n
+	return value, true
+}
+
+// decodeSymbol 逐比特解码一个霍夫曼符号，输入不足时返回 false；读完最长的码长仍没有对应的符号（码表为空或未分配的码字）时返回错误
+func (f *inflater) decodeSymbol(h *inflateHuffman) (int, bool, error) {
+	code, first, index := 0, 0, 0
+	for length := 1; length <= h.maxLen; length++ {
+		bit, ok := f.bits(1)
+		if !ok {
+			return 0, false, nil
+		}
+		code |= int(bit)
+		count := int(h.count[length])
+		if code-first < count {
+			return int(h.symbol[index+code-first]), true, nil
+		}
+		index += count
+		first = (first + count) << 1
+		code <<= 1
+	}
+	return 0, true, errInvalidDeflate
+}
+
+// emit 输出一段解压内容并记录到窗口中，窗口超过两倍大小时只保留最近 32KB
+func (f *inflater) emit(data ...byte) error {
+	if len(f.out)+len(data) > f.limit {
+		return errDecodedTooLarge
+	}
+	f.out = append(f.out, data...)
+	f.window = append(f.window, data...)
+	if len(f.window) > 2*inflateWindowSize {
+		f.window = append(f.window[:0], f.window[len(f.window)-inflateWindowSize:]...)
+	}
+	return nil
+}
+
+// copyMatch 输出长度/距离引用的内容，引用区域可以与输出重叠
+func (f *inflater) copyMatch(length, dist int) error {
+	if dist > len(f.window) {
+		return errInvalidDeflate
+	}
+	if len(f.out)+length > f.limit {
+		return errDecodedTooLarge
+	}
+	// 逐字节复制，每输出一个字节后引用位置随窗口末尾后移
+	for i := 0; i < length; i++ {
+		if err := f.emit(f.window[len(f.window)-dist]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inflate 追加压缩数据并继续解压，返回本次解压出的内容；数据不完整时保留状态，等待后续数据
+func (f *inflater) inflate(data []byte, limit int) ([]byte, error) {
+	// 丢弃已处理的输入
+	f.in = append(f.in[f.pos:], data...)
+	f.pos = 0
+	f.out = nil
+	f.limit = limit
+	for {
+		var ok bool
+		var err error
+		switch f.state {
+		case inflateDone:
+			return f.out, nil
+		case inflateBlockHeader:
+			ok, err = f.readBlockHeader()
+		case inflateStored:
+			ok, err = f.copyStored()
+		case inflateCodes:
+			ok, err = f.decodeCodes()
+		}
+		if err != nil {
+			return f.out, err
+		}
+		if !ok {
+			return f.out, nil
+		}
+	}
+}
+
+// endBlock 当前块处理完成
+func (f *inflater) endBlock() {
+	if f.final {
+		f.state = inflateDone
+	} else {
+		f.state = inflateBlockHeader
+	}
+}
+
+// readBlockHeader 读取块头，输入不足时回退到块头开始的位置
+func (f *inflater) readBlockHeader() (bool, error) {
+	cp := f.checkpoint()
+	header, ok := f.bits(3)
+	if !ok {
+		return false, nil
+	}
+	switch header >> 1 {
+	case 0:
+		// 未压缩块：丢弃当前字节剩余的比特，读取 LEN 与 NLEN
+		f.bitBuf, f.bitCnt = 0, 0
+		if f.pos+4 > len(f.in) {
+			f.restore(cp)
+			return false, nil
+		}
+		length := int(f.in[f.pos]) | int(f.in[f.pos+1])<<8
+		nlength := int(f.in[f.pos+2]) | int(f.in[f.pos+3])<<8
+		if length != ^nlength&0xffff {
+			return true, errInvalidDeflate
+		}
+		f.pos += 4
+		f.storedLeft = length
+		f.state = inflateStored
+	case 1:
+		f.lencode, f.distcode = fixedInflateHuffman()
+		f.state = inflateCodes
+	case 2:
+		ok, err := f.readDynamicTables()
+		if err != nil {
+			return true, err
+		}
+		if !ok {
+			f.restore(cp)
+			return false, nil
+		}
+		f.state = inflateCodes
+	default:
+		return true, errInvalidDeflate
+	}
+	f.final = header&1 == 1
+	return true, nil
+}
+
+// readDynamicTables 读取动态霍夫曼块的码表
+func (f *inflater) readDynamicTables() (bool, error) {
+	counts, ok := f.bits(14)
+	if !ok {
+		return false, nil
+	}
+	nlen := int(counts&0x1f) + 257
+	ndist := int(counts>>5&0x1f) + 1
+	ncode := int(counts>>10) + 4
+	if nlen > 286 || ndist > 30 {
+		return true, errInvalidDeflate
+	}
+
+	var codeLengths [19]uint8
+	for i := 0; i < ncode; i++ {
+		length, ok := f.bits(3)
+		if !ok {
+			return false, nil
+		}
+		codeLengths[inflateCodeLengthOrder[i]] = uint8(length)
+	}
+	lencode, err := newInflateHuffman(codeLengths[:])
+	if err != nil {
+		return true, err
+	}
+
+	lengths := make([]uint8, nlen+ndist)
+	for i := 0; i < len(lengths); {
+		symbol, ok, err := f.decodeSymbol(lencode)
+		if err != nil || !ok {
+			return ok, err
+		}
+		if symbol < 16 {
+			lengths[i] = uint8(symbol)
+			i++
+			continue
+		}
+		var repeat uint32
+		var value uint8
+		switch symbol {
+		case 16:
+			if i == 0 {
+				return true, errInvalidDeflate
+			}
+			value = lengths[i-1]
+			repeat, ok = f.bits(2)
+			repeat += 3
+		case 17:
+			repeat, ok = f.bits(3)
+			repeat += 3
+		default:
+			repeat, ok = f.bits(7)
+			repeat += 11
+		}
+		if !ok {
+			return false, nil
+		}
+		if i+int(repeat) > len(lengths) {
+			return true, errInvalidDeflate
+		}
+		for ; repeat > 0; repeat-- {
+			lengths[i] = value
+			i++
+		}
+	}
+	if lengths[256] == 0 {
+		// 没有块结束符
+		return true, errInvalidDeflate
+	}
+	if f.lencode, err = newInflateHuffman(lengths[:nlen]); err != nil {
+		return true, err
+	}
+	if f.distcode, err = newInflateHuffman(lengths[nlen:]); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// copyStored 复制未压缩块中已收到的数据
+func (f *inflater) copyStored() (bool, error) {
+	n := min(f.storedLeft, len(f.in)-f.pos)
+	if n == 0 && f.storedLeft > 0 {
+		return false, nil
+	}
+	if err := f.emit(f.in[f.pos : f.pos+n]...); err != nil {
+		return true, err
+	}
+	f.pos += n
+	f.storedLeft -= n
+	if f.storedLeft == 0 {
+		f.endBlock()
+	}
+	return true, nil
+}
+
+// decodeCodes 解码一个字面量、块结束符或长度/距离引用，输入不足时回退到该符号开始的位置
+func (f *inflater) decodeCodes() (bool, error) {
+	cp := f.checkpoint()
+	symbol, ok, err := f.decodeSymbol(f.lencode)
+	if err != nil {
+		return true, err
+	}
+	if !ok {
+		f.restore(cp)
+		return false, nil
+	}
+	switch {
+	case symbol < 256:
+		return true, f.emit(byte(symbol))
+	case symbol == 256:
+		f.endBlock()
+		return true, nil
+	}
+
+	symbol -= 257
+	if symbol >= len(inflateLengthBase) {
+		return true, errInvalidDeflate
+	}
+	extra, ok := f.bits(uint(inflateLengthExtra[symbol]))
+	if !ok {
+		f.restore(cp)
+		return false, nil
+	}
+	length := int(inflateLengthBase[symbol]) + int(extra)
+
+	symbol, ok, err = f.decodeSymbol(f.distcode)
+	if err != nil {
+		return true, err
+	}
+	if !ok {
+		f.restore(cp)
+		return false, nil
+	}
+	if symbol >= len(inflateDistBase) {
+		return true, errInvalidDeflate
+	}
+	if extra, ok = f.bits(uint(inflateDistExtra[symbol])); !ok {
+		f.restore(cp)
+		return false, nil
+	}
+	return true, f.copyMatch(length, int(inflateDistBase[symbol])+int(extra))
+}
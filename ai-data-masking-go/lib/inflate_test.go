@@ -0,0 +1,153 @@
+package lib
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// deflateBitWriter 按 DEFLATE 的比特顺序构造测试数据
+type deflateBitWriter struct {
+	data   []byte
+	bitCnt uint
+}
+
+// bits 写入 n 个比特（低位在前），用于块头、码长、额外比特等字段
+func (w *deflateBitWriter) bits(value uint32, n uint) *deflateBitWriter {
+	for i := uint(0); i < n; i++ {
+		if w.bitCnt%8 == 0 {
+			w.data = append(w.data, 0)
+		}
+		w.data[len(w.data)-1] |= byte(value>>i&1) << (w.bitCnt % 8)
+		w.bitCnt++
+	}
+	return w
+}
+
+// code 写入 n 个比特的霍夫曼码（高位在前）
+func (w *deflateBitWriter) code(value uint32, n uint) *deflateBitWriter {
+	for i := n; i > 0; i-- {
+		w.bits(value>>(i-1)&1, 1)
+	}
+	return w
+}
+
+// codeLengthCodes 写入动态块的码长码：HCLEN 之后按排列顺序的各码长
+func (w *deflateBitWriter) codeLengthCodes(lengths ...uint32) *deflateBitWriter {
+	w.bits(uint32(len(lengths)-4), 4)
+	for _, length := range lengths {
+		w.bits(length, 3)
+	}
+	return w
+}
+
+// TestInflate_Malformed 测试损坏的压缩数据返回错误，与 compress/flate 的判断一致
+func TestInflate_Malformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "块类型无效", data: new(deflateBitWriter).bits(1|3<<1, 3).data},
+		{name: "未压缩块长度校验错误", data: []byte{0x01, 0x05, 0x00, 0x00, 0x00, 'a'}},
+		{
+			// 固定码块：长度 3（符号 257）、距离 1（符号 0），之前没有任何输出
+			name: "距离超出已输出的内容",
+			data: new(deflateBitWriter).bits(1|1<<1, 3).code(1, 7).code(0, 5).code(0, 7).data,
+		},
+		{
+			// 固定码块：符号 286 不对应任何长度
+			name: "无效的长度符号",
+			data: new(deflateBitWriter).bits(1|1<<1, 3).code(0xc6, 8).data,
+		},
+		{
+			// 固定码块：字面量 a 之后距离符号 30 不对应任何距离
+			name: "无效的距离符号",
+			data: new(deflateBitWriter).bits(1|1<<1, 3).code(0x30+'a', 8).code(1, 7).code(30, 5).data,
+		},
+		{
+			// 动态块：码长码 16、17、18、0 的码长都是 1
+			name: "码长码超额分配",
+			data: new(deflateBitWriter).bits(1|2<<1, 3).bits(0, 5).bits(0, 5).codeLengthCodes(1, 1, 1, 1).data,
+		},
+		{
+			// 动态块：码长码 16、17、18 的码长都是 2，还剩一个码字没有分配
+			name: "码长码不完整",
+			data: new(deflateBitWriter).bits(1|2<<1, 3).bits(0, 5).bits(0, 5).codeLengthCodes(2, 2, 2, 0).data,
+		},
+		{
+			// 动态块：码长码 16、0 的码长为 1，第一个码长就是重复前一个码长的 16
+			name: "重复码没有前一个码长",
+			data: new(deflateBitWriter).bits(1|2<<1, 3).bits(0, 5).bits(0, 5).codeLengthCodes(1, 0, 0, 1).code(1, 1).bits(0, 2).data,
+		},
+		{
+			// 动态块：码长码 18 为 1 比特，1、2 为 2 比特；字面量 0 的码长为 2、块结束符的码长为 1，还剩一个码字没有分配
+			name: "字面量码表不完整",
+			data: new(deflateBitWriter).bits(1|2<<1, 3).bits(0, 5).bits(0, 5).
+				codeLengthCodes(0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 0, 2).
+				code(3, 2).code(0, 1).bits(127, 7).code(0, 1).bits(106, 7).code(2, 2).code(2, 2).data,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := io.ReadAll(flate.NewReader(bytes.NewReader(tt.data))); err == nil || errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Fatalf("compress/flate 应判断为损坏的数据, 实际 %v", err)
+			}
+			if _, err := (&inflater{}).inflate(tt.data, 1024); err != errInvalidDeflate {
+				t.Errorf("期望返回 errInvalidDeflate, 实际 %v", err)
+			}
+		})
+	}
+}
+
+// FuzzInflate 比较 inflater 与 compress/flate 的解压结果：数据分两段输入，
+// compress/flate 解压成功时结果必须相同，数据损坏时必须返回错误，输出的内容始终与 compress/flate 一致
+func FuzzInflate(f *testing.F) {
+	const limit = 1 << 20
+	for _, level := range []int{flate.NoCompression, flate.BestSpeed, flate.DefaultCompression, flate.HuffmanOnly} {
+		var buf bytes.Buffer
+		writer, _ := flate.NewWriter(&buf, level)
+		writer.Write([]byte(strings.Repeat("敏感词检测，hello world。", 50)))
+		writer.Flush()
+		writer.Write([]byte("abcabcabcabc"))
+		writer.Close()
+		f.Add(buf.Bytes(), uint16(buf.Len()/2))
+	}
+	f.Add(new(deflateBitWriter).bits(1|1<<1, 3).code(1, 7).code(0, 5).code(0, 7).data, uint16(1))
+
+	f.Fuzz(func(t *testing.T, data []byte, split uint16) {
+		expected, expectedErr := io.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(data)), limit+1))
+		if len(expected) > limit {
+			return
+		}
+
+		inf := &inflater{}
+		cut := int(split) % (len(data) + 1)
+		output, err := inf.inflate(data[:cut], limit)
+		if err == nil {
+			var rest []byte
+			rest, err = inf.inflate(data[cut:], limit)
+			output = append(output, rest...)
+		}
+
+		switch {
+		case expectedErr == nil:
+			if err != nil || inf.state != inflateDone || !bytes.Equal(output, expected) {
+				t.Fatalf("compress/flate 解压成功，inflater 结果不一致: done=%v, err=%v", inf.state == inflateDone, err)
+			}
+		case errors.Is(expectedErr, io.ErrUnexpectedEOF):
+			if inf.state == inflateDone {
+				t.Fatalf("数据不完整，inflater 不应结束")
+			}
+		default:
+			if err == nil {
+				t.Fatalf("数据损坏，inflater 应返回错误: %v", expectedErr)
+			}
+		}
+		if !bytes.HasPrefix(output, expected) && !bytes.HasPrefix(expected, output) {
+			t.Fatalf("inflater 输出的内容与 compress/flate 不一致")
+		}
+	})
+}
@@ -0,0 +1,88 @@
+package lib
+
+import (
+	"ai-data-masking/config"
+	"regexp"
+	"testing"
+)
+
+// TestMaskValue 测试部分掩码按字符计数
+func TestMaskValue(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		opts     config.MaskOptions
+		expected string
+	}{
+		{name: "手机号", value: "13812345678", opts: config.MaskOptions{KeepLeft: 3, KeepRight: 4}, expected: "138****5678"},
+		{name: "只保留右侧", value: "6222021234567890", opts: config.MaskOptions{KeepRight: 4}, expected: "************7890"},
+		{name: "中文", value: "张三丰", opts: config.MaskOptions{KeepLeft: 1}, expected: "张**"},
+		{name: "自定义掩码字符", value: "abcdef", opts: config.MaskOptions{KeepLeft: 1, KeepRight: 1, MaskChar: "●"}, expected: "a●●●●f"},
+		{name: "保留过多时整体掩码", value: "张三", opts: config.MaskOptions{KeepLeft: 1, KeepRight: 1}, expected: "**"},
+		{name: "负数按0处理", value: "abc", opts: config.MaskOptions{KeepLeft: -1}, expected: "***"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MaskValue(tt.value, tt.opts); got != tt.expected {
+				t.Errorf("期望 %q, 实际 %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestReplaceMessage_Mask 测试 mask 规则类型以及识别器未配置 value 时的部分掩码
+func TestReplaceMessage_Mask(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     config.Rule
+		text     string
+		expected string
+	}{
+		{
+			name: "mask 类型",
+			rule: config.Rule{
+				Type:          config.RuleTypeMask,
+				MaskOptions:   config.MaskOptions{KeepRight: 4},
+				CompiledRegex: regexp.MustCompile(`\d{16}`),
+			},
+			text:     "卡号6222021234567890",
+			expected: "卡号************7890",
+		},
+		{
+			name: "识别器部分掩码",
+			rule: config.Rule{
+				Type:          config.RuleTypeMobile,
+				MaskOptions:   config.MaskOptions{KeepLeft: 3, KeepRight: 4},
+				CompiledRegex: regexp.MustCompile(DetectorPattern(config.RuleTypeMobile)),
+			},
+			text:     "电话13812345678，编号12012345678",
+			expected: "电话138****5678，编号12012345678",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pluginCtx := &config.PluginContext{
+				Config:  &config.AiDataMaskingConfig{ReplaceRoles: []config.Rule{tt.rule}},
+				MaskMap: make(map[string]*string),
+			}
+			if got := ReplaceMessage(tt.text, pluginCtx); got != tt.expected {
+				t.Errorf("期望 %q, 实际 %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestReplaceSensitiveWordsWithValue_Mask 测试响应替换策略使用部分掩码
+func TestReplaceSensitiveWordsWithValue_Mask(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.ResponseDenyPlot.Plot = "replace"
+	cfg.ResponseDenyPlot.Type = config.DenyPlotTypeMask
+	cfg.ResponseDenyPlot.MaskOptions = config.MaskOptions{KeepLeft: 1, KeepRight: 1, MaskChar: "#"}
+
+	got := ReplaceSensitiveWordsWithValue("这里有违规内容", cfg, nil, "")
+	if got != "这里有违##容" {
+		t.Errorf("替换结果不正确: %q", got)
+	}
+}
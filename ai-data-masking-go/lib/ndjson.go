@@ -0,0 +1,89 @@
+package lib
+
+import (
+	"strings"
+
+	"ai-data-masking/config"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// ndjsonPendingKey NDJSON 流式响应还原时暂存内容的键，每行只有一个增量文本字段
+const ndjsonPendingKey = "0.ndjson"
+
+// IsNDJSONContentType 判断响应 Content-Type 是否为 NDJSON（Ollama 等后端的流式响应）
+func IsNDJSONContentType(contentType string) bool {
+	return strings.Contains(contentType, "application/x-ndjson") || strings.Contains(contentType, "application/ndjson")
+}
+
+// NDJSONToSSE 将 NDJSON 流式响应 chunk 转换为 SSE 事件，与 SSE 共用流式处理逻辑
+// 一行 JSON 可能被拆分到多个 chunk，末尾不完整的行暂存，与后续 chunk 拼接；最后一个 chunk 时输出全部内容
+func NDJSONToSSE(pluginCtx *config.PluginContext, chunk []byte, isLastChunk bool) []byte {
+	lines := strings.Split(pluginCtx.StreamNDJSONPending+string(chunk), "\n")
+	pluginCtx.StreamNDJSONPending = ""
+	if !isLastChunk {
+		pluginCtx.StreamNDJSONPending = lines[len(lines)-1]
+		lines = lines[:len(lines)-1]
+	}
+
+	var result strings.Builder
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		result.WriteString("data: " + line + "\n\n")
+	}
+	return []byte(result.String())
+}
+
+// SSEToNDJSON 将处理后的 SSE 事件还原为 NDJSON，每个事件的 data 输出为一行，没有 data 的事件（如注释）丢弃
+func SSEToNDJSON(chunk []byte) []byte {
+	var result strings.Builder
+	for _, eventStr := range strings.Split(strings.TrimSpace(string(chunk)), "\n\n") {
+		if data := sseEventData(eventStr); data != "" {
+			result.WriteString(data + "\n")
+		}
+	}
+	if result.Len() == 0 {
+		return nil
+	}
+	return []byte(result.String())
+}
+
+// ndjsonDenyEvent 构造 NDJSON 流式响应中途拒绝时的最后一行：增量文本字段为拒绝消息，done 为 true
+func ndjsonDenyEvent(pluginCtx *config.PluginContext, message string) string {
+	data, _ := sjson.Set(`{"done":true}`, "model", pluginCtx.OpenAIRequest.Model)
+	data, _ = sjson.Set(data, pluginCtx.Config.StreamNDJSONPath, message)
+	return "data: " + data + "\n\n"
+}
+
+// restoreNDJSONEvent 还原 NDJSON 行中增量文本字段，返回新的 JSON 以及是否修改
+// done 为 true 的行（Ollama 等后端的最后一行）不再暂存，输出全部内容
+func restoreNDJSONEvent(pluginCtx *config.PluginContext, jsonStr string, keys []string, replacer *strings.Replacer) (string, bool) {
+	path := pluginCtx.Config.StreamNDJSONPath
+	delta := gjson.Get(jsonStr, path)
+	finished := gjson.Get(jsonStr, "done").Bool()
+	pending := pluginCtx.StreamRestorePending[ndjsonPendingKey]
+	pluginCtx.StreamRestoreLastEvent = jsonStr
+	if delta.Type != gjson.String && !(finished && pending != "") {
+		return jsonStr, false
+	}
+
+	text, rest := pending+delta.String(), ""
+	if !finished {
+		text, rest = splitRestorePending(text, keys)
+	}
+	if rest == "" {
+		delete(pluginCtx.StreamRestorePending, ndjsonPendingKey)
+	} else {
+		pluginCtx.StreamRestorePending[ndjsonPendingKey] = rest
+	}
+	text = replacer.Replace(text)
+	if text == delta.String() {
+		return jsonStr, false
+	}
+	newJsonStr, err := sjson.Set(jsonStr, path, text)
+	return newJsonStr, err == nil
+}
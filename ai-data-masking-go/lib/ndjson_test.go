@@ -0,0 +1,147 @@
+package lib
+
+import (
+	"ai-data-masking/config"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+// collectNDJSONText 拼接 NDJSON 输出中各行指定路径的文本
+func collectNDJSONText(output, path string) string {
+	var text strings.Builder
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		text.WriteString(gjson.Get(line, path).String())
+	}
+	return text.String()
+}
+
+// TestIsNDJSONContentType 测试 NDJSON 响应类型识别
+func TestIsNDJSONContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		expected    bool
+	}{
+		{contentType: "application/x-ndjson", expected: true},
+		{contentType: "application/x-ndjson; charset=utf-8", expected: true},
+		{contentType: "application/ndjson", expected: true},
+		{contentType: "text/event-stream", expected: false},
+		{contentType: "application/json", expected: false},
+	}
+	for _, tt := range tests {
+		if got := IsNDJSONContentType(tt.contentType); got != tt.expected {
+			t.Errorf("%s: 期望 %v, 实际 %v", tt.contentType, tt.expected, got)
+		}
+	}
+}
+
+// TestNDJSONToSSE 测试被 chunk 拆分的行拼接完整后再转换为 SSE 事件
+func TestNDJSONToSSE(t *testing.T) {
+	pluginCtx := &config.PluginContext{}
+	tests := []struct {
+		name        string
+		chunk       string
+		isLastChunk bool
+		expected    string
+	}{
+		{name: "完整的行", chunk: "{\"response\":\"你\"}\n", expected: "data: {\"response\":\"你\"}\n\n"},
+		{name: "行被拆分", chunk: "{\"response\":\"好\"}\n{\"resp", expected: "data: {\"response\":\"好\"}\n\n"},
+		{name: "拼接暂存的行", chunk: "onse\":\"吗\"}\n\n", expected: "data: {\"response\":\"吗\"}\n\n"},
+		{name: "只有不完整的行", chunk: "{\"done\":", expected: ""},
+		{name: "最后一个 chunk 输出全部内容", chunk: "true}", isLastChunk: true, expected: "data: {\"done\":true}\n\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(NDJSONToSSE(pluginCtx, []byte(tt.chunk), tt.isLastChunk)); got != tt.expected {
+				t.Errorf("期望 %q, 实际 %q", tt.expected, got)
+			}
+		})
+	}
+	if pluginCtx.StreamNDJSONPending != "" {
+		t.Errorf("最后一个 chunk 后不应有暂存内容: %q", pluginCtx.StreamNDJSONPending)
+	}
+}
+
+// TestSSEToNDJSON 测试处理后的 SSE 事件还原为 NDJSON，注释事件被丢弃
+func TestSSEToNDJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		chunk    string
+		expected string
+	}{
+		{name: "多个事件", chunk: "data: {\"a\":1}\n\ndata: {\"b\":2}\n\n", expected: "{\"a\":1}\n{\"b\":2}\n"},
+		{name: "注释事件", chunk: ": HIGRESS AI DATA PROCESSING \n\n", expected: ""},
+		{name: "空 chunk", chunk: "", expected: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(SSEToNDJSON([]byte(tt.chunk))); got != tt.expected {
+				t.Errorf("期望 %q, 实际 %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestWriteReplacedChunks_NDJSON 测试按配置路径提取 NDJSON 增量并回写替换结果
+func TestWriteReplacedChunks_NDJSON(t *testing.T) {
+	pluginCtx := &config.PluginContext{
+		Config:        &config.AiDataMaskingConfig{StreamNDJSONPath: "message.content"},
+		RespIsNDJSON:  true,
+		OpenAIRequest: &config.OpenAIRequest{Model: "llama3"},
+	}
+	lines := []string{
+		`{"model":"llama3","message":{"role":"assistant","content":"机"},"done":false}`,
+		`{"model":"llama3","message":{"role":"assistant","content":"密号码"},"done":false}`,
+		`{"model":"llama3","message":{"role":"assistant","content":""},"done":true,"eval_count":3}`,
+	}
+	for _, line := range lines {
+		contentStart := len(pluginCtx.StreamContentBuffer)
+		content, reasoning, toolCall := streamEventDeltas(pluginCtx, gjson.Parse(line))
+		if reasoning != "" || toolCall != "" {
+			t.Fatalf("NDJSON 只应提取配置路径的增量: reasoning=%q, toolCall=%q", reasoning, toolCall)
+		}
+		pluginCtx.StreamContentBuffer += content
+		pluginCtx.StreamChunkBuffer = append(pluginCtx.StreamChunkBuffer, config.StreamChunk{
+			Data:         []byte("data: " + line + "\n\n"),
+			ContentStart: contentStart,
+			ContentEnd:   len(pluginCtx.StreamContentBuffer),
+		})
+	}
+	if pluginCtx.StreamContentBuffer != "机密号码" {
+		t.Fatalf("增量提取不正确: %q", pluginCtx.StreamContentBuffer)
+	}
+
+	var result strings.Builder
+	writeReplacedChunks(&result, pluginCtx, "**号码", "", "")
+	output := string(SSEToNDJSON([]byte(result.String())))
+	if got := collectNDJSONText(output, "message.content"); got != "**号码" {
+		t.Errorf("替换回写不正确: %q", got)
+	}
+	if last := strings.Split(strings.TrimSpace(output), "\n")[2]; last != lines[2] {
+		t.Errorf("没有增量的行应原样输出: %s", last)
+	}
+}
+
+// TestRestoreStreamResponse_NDJSON 测试 NDJSON 流式响应的还原，done 为 true 的行补发暂存的内容
+func TestRestoreStreamResponse_NDJSON(t *testing.T) {
+	pluginCtx := createTestPluginContext(withMaskMap(map[string]string{"<PHONE_1>": "13812345678"}))
+	pluginCtx.RespIsNDJSON = true
+	pluginCtx.Config.StreamNDJSONPath = "response"
+	chunks := []string{
+		"{\"response\":\"请拨打 <PHO\",\"done\":false}\n",
+		"{\"response\":\"NE_1> 或 <PH\",\"done\":false}\n",
+		"{\"response\":\"\",\"done\":true}\n",
+	}
+	var output strings.Builder
+	for i, chunk := range chunks {
+		isLastChunk := i == len(chunks)-1
+		output.Write(SSEToNDJSON(RestoreStreamResponse(pluginCtx, NDJSONToSSE(pluginCtx, []byte(chunk), isLastChunk), isLastChunk)))
+	}
+	if got := collectNDJSONText(output.String(), "response"); got != "请拨打 13812345678 或 <PH" {
+		t.Errorf("还原不正确: %q", got)
+	}
+	if len(pluginCtx.StreamRestorePending) != 0 {
+		t.Errorf("done 之后不应有暂存内容: %v", pluginCtx.StreamRestorePending)
+	}
+}
@@ -0,0 +1,191 @@
+package lib
+
+import (
+	"ai-data-masking/config"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// traditionalToSimplified 繁体到简体的字符映射，在包初始化时由映射表构建
+var traditionalToSimplified = buildTraditionalToSimplified(traditionalToSimplifiedPairs)
+
+func buildTraditionalToSimplified(pairs string) map[rune]rune {
+	fields := strings.Fields(pairs)
+	m := make(map[rune]rune, len(fields))
+	for _, field := range fields {
+		runes := []rune(field)
+		if len(runes) != 2 {
+			continue
+		}
+		m[runes[0]] = runes[1]
+	}
+	return m
+}
+
+// NormalizedText 归一化后的文本，以及归一化文本每个字节到原始文本字节区间的映射
+// 未开启任何归一化步骤时 starts/ends 为空，位置一一对应
+type NormalizedText struct {
+	Text   string
+	starts []int // 归一化文本第 i 个字节对应的原始文本起始字节位置
+	ends   []int // 归一化文本第 i 个字节对应的原始文本结束字节位置
+}
+
+// OriginalRange 将归一化文本中的 [start, end) 字节区间映射回原始文本的字节区间
+func (n *NormalizedText) OriginalRange(start, end int) (int, int) {
+	if n.starts == nil {
+		return start, end
+	}
+	if start >= len(n.starts) || end <= start {
+		return start, end
+	}
+	if end > len(n.ends) {
+		end = len(n.ends)
+	}
+	return n.starts[start], n.ends[end-1]
+}
+
+// NormalizeText 按配置对文本做归一化，并记录到原始文本的偏移映射
+// 归一化流水线：零宽字符剔除 -> NFKC -> 全角转半角 -> 大小写折叠 -> 繁转简 -> 分隔符剔除
+// NFKC 按规范化片段（基字符及其后的组合字符）进行，分解形式的组合序列（如 e + U+0301）与预组合字符（é）得到相同的结果，
+// 片段的输出整体映射到片段在原始文本中的区间；其余步骤逐个字符进行
+func NormalizeText(text string, opts *config.NormalizeConfig) *NormalizedText {
+	if opts == nil || !opts.Enabled() {
+		return &NormalizedText{Text: text}
+	}
+
+	// 剔除零宽字符，记录保留下来的每个字节所属字符在原始文本中的区间
+	source, sourceStarts, sourceEnds := stripZeroWidth(text, opts.StripZeroWidth)
+
+	var builder strings.Builder
+	builder.Grow(len(text))
+	starts := make([]int, 0, len(text))
+	ends := make([]int, 0, len(text))
+	write := func(segment string, start, end int) {
+		out := normalizeSegment(segment, opts)
+		for j := 0; j < len(out); j++ {
+			starts = append(starts, sourceStarts[start])
+			ends = append(ends, sourceEnds[end-1])
+		}
+		builder.WriteString(out)
+	}
+
+	if opts.NFKC {
+		var iter norm.Iter
+		iter.InitString(norm.NFKC, source)
+		for !iter.Done() {
+			start := iter.Pos()
+			segment := string(iter.Next())
+			write(segment, start, iter.Pos())
+		}
+	} else {
+		for i := 0; i < len(source); {
+			_, size := utf8.DecodeRuneInString(source[i:])
+			write(source[i:i+size], i, i+size)
+			i += size
+		}
+	}
+
+	return &NormalizedText{Text: builder.String(), starts: starts, ends: ends}
+}
+
+// stripZeroWidth 剔除零宽字符（strip 为 false 时保留），返回剩余文本以及其中每个字节所属字符在原始文本中的起止位置
+func stripZeroWidth(text string, strip bool) (string, []int, []int) {
+	var builder strings.Builder
+	builder.Grow(len(text))
+	starts := make([]int, 0, len(text))
+	ends := make([]int, 0, len(text))
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if !strip || !isZeroWidth(r) {
+			builder.WriteString(text[i : i+size])
+			for j := 0; j < size; j++ {
+				starts = append(starts, i)
+				ends = append(ends, i+size)
+			}
+		}
+		i += size
+	}
+	return builder.String(), starts, ends
+}
+
+// NormalizeWord 对敏感词等字典项做与文本相同的归一化
+// 归一化结果为空（例如整个词都是分隔符）时返回原词，避免构建出匹配任意位置的空模式
+func NormalizeWord(word string, opts *config.NormalizeConfig) string {
+	if opts == nil || !opts.Enabled() {
+		return word
+	}
+	normalized := NormalizeText(word, opts).Text
+	if normalized == "" {
+		return word
+	}
+	return normalized
+}
+
+// normalizeWords 批量归一化字典项，保持下标与原列表一致
+func normalizeWords(words []string, opts *config.NormalizeConfig) []string {
+	if opts == nil || !opts.Enabled() {
+		return words
+	}
+	normalized := make([]string, len(words))
+	for i, word := range words {
+		normalized[i] = NormalizeWord(word, opts)
+	}
+	return normalized
+}
+
+// normalizeSegment 对 NFKC 之后的片段逐个字符执行其余的归一化步骤，返回归一化后的字符串（可能为空或多个字符）
+func normalizeSegment(segment string, opts *config.NormalizeConfig) string {
+	var builder strings.Builder
+	for _, c := range segment {
+		if opts.StripZeroWidth && isZeroWidth(c) {
+			continue
+		}
+		if opts.FullWidth {
+			c = toHalfWidth(c)
+		}
+		if opts.CaseFold {
+			c = unicode.ToLower(c)
+		}
+		if opts.TraditionalToSimplified {
+			if s, ok := traditionalToSimplified[c]; ok {
+				c = s
+			}
+		}
+		if opts.StripSeparators && isSeparator(c) {
+			continue
+		}
+		builder.WriteRune(c)
+	}
+	return builder.String()
+}
+
+// toHalfWidth 全角 ASCII 字符（U+FF01-U+FF5E）与全角空格转换为对应的半角字符
+func toHalfWidth(r rune) rune {
+	if r == '\u3000' {
+		return ' '
+	}
+	if r >= '\uFF01' && r <= '\uFF5E' {
+		return r - 0xFEE0
+	}
+	return r
+}
+
+// isZeroWidth 零宽字符、变体选择符及其他不可见的格式控制字符
+func isZeroWidth(r rune) bool {
+	switch {
+	case r >= '\u200B' && r <= '\u200F', // 零宽空格、零宽连接符、方向标记
+		r >= '\u2060' && r <= '\u2064', // 词连接符、不可见运算符
+		r >= '\uFE00' && r <= '\uFE0F', // 变体选择符
+		r == '\uFEFF', r == '\u00AD', r == '\u180E', r == '\u034F':
+		return true
+	}
+	return unicode.Is(unicode.Cf, r)
+}
+
+// isSeparator 空白、标点和符号，攻击者常在敏感词中插入这些字符绕过匹配
+func isSeparator(r rune) bool {
+	return unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r)
+}
@@ -0,0 +1,193 @@
+package lib
+
+import (
+	"ai-data-masking/config"
+	"strings"
+	"testing"
+)
+
+// allNormalizeOptions 开启全部归一化步骤
+var allNormalizeOptions = config.NormalizeConfig{
+	NFKC:                    true,
+	CaseFold:                true,
+	FullWidth:               true,
+	TraditionalToSimplified: true,
+	StripSeparators:         true,
+	StripZeroWidth:          true,
+}
+
+// TestNormalizeText 测试各归一化步骤
+func TestNormalizeText(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		opts     config.NormalizeConfig
+		expected string
+	}{
+		{
+			name:     "未开启归一化",
+			text:     "ＡＢＣ 敏感",
+			opts:     config.NormalizeConfig{},
+			expected: "ＡＢＣ 敏感",
+		},
+		{
+			name:     "全角转半角",
+			text:     "ＡＢＣ１２３",
+			opts:     config.NormalizeConfig{FullWidth: true},
+			expected: "ABC123",
+		},
+		{
+			name:     "NFKC",
+			text:     "①ﬁ",
+			opts:     config.NormalizeConfig{NFKC: true},
+			expected: "1fi",
+		},
+		{
+			name:     "NFKC 组合分解形式的组合字符",
+			text:     "cafe\u0301",
+			opts:     config.NormalizeConfig{NFKC: true},
+			expected: "café",
+		},
+		{
+			name:     "大小写折叠",
+			text:     "HeLLo",
+			opts:     config.NormalizeConfig{CaseFold: true},
+			expected: "hello",
+		},
+		{
+			name:     "繁转简",
+			text:     "違規內容",
+			opts:     config.NormalizeConfig{TraditionalToSimplified: true},
+			expected: "违规内容",
+		},
+		{
+			name:     "去除分隔符",
+			text:     "敏 感-词，1",
+			opts:     config.NormalizeConfig{StripSeparators: true},
+			expected: "敏感词1",
+		},
+		{
+			name:     "去除零宽字符",
+			text:     "敏\u200b感\u200d词\ufeff1",
+			opts:     config.NormalizeConfig{StripZeroWidth: true},
+			expected: "敏感词1",
+		},
+		{
+			name:     "组合",
+			text:     "Ｔｅｓｔ\u200b 違 規",
+			opts:     allNormalizeOptions,
+			expected: "test违规",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			got := NormalizeText(tt.text, &opts).Text
+			if got != tt.expected {
+				t.Errorf("期望 %q, 实际 %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestNormalizedText_OriginalRange 测试归一化文本到原文的位置映射
+func TestNormalizedText_OriginalRange(t *testing.T) {
+	opts := allNormalizeOptions
+	text := "前缀ＡＢ 違\u200b規"
+	normalized := NormalizeText(text, &opts)
+	if normalized.Text != "前缀ab违规" {
+		t.Fatalf("归一化结果不正确: %q", normalized.Text)
+	}
+
+	start := len("前缀")
+	start, end := normalized.OriginalRange(start, len(normalized.Text))
+	if got := text[start:end]; got != "ＡＢ 違\u200b規" {
+		t.Errorf("映射回原文的片段不正确: %q", got)
+	}
+}
+
+// TestFindSensitiveWordMatches_Normalize 测试归一化后命中并返回原文位置
+func TestFindSensitiveWordMatches_Normalize(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.Normalize = allNormalizeOptions
+
+	tests := []struct {
+		name     string
+		text     string
+		expected string // 原文中被命中的片段
+	}{
+		{name: "插入空格", text: "这里有违 规 内 容哦", expected: "违 规 内 容"},
+		{name: "繁体", text: "這是違規內容", expected: "違規內容"},
+		{name: "零宽字符", text: "不良\u200b信息", expected: "不良\u200b信息"},
+		{name: "全角与大小写", text: "敏感词１", expected: "敏感词１"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := FindSensitiveWordMatches(tt.text, cfg, nil)
+			if len(results) == 0 {
+				t.Fatalf("期望命中敏感词，实际未命中")
+			}
+			got := tt.text[results[0].StartPos:results[0].EndPos]
+			if got != tt.expected {
+				t.Errorf("期望原文片段 %q, 实际 %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestFindSensitiveWordMatches_NFKCCombining 测试分解形式的组合序列命中包含预组合字符的敏感词，原文位置覆盖完整的组合序列
+func TestFindSensitiveWordMatches_NFKCCombining(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.DenyWords = append(cfg.DenyWords, "café")
+	cfg.Normalize = config.NormalizeConfig{NFKC: true}
+
+	text := "去 cafe\u0301 喝咖啡"
+	results := FindSensitiveWordMatches(text, cfg, nil)
+	if len(results) != 1 {
+		t.Fatalf("期望命中 1 个敏感词，实际 %d 个", len(results))
+	}
+	if got := text[results[0].StartPos:results[0].EndPos]; got != "cafe\u0301" {
+		t.Errorf("期望原文片段 %q, 实际 %q", "cafe\u0301", got)
+	}
+}
+
+// TestReplaceSensitiveWordsWithValue_Normalize 测试归一化命中时按原文片段替换且字符数不变
+func TestReplaceSensitiveWordsWithValue_Normalize(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.Normalize = allNormalizeOptions
+
+	got := ReplaceSensitiveWordsWithValue("前违 规内容后", cfg, nil, "*")
+	if got != "前*****后" {
+		t.Errorf("替换结果不正确: %q", got)
+	}
+}
+
+// TestStreamKeepStart_Padding 测试流式窗口按归一化文本保留：敏感词中间的分隔符比保留长度更长时，下一批数据到达后仍能命中
+func TestStreamKeepStart_Padding(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.Normalize = config.NormalizeConfig{StripSeparators: true}
+	keepLen := CalculateMaxSensitiveWordLength(cfg)
+	padding := strings.Repeat(" ", keepLen+16)
+
+	// 第一批数据以敏感词的前半部分和分隔符结尾，处理完成后只保留窗口内的尾部
+	buffer := "正常的回答内容，" + "违" + padding
+	retained := buffer[streamKeepStart(buffer, keepLen, &cfg.Normalize):]
+	if !strings.Contains(retained, "违") {
+		t.Fatalf("保留的尾部应包含敏感词的前半部分: %q", retained)
+	}
+
+	// 第二批数据补全敏感词
+	text := retained + "规内容"
+	results := FindSensitiveWordMatches(text, cfg, nil)
+	if len(results) != 1 || text[results[0].StartPos:results[0].EndPos] != "违"+padding+"规内容" {
+		t.Errorf("跨窗口的敏感词未命中: %+v", results)
+	}
+
+	// 未开启归一化时按原文保留末尾 keepLen 字节
+	buffer = strings.Repeat("a", keepLen*2)
+	if got := streamKeepStart(buffer, keepLen, &config.NormalizeConfig{}); got != keepLen {
+		t.Errorf("期望保留起始位置 %d, 实际 %d", keepLen, got)
+	}
+}
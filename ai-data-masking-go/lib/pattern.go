@@ -0,0 +1,133 @@
+package lib
+
+import (
+	"ai-data-masking/config"
+	"regexp/syntax"
+	"unicode/utf8"
+)
+
+// defaultDenyPatternWindow 无长度上界的拦截正则（如包含 * 或 +）在流式场景下保留的历史字节数
+const defaultDenyPatternWindow = 256
+
+// appendPatternMatches 查找拦截正则在原始文本中的所有命中
+// MatchedWord 记录命中的规则而不是原文片段，避免在日志中输出敏感内容
+func appendPatternMatches(results []MatchResult, text string, cfg *config.AiDataMaskingConfig) []MatchResult {
+	for i, re := range cfg.CompiledDenyPatterns {
+		for _, loc := range re.FindAllStringIndex(text, -1) {
+			if loc[0] == loc[1] {
+				continue
+			}
+			results = append(results, MatchResult{
+				MatchedWord: cfg.DenyPatterns[i],
+				StartPos:    loc[0],
+				EndPos:      loc[1],
+				Action:      config.DenyActionBlock,
+				Pattern:     true,
+			})
+		}
+	}
+	return results
+}
+
+// findPatternMatch 返回第一个命中的拦截正则，未命中返回 nil
+func findPatternMatch(text string, cfg *config.AiDataMaskingConfig) *MatchResult {
+	for i, re := range cfg.CompiledDenyPatterns {
+		if loc := re.FindStringIndex(text); loc != nil && loc[0] != loc[1] {
+			return &MatchResult{
+				MatchedWord: cfg.DenyPatterns[i],
+				StartPos:    loc[0],
+				EndPos:      loc[1],
+				Action:      config.DenyActionBlock,
+				Pattern:     true,
+			}
+		}
+	}
+	return nil
+}
+
+// HasSettledMatch 流式场景下判断缓冲区中是否存在确定的命中
+// 正则命中延伸到缓冲区末尾时，后续数据可能让匹配变长（如 \d{8,11}），此时不视为确定命中，继续等待数据
+func HasSettledMatch(text string, cfg *config.AiDataMaskingConfig, systemDenyWords []string) bool {
+	if len(cfg.CompiledDenyPatterns) == 0 {
+		return CheckMessage(text, cfg, systemDenyWords, true)
+	}
+	for _, match := range BlockingMatches(FindSensitiveWordMatches(text, cfg, systemDenyWords)) {
+		if !match.Pattern || match.EndPos < len(text) {
+			return true
+		}
+	}
+	return false
+}
+
+// maxDenyPatternLength 计算拦截正则单次命中的最大字节数，用于确定流式场景下需要保留的历史数据
+func maxDenyPatternLength(cfg *config.AiDataMaskingConfig) int {
+	maxLen := 0
+	for _, re := range cfg.CompiledDenyPatterns {
+		parsed, err := syntax.Parse(re.String(), syntax.Perl)
+		if err != nil {
+			continue
+		}
+		n := regexMaxLength(parsed)
+		if n < 0 || n > defaultDenyPatternWindow {
+			n = defaultDenyPatternWindow
+		}
+		if n > maxLen {
+			maxLen = n
+		}
+	}
+	return maxLen
+}
+
+// regexMaxLength 估算正则单次匹配的最大字节数，没有上界时返回 -1
+func regexMaxLength(re *syntax.Regexp) int {
+	switch re.Op {
+	case syntax.OpLiteral:
+		n := 0
+		for _, r := range re.Rune {
+			n += utf8.RuneLen(r)
+		}
+		return n
+	case syntax.OpCharClass:
+		if len(re.Rune) == 0 {
+			return 0
+		}
+		return utf8.RuneLen(re.Rune[len(re.Rune)-1])
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return utf8.UTFMax
+	case syntax.OpCapture, syntax.OpQuest:
+		return regexMaxLength(re.Sub[0])
+	case syntax.OpStar, syntax.OpPlus:
+		return -1
+	case syntax.OpRepeat:
+		n := regexMaxLength(re.Sub[0])
+		if re.Max < 0 || n < 0 {
+			return -1
+		}
+		return n * re.Max
+	case syntax.OpConcat:
+		total := 0
+		for _, sub := range re.Sub {
+			n := regexMaxLength(sub)
+			if n < 0 {
+				return -1
+			}
+			total += n
+		}
+		return total
+	case syntax.OpAlternate:
+		maxLen := 0
+		for _, sub := range re.Sub {
+			n := regexMaxLength(sub)
+			if n < 0 {
+				return -1
+			}
+			if n > maxLen {
+				maxLen = n
+			}
+		}
+		return maxLen
+	default:
+		// 空匹配、行首行尾、单词边界等不消耗字符
+		return 0
+	}
+}
@@ -0,0 +1,96 @@
+package lib
+
+import (
+	"ai-data-masking/config"
+	"regexp/syntax"
+	"testing"
+)
+
+// TestFindSensitiveWordMatches_Pattern 测试拦截正则命中与敏感词命中一起返回
+func TestFindSensitiveWordMatches_Pattern(t *testing.T) {
+	cfg := createTestConfig(withDenyPatterns(`1[3-9]\d{9}`, `sk-[0-9a-zA-Z]{8,}`))
+
+	text := "电话13812345678，密钥sk-abcdef123456，还有违规内容"
+	results := FindSensitiveWordMatches(text, cfg, nil)
+
+	var fragments []string
+	for _, match := range results {
+		fragments = append(fragments, text[match.StartPos:match.EndPos])
+		if match.Pattern && match.Action != config.DenyActionBlock {
+			t.Errorf("正则命中应为 block, 实际 %s", match.Action)
+		}
+	}
+	expected := map[string]bool{"13812345678": true, "sk-abcdef123456": true, "违规内容": true}
+	if len(fragments) != len(expected) {
+		t.Fatalf("期望命中 %d 个片段，实际 %v", len(expected), fragments)
+	}
+	for _, fragment := range fragments {
+		if !expected[fragment] {
+			t.Errorf("意外的命中片段 %q", fragment)
+		}
+	}
+
+	if got := ReplaceSensitiveWordsWithValue("电话13812345678", cfg, nil, "*"); got != "电话***********" {
+		t.Errorf("替换结果不正确: %q", got)
+	}
+}
+
+// TestHasSettledMatch 测试流式场景下延伸到缓冲区末尾的正则命中暂不确定
+func TestHasSettledMatch(t *testing.T) {
+	cfg := createTestConfig(withDenyPatterns(`\d{8,11}`))
+
+	tests := []struct {
+		name     string
+		text     string
+		expected bool
+	}{
+		{name: "命中延伸到末尾", text: "号码12345678", expected: false},
+		{name: "命中后还有其他字符", text: "号码12345678，", expected: true},
+		{name: "未命中", text: "号码1234", expected: false},
+		{name: "敏感词命中", text: "违规内容12345678", expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HasSettledMatch(tt.text, cfg, nil); got != tt.expected {
+				t.Errorf("期望 %v, 实际 %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestRegexMaxLength 测试正则单次命中最大字节数的估算
+func TestRegexMaxLength(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		expected int
+	}{
+		{pattern: `\d{8,11}`, expected: 11},
+		{pattern: `1[3-9]\d{9}`, expected: 11},
+		{pattern: `身份证\d{18}`, expected: 27},
+		{pattern: `(abc|de)?x`, expected: 4},
+		{pattern: `sk-[a-z]+`, expected: -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			parsed, err := syntax.Parse(tt.pattern, syntax.Perl)
+			if err != nil {
+				t.Fatalf("解析正则失败: %v", err)
+			}
+			if got := regexMaxLength(parsed); got != tt.expected {
+				t.Errorf("期望 %d, 实际 %d", tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestCalculateMaxSensitiveWordLength_Pattern 测试保留长度覆盖拦截正则
+func TestCalculateMaxSensitiveWordLength_Pattern(t *testing.T) {
+	cfg := createTestConfig(withDenyPatterns(`sk-[a-z]+`))
+	cfg.DenyWords = []string{"短词"}
+
+	if got := CalculateMaxSensitiveWordLength(cfg); got != defaultDenyPatternWindow*2 {
+		t.Errorf("期望 %d, 实际 %d", defaultDenyPatternWindow*2, got)
+	}
+}
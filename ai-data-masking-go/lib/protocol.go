@@ -0,0 +1,494 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"ai-data-masking/config"
+
+	"github.com/higress-group/wasm-go/pkg/wrapper"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// textField 请求或响应 JSON 中需要检查的文本字段
+type textField struct {
+	path   string // sjson 路径
+	text   string // 字段内容
+	signed bool   // 内容带签名（如 Anthropic thinking），改写后签名失效，不做还原
+	json   bool   // 内容为 JSON 文本（如 tool_calls 的 arguments），只检查、处理其中的字符串值
+	raw    bool   // 字段本身是 JSON 对象（如 Gemini functionCall.args），text 为其原始 JSON，按原始 JSON 写回
+}
+
+// checkText 返回用于拦截检查的文本，JSON 字段为解码后的各字符串值
+func (f textField) checkText() string {
+	if f.json {
+		return jsonStringValues(f.text)
+	}
+	return f.text
+}
+
+// apply 对字段内容执行文本处理，JSON 字段只处理其中的字符串值，处理后仍是合法的 JSON
+func (f textField) apply(fn func(string) string) string {
+	if f.json {
+		return mapJSONStrings(f.text, fn)
+	}
+	return fn(f.text)
+}
+
+// set 将处理后的内容写回 JSON 中的字段
+func (f textField) set(jsonStr, newText string) (string, error) {
+	if f.raw {
+		return sjson.SetRaw(jsonStr, f.path, newText)
+	}
+	return sjson.Set(jsonStr, f.path, newText)
+}
+
+// jsonStringValues 返回 JSON 文本中所有字符串值（解码后）以换行拼接的结果，不是合法 JSON 时返回原文
+func jsonStringValues(raw string) string {
+	if !gjson.Valid(raw) {
+		return raw
+	}
+	var values []string
+	var walk func(value gjson.Result)
+	walk = func(value gjson.Result) {
+		if value.Type == gjson.String {
+			values = append(values, value.String())
+			return
+		}
+		if !value.IsObject() && !value.IsArray() {
+			return
+		}
+		value.ForEach(func(_, item gjson.Result) bool {
+			walk(item)
+			return true
+		})
+	}
+	walk(gjson.Parse(raw))
+	return strings.Join(values, "\n")
+}
+
+// mapJSONStrings 对 JSON 文本中的每个字符串值执行 fn 并重新编码，没有变化时返回原文
+// 不是合法 JSON（如模型输出被截断）时对整个文本执行 fn
+func mapJSONStrings(raw string, fn func(string) string) string {
+	if !gjson.Valid(raw) {
+		return fn(raw)
+	}
+	var builder strings.Builder
+	if !writeMappedJSON(&builder, gjson.Parse(raw), fn) {
+		return raw
+	}
+	return builder.String()
+}
+
+// writeMappedJSON 递归写出处理后的 JSON 值，返回是否有字符串值发生变化
+func writeMappedJSON(builder *strings.Builder, value gjson.Result, fn func(string) string) bool {
+	switch {
+	case value.Type == gjson.String:
+		newValue := fn(value.String())
+		if newValue == value.String() {
+			builder.WriteString(value.Raw)
+			return false
+		}
+		builder.WriteString(jsonQuote(newValue))
+		return true
+	case value.IsObject(), value.IsArray():
+		open, close := byte('['), byte(']')
+		if value.IsObject() {
+			open, close = '{', '}'
+		}
+		changed := false
+		first := true
+		builder.WriteByte(open)
+		value.ForEach(func(key, item gjson.Result) bool {
+			if !first {
+				builder.WriteByte(',')
+			}
+			first = false
+			if value.IsObject() {
+				builder.WriteString(key.Raw)
+				builder.WriteByte(':')
+			}
+			if writeMappedJSON(builder, item, fn) {
+				changed = true
+			}
+			return true
+		})
+		builder.WriteByte(close)
+		return changed
+	default:
+		builder.WriteString(value.Raw)
+		return false
+	}
+}
+
+// jsonQuote 将字符串编码为 JSON 字符串，不转义 HTML 字符，保持 <PHONE_1> 等占位符可读
+func jsonQuote(s string) string {
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	_ = encoder.Encode(s)
+	return strings.TrimSuffix(buffer.String(), "\n")
+}
+
+// processRequestFields 依次检查请求中的文本字段：命中拦截词时拒绝（request_deny_plot 为 replace 时替换后继续），否则脱敏后回写
+// 返回处理后的请求体、是否修改、是否拒绝
+func processRequestFields(pluginCtx *config.PluginContext, bodyStr string, fields []textField) (string, bool, bool) {
+	modified := false
+	for _, field := range fields {
+		if checkRequestDeny(pluginCtx, field.checkText()) {
+			return bodyStr, modified, true
+		}
+		newText := field.apply(func(text string) string { return maskMessage(text, pluginCtx) })
+		if newText == field.text {
+			continue
+		}
+		if updated, err := field.set(bodyStr, newText); err == nil {
+			bodyStr = updated
+			modified = true
+		}
+	}
+	return bodyStr, modified, false
+}
+
+// denyImages 按 deny_image 策略检查请求中的图片，命中时记录分类并返回 true
+func denyImages(pluginCtx *config.PluginContext, images []string) bool {
+	for _, url := range images {
+		if pluginCtx.Config.DenyImageURL(url) {
+			pluginCtx.DenyCategory = config.DenyCategoryImage
+			return true
+		}
+	}
+	return false
+}
+
+// openAIRequestFields 返回 OpenAI 请求各消息中的文本字段以及图片 URL
+// content 可以是字符串或内容片段数组，数组中只处理 text 片段，image_url 片段只做策略检查，其他片段保持不变
+func openAIRequestFields(messages gjson.Result) ([]textField, []string) {
+	var fields []textField
+	var images []string
+	messages.ForEach(func(key, message gjson.Result) bool {
+		basePath := fmt.Sprintf("messages.%d.", key.Int())
+		content := message.Get("content")
+		if content.Type == gjson.String {
+			fields = append(fields, textField{path: basePath + "content", text: content.String()})
+		} else if content.IsArray() {
+			content.ForEach(func(partKey, part gjson.Result) bool {
+				switch part.Get("type").String() {
+				case "text":
+					fields = append(fields, textField{path: fmt.Sprintf("%scontent.%d.text", basePath, partKey.Int()), text: part.Get("text").String()})
+				case "image_url":
+					// image_url 可以是对象 {"url": "..."} 或直接是字符串
+					imageURL := part.Get("image_url")
+					if imageURL.IsObject() {
+						imageURL = imageURL.Get("url")
+					}
+					images = append(images, imageURL.String())
+				}
+				return true
+			})
+		}
+		if reasoning := message.Get("reasoning_content"); reasoning.Type == gjson.String {
+			fields = append(fields, textField{path: basePath + "reasoning_content", text: reasoning.String()})
+		}
+		fields = appendToolCallFields(fields, basePath, message)
+		return true
+	})
+	return fields, images
+}
+
+// appendToolCallFields 添加消息中 tool_calls[].function.arguments 以及旧版 function_call.arguments 字段
+// arguments 是 JSON 字符串，按 JSON 字段处理；role 为 tool 的消息结果位于 content，与普通消息一样处理
+func appendToolCallFields(fields []textField, basePath string, message gjson.Result) []textField {
+	message.Get("tool_calls").ForEach(func(key, toolCall gjson.Result) bool {
+		if arguments := toolCall.Get("function.arguments"); arguments.Type == gjson.String {
+			fields = append(fields, textField{path: fmt.Sprintf("%stool_calls.%d.function.arguments", basePath, key.Int()), text: arguments.String(), json: true})
+		}
+		return true
+	})
+	if arguments := message.Get("function_call.arguments"); arguments.Type == gjson.String {
+		fields = append(fields, textField{path: basePath + "function_call.arguments", text: arguments.String(), json: true})
+	}
+	return fields
+}
+
+// processResponseFields 检查响应中的文本字段：命中拦截词时拒绝；
+// 否则将动作为 replace 的敏感词替换为掩码，再还原请求阶段脱敏的数据后回写
+// 返回处理后的响应体、是否修改、是否拒绝
+func processResponseFields(pluginCtx *config.PluginContext, bodyStr string, fields []textField) (string, bool, bool) {
+	for _, field := range fields {
+		if checkDeny(pluginCtx, field.checkText(), false) {
+			return bodyStr, false, true
+		}
+	}
+
+	modified := false
+	for _, field := range fields {
+		newText := field.apply(func(text string) string {
+			text = MaskReplaceActionWords(text, pluginCtx.Config, config.GetSystemDenyWords())
+			if !field.signed {
+				text = RestoreMessage(text, pluginCtx)
+			}
+			return text
+		})
+		if newText == field.text {
+			continue
+		}
+		if updated, err := field.set(bodyStr, newText); err == nil {
+			bodyStr = updated
+			modified = true
+		}
+	}
+	return bodyStr, modified, false
+}
+
+// openAIResponseFields 返回 OpenAI 响应中各 choice 的 content、reasoning 与 tool_calls 参数字段
+func openAIResponseFields(root gjson.Result) []textField {
+	var fields []textField
+	root.Get("choices").ForEach(func(key, choice gjson.Result) bool {
+		basePath := fmt.Sprintf("choices.%d.message.", key.Int())
+		fields = append(fields,
+			textField{path: basePath + "content", text: choice.Get("message.content").String()},
+			textField{path: basePath + "reasoning", text: choice.Get("message.reasoning").String()},
+		)
+		fields = appendToolCallFields(fields, basePath, choice.Get("message"))
+		return true
+	})
+	return fields
+}
+
+// responseTextFields 按请求协议返回响应中需要检查的文本字段
+func responseTextFields(pluginCtx *config.PluginContext, root gjson.Result) []textField {
+	if ResponseModifyType(pluginCtx) == config.DenyModifyTypeJSONPath {
+		return jsonPathFields(root, pluginCtx.Config.ResponseJSONPath)
+	}
+	switch pluginCtx.Protocol {
+	case config.APIProtocolAnthropic:
+		return anthropicResponseFields(root)
+	case config.APIProtocolResponses:
+		return responsesOutputFields(nil, "output", root.Get("output"))
+	case config.APIProtocolGemini:
+		return geminiResponseFields(root)
+	case config.APIProtocolCompletions:
+		return completionsResponseFields(root)
+	}
+	return openAIResponseFields(root)
+}
+
+// ProcessProtocolRequest 按请求头阶段识别的协议处理请求体（Anthropic Messages、OpenAI Responses、Gemini）
+// 返回处理后的请求体、是否修改、是否拒绝
+func ProcessProtocolRequest(ctx wrapper.HttpContext, pluginCtx *config.PluginContext, body []byte) ([]byte, bool, bool) {
+	switch pluginCtx.Protocol {
+	case config.APIProtocolAnthropic:
+		return ProcessAnthropicRequest(ctx, pluginCtx, body)
+	case config.APIProtocolResponses:
+		return ProcessResponsesRequest(ctx, pluginCtx, body)
+	case config.APIProtocolGemini:
+		return ProcessGeminiRequest(ctx, pluginCtx, body)
+	}
+	return body, false, false
+}
+
+// ProcessProtocolResponse 按请求协议处理非流式响应体，默认按 OpenAI Chat Completions 处理
+// 返回处理后的响应体、是否修改、是否拒绝
+func ProcessProtocolResponse(ctx wrapper.HttpContext, pluginCtx *config.PluginContext, bodyStr string, body []byte) ([]byte, bool, bool) {
+	switch pluginCtx.Protocol {
+	case config.APIProtocolAnthropic:
+		return ProcessAnthropicResponse(ctx, pluginCtx, bodyStr, body)
+	case config.APIProtocolResponses:
+		return ProcessResponsesResponse(ctx, pluginCtx, bodyStr, body)
+	case config.APIProtocolGemini:
+		return ProcessGeminiResponse(ctx, pluginCtx, bodyStr, body)
+	}
+	return ProcessOpenAIResponse(ctx, pluginCtx, bodyStr, body)
+}
+
+// ProtocolDenyResponse 按请求协议构造拒绝响应（Anthropic Messages、OpenAI Responses、Gemini、Completions、Embeddings），
+// stream 为 true 时返回 SSE 事件序列
+func ProtocolDenyResponse(pluginCtx *config.PluginContext, message string, stream bool) []byte {
+	switch pluginCtx.Protocol {
+	case config.APIProtocolCompletions:
+		return CompletionsDenyResponse(pluginCtx.OpenAIRequest.Model, message, stream)
+	case config.APIProtocolEmbeddings:
+		return EmbeddingsDenyResponse(message)
+	case config.APIProtocolResponses:
+		return ResponsesDenyResponse(pluginCtx.OpenAIRequest.Model, message, stream)
+	case config.APIProtocolGemini:
+		return GeminiDenyResponse(pluginCtx.OpenAIRequest.Model, message, stream, pluginCtx.GeminiArrayStream)
+	}
+	return AnthropicDenyResponse(pluginCtx.OpenAIRequest.Model, message, stream)
+}
+
+// ResponseInspected 响应是否需要检查：开启 deny_openai、请求按 LLM 协议识别，或按 response_jsonpath、deny_raw 检查
+func ResponseInspected(pluginCtx *config.PluginContext) bool {
+	return pluginCtx.Config.DenyOpenAI || pluginCtx.Protocol.HasAdapter() || isRawResponseMode(pluginCtx)
+}
+
+// ProtocolModifyType 按请求协议返回拒绝/修改类型
+func ProtocolModifyType(pluginCtx *config.PluginContext) config.DenyModifyType {
+	switch pluginCtx.Protocol {
+	case config.APIProtocolAnthropic:
+		return config.DenyModifyTypeAnthropic
+	case config.APIProtocolResponses:
+		return config.DenyModifyTypeResponses
+	case config.APIProtocolGemini:
+		return config.DenyModifyTypeGemini
+	}
+	return config.DenyModifyTypeOpenAI
+}
+
+// trackStreamEvents 根据已发送给客户端的缓冲区事件更新流式状态，中途拒绝时据此补齐事件序列
+func trackStreamEvents(pluginCtx *config.PluginContext) {
+	switch pluginCtx.Protocol {
+	case config.APIProtocolAnthropic:
+		trackAnthropicStream(pluginCtx)
+	case config.APIProtocolResponses:
+		trackResponsesStream(pluginCtx)
+	}
+}
+
+// writeStreamChunk 输出缓冲区中的 chunk；Responses API 的 *.done、response.completed 等事件携带完整文本，
+// 其中的敏感词需要与增量一样替换
+func writeStreamChunk(result *strings.Builder, pluginCtx *config.PluginContext, data []byte) {
+	if pluginCtx.Protocol == config.APIProtocolResponses {
+		if eventData := sseEventData(string(data)); eventData != "" {
+			if newData, ok := rewriteResponsesEvent(eventData, responsesDoneTransform(pluginCtx)); ok {
+				result.WriteString(replaceSSEData(string(data), newData))
+				return
+			}
+		}
+	}
+	result.Write(data)
+}
+
+// streamEventDeltas 按请求协议提取流式事件中的 content、reasoning 与 tool_calls 参数增量
+func streamEventDeltas(pluginCtx *config.PluginContext, root gjson.Result) (string, string, string) {
+	switch ResponseModifyType(pluginCtx) {
+	case config.DenyModifyTypeJSONPath:
+		return root.Get(jsonPathStreamPath(pluginCtx, root)).String(), "", ""
+	case config.DenyModifyTypeRaw:
+		// deny_raw：data 的原始文本
+		return root.String(), "", ""
+	}
+	if pluginCtx.RespIsNDJSON {
+		// NDJSON：每行只有一个配置路径的增量文本字段
+		return root.Get(pluginCtx.Config.StreamNDJSONPath).String(), "", ""
+	}
+	switch pluginCtx.Protocol {
+	case config.APIProtocolAnthropic:
+		content, reasoning := anthropicEventDeltas(root)
+		return content, reasoning, ""
+	case config.APIProtocolResponses:
+		return responsesEventDeltas(root)
+	case config.APIProtocolGemini:
+		return geminiEventDeltas(root)
+	case config.APIProtocolCompletions:
+		return completionsEventDeltas(root), "", ""
+	}
+
+	// OpenAI：累加各 choice 的 delta
+	var content, reasoning, toolCall string
+	root.Get("choices").ForEach(func(key, choice gjson.Result) bool {
+		content += choice.Get("delta.content").String()
+		reasoning += choice.Get("delta.reasoning").String()
+		choice.Get("delta.tool_calls").ForEach(func(_, call gjson.Result) bool {
+			toolCall += call.Get("function.arguments").String()
+			return true
+		})
+		return true
+	})
+	return content, reasoning, toolCall
+}
+
+// setToolCallDeltas 将替换后的 tool_calls 参数增量按原有各参数片段的字符数写回流式事件
+func setToolCallDeltas(pluginCtx *config.PluginContext, jsonStr string, root gjson.Result, replaced string) (string, error) {
+	if pluginCtx.Protocol == config.APIProtocolResponses {
+		return sjson.Set(jsonStr, "delta", replaced)
+	}
+	runes := []rune(replaced)
+	pos := 0
+	var err error
+	root.Get("choices").ForEach(func(choiceKey, choice gjson.Result) bool {
+		choice.Get("delta.tool_calls").ForEach(func(callKey, call gjson.Result) bool {
+			arguments := call.Get("function.arguments")
+			if !arguments.Exists() {
+				return true
+			}
+			end := pos + len([]rune(arguments.String()))
+			if end > len(runes) {
+				return false
+			}
+			path := fmt.Sprintf("choices.%d.delta.tool_calls.%d.function.arguments", choiceKey.Int(), callKey.Int())
+			jsonStr, err = sjson.Set(jsonStr, path, string(runes[pos:end]))
+			pos = end
+			return err == nil
+		})
+		return err == nil
+	})
+	return jsonStr, err
+}
+
+// streamDeltaPaths 按请求协议返回流式事件中 content 与 reasoning 增量的 sjson 路径，response_jsonpath 的路径与事件有关
+func streamDeltaPaths(pluginCtx *config.PluginContext, root gjson.Result) (string, string) {
+	if ResponseModifyType(pluginCtx) == config.DenyModifyTypeJSONPath {
+		return jsonPathStreamPath(pluginCtx, root), ""
+	}
+	if pluginCtx.RespIsNDJSON {
+		return pluginCtx.Config.StreamNDJSONPath, ""
+	}
+	switch pluginCtx.Protocol {
+	case config.APIProtocolAnthropic:
+		return "delta.text", "delta.thinking"
+	case config.APIProtocolResponses:
+		return "delta", "delta"
+	case config.APIProtocolCompletions:
+		return "choices.0.text", ""
+	}
+	return "choices.0.delta.content", "choices.0.delta.reasoning"
+}
+
+// isStreamEndEvent 判断 SSE 事件是否为流结束标记：OpenAI 的 [DONE]、Anthropic 的 message_stop 或 Responses API 的 response.completed 等
+func isStreamEndEvent(eventStr string) bool {
+	for _, line := range strings.Split(eventStr, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		eventType := gjson.Get(data, "type").String()
+		if data == "[DONE]" || eventType == "message_stop" || isResponsesEndEvent(eventType) {
+			return true
+		}
+	}
+	return false
+}
+
+// replaceSSEData 替换 SSE 事件中的 data 行，保留 event、id 等其他行
+func replaceSSEData(eventStr, data string) string {
+	lines := strings.Split(strings.TrimSpace(eventStr), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "data:") {
+			lines[i] = "data: " + data
+			break
+		}
+	}
+	return strings.Join(lines, "\n") + "\n\n"
+}
+
+// typedSSEEvent 构造 event 行为 data 中 type 字段的 SSE 事件（Anthropic、Responses API 使用）
+func typedSSEEvent(data string) string {
+	return "event: " + gjson.Get(data, "type").String() + "\ndata: " + data + "\n\n"
+}
+
+// sseEventData 返回 SSE 事件中 data 行的内容
+func sseEventData(eventStr string) string {
+	for _, line := range strings.Split(eventStr, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "data:") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		}
+	}
+	return ""
+}
@@ -0,0 +1,217 @@
+package lib
+
+import (
+	"ai-data-masking/config"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+// TestProcessOpenAIRequest_ContentParts 测试内容片段数组中逐个处理 text 片段，其他片段保持不变
+func TestProcessOpenAIRequest_ContentParts(t *testing.T) {
+	pluginCtx := &config.PluginContext{
+		Config: &config.AiDataMaskingConfig{
+			DenyImage:    config.DenyImageNone,
+			ReplaceRoles: []config.Rule{{Type: config.RuleTypeReplace, Value: "****", CompiledRegex: regexp.MustCompile(`1\d{10}`)}},
+		},
+		MaskMap: make(map[string]*string),
+	}
+	body := `{"model":"gpt-4o","messages":[{"role":"system","content":"电话 13812345678"},` +
+		`{"role":"user","content":[{"type":"text","text":"看图 13900001111"},{"type":"image_url","image_url":{"url":"https://example.com/13700002222.png"}},{"type":"text","text":"无敏感信息"}]}]}`
+
+	newBody, modified, denied := ProcessOpenAIRequest(nil, pluginCtx, []byte(body))
+	if denied || !modified {
+		t.Fatalf("modified=%v, denied=%v", modified, denied)
+	}
+	expected := map[string]string{
+		"messages.0.content":                 "电话 ****",
+		"messages.1.content.0.text":          "看图 ****",
+		"messages.1.content.1.type":          "image_url",
+		"messages.1.content.1.image_url.url": "https://example.com/13700002222.png",
+		"messages.1.content.2.text":          "无敏感信息",
+	}
+	for path, want := range expected {
+		if got := gjson.GetBytes(newBody, path).String(); got != want {
+			t.Errorf("%s: 期望 %q, 实际 %q", path, want, got)
+		}
+	}
+	if !gjson.GetBytes(newBody, "messages.1.content").IsArray() {
+		t.Errorf("内容片段数组被破坏: %s", newBody)
+	}
+}
+
+// TestDenyImage 测试图片拦截策略
+func TestDenyImage(t *testing.T) {
+	openAIBody := func(url string) string {
+		return `{"messages":[{"role":"user","content":[{"type":"text","text":"描述图片"},{"type":"image_url","image_url":{"url":"` + url + `"}}]}]}`
+	}
+	anthropicBody := `{"model":"claude","messages":[{"role":"user","content":[{"type":"image","source":{"type":"base64","media_type":"image/png","data":"iVBORw0KGgo="}},{"type":"text","text":"描述图片"}]}]}`
+
+	tests := []struct {
+		name     string
+		policy   string
+		body     string
+		protocol config.APIProtocol
+		denied   bool
+	}{
+		{name: "默认不拦截", policy: config.DenyImageNone, body: openAIBody("data:image/png;base64,iVBORw0KGgo="), denied: false},
+		{name: "拦截 data URI", policy: config.DenyImageDataURI, body: openAIBody("DATA:image/png;base64,iVBORw0KGgo="), denied: true},
+		{name: "允许外链图片", policy: config.DenyImageDataURI, body: openAIBody("https://example.com/a.png"), denied: false},
+		{name: "拦截所有图片", policy: config.DenyImageAll, body: openAIBody("https://example.com/a.png"), denied: true},
+		{name: "anthropic base64 图片", policy: config.DenyImageDataURI, body: anthropicBody, protocol: config.APIProtocolAnthropic, denied: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pluginCtx := &config.PluginContext{
+				Config:   &config.AiDataMaskingConfig{DenyImage: tt.policy},
+				MaskMap:  make(map[string]*string),
+				Protocol: tt.protocol,
+			}
+			var denied bool
+			if tt.protocol == config.APIProtocolAnthropic {
+				_, _, denied = ProcessAnthropicRequest(nil, pluginCtx, []byte(tt.body))
+			} else {
+				_, _, denied = ProcessOpenAIRequest(nil, pluginCtx, []byte(tt.body))
+			}
+			if denied != tt.denied {
+				t.Fatalf("期望 denied=%v, 实际 %v", tt.denied, denied)
+			}
+			if denied && pluginCtx.DenyCategory != config.DenyCategoryImage {
+				t.Errorf("拦截分类不正确: %q", pluginCtx.DenyCategory)
+			}
+		})
+	}
+}
+
+// TestMapJSONStrings 测试只处理 JSON 中的字符串值，结果仍是合法的 JSON
+func TestMapJSONStrings(t *testing.T) {
+	phone := regexp.MustCompile(`1\d{10}`)
+	mask := func(text string) string { return phone.ReplaceAllString(text, `"****"`) }
+
+	tests := []struct {
+		name     string
+		raw      string
+		expected string
+	}{
+		{name: "嵌套对象与数组", raw: `{"user": {"phone": "13812345678", "tags": ["备用 13900001111", 1]}, "id": 13812345678}`,
+			expected: `{"user":{"phone":"\"****\"","tags":["备用 \"****\"",1]},"id":13812345678}`},
+		{name: "没有变化时保持原文", raw: `{"city": "杭州",  "days": 3}`, expected: `{"city": "杭州",  "days": 3}`},
+		{name: "不是合法 JSON", raw: `{"phone": "1381234567`, expected: `{"phone": "1381234567`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mapJSONStrings(tt.raw, mask)
+			if got != tt.expected {
+				t.Errorf("期望 %s, 实际 %s", tt.expected, got)
+			}
+			if gjson.Valid(tt.raw) && !gjson.Valid(got) {
+				t.Errorf("结果不是合法的 JSON: %s", got)
+			}
+		})
+	}
+
+	if got := jsonStringValues(`{"a":"张三","b":["x",2]}`); got != "张三\nx" {
+		t.Errorf("字符串值提取不正确: %q", got)
+	}
+}
+
+// TestProcessOpenAIRequest_ToolCalls 测试 tool_calls 参数与 tool 消息的脱敏
+func TestProcessOpenAIRequest_ToolCalls(t *testing.T) {
+	pluginCtx := &config.PluginContext{
+		Config: &config.AiDataMaskingConfig{
+			ReplaceRoles: []config.Rule{{Type: config.RuleTypeToken, Label: "phone", Restore: true, CompiledRegex: regexp.MustCompile(`1\d{10}`)}},
+		},
+		MaskMap: make(map[string]*string),
+	}
+	body := `{"messages":[{"role":"user","content":"给 13812345678 发短信"},` +
+		`{"role":"assistant","content":null,"tool_calls":[{"id":"call_1","type":"function","function":{"name":"send_sms","arguments":"{\"to\":\"13812345678\",\"text\":\"你好\"}"}}]},` +
+		`{"role":"tool","tool_call_id":"call_1","content":"已发送到 13812345678"}]}`
+
+	newBody, modified, denied := ProcessOpenAIRequest(nil, pluginCtx, []byte(body))
+	if denied || !modified {
+		t.Fatalf("modified=%v, denied=%v", modified, denied)
+	}
+	arguments := gjson.GetBytes(newBody, "messages.1.tool_calls.0.function.arguments").String()
+	if !gjson.Valid(arguments) || gjson.Get(arguments, "to").String() != "<PHONE_1>" || gjson.Get(arguments, "text").String() != "你好" {
+		t.Errorf("tool_calls 参数脱敏不正确: %s", arguments)
+	}
+	if got := gjson.GetBytes(newBody, "messages.2.content").String(); got != "已发送到 <PHONE_1>" {
+		t.Errorf("tool 消息脱敏不正确: %q", got)
+	}
+	if gjson.GetBytes(newBody, "messages.1.content").Type != gjson.Null {
+		t.Errorf("content 为 null 时不应修改: %s", newBody)
+	}
+}
+
+// TestProcessOpenAIResponse_ToolCalls 测试响应 tool_calls 参数的还原，原始值按 JSON 转义
+func TestProcessOpenAIResponse_ToolCalls(t *testing.T) {
+	pluginCtx := createTestPluginContext(withMaskMap(map[string]string{"<NAME_1>": `张"三"`}))
+	body := `{"choices":[{"index":0,"message":{"role":"assistant","content":null,"tool_calls":[{"id":"call_1","type":"function","function":{"name":"lookup","arguments":"{\"name\":\"<NAME_1>\"}"}}]}}]}`
+
+	newBody, modified, denied := ProcessOpenAIResponse(nil, pluginCtx, body, []byte(body))
+	if denied || !modified {
+		t.Fatalf("modified=%v, denied=%v", modified, denied)
+	}
+	arguments := gjson.GetBytes(newBody, "choices.0.message.tool_calls.0.function.arguments").String()
+	if !gjson.Valid(arguments) || gjson.Get(arguments, "name").String() != `张"三"` {
+		t.Errorf("tool_calls 参数还原不正确: %s", arguments)
+	}
+}
+
+// TestStreamToolCallDeltas 测试流式 tool_calls 参数增量的提取与替换回写
+func TestStreamToolCallDeltas(t *testing.T) {
+	pluginCtx := &config.PluginContext{Config: &config.AiDataMaskingConfig{}}
+	events := []string{
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","function":{"name":"send_sms","arguments":""}}]}}]}`,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"to\":\"机密"}}]}}]}`,
+		`{"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"号码\"}"}}]}}]}`,
+	}
+	for _, event := range events {
+		start := len(pluginCtx.StreamToolCallBuffer)
+		_, _, toolCall := streamEventDeltas(pluginCtx, gjson.Parse(event))
+		pluginCtx.StreamToolCallBuffer += toolCall
+		pluginCtx.StreamChunkBuffer = append(pluginCtx.StreamChunkBuffer, config.StreamChunk{
+			Data:          []byte("data: " + event + "\n\n"),
+			ToolCallStart: start,
+			ToolCallEnd:   len(pluginCtx.StreamToolCallBuffer),
+		})
+	}
+	if pluginCtx.StreamToolCallBuffer != `{"to":"机密号码"}` {
+		t.Fatalf("参数拼接不正确: %s", pluginCtx.StreamToolCallBuffer)
+	}
+
+	var result strings.Builder
+	writeReplacedChunks(&result, pluginCtx, "", "", `{"to":"**号码"}`)
+	var arguments strings.Builder
+	for _, event := range strings.Split(strings.TrimSpace(result.String()), "\n\n") {
+		arguments.WriteString(gjson.Get(sseEventData(event), "choices.0.delta.tool_calls.0.function.arguments").String())
+	}
+	if arguments.String() != `{"to":"**号码"}` {
+		t.Errorf("替换回写不正确: %s", arguments.String())
+	}
+}
+
+// TestRestoreStreamResponse_ToolCalls 测试流式 tool_calls 参数中被拆分的占位符在 finish_reason 时补发
+func TestRestoreStreamResponse_ToolCalls(t *testing.T) {
+	pluginCtx := createTestPluginContext(withMaskMap(map[string]string{"<PHONE_1>": "13812345678"}))
+	chunks := []string{
+		`data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"to\":\"<PHO"}}]},"finish_reason":null}]}` + "\n\n",
+		`data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"NE_1>\"}<PH"}}]},"finish_reason":null}]}` + "\n\n",
+		`data: {"choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}` + "\n\n" + "data: [DONE]\n\n",
+	}
+	var arguments strings.Builder
+	for i, chunk := range chunks {
+		out := string(RestoreStreamResponse(pluginCtx, []byte(chunk), i == len(chunks)-1))
+		for _, event := range strings.Split(strings.TrimSpace(out), "\n\n") {
+			gjson.Get(sseEventData(event), "choices.0.delta.tool_calls").ForEach(func(_, call gjson.Result) bool {
+				arguments.WriteString(call.Get("function.arguments").String())
+				return true
+			})
+		}
+	}
+	if arguments.String() != `{"to":"13812345678"}<PH` {
+		t.Errorf("还原结果不正确: %s", arguments.String())
+	}
+}
@@ -0,0 +1,101 @@
+package lib
+
+import (
+	"strings"
+
+	"ai-data-masking/config"
+
+	"github.com/higress-group/wasm-go/pkg/wrapper"
+	"github.com/tidwall/gjson"
+)
+
+// responsePendingKey response_jsonpath、deny_raw 流式响应还原时暂存内容的键，每个事件只有一个增量文本
+const responsePendingKey = "0.response"
+
+// ResponseModifyType 返回响应的检查方式：请求按 LLM 协议识别时按对应协议检查；
+// 否则配置了 response_jsonpath 时检查其中的字段，开启 deny_raw 时检查原始响应体
+func ResponseModifyType(pluginCtx *config.PluginContext) config.DenyModifyType {
+	if pluginCtx.Protocol == "" {
+		if len(pluginCtx.Config.ResponseJSONPath) > 0 {
+			return config.DenyModifyTypeJSONPath
+		}
+		if pluginCtx.Config.DenyRaw {
+			return config.DenyModifyTypeRaw
+		}
+	}
+	return ProtocolModifyType(pluginCtx)
+}
+
+// isRawResponseMode 响应是否按 response_jsonpath 或 deny_raw 检查
+func isRawResponseMode(pluginCtx *config.PluginContext) bool {
+	modifyType := ResponseModifyType(pluginCtx)
+	return modifyType == config.DenyModifyTypeJSONPath || modifyType == config.DenyModifyTypeRaw
+}
+
+// jsonPathFields 返回 JSON 中 paths 命中的字符串字段，数组结果（如 data.#.text）展开为各个元素
+func jsonPathFields(root gjson.Result, paths []string) []textField {
+	var fields []textField
+	for _, path := range paths {
+		result := root.Get(path)
+		if !result.Exists() {
+			continue
+		}
+		items := []gjson.Result{result}
+		itemPaths := []string{result.Path(root.Raw)}
+		if result.IsArray() {
+			items = result.Array()
+			itemPaths = result.Paths(root.Raw)
+		}
+		for i, item := range items {
+			// 无法确定字段位置时不能写回，跳过
+			if item.Type != gjson.String || i >= len(itemPaths) || itemPaths[i] == "" {
+				continue
+			}
+			fields = append(fields, textField{path: itemPaths[i], text: item.String()})
+		}
+	}
+	return fields
+}
+
+// ProcessJSONPathResponse 检查 response_jsonpath 命中的响应字段，未拒绝时脱敏并还原后回写
+// 返回处理后的响应体、是否修改、是否拒绝
+func ProcessJSONPathResponse(ctx wrapper.HttpContext, pluginCtx *config.PluginContext, bodyStr string, body []byte) ([]byte, bool, bool) {
+	root := gjson.Parse(bodyStr)
+	if !root.IsObject() && !root.IsArray() {
+		return body, false, false
+	}
+	newBodyStr, modified, denied := processResponseFields(pluginCtx, bodyStr, jsonPathFields(root, pluginCtx.Config.ResponseJSONPath))
+	if denied {
+		return body, false, true
+	}
+	return []byte(newBodyStr), modified, false
+}
+
+// replaceRawResponse replace 策略处理原始响应体：替换敏感词后还原请求阶段脱敏的数据
+func replaceRawResponse(pluginCtx *config.PluginContext, bodyStr, replaceValue string) string {
+	return RestoreMessage(ReplaceSensitiveWordsWithValue(bodyStr, pluginCtx.Config, config.GetSystemDenyWords(), replaceValue), pluginCtx)
+}
+
+// streamEventRoot 解析流式事件的 data；deny_raw 检查原始文本，data 按字符串处理
+func streamEventRoot(pluginCtx *config.PluginContext, data string) gjson.Result {
+	if ResponseModifyType(pluginCtx) == config.DenyModifyTypeRaw {
+		return gjson.Parse(jsonQuote(data))
+	}
+	return gjson.Parse(data)
+}
+
+// jsonPathStreamPath 返回流式事件中 response_jsonpath 第一个命中字符串字段的路径
+func jsonPathStreamPath(pluginCtx *config.PluginContext, root gjson.Result) string {
+	for _, path := range pluginCtx.Config.ResponseJSONPath {
+		if result := root.Get(path); result.Type == gjson.String {
+			return result.Path(root.Raw)
+		}
+	}
+	return ""
+}
+
+// rawStreamDenyEvent 构造 response_jsonpath、deny_raw 流式响应中途拒绝时的事件，data 为渲染后的 deny_raw_message
+func rawStreamDenyEvent(pluginCtx *config.PluginContext) string {
+	message := strings.ReplaceAll(string(RenderDenyRawMessage(pluginCtx)), "\n", "\ndata: ")
+	return "data: " + message + "\n\n"
+}
@@ -0,0 +1,165 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+
+	"ai-data-masking/config"
+
+	"github.com/tidwall/gjson"
+)
+
+// rawResponseMaskMap 请求阶段将 13812345678 脱敏为 <PHONE_1>
+var rawResponseMaskMap = map[string]string{"<PHONE_1>": "13812345678"}
+
+// TestResponseModifyType 测试响应检查方式的选择
+func TestResponseModifyType(t *testing.T) {
+	tests := []struct {
+		name      string
+		protocol  config.APIProtocol
+		jsonPaths []string
+		denyRaw   bool
+		expected  config.DenyModifyType
+	}{
+		{name: "未识别协议且配置 response_jsonpath", jsonPaths: []string{"output"}, denyRaw: true, expected: config.DenyModifyTypeJSONPath},
+		{name: "未识别协议且开启 deny_raw", denyRaw: true, expected: config.DenyModifyTypeRaw},
+		{name: "未识别协议且未配置", expected: config.DenyModifyTypeOpenAI},
+		{name: "OpenAI 请求", protocol: config.APIProtocolOpenAI, jsonPaths: []string{"output"}, denyRaw: true, expected: config.DenyModifyTypeOpenAI},
+		{name: "Anthropic 请求", protocol: config.APIProtocolAnthropic, denyRaw: true, expected: config.DenyModifyTypeAnthropic},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pluginCtx := createTestPluginContext(withConfig(createTestConfig(withDenyWordEntries(testDenyWordEntries...), withResponseJSONPath(tt.jsonPaths...), withDenyRaw(tt.denyRaw))),
+				withRequest(config.OpenAIRequest{}), withMaskMap(rawResponseMaskMap))
+			pluginCtx.Protocol = tt.protocol
+			if got := ResponseModifyType(pluginCtx); got != tt.expected {
+				t.Errorf("期望 %s, 实际 %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestJSONPathFields 测试 response_jsonpath 命中的字符串字段及其写回路径
+func TestJSONPathFields(t *testing.T) {
+	root := gjson.Parse(`{"result":{"text":"你好"},"items":[{"text":"a"},{"text":1},{"text":"b"}],"code":0}`)
+	fields := jsonPathFields(root, []string{"result.text", "items.#.text", "code", "missing"})
+	var got []string
+	for _, field := range fields {
+		got = append(got, field.path+"="+field.text)
+	}
+	expected := "result.text=你好,items.0.text=a,items.2.text=b"
+	if strings.Join(got, ",") != expected {
+		t.Errorf("期望 %s, 实际 %s", expected, strings.Join(got, ","))
+	}
+}
+
+// TestProcessJSONPathResponse 测试只处理 response_jsonpath 命中的字段：替换动作为 replace 的敏感词并还原脱敏数据
+func TestProcessJSONPathResponse(t *testing.T) {
+	pluginCtx := createTestPluginContext(withConfig(createTestConfig(withDenyWordEntries(testDenyWordEntries...), withResponseJSONPath("data.answer"))),
+		withRequest(config.OpenAIRequest{}), withMaskMap(rawResponseMaskMap))
+	body := `{"data":{"answer":"内部代号的电话是<PHONE_1>","echo":"<PHONE_1>"}}`
+	newBody, modified, denied := ProcessJSONPathResponse(nil, pluginCtx, body, []byte(body))
+	if denied || !modified {
+		t.Fatalf("期望修改且未拒绝: modified=%v, denied=%v", modified, denied)
+	}
+	if got := gjson.GetBytes(newBody, "data.answer").String(); got != "****的电话是13812345678" {
+		t.Errorf("字段处理不正确: %s", got)
+	}
+	if got := gjson.GetBytes(newBody, "data.echo").String(); got != "<PHONE_1>" {
+		t.Errorf("未配置的字段不应处理: %s", got)
+	}
+}
+
+// TestProcessRawResponse 测试整个原始响应体的替换与还原
+func TestProcessRawResponse(t *testing.T) {
+	pluginCtx := createTestPluginContext(withConfig(createTestConfig(withDenyWordEntries(testDenyWordEntries...), withDenyRaw(true))),
+		withRequest(config.OpenAIRequest{}), withMaskMap(rawResponseMaskMap))
+	body := "内部代号: <PHONE_1>"
+	newBody, modified, denied := ProcessRawResponse(nil, pluginCtx, body, []byte(body))
+	if denied || !modified || string(newBody) != "****: 13812345678" {
+		t.Errorf("处理不正确: %q, modified=%v, denied=%v", newBody, modified, denied)
+	}
+
+	body = "没有敏感内容"
+	if newBody, modified, _ = ProcessRawResponse(nil, pluginCtx, body, []byte(body)); modified || string(newBody) != body {
+		t.Errorf("不应修改: %q", newBody)
+	}
+}
+
+// TestRawResponseStream 测试 response_jsonpath、deny_raw 流式事件的增量提取与替换后写回
+func TestRawResponseStream(t *testing.T) {
+	t.Run("response_jsonpath", func(t *testing.T) {
+		pluginCtx := createTestPluginContext(withConfig(createTestConfig(withDenyWordEntries(testDenyWordEntries...), withResponseJSONPath("output.text"))),
+			withRequest(config.OpenAIRequest{}), withMaskMap(rawResponseMaskMap))
+		data := `{"output":{"text":"内部代号"},"id":1}`
+		root := streamEventRoot(pluginCtx, data)
+		if content, _, _ := streamEventDeltas(pluginCtx, root); content != "内部代号" {
+			t.Fatalf("增量不正确: %q", content)
+		}
+		pluginCtx.StreamContentBuffer = "内部代号"
+		pluginCtx.StreamChunkBuffer = []config.StreamChunk{{Data: []byte("data: " + data + "\n\n"), ContentEnd: len("内部代号")}}
+		var result strings.Builder
+		writeReplacedChunks(&result, pluginCtx, "****", "", "")
+		if got := gjson.Get(sseEventData(result.String()), "output.text").String(); got != "****" {
+			t.Errorf("写回不正确: %q", result.String())
+		}
+	})
+
+	t.Run("deny_raw", func(t *testing.T) {
+		pluginCtx := createTestPluginContext(withConfig(createTestConfig(withDenyWordEntries(testDenyWordEntries...), withDenyRaw(true))),
+			withRequest(config.OpenAIRequest{}), withMaskMap(rawResponseMaskMap))
+		data := `纯文本 "内部代号"`
+		if content, _, _ := streamEventDeltas(pluginCtx, streamEventRoot(pluginCtx, data)); content != data {
+			t.Fatalf("增量不正确: %q", content)
+		}
+		pluginCtx.StreamContentBuffer = data
+		pluginCtx.StreamChunkBuffer = []config.StreamChunk{{Data: []byte("event: message\ndata: " + data + "\n\n"), ContentEnd: len(data)}}
+		var result strings.Builder
+		writeReplacedChunks(&result, pluginCtx, `纯文本 "****"`, "", "")
+		if result.String() != "event: message\ndata: 纯文本 \"****\"\n\n" {
+			t.Errorf("写回不正确: %q", result.String())
+		}
+	})
+}
+
+// TestRawStreamDenyEvent 测试流式响应中途拒绝时以 deny_raw_message 作为最后一个事件，多行内容按多个 data 行输出
+func TestRawStreamDenyEvent(t *testing.T) {
+	pluginCtx := createTestPluginContext(withConfig(createTestConfig(withDenyWordEntries(testDenyWordEntries...), withDenyRaw(true))),
+		withRequest(config.OpenAIRequest{}), withMaskMap(rawResponseMaskMap))
+	pluginCtx.Config.DenyMessage = "已屏蔽"
+	pluginCtx.Config.DenyRawMessage = "第一行 ${message}\n第二行"
+	if got := rawStreamDenyEvent(pluginCtx); got != "data: 第一行 已屏蔽\ndata: 第二行\n\n" {
+		t.Errorf("实际 %q", got)
+	}
+}
+
+// TestRestoreRawResponseStream 测试被拆分到多个事件的占位符拼接后还原
+func TestRestoreRawResponseStream(t *testing.T) {
+	t.Run("response_jsonpath", func(t *testing.T) {
+		pluginCtx := createTestPluginContext(withConfig(createTestConfig(withDenyWordEntries(testDenyWordEntries...), withResponseJSONPath("text"))),
+			withRequest(config.OpenAIRequest{}), withMaskMap(rawResponseMaskMap))
+		chunk := "data: {\"text\":\"电话 <PHO\"}\n\ndata: {\"text\":\"NE_1> 结束\"}\n\n"
+		output := string(RestoreStreamResponse(pluginCtx, []byte(chunk), false))
+		if got := collectSSEText(output, "text"); got != "电话 13812345678 结束" {
+			t.Errorf("还原不正确: %q", output)
+		}
+	})
+
+	t.Run("deny_raw 流结束时补发暂存内容", func(t *testing.T) {
+		pluginCtx := createTestPluginContext(withConfig(createTestConfig(withDenyWordEntries(testDenyWordEntries...), withDenyRaw(true))),
+			withRequest(config.OpenAIRequest{}), withMaskMap(rawResponseMaskMap))
+		output := string(RestoreStreamResponse(pluginCtx, []byte("data: 电话 <PHONE\n\n"), true))
+		if output != "data: 电话 \n\ndata: <PHONE\n\n" {
+			t.Errorf("还原不正确: %q", output)
+		}
+	})
+}
+
+// collectSSEText 拼接 SSE 输出中各事件指定路径的文本
+func collectSSEText(output, path string) string {
+	var text strings.Builder
+	for _, eventStr := range strings.Split(strings.TrimSpace(output), "\n\n") {
+		text.WriteString(gjson.Get(sseEventData(eventStr), path).String())
+	}
+	return text.String()
+}
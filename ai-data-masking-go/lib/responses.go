@@ -0,0 +1,344 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"ai-data-masking/config"
+
+	"github.com/google/uuid"
+	"github.com/higress-group/wasm-go/pkg/wrapper"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// responsesPath OpenAI Responses 接口路径
+const responsesPath = "/v1/responses"
+
+// IsResponsesPath 判断请求路径是否为 OpenAI Responses 创建接口，忽略查询参数
+func IsResponsesPath(path string) bool {
+	path, _, _ = strings.Cut(path, "?")
+	return strings.HasSuffix(strings.TrimSuffix(path, "/"), responsesPath)
+}
+
+// ProcessResponsesRequest 处理 Responses API 请求，检查 instructions 以及 input 中的消息、工具调用参数和工具输出
+// 返回处理后的请求体、是否修改、是否拒绝
+func ProcessResponsesRequest(ctx wrapper.HttpContext, pluginCtx *config.PluginContext, body []byte) ([]byte, bool, bool) {
+	bodyStr := string(body)
+	root := gjson.Parse(bodyStr)
+	if !root.Get("input").Exists() && !root.Get("instructions").Exists() {
+		return body, false, false
+	}
+
+	// 初始化 OpenAIRequest（如果为 nil），模型与是否流式与 OpenAI 请求共用
+	if pluginCtx.OpenAIRequest == nil {
+		pluginCtx.OpenAIRequest = &config.OpenAIRequest{}
+	}
+	pluginCtx.OpenAIRequest.Stream = root.Get("stream").Bool()
+	pluginCtx.OpenAIRequest.Model = root.Get("model").String()
+
+	fields, images := responsesRequestFields(root)
+	if denyImages(pluginCtx, images) {
+		return body, false, true
+	}
+
+	bodyStr, modified, denied := processRequestFields(pluginCtx, bodyStr, fields)
+	return []byte(bodyStr), modified, denied
+}
+
+// ProcessResponsesResponse 处理 Responses API 非流式响应，检查 output 中的消息、推理摘要和工具调用参数
+// 返回处理后的响应体、是否修改、是否拒绝
+func ProcessResponsesResponse(ctx wrapper.HttpContext, pluginCtx *config.PluginContext, bodyStr string, body []byte) ([]byte, bool, bool) {
+	root := gjson.Parse(bodyStr)
+	if root.Get("object").String() != "response" || !root.Get("output").IsArray() {
+		return body, false, false
+	}
+
+	newBodyStr, modified, denied := processResponseFields(pluginCtx, bodyStr, responsesOutputFields(nil, "output", root.Get("output")))
+	if denied {
+		return body, modified, denied
+	}
+	return []byte(newBodyStr), modified, denied
+}
+
+// responsesRequestFields 返回 Responses API 请求中的文本字段以及图片 URL
+// input 可以是字符串或输入项数组，输入项包括消息、function_call 及 function_call_output，其他输入项（如 reasoning）保持不变
+func responsesRequestFields(root gjson.Result) ([]textField, []string) {
+	var fields []textField
+	var images []string
+	if instructions := root.Get("instructions"); instructions.Type == gjson.String {
+		fields = append(fields, textField{path: "instructions", text: instructions.String()})
+	}
+
+	input := root.Get("input")
+	if input.Type == gjson.String {
+		return append(fields, textField{path: "input", text: input.String()}), images
+	}
+	input.ForEach(func(key, item gjson.Result) bool {
+		basePath := fmt.Sprintf("input.%d", key.Int())
+		switch item.Get("type").String() {
+		case "function_call":
+			if arguments := item.Get("arguments"); arguments.Type == gjson.String {
+				fields = append(fields, textField{path: basePath + ".arguments", text: arguments.String(), json: true})
+			}
+		case "function_call_output":
+			fields, images = appendResponsesContent(fields, images, basePath+".output", item.Get("output"))
+		case "message", "":
+			fields, images = appendResponsesContent(fields, images, basePath+".content", item.Get("content"))
+		}
+		return true
+	})
+	return fields, images
+}
+
+// appendResponsesContent content 可以是字符串或内容片段数组，数组中只处理文本片段，input_image 片段只做策略检查
+func appendResponsesContent(fields []textField, images []string, path string, content gjson.Result) ([]textField, []string) {
+	if content.Type == gjson.String {
+		return append(fields, textField{path: path, text: content.String()}), images
+	}
+	content.ForEach(func(key, part gjson.Result) bool {
+		partPath := fmt.Sprintf("%s.%d", path, key.Int())
+		switch part.Get("type").String() {
+		case "input_text", "output_text":
+			fields = append(fields, textField{path: partPath + ".text", text: part.Get("text").String()})
+		case "refusal":
+			fields = append(fields, textField{path: partPath + ".refusal", text: part.Get("refusal").String()})
+		case "input_image":
+			// 通过 file_id 引用的图片没有 URL，不做检查
+			if imageURL := part.Get("image_url"); imageURL.Exists() {
+				images = append(images, imageURL.String())
+			}
+		}
+		return true
+	})
+	return fields, images
+}
+
+// responsesOutputFields 返回 output 数组中各输出项的文本字段，path 为 output 数组的 sjson 路径
+func responsesOutputFields(fields []textField, path string, output gjson.Result) []textField {
+	output.ForEach(func(key, item gjson.Result) bool {
+		fields = responsesItemFields(fields, fmt.Sprintf("%s.%d", path, key.Int()), item)
+		return true
+	})
+	return fields
+}
+
+// responsesItemFields 返回单个输出项中的文本字段：消息的 output_text/refusal、推理摘要以及 function_call 的参数
+func responsesItemFields(fields []textField, path string, item gjson.Result) []textField {
+	switch item.Get("type").String() {
+	case "message":
+		fields, _ = appendResponsesContent(fields, nil, path+".content", item.Get("content"))
+	case "reasoning":
+		item.Get("summary").ForEach(func(key, summary gjson.Result) bool {
+			fields = append(fields, textField{path: fmt.Sprintf("%s.summary.%d.text", path, key.Int()), text: summary.Get("text").String()})
+			return true
+		})
+	case "function_call":
+		if arguments := item.Get("arguments"); arguments.Type == gjson.String {
+			fields = append(fields, textField{path: path + ".arguments", text: arguments.String(), json: true})
+		}
+	}
+	return fields
+}
+
+// responsesEventDeltas 提取 Responses API 流式事件中的文本、推理摘要与函数调用参数增量
+func responsesEventDeltas(root gjson.Result) (string, string, string) {
+	delta := root.Get("delta").String()
+	switch root.Get("type").String() {
+	case "response.output_text.delta", "response.refusal.delta":
+		return delta, "", ""
+	case "response.reasoning_summary_text.delta", "response.reasoning_text.delta":
+		return "", delta, ""
+	case "response.function_call_arguments.delta":
+		return "", "", delta
+	}
+	return "", "", ""
+}
+
+// responsesEventFields 返回 Responses API 流式事件中携带完整文本的字段
+// 除增量事件外，*.done、output_item.*、response.completed 等事件会重复输出完整的文本，需要与增量一样处理
+func responsesEventFields(root gjson.Result) []textField {
+	eventType := root.Get("type").String()
+	switch eventType {
+	case "response.output_text.done", "response.reasoning_summary_text.done", "response.reasoning_text.done":
+		return []textField{{path: "text", text: root.Get("text").String()}}
+	case "response.refusal.done":
+		return []textField{{path: "refusal", text: root.Get("refusal").String()}}
+	case "response.function_call_arguments.done":
+		return []textField{{path: "arguments", text: root.Get("arguments").String(), json: true}}
+	case "response.content_part.added", "response.content_part.done",
+		"response.reasoning_summary_part.added", "response.reasoning_summary_part.done":
+		part := root.Get("part")
+		if part.Get("type").String() == "refusal" {
+			return []textField{{path: "part.refusal", text: part.Get("refusal").String()}}
+		}
+		return []textField{{path: "part.text", text: part.Get("text").String()}}
+	case "response.output_item.added", "response.output_item.done":
+		return responsesItemFields(nil, "item", root.Get("item"))
+	}
+	if root.Get("response.output").IsArray() {
+		// response.created、response.in_progress、response.completed 等
+		return responsesOutputFields(nil, "response.output", root.Get("response.output"))
+	}
+	return nil
+}
+
+// isResponsesEndEvent 判断是否为 Responses API 流结束事件
+func isResponsesEndEvent(eventType string) bool {
+	return eventType == "response.completed" || eventType == "response.incomplete" || eventType == "response.failed"
+}
+
+// rewriteResponsesEvent 对 Responses API 流式事件中携带完整文本的字段执行 transform，返回新的事件 JSON 以及是否修改
+func rewriteResponsesEvent(data string, transform func(string) string) (string, bool) {
+	modified := false
+	for _, field := range responsesEventFields(gjson.Parse(data)) {
+		if field.text == "" {
+			continue
+		}
+		newText := field.apply(transform)
+		if newText == field.text {
+			continue
+		}
+		if updated, err := field.set(data, newText); err == nil {
+			data = updated
+			modified = true
+		}
+	}
+	return data, modified
+}
+
+// responsesDoneTransform 完整文本字段的敏感词处理方式：replace 策略替换所有敏感词，stop 策略只替换动作为 replace 的敏感词
+// 动作为 block 的敏感词在增量中已经触发拒绝
+func responsesDoneTransform(pluginCtx *config.PluginContext) func(string) string {
+	if pluginCtx.Config.ResponseDenyPlot.Plot == "replace" {
+		replaceValue := pluginCtx.Config.ResponseDenyPlot.Value
+		if replaceValue == "" {
+			replaceValue = "*"
+		}
+		return func(text string) string {
+			return ReplaceSensitiveWordsWithValue(text, pluginCtx.Config, config.GetSystemDenyWords(), replaceValue)
+		}
+	}
+	return func(text string) string {
+		return MaskReplaceActionWords(text, pluginCtx.Config, config.GetSystemDenyWords())
+	}
+}
+
+// responsesObject 构造 Responses API 的 response 对象，output 为输出项数组的 JSON
+func responsesObject(id, model, status, output string) string {
+	if id == "" {
+		id = "resp_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+	}
+	response, _ := sjson.Set(`{"object":"response"}`, "id", id)
+	response, _ = sjson.Set(response, "created_at", time.Now().Unix())
+	response, _ = sjson.Set(response, "status", status)
+	response, _ = sjson.Set(response, "model", model)
+	response, _ = sjson.SetRaw(response, "output", output)
+	response, _ = sjson.SetRaw(response, "usage", `{"input_tokens":0,"output_tokens":0,"total_tokens":0}`)
+	return response
+}
+
+// responsesMessageItem 构造包含一段 output_text 的消息输出项
+func responsesMessageItem(id, status, text string) string {
+	item, _ := sjson.Set(`{"type":"message","role":"assistant"}`, "id", id)
+	item, _ = sjson.Set(item, "status", status)
+	if status == "in_progress" {
+		item, _ = sjson.SetRaw(item, "content", `[]`)
+		return item
+	}
+	item, _ = sjson.SetRaw(item, "content", "["+responsesTextPart(text)+"]")
+	return item
+}
+
+// responsesTextPart 构造 output_text 内容片段
+func responsesTextPart(text string) string {
+	part, _ := sjson.Set(`{"type":"output_text","annotations":[]}`, "text", text)
+	return part
+}
+
+// ResponsesDenyResponse 构造 Responses API 的拒绝响应，stream 为 true 时返回完整的 SSE 事件序列
+func ResponsesDenyResponse(model, message string, stream bool) []byte {
+	if stream {
+		return []byte(responsesDenyEvents(model, message, config.ResponsesStreamState{}, ""))
+	}
+	itemID := "msg_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+	return []byte(responsesObject("", model, "completed", "["+responsesMessageItem(itemID, "completed", message)+"]"))
+}
+
+// responsesDenyEvents 构造 Responses API 流式拒绝事件序列：在已发送的事件之后补齐 response.created，
+// 以一个新的消息输出项输出拒绝消息，最后以 response.completed 结束
+// created 为尚未发送的原始 response.created 事件，为空时按 model 构造
+func responsesDenyEvents(model, message string, state config.ResponsesStreamState, created string) string {
+	var result strings.Builder
+	sequence := state.NextSequence
+	writeEvent := func(data string) {
+		data, _ = sjson.Set(data, "sequence_number", sequence)
+		sequence++
+		result.WriteString(typedSSEEvent(data))
+	}
+
+	responseID := state.ResponseID
+	if !state.Created {
+		if created != "" {
+			responseID = gjson.Get(sseEventData(created), "response.id").String()
+			result.WriteString(created)
+			sequence = gjson.Get(sseEventData(created), "sequence_number").Int() + 1
+		} else {
+			responseID = "resp_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+			event, _ := sjson.SetRaw(`{"type":"response.created"}`, "response", responsesObject(responseID, model, "in_progress", `[]`))
+			writeEvent(event)
+		}
+	}
+
+	itemID := "msg_" + strings.ReplaceAll(uuid.New().String(), "-", "")
+	outputIndex := state.NextOutput
+	position := fmt.Sprintf(`"item_id":"%s","output_index":%d,"content_index":0`, itemID, outputIndex)
+
+	event, _ := sjson.SetRaw(fmt.Sprintf(`{"type":"response.output_item.added","output_index":%d}`, outputIndex), "item", responsesMessageItem(itemID, "in_progress", ""))
+	writeEvent(event)
+	event, _ = sjson.SetRaw(`{"type":"response.content_part.added",`+position+`}`, "part", responsesTextPart(""))
+	writeEvent(event)
+	event, _ = sjson.Set(`{"type":"response.output_text.delta",`+position+`}`, "delta", message)
+	writeEvent(event)
+	event, _ = sjson.Set(`{"type":"response.output_text.done",`+position+`}`, "text", message)
+	writeEvent(event)
+	event, _ = sjson.SetRaw(`{"type":"response.content_part.done",`+position+`}`, "part", responsesTextPart(message))
+	writeEvent(event)
+	item := responsesMessageItem(itemID, "completed", message)
+	event, _ = sjson.SetRaw(fmt.Sprintf(`{"type":"response.output_item.done","output_index":%d}`, outputIndex), "item", item)
+	writeEvent(event)
+	event, _ = sjson.SetRaw(`{"type":"response.completed"}`, "response", responsesObject(responseID, model, "completed", "["+item+"]"))
+	writeEvent(event)
+	return result.String()
+}
+
+// responsesStreamDenyEvents 响应阶段中途拒绝时的事件序列，缓冲区中尚未发送的 response.created 原样输出
+func responsesStreamDenyEvents(pluginCtx *config.PluginContext, message string) string {
+	var created string
+	for _, streamChunk := range pluginCtx.StreamChunkBuffer {
+		if gjson.Get(sseEventData(string(streamChunk.Data)), "type").String() == "response.created" {
+			created = string(streamChunk.Data)
+			break
+		}
+	}
+	return responsesDenyEvents(pluginCtx.OpenAIRequest.Model, message, pluginCtx.ResponsesStream, created)
+}
+
+// trackResponsesStream 根据已发送给客户端的缓冲区事件更新 Responses API 流式状态
+func trackResponsesStream(pluginCtx *config.PluginContext) {
+	state := &pluginCtx.ResponsesStream
+	for _, streamChunk := range pluginCtx.StreamChunkBuffer {
+		root := gjson.Parse(sseEventData(string(streamChunk.Data)))
+		if sequence := root.Get("sequence_number"); sequence.Exists() {
+			state.NextSequence = sequence.Int() + 1
+		}
+		switch root.Get("type").String() {
+		case "response.created":
+			state.Created = true
+			state.ResponseID = root.Get("response.id").String()
+		case "response.output_item.added":
+			state.NextOutput = int(root.Get("output_index").Int()) + 1
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package lib
+
+import (
+	"ai-data-masking/config"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+// collectResponsesEvents 返回 SSE 输出中各事件的数据
+func collectResponsesEvents(output string) []gjson.Result {
+	var events []gjson.Result
+	for _, event := range strings.Split(strings.TrimSpace(output), "\n\n") {
+		events = append(events, gjson.Parse(sseEventData(event)))
+	}
+	return events
+}
+
+// TestIsResponsesPath 测试 Responses 接口路径识别
+func TestIsResponsesPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected bool
+	}{
+		{path: "/v1/responses", expected: true},
+		{path: "/openai/v1/responses?stream=true", expected: true},
+		{path: "/v1/responses/", expected: true},
+		{path: "/v1/responses/resp_123", expected: false},
+		{path: "/v1/chat/completions", expected: false},
+	}
+	for _, tt := range tests {
+		if got := IsResponsesPath(tt.path); got != tt.expected {
+			t.Errorf("%s: 期望 %v, 实际 %v", tt.path, tt.expected, got)
+		}
+	}
+}
+
+// TestProcessResponsesRequest 测试 instructions 与各类输入项的脱敏，不支持的输入项保持不变
+func TestProcessResponsesRequest(t *testing.T) {
+	pluginCtx := &config.PluginContext{
+		Config: &config.AiDataMaskingConfig{
+			ReplaceRoles: []config.Rule{{Type: config.RuleTypeReplace, Value: "****", CompiledRegex: regexp.MustCompile(`1\d{10}`)}},
+		},
+		MaskMap:  make(map[string]*string),
+		Protocol: config.APIProtocolResponses,
+	}
+	body := `{"model":"gpt-4.1","stream":true,"instructions":"客服电话 13812345678","input":[` +
+		`{"role":"user","content":"我的电话 13900001111"},` +
+		`{"type":"message","role":"user","content":[{"type":"input_text","text":"备用 13700002222"},{"type":"input_image","image_url":"https://example.com/13700002222.png"}]},` +
+		`{"type":"function_call","call_id":"call_1","name":"send_sms","arguments":"{\"to\":\"13812345678\"}"},` +
+		`{"type":"function_call_output","call_id":"call_1","output":"已发送到 13812345678"},` +
+		`{"type":"reasoning","id":"rs_1","encrypted_content":"13812345678"}]}`
+
+	newBody, modified, denied := ProcessProtocolRequest(nil, pluginCtx, []byte(body))
+	if denied || !modified {
+		t.Fatalf("modified=%v, denied=%v", modified, denied)
+	}
+	expected := map[string]string{
+		"instructions":                "客服电话 ****",
+		"input.0.content":             "我的电话 ****",
+		"input.1.content.0.text":      "备用 ****",
+		"input.1.content.1.image_url": "https://example.com/13700002222.png",
+		"input.3.output":              "已发送到 ****",
+		"input.4.encrypted_content":   "13812345678",
+	}
+	for path, want := range expected {
+		if got := gjson.GetBytes(newBody, path).String(); got != want {
+			t.Errorf("%s: 期望 %q, 实际 %q", path, want, got)
+		}
+	}
+	if arguments := gjson.GetBytes(newBody, "input.2.arguments").String(); !gjson.Valid(arguments) || gjson.Get(arguments, "to").String() != "****" {
+		t.Errorf("函数调用参数脱敏不正确: %s", arguments)
+	}
+	if !pluginCtx.OpenAIRequest.Stream || pluginCtx.OpenAIRequest.Model != "gpt-4.1" {
+		t.Errorf("请求信息不正确: %+v", pluginCtx.OpenAIRequest)
+	}
+
+	t.Run("拦截 input_image", func(t *testing.T) {
+		pluginCtx := &config.PluginContext{
+			Config:   &config.AiDataMaskingConfig{DenyImage: config.DenyImageDataURI},
+			MaskMap:  make(map[string]*string),
+			Protocol: config.APIProtocolResponses,
+		}
+		body := `{"input":[{"role":"user","content":[{"type":"input_image","image_url":"data:image/png;base64,iVBORw0KGgo="}]}]}`
+		if _, _, denied := ProcessProtocolRequest(nil, pluginCtx, []byte(body)); !denied || pluginCtx.DenyCategory != config.DenyCategoryImage {
+			t.Errorf("denied=%v, category=%q", denied, pluginCtx.DenyCategory)
+		}
+	})
+}
+
+// TestProcessResponsesResponse_Restore 测试非流式响应中消息与函数调用参数的还原
+func TestProcessResponsesResponse_Restore(t *testing.T) {
+	pluginCtx := createTestPluginContext(withMaskMap(map[string]string{"<PHONE_1>": "13812345678"}))
+	pluginCtx.Protocol = config.APIProtocolResponses
+	body := `{"id":"resp_1","object":"response","status":"completed","output":[` +
+		`{"type":"message","id":"msg_1","role":"assistant","content":[{"type":"output_text","text":"已记录 <PHONE_1>","annotations":[]}]},` +
+		`{"type":"function_call","id":"fc_1","call_id":"call_1","name":"send_sms","arguments":"{\"to\":\"<PHONE_1>\"}"}]}`
+
+	newBody, modified, denied := ProcessProtocolResponse(nil, pluginCtx, body, []byte(body))
+	if denied || !modified {
+		t.Fatalf("modified=%v, denied=%v", modified, denied)
+	}
+	if got := gjson.GetBytes(newBody, "output.0.content.0.text").String(); got != "已记录 13812345678" {
+		t.Errorf("消息还原不正确: %q", got)
+	}
+	if got := gjson.Get(gjson.GetBytes(newBody, "output.1.arguments").String(), "to").String(); got != "13812345678" {
+		t.Errorf("函数调用参数还原不正确: %q", got)
+	}
+}
+
+// TestResponsesDenyResponse 测试 Responses 协议的拒绝响应
+func TestResponsesDenyResponse(t *testing.T) {
+	t.Run("非流式", func(t *testing.T) {
+		response := gjson.ParseBytes(ResponsesDenyResponse("gpt-4.1", "已屏蔽", false))
+		if response.Get("object").String() != "response" || response.Get("status").String() != "completed" ||
+			response.Get("model").String() != "gpt-4.1" || response.Get("output.0.type").String() != "message" ||
+			response.Get("output.0.content.0.text").String() != "已屏蔽" {
+			t.Errorf("拒绝响应格式不正确: %s", response.Raw)
+		}
+	})
+
+	t.Run("流式", func(t *testing.T) {
+		output := string(ResponsesDenyResponse("gpt-4.1", "已屏蔽", true))
+		var types []string
+		for i, event := range collectResponsesEvents(output) {
+			types = append(types, event.Get("type").String())
+			if event.Get("sequence_number").Int() != int64(i) {
+				t.Errorf("sequence_number 不连续: %s", event.Raw)
+			}
+		}
+		expected := "response.created,response.output_item.added,response.content_part.added,response.output_text.delta," +
+			"response.output_text.done,response.content_part.done,response.output_item.done,response.completed"
+		if got := strings.Join(types, ","); got != expected {
+			t.Errorf("事件序列不正确: %s", got)
+		}
+		if !strings.HasPrefix(output, "event: response.created\n") {
+			t.Errorf("event 行不正确: %s", output)
+		}
+	})
+}
+
+// TestResponsesStreamDenyEvents 测试流式中途拒绝时接续已发送的事件
+func TestResponsesStreamDenyEvents(t *testing.T) {
+	pluginCtx := &config.PluginContext{
+		Protocol:      config.APIProtocolResponses,
+		OpenAIRequest: &config.OpenAIRequest{Model: "gpt-4.1"},
+	}
+	for _, data := range []string{
+		`{"type":"response.created","sequence_number":0,"response":{"id":"resp_origin","object":"response","status":"in_progress","output":[]}}`,
+		`{"type":"response.output_item.added","sequence_number":1,"output_index":0,"item":{"type":"reasoning","id":"rs_1","summary":[]}}`,
+	} {
+		pluginCtx.StreamChunkBuffer = append(pluginCtx.StreamChunkBuffer, config.StreamChunk{Data: []byte(typedSSEEvent(data))})
+	}
+	trackStreamEvents(pluginCtx)
+	if state := pluginCtx.ResponsesStream; !state.Created || state.ResponseID != "resp_origin" || state.NextOutput != 1 || state.NextSequence != 2 {
+		t.Fatalf("流式状态不正确: %+v", state)
+	}
+
+	events := collectResponsesEvents(responsesStreamDenyEvents(pluginCtx, "已屏蔽"))
+	if first := events[0]; first.Get("type").String() != "response.output_item.added" || first.Get("output_index").Int() != 1 || first.Get("sequence_number").Int() != 2 {
+		t.Errorf("拒绝消息应使用新的输出项: %s", first.Raw)
+	}
+	if last := events[len(events)-1]; last.Get("type").String() != "response.completed" || last.Get("response.id").String() != "resp_origin" {
+		t.Errorf("应以原始 response 的 response.completed 结束: %s", last.Raw)
+	}
+}
+
+// TestRestoreStreamResponse_Responses 测试 Responses 流式响应的还原，占位符前缀在 output_text.done 之前补发
+func TestRestoreStreamResponse_Responses(t *testing.T) {
+	pluginCtx := createTestPluginContext(withMaskMap(map[string]string{"<PHONE_1>": "13812345678"}))
+	pluginCtx.Protocol = config.APIProtocolResponses
+	position := `"item_id":"msg_1","output_index":0,"content_index":0`
+	chunks := []string{
+		typedSSEEvent(`{"type":"response.output_text.delta",` + position + `,"delta":"请拨打 <PHO"}`),
+		typedSSEEvent(`{"type":"response.output_text.delta",` + position + `,"delta":"NE_1> 或 <PH"}`),
+		typedSSEEvent(`{"type":"response.output_text.done",`+position+`,"text":"请拨打 <PHONE_1> 或 <PH"}`) +
+			typedSSEEvent(`{"type":"response.completed","response":{"object":"response","status":"completed","output":[{"type":"message","id":"msg_1","content":[{"type":"output_text","text":"请拨打 <PHONE_1> 或 <PH"}]}]}}`),
+	}
+	var output strings.Builder
+	for i, chunk := range chunks {
+		output.WriteString(string(RestoreStreamResponse(pluginCtx, []byte(chunk), i == len(chunks)-1)))
+	}
+
+	var deltas strings.Builder
+	var doneIndex, lastDeltaIndex int
+	for i, event := range collectResponsesEvents(output.String()) {
+		switch event.Get("type").String() {
+		case "response.output_text.delta":
+			deltas.WriteString(event.Get("delta").String())
+			lastDeltaIndex = i
+		case "response.output_text.done":
+			doneIndex = i
+			if got := event.Get("text").String(); got != "请拨打 13812345678 或 <PH" {
+				t.Errorf("done 事件还原不正确: %q", got)
+			}
+		case "response.completed":
+			if got := event.Get("response.output.0.content.0.text").String(); got != "请拨打 13812345678 或 <PH" {
+				t.Errorf("response.completed 还原不正确: %q", got)
+			}
+		}
+	}
+	if deltas.String() != "请拨打 13812345678 或 <PH" {
+		t.Errorf("增量还原不正确: %q", deltas.String())
+	}
+	if lastDeltaIndex > doneIndex {
+		t.Errorf("补发的增量必须位于 done 事件之前: %s", output.String())
+	}
+}
+
+// TestWriteStreamChunk_Responses 测试 Responses 完整文本事件中的敏感词与增量一样替换
+func TestWriteStreamChunk_Responses(t *testing.T) {
+	cfg := createTestConfig(withDenyWordEntries(testDenyWordEntries...))
+	pluginCtx := &config.PluginContext{Config: cfg, Protocol: config.APIProtocolResponses}
+	event := typedSSEEvent(`{"type":"response.output_text.done","item_id":"msg_1","output_index":0,"content_index":0,"text":"项目内部代号"}`)
+
+	var result strings.Builder
+	writeStreamChunk(&result, pluginCtx, []byte(event))
+	if got := gjson.Get(sseEventData(result.String()), "text").String(); got != "项目****" {
+		t.Errorf("替换结果不正确: %q", got)
+	}
+	if !strings.HasPrefix(result.String(), "event: response.output_text.done\n") {
+		t.Errorf("event 行丢失: %s", result.String())
+	}
+
+	pluginCtx.Protocol = ""
+	result.Reset()
+	writeStreamChunk(&result, pluginCtx, []byte(event))
+	if result.String() != event {
+		t.Errorf("其他协议应原样输出: %s", result.String())
+	}
+}
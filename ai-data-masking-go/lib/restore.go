@@ -0,0 +1,595 @@
+package lib
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"ai-data-masking/config"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// restoreDeltaFields 流式响应 delta 中需要还原的文本字段
+var restoreDeltaFields = []string{"content", "reasoning", "reasoning_content"}
+
+// restoreKeys 返回需要还原的占位符，按长度从长到短排序
+func restoreKeys(pluginCtx *config.PluginContext) []string {
+	keys := make([]string, 0, len(pluginCtx.MaskMap))
+	for key, original := range pluginCtx.MaskMap {
+		if key != "" && original != nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) > len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// restoreReplacer 构造占位符到原始值的替换器，keys 的顺序即匹配优先级
+func restoreReplacer(keys []string, pluginCtx *config.PluginContext) *strings.Replacer {
+	pairs := make([]string, 0, len(keys)*2)
+	for _, key := range keys {
+		pairs = append(pairs, key, *pluginCtx.MaskMap[key])
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// restoreJSONReplacer 构造还原到 JSON 字符串内部的替换器，原始值按 JSON 转义，用于 tool_calls 参数增量
+func restoreJSONReplacer(keys []string, pluginCtx *config.PluginContext) *strings.Replacer {
+	pairs := make([]string, 0, len(keys)*2)
+	for _, key := range keys {
+		quoted := jsonQuote(*pluginCtx.MaskMap[key])
+		pairs = append(pairs, key, quoted[1:len(quoted)-1])
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// splitRestorePending 将文本拆分为可以输出的部分和暂存的尾部
+// 尾部是某个占位符的前缀时，可能与后续增量拼接成完整的占位符，需要等待更多数据
+func splitRestorePending(text string, keys []string) (string, string) {
+	if len(keys) == 0 {
+		return text, ""
+	}
+	// keys 按长度从长到短排序，第一个即最长的占位符
+	start := len(text) - len(keys[0]) + 1
+	if start < 0 {
+		start = 0
+	}
+	for ; start < len(text); start++ {
+		suffix := text[start:]
+		for _, key := range keys {
+			if len(suffix) < len(key) && strings.HasPrefix(key, suffix) {
+				return text[:start], suffix
+			}
+		}
+	}
+	return text, ""
+}
+
+// RestoreStreamResponse 还原流式响应增量中请求阶段脱敏的数据
+// 占位符可能被拆分到多个 chunk：文本末尾是占位符前缀的部分暂不输出，与后续增量拼接后再还原，
+// 在 choice 结束（finish_reason 不为空）、Anthropic 内容块结束（content_block_stop）、Responses API 的 *.done 事件、
+// Gemini 候选结束（finishReason 不为空）、NDJSON 最后一行（done 为 true）、流结束标记或最后一个 chunk 时补发
+func RestoreStreamResponse(pluginCtx *config.PluginContext, chunk []byte, isLastChunk bool) []byte {
+	if len(pluginCtx.MaskMap) == 0 {
+		return chunk
+	}
+	// 已拒绝的流只包含拒绝消息，丢弃暂存的内容
+	if pluginCtx.StreamDenied {
+		pluginCtx.StreamRestorePending = nil
+		return chunk
+	}
+	if pluginCtx.StreamRestorePending == nil {
+		pluginCtx.StreamRestorePending = make(map[string]string)
+	}
+	if pluginCtx.StreamRestoreTemplates == nil {
+		pluginCtx.StreamRestoreTemplates = make(map[string]string)
+	}
+
+	keys := restoreKeys(pluginCtx)
+	replacer := restoreReplacer(keys, pluginCtx)
+
+	var result strings.Builder
+	for _, eventStr := range strings.Split(strings.TrimSpace(string(chunk)), "\n\n") {
+		if eventStr == "" {
+			continue
+		}
+		// 流结束标记之前补发所有暂存的内容
+		if isStreamEndEvent(eventStr) {
+			writeRestorePending(&result, pluginCtx, replacer)
+			if pluginCtx.Protocol == config.APIProtocolResponses {
+				// response.completed 携带完整的输出，按完整文本还原
+				if newJsonStr, ok := restoreResponsesFields(pluginCtx, sseEventData(eventStr)); ok {
+					eventStr = strings.TrimSpace(replaceSSEData(eventStr, newJsonStr))
+				}
+			}
+			result.WriteString(eventStr + "\n\n")
+			continue
+		}
+
+		lines := strings.Split(eventStr, "\n")
+		for i, line := range lines {
+			line = strings.TrimSpace(line)
+			if !strings.HasPrefix(line, "data:") {
+				continue
+			}
+			jsonStr := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+			var newJsonStr string
+			var ok bool
+			switch {
+			case isRawResponseMode(pluginCtx):
+				newJsonStr, ok = restoreRawResponseEvent(pluginCtx, jsonStr, keys, replacer)
+			case pluginCtx.RespIsNDJSON:
+				newJsonStr, ok = restoreNDJSONEvent(pluginCtx, jsonStr, keys, replacer)
+			case pluginCtx.Protocol == config.APIProtocolAnthropic:
+				newJsonStr, ok = restoreAnthropicEvent(&result, pluginCtx, jsonStr, keys, replacer)
+			case pluginCtx.Protocol == config.APIProtocolResponses:
+				newJsonStr, ok = restoreResponsesEvent(&result, pluginCtx, jsonStr, keys, replacer)
+			case pluginCtx.Protocol == config.APIProtocolGemini:
+				newJsonStr, ok = restoreGeminiEvent(&result, pluginCtx, jsonStr, keys, replacer)
+			default:
+				newJsonStr, ok = restoreStreamEvent(pluginCtx, jsonStr, keys, replacer)
+			}
+			if ok {
+				lines[i] = "data: " + newJsonStr
+			}
+		}
+		result.WriteString(strings.Join(lines, "\n") + "\n\n")
+	}
+
+	if isLastChunk {
+		writeRestorePending(&result, pluginCtx, replacer)
+	}
+
+	if result.Len() == 0 {
+		return nil
+	}
+	return []byte(result.String())
+}
+
+// restoreStreamEvent 还原单个 SSE 事件中各 choice 的 delta 文本，返回新的 JSON 以及是否为 OpenAI 流式事件
+func restoreStreamEvent(pluginCtx *config.PluginContext, jsonStr string, keys []string, replacer *strings.Replacer) (string, bool) {
+	choices := gjson.Get(jsonStr, "choices")
+	if !choices.IsArray() {
+		return jsonStr, false
+	}
+
+	newJsonStr := jsonStr
+	choices.ForEach(func(key, choice gjson.Result) bool {
+		index := key.Int()
+		if choice.Get("index").Exists() {
+			index = choice.Get("index").Int()
+		}
+		finished := choice.Get("finish_reason").String() != ""
+
+		deltaPrefix, fields := "delta.", restoreDeltaFields
+		if pluginCtx.Protocol == config.APIProtocolCompletions {
+			// 旧版 Completions 的增量位于 choices[].text
+			deltaPrefix, fields = "", []string{"text"}
+		}
+		for _, field := range fields {
+			delta := choice.Get(deltaPrefix + field)
+			pendingKey := fmt.Sprintf("%d.%s", index, field)
+			pending := pluginCtx.StreamRestorePending[pendingKey]
+			if !delta.Exists() && pending == "" {
+				continue
+			}
+
+			// choice 结束时不再等待，输出全部内容
+			text, rest := pending+delta.String(), ""
+			if !finished {
+				text, rest = splitRestorePending(text, keys)
+			}
+			if rest == "" {
+				delete(pluginCtx.StreamRestorePending, pendingKey)
+			} else {
+				pluginCtx.StreamRestorePending[pendingKey] = rest
+			}
+
+			text = replacer.Replace(text)
+			if text == delta.String() || (!delta.Exists() && text == "") {
+				continue
+			}
+			path := fmt.Sprintf("choices.%d.%s%s", key.Int(), deltaPrefix, field)
+			if updated, err := sjson.Set(newJsonStr, path, text); err == nil {
+				newJsonStr = updated
+			}
+		}
+
+		newJsonStr = restoreToolCallDeltas(pluginCtx, newJsonStr, key.Int(), index, choice, finished, keys)
+		return true
+	})
+
+	pluginCtx.StreamRestoreLastEvent = newJsonStr
+	return newJsonStr, true
+}
+
+// restoreToolCallDeltas 还原 choice 中 tool_calls 的参数增量，暂存的尾部按 tool_call 下标区分
+// choice 结束时，本事件中没有出现的 tool_call 以新的参数片段补发暂存的内容
+func restoreToolCallDeltas(pluginCtx *config.PluginContext, jsonStr string, choiceKey, index int64, choice gjson.Result, finished bool, keys []string) string {
+	prefix := fmt.Sprintf("%d.tool_calls.", index)
+	toolCalls := choice.Get("delta.tool_calls")
+	if !toolCalls.Exists() && !(finished && hasPendingPrefix(pluginCtx, prefix)) {
+		return jsonStr
+	}
+	replacer := restoreJSONReplacer(keys, pluginCtx)
+
+	toolCalls.ForEach(func(callKey, call gjson.Result) bool {
+		arguments := call.Get("function.arguments")
+		if !arguments.Exists() {
+			return true
+		}
+		toolIndex := callKey.Int()
+		if call.Get("index").Exists() {
+			toolIndex = call.Get("index").Int()
+		}
+		pendingKey := fmt.Sprintf("%s%d", prefix, toolIndex)
+		text, rest := pluginCtx.StreamRestorePending[pendingKey]+arguments.String(), ""
+		if !finished {
+			text, rest = splitRestorePending(text, keys)
+		}
+		if rest == "" {
+			delete(pluginCtx.StreamRestorePending, pendingKey)
+		} else {
+			pluginCtx.StreamRestorePending[pendingKey] = rest
+		}
+
+		text = replacer.Replace(text)
+		if text == arguments.String() {
+			return true
+		}
+		path := fmt.Sprintf("choices.%d.delta.tool_calls.%d.function.arguments", choiceKey, callKey.Int())
+		if updated, err := sjson.Set(jsonStr, path, text); err == nil {
+			jsonStr = updated
+		}
+		return true
+	})
+
+	if finished {
+		for _, pendingKey := range sortedPendingKeys(pluginCtx) {
+			toolIndex, ok := strings.CutPrefix(pendingKey, prefix)
+			if !ok {
+				continue
+			}
+			part, err := sjson.Set(`{"index":`+toolIndex+`,"function":{}}`, "function.arguments", replacer.Replace(pluginCtx.StreamRestorePending[pendingKey]))
+			if err != nil {
+				continue
+			}
+			if updated, err := sjson.SetRaw(jsonStr, fmt.Sprintf("choices.%d.delta.tool_calls.-1", choiceKey), part); err == nil {
+				jsonStr = updated
+			}
+			delete(pluginCtx.StreamRestorePending, pendingKey)
+		}
+	}
+	return jsonStr
+}
+
+// hasPendingPrefix 是否有以 prefix 开头的暂存内容
+func hasPendingPrefix(pluginCtx *config.PluginContext, prefix string) bool {
+	for pendingKey := range pluginCtx.StreamRestorePending {
+		if strings.HasPrefix(pendingKey, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedPendingKeys 返回排序后的暂存键
+func sortedPendingKeys(pluginCtx *config.PluginContext) []string {
+	pendingKeys := make([]string, 0, len(pluginCtx.StreamRestorePending))
+	for pendingKey := range pluginCtx.StreamRestorePending {
+		pendingKeys = append(pendingKeys, pendingKey)
+	}
+	sort.Strings(pendingKeys)
+	return pendingKeys
+}
+
+// restoreAnthropicEvent 还原 Anthropic content_block_delta 事件中的文本增量，返回新的 JSON 以及是否修改
+// thinking 增量带签名，不做还原；内容块结束前补发该块暂存的内容
+func restoreAnthropicEvent(result *strings.Builder, pluginCtx *config.PluginContext, jsonStr string, keys []string, replacer *strings.Replacer) (string, bool) {
+	root := gjson.Parse(jsonStr)
+	pendingKey := fmt.Sprintf("%d.text", root.Get("index").Int())
+
+	switch root.Get("type").String() {
+	case "content_block_delta":
+		delta := root.Get("delta.text")
+		if !delta.Exists() {
+			return jsonStr, false
+		}
+		text, rest := splitRestorePending(pluginCtx.StreamRestorePending[pendingKey]+delta.String(), keys)
+		if rest == "" {
+			delete(pluginCtx.StreamRestorePending, pendingKey)
+		} else {
+			pluginCtx.StreamRestorePending[pendingKey] = rest
+		}
+		text = replacer.Replace(text)
+		if text == delta.String() {
+			return jsonStr, false
+		}
+		newJsonStr, err := sjson.Set(jsonStr, "delta.text", text)
+		return newJsonStr, err == nil
+	case "content_block_stop":
+		if pending, ok := pluginCtx.StreamRestorePending[pendingKey]; ok {
+			result.WriteString(anthropicTextDeltaEvent(root.Get("index").Int(), replacer.Replace(pending)))
+			delete(pluginCtx.StreamRestorePending, pendingKey)
+		}
+	}
+	return jsonStr, false
+}
+
+// restoreResponsesEvent 还原 Responses API 流式事件，返回新的 JSON 以及是否修改
+// 增量事件按输出项、内容片段分别暂存占位符前缀，对应的 *.done 事件之前补发；携带完整文本的事件直接还原
+func restoreResponsesEvent(result *strings.Builder, pluginCtx *config.PluginContext, jsonStr string, keys []string, replacer *strings.Replacer) (string, bool) {
+	root := gjson.Parse(jsonStr)
+	eventType := root.Get("type").String()
+	kind, isDelta := strings.CutSuffix(eventType, ".delta")
+	if !isDelta {
+		kind = strings.TrimSuffix(eventType, ".done")
+	}
+	pendingKey := fmt.Sprintf("%s|%s|%d", kind, root.Get("item_id").String(), root.Get("content_index").Int()+root.Get("summary_index").Int())
+	if kind == "response.function_call_arguments" {
+		// 函数调用参数是 JSON 文本，原始值需要按 JSON 转义
+		replacer = restoreJSONReplacer(keys, pluginCtx)
+	}
+
+	if isDelta {
+		delta := root.Get("delta")
+		if delta.Type != gjson.String {
+			return jsonStr, false
+		}
+		text, rest := splitRestorePending(pluginCtx.StreamRestorePending[pendingKey]+delta.String(), keys)
+		if rest == "" {
+			delete(pluginCtx.StreamRestorePending, pendingKey)
+			delete(pluginCtx.StreamRestoreTemplates, pendingKey)
+		} else {
+			pluginCtx.StreamRestorePending[pendingKey] = rest
+			pluginCtx.StreamRestoreTemplates[pendingKey] = jsonStr
+		}
+		text = replacer.Replace(text)
+		if text == delta.String() {
+			return jsonStr, false
+		}
+		newJsonStr, err := sjson.Set(jsonStr, "delta", text)
+		return newJsonStr, err == nil
+	}
+
+	// 完整文本事件之前补发该字段暂存的内容
+	if pending, ok := pluginCtx.StreamRestorePending[pendingKey]; ok {
+		if event, err := sjson.Set(pluginCtx.StreamRestoreTemplates[pendingKey], "delta", replacer.Replace(pending)); err == nil {
+			result.WriteString(typedSSEEvent(event))
+		}
+		delete(pluginCtx.StreamRestorePending, pendingKey)
+		delete(pluginCtx.StreamRestoreTemplates, pendingKey)
+	}
+	return restoreResponsesFields(pluginCtx, jsonStr)
+}
+
+// restoreGeminiEvent 还原 Gemini 流式事件各候选中的文本与 thought 文本，返回新的 JSON 以及是否为 Gemini 流式事件
+// functionCall 在单个事件中是完整的，参数直接还原；候选结束时不再暂存，本事件中没有消费的暂存内容在事件之前补发
+func restoreGeminiEvent(result *strings.Builder, pluginCtx *config.PluginContext, jsonStr string, keys []string, replacer *strings.Replacer) (string, bool) {
+	candidates := gjson.Get(jsonStr, "candidates")
+	if !candidates.IsArray() {
+		return jsonStr, false
+	}
+
+	newJsonStr := jsonStr
+	candidates.ForEach(func(key, candidate gjson.Result) bool {
+		index := key.Int()
+		if candidate.Get("index").Exists() {
+			index = candidate.Get("index").Int()
+		}
+		finished := candidate.Get("finishReason").String() != ""
+
+		candidate.Get("content.parts").ForEach(func(partKey, part gjson.Result) bool {
+			path := fmt.Sprintf("candidates.%d.content.parts.%d", key.Int(), partKey.Int())
+			if call, name := geminiField(part, "functionCall", "function_call"); call.Get("args").IsObject() {
+				args := call.Get("args").Raw
+				if newArgs := mapJSONStrings(args, replacer.Replace); newArgs != args {
+					if updated, err := sjson.SetRaw(newJsonStr, path+"."+name+".args", newArgs); err == nil {
+						newJsonStr = updated
+					}
+				}
+				return true
+			}
+			delta := part.Get("text")
+			if delta.Type != gjson.String {
+				return true
+			}
+
+			pendingKey := fmt.Sprintf("%d.%s", index, geminiPartKind(part))
+			text, rest := pluginCtx.StreamRestorePending[pendingKey]+delta.String(), ""
+			if !finished {
+				text, rest = splitRestorePending(text, keys)
+			}
+			if rest == "" {
+				delete(pluginCtx.StreamRestorePending, pendingKey)
+			} else {
+				pluginCtx.StreamRestorePending[pendingKey] = rest
+			}
+			text = replacer.Replace(text)
+			if text == delta.String() {
+				return true
+			}
+			if updated, err := sjson.Set(newJsonStr, path+".text", text); err == nil {
+				newJsonStr = updated
+			}
+			return true
+		})
+
+		if finished {
+			for _, kind := range []string{"thought", "text"} {
+				pendingKey := fmt.Sprintf("%d.%s", index, kind)
+				if pending, ok := pluginCtx.StreamRestorePending[pendingKey]; ok {
+					result.WriteString(geminiTextEvent(index, kind, replacer.Replace(pending)))
+					delete(pluginCtx.StreamRestorePending, pendingKey)
+				}
+			}
+		}
+		return true
+	})
+	return newJsonStr, true
+}
+
+// restoreResponsesFields 还原 Responses API 事件中携带完整文本的字段
+func restoreResponsesFields(pluginCtx *config.PluginContext, jsonStr string) (string, bool) {
+	return rewriteResponsesEvent(jsonStr, func(text string) string {
+		return RestoreMessage(text, pluginCtx)
+	})
+}
+
+// anthropicTextDeltaEvent 构造 Anthropic 文本增量事件
+func anthropicTextDeltaEvent(index int64, text string) string {
+	data, _ := sjson.Set(fmt.Sprintf(`{"type":"content_block_delta","index":%d,"delta":{"type":"text_delta"}}`, index), "delta.text", text)
+	return typedSSEEvent(data)
+}
+
+// writeRestorePending 以最近一个事件为模板，为每个暂存的字段补发一个 SSE 事件
+func writeRestorePending(result *strings.Builder, pluginCtx *config.PluginContext, replacer *strings.Replacer) {
+	if len(pluginCtx.StreamRestorePending) == 0 {
+		return
+	}
+
+	if isRawResponseMode(pluginCtx) {
+		// deny_raw 直接补发文本，response_jsonpath 以最近一个包含增量字段的事件为模板补发
+		event := replacer.Replace(pluginCtx.StreamRestorePending[responsePendingKey])
+		if ResponseModifyType(pluginCtx) == config.DenyModifyTypeJSONPath {
+			var err error
+			path, _ := streamDeltaPaths(pluginCtx, gjson.Parse(pluginCtx.StreamRestoreLastEvent))
+			if event, err = sjson.Set(pluginCtx.StreamRestoreLastEvent, path, event); err != nil {
+				event = ""
+			}
+		}
+		if event != "" {
+			result.WriteString("data: " + event + "\n\n")
+		}
+		pluginCtx.StreamRestorePending = make(map[string]string)
+		return
+	}
+
+	if pluginCtx.RespIsNDJSON {
+		// 以最近一行为模板补发
+		if event, err := sjson.Set(pluginCtx.StreamRestoreLastEvent, pluginCtx.Config.StreamNDJSONPath, replacer.Replace(pluginCtx.StreamRestorePending[ndjsonPendingKey])); err == nil {
+			result.WriteString("data: " + event + "\n\n")
+		}
+		pluginCtx.StreamRestorePending = make(map[string]string)
+		return
+	}
+
+	pendingKeys := sortedPendingKeys(pluginCtx)
+
+	if pluginCtx.Protocol == config.APIProtocolResponses {
+		// 以暂存时记录的增量事件为模板补发
+		for _, pendingKey := range pendingKeys {
+			fieldReplacer := replacer
+			if strings.HasPrefix(pendingKey, "response.function_call_arguments|") {
+				fieldReplacer = restoreJSONReplacer(restoreKeys(pluginCtx), pluginCtx)
+			}
+			if event, err := sjson.Set(pluginCtx.StreamRestoreTemplates[pendingKey], "delta", fieldReplacer.Replace(pluginCtx.StreamRestorePending[pendingKey])); err == nil {
+				result.WriteString(typedSSEEvent(event))
+			}
+		}
+		pluginCtx.StreamRestorePending = make(map[string]string)
+		pluginCtx.StreamRestoreTemplates = make(map[string]string)
+		return
+	}
+
+	if pluginCtx.Protocol == config.APIProtocolGemini {
+		for _, pendingKey := range pendingKeys {
+			index, kind, _ := strings.Cut(pendingKey, ".")
+			candidateIndex, _ := strconv.ParseInt(index, 10, 64)
+			result.WriteString(geminiTextEvent(candidateIndex, kind, replacer.Replace(pluginCtx.StreamRestorePending[pendingKey])))
+		}
+		pluginCtx.StreamRestorePending = make(map[string]string)
+		return
+	}
+
+	if pluginCtx.Protocol == config.APIProtocolAnthropic {
+		for _, pendingKey := range pendingKeys {
+			index, _, _ := strings.Cut(pendingKey, ".")
+			blockIndex, _ := strconv.ParseInt(index, 10, 64)
+			result.WriteString(anthropicTextDeltaEvent(blockIndex, replacer.Replace(pluginCtx.StreamRestorePending[pendingKey])))
+		}
+		pluginCtx.StreamRestorePending = make(map[string]string)
+		return
+	}
+
+	template := pluginCtx.StreamRestoreLastEvent
+	if template == "" {
+		template = `{"object":"chat.completion.chunk"}`
+	}
+	template, _ = sjson.Delete(template, "usage")
+
+	var jsonReplacer *strings.Replacer
+	for _, pendingKey := range pendingKeys {
+		index, field, _ := strings.Cut(pendingKey, ".")
+		if pluginCtx.Protocol == config.APIProtocolCompletions {
+			// 旧版 Completions 以新的 text 增量补发
+			if event, err := sjson.SetRaw(template, "choices", `[{"index":`+index+`,"logprobs":null,"finish_reason":null}]`); err == nil {
+				if event, err = sjson.Set(event, "choices.0.text", replacer.Replace(pluginCtx.StreamRestorePending[pendingKey])); err == nil {
+					result.WriteString("data: " + event + "\n\n")
+				}
+			}
+			continue
+		}
+		event, err := sjson.SetRaw(template, "choices", `[{"index":`+index+`,"delta":{},"finish_reason":null}]`)
+		if err != nil {
+			continue
+		}
+		pending := pluginCtx.StreamRestorePending[pendingKey]
+		if toolIndex, ok := strings.CutPrefix(field, "tool_calls."); ok {
+			// tool_calls 参数片段，原始值需要按 JSON 转义
+			if jsonReplacer == nil {
+				jsonReplacer = restoreJSONReplacer(restoreKeys(pluginCtx), pluginCtx)
+			}
+			event, err = sjson.SetRaw(event, "choices.0.delta.tool_calls", `[{"index":`+toolIndex+`,"function":{}}]`)
+			if err == nil {
+				event, err = sjson.Set(event, "choices.0.delta.tool_calls.0.function.arguments", jsonReplacer.Replace(pending))
+			}
+		} else {
+			event, err = sjson.Set(event, "choices.0.delta."+field, replacer.Replace(pending))
+		}
+		if err != nil {
+			continue
+		}
+		result.WriteString("data: " + event + "\n\n")
+	}
+	pluginCtx.StreamRestorePending = make(map[string]string)
+}
+
+// restoreRawResponseEvent 还原 response_jsonpath、deny_raw 流式事件中的增量文本，返回新的 data 以及是否修改
+// 每个事件只有一个增量文本，末尾可能是占位符前缀的部分暂存，与下一个事件的增量拼接后再还原
+func restoreRawResponseEvent(pluginCtx *config.PluginContext, data string, keys []string, replacer *strings.Replacer) (string, bool) {
+	root := streamEventRoot(pluginCtx, data)
+	path, _ := streamDeltaPaths(pluginCtx, root)
+	delta := root
+	if ResponseModifyType(pluginCtx) == config.DenyModifyTypeJSONPath {
+		if path == "" {
+			return data, false
+		}
+		delta = root.Get(path)
+		pluginCtx.StreamRestoreLastEvent = data
+	}
+
+	text, rest := splitRestorePending(pluginCtx.StreamRestorePending[responsePendingKey]+delta.String(), keys)
+	if rest == "" {
+		delete(pluginCtx.StreamRestorePending, responsePendingKey)
+	} else {
+		pluginCtx.StreamRestorePending[responsePendingKey] = rest
+	}
+	text = replacer.Replace(text)
+	if text == delta.String() {
+		return data, false
+	}
+	if ResponseModifyType(pluginCtx) == config.DenyModifyTypeRaw {
+		return text, true
+	}
+	newData, err := sjson.Set(data, path, text)
+	return newData, err == nil
+}
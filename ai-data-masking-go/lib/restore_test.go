@@ -0,0 +1,115 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+// collectStreamContent 拼接 SSE 输出中各事件的 delta 字段
+func collectStreamContent(output, field string) string {
+	var builder strings.Builder
+	for _, event := range strings.Split(output, "\n\n") {
+		jsonStr := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(event), "data:"))
+		if jsonStr == "" || jsonStr == "[DONE]" {
+			continue
+		}
+		builder.WriteString(gjson.Get(jsonStr, "choices.0.delta."+field).String())
+	}
+	return builder.String()
+}
+
+// TestRestoreMessage_LongestFirst 测试占位符按长度优先匹配
+func TestRestoreMessage_LongestFirst(t *testing.T) {
+	pluginCtx := createTestPluginContext(withMaskMap(map[string]string{"***": "短", "****": "长"}))
+	if got := RestoreMessage("a****b***c", pluginCtx); got != "a长b短c" {
+		t.Errorf("还原结果不正确: %q", got)
+	}
+}
+
+// TestSplitRestorePending 测试占位符前缀的暂存
+func TestSplitRestorePending(t *testing.T) {
+	keys := []string{"<PHONE_1>", "****", "***"}
+	tests := []struct {
+		text    string
+		emit    string
+		pending string
+	}{
+		{text: "电话 <PHO", emit: "电话 ", pending: "<PHO"},
+		{text: "电话 <PHONE_1>", emit: "电话 <PHONE_1>", pending: ""},
+		{text: "卡号 ***", emit: "卡号 ", pending: "***"}, // 可能是 **** 的前缀
+		{text: "a < b", emit: "a < b", pending: ""},
+		{text: "结尾<", emit: "结尾", pending: "<"},
+	}
+	for _, tt := range tests {
+		emit, pending := splitRestorePending(tt.text, keys)
+		if emit != tt.emit || pending != tt.pending {
+			t.Errorf("%q: 期望 (%q, %q), 实际 (%q, %q)", tt.text, tt.emit, tt.pending, emit, pending)
+		}
+	}
+}
+
+// TestRestoreStreamResponse 测试占位符被拆分到多个 chunk 时的还原
+func TestRestoreStreamResponse(t *testing.T) {
+	t.Run("finish_reason 时补发", func(t *testing.T) {
+		pluginCtx := createTestPluginContext(withMaskMap(map[string]string{"<PHONE_1>": "13812345678"}))
+		chunks := []string{
+			`data: {"id":"1","choices":[{"index":0,"delta":{"content":"请拨打 <PH"},"finish_reason":null}]}` + "\n\n",
+			`data: {"id":"1","choices":[{"index":0,"delta":{"content":"ONE"},"finish_reason":null}]}` + "\n\n",
+			`data: {"id":"1","choices":[{"index":0,"delta":{"content":"_1> 联系<PH"},"finish_reason":null}]}` + "\n\n",
+			`data: {"id":"1","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}` + "\n\n" + "data: [DONE]\n\n",
+		}
+		var output strings.Builder
+		for i, chunk := range chunks {
+			out := string(RestoreStreamResponse(pluginCtx, []byte(chunk), i == len(chunks)-1))
+			if strings.Contains(out, "<PHONE") || strings.Contains(out, "ONE_1>") {
+				t.Fatalf("chunk %d 输出了未还原的占位符: %s", i, out)
+			}
+			output.WriteString(out)
+		}
+		if got := collectStreamContent(output.String(), "content"); got != "请拨打 13812345678 联系<PH" {
+			t.Errorf("还原结果不正确: %q", got)
+		}
+		if !strings.HasSuffix(output.String(), "data: [DONE]\n\n") {
+			t.Errorf("[DONE] 丢失: %s", output.String())
+		}
+	})
+
+	t.Run("DONE 前补发", func(t *testing.T) {
+		pluginCtx := createTestPluginContext(withMaskMap(map[string]string{"<NAME_1>": "张三"}))
+		first := string(RestoreStreamResponse(pluginCtx, []byte(`data: {"id":"2","model":"m","choices":[{"index":0,"delta":{"reasoning_content":"用户是<NA"}}]}`+"\n\n"), false))
+		second := string(RestoreStreamResponse(pluginCtx, []byte("data: [DONE]\n\n"), true))
+		output := first + second
+		if got := collectStreamContent(output, "reasoning_content"); got != "用户是<NA" {
+			t.Errorf("补发结果不正确: %q", got)
+		}
+		if !strings.Contains(second, `"model":"m"`) || !strings.HasSuffix(second, "data: [DONE]\n\n") {
+			t.Errorf("补发事件格式不正确: %s", second)
+		}
+	})
+
+	t.Run("已拒绝不补发", func(t *testing.T) {
+		pluginCtx := createTestPluginContext(withMaskMap(map[string]string{"<NAME_1>": "张三"}))
+		RestoreStreamResponse(pluginCtx, []byte(`data: {"choices":[{"index":0,"delta":{"content":"<NA"}}]}`+"\n\n"), false)
+		pluginCtx.StreamDenied = true
+		denied := "data: [DONE]\n\n"
+		if got := string(RestoreStreamResponse(pluginCtx, []byte(denied), true)); got != denied {
+			t.Errorf("已拒绝的流不应补发: %s", got)
+		}
+	})
+}
+
+// TestProcessOpenAIResponse_Restore 测试非流式响应的还原
+func TestProcessOpenAIResponse_Restore(t *testing.T) {
+	pluginCtx := createTestPluginContext(withMaskMap(map[string]string{"<PHONE_1>": "13812345678"}))
+	body := `{"choices":[{"index":0,"message":{"role":"assistant","content":"已记录 <PHONE_1>"}}]}`
+
+	newBody, modified, denied := ProcessOpenAIResponse(nil, pluginCtx, body, []byte(body))
+	if denied || !modified {
+		t.Fatalf("modified=%v, denied=%v", modified, denied)
+	}
+	if got := gjson.GetBytes(newBody, "choices.0.message.content").String(); got != "已记录 13812345678" {
+		t.Errorf("还原结果不正确: %q", got)
+	}
+}
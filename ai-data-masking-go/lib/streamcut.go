@@ -0,0 +1,107 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"ai-data-masking/config"
+
+	"github.com/higress-group/wasm-go/pkg/wrapper"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// recordStreamUsage 记录 OpenAI 流式事件中的 usage，流被截断后据此补发
+func recordStreamUsage(pluginCtx *config.PluginContext, root gjson.Result) {
+	if usage := root.Get("usage"); usage.IsObject() {
+		pluginCtx.StreamCut.LastUsage = usage.Raw
+	}
+}
+
+// streamDenyEvent 构造 OpenAI、旧版 Completions 流式响应的拒绝事件：以缓冲区中的事件为模板，保留上游的 id、model、created，
+// 以 finish_reason 为 content_filter 的增量输出拒绝消息；多个 choice 时只结束正在处理的 choice
+func streamDenyEvent(pluginCtx *config.PluginContext, message string) string {
+	template := ""
+	for _, streamChunk := range pluginCtx.StreamChunkBuffer {
+		if data := sseEventData(string(streamChunk.Data)); gjson.Get(data, "choices").IsArray() {
+			template = data
+			break
+		}
+	}
+	if template == "" {
+		template = `{"id":"chatcmpl-deny","object":"chat.completion.chunk"}`
+		if pluginCtx.Protocol == config.APIProtocolCompletions {
+			template = `{"id":"cmpl-deny","object":"text_completion"}`
+		}
+		template, _ = sjson.Set(template, "created", time.Now().Unix())
+		template, _ = sjson.Set(template, "model", pluginCtx.OpenAIRequest.Model)
+	}
+	template, _ = sjson.Delete(template, "usage")
+	pluginCtx.StreamCut.Template = template
+
+	index := int64(0)
+	if pluginCtx.StreamInChoice {
+		index = pluginCtx.StreamChoiceIndex
+	}
+	choice := fmt.Sprintf(`{"index":%d,"delta":{"role":"assistant","content":""},"finish_reason":"content_filter"}`, index)
+	textPath := "choices.0.delta.content"
+	if pluginCtx.Protocol == config.APIProtocolCompletions {
+		choice = fmt.Sprintf(`{"index":%d,"text":"","logprobs":null,"finish_reason":"content_filter"}`, index)
+		textPath = "choices.0.text"
+	}
+	event, err := sjson.SetRaw(template, "choices", "["+choice+"]")
+	if err != nil {
+		return ""
+	}
+	event, _ = sjson.Set(event, textPath, message)
+	return "data: " + event + "\n\n"
+}
+
+// streamDenyEnd 返回截断 OpenAI 流式响应时拒绝事件之后的内容
+// 流已结束时补发缓冲区中最近的 usage 后以 [DONE] 结束；请求指定 stream_options.include_usage 且流未结束时，
+// 暂不结束，继续等待上游的 usage 事件（见 DeniedStreamTail）
+func streamDenyEnd(pluginCtx *config.PluginContext, streamEnded bool) string {
+	if !streamEnded && pluginCtx.OpenAIRequest != nil && pluginCtx.OpenAIRequest.IncludeUsage {
+		pluginCtx.StreamCut.AwaitUsage = true
+		return ""
+	}
+	return streamUsageEvent(pluginCtx) + "data: [DONE]\n\n"
+}
+
+// streamUsageEvent 以拒绝事件为模板构造只包含 usage 的事件（与 stream_options.include_usage 的最后一个事件格式一致），没有记录到 usage 时返回空字符串
+func streamUsageEvent(pluginCtx *config.PluginContext) string {
+	if pluginCtx.StreamCut.LastUsage == "" {
+		return ""
+	}
+	event, err := sjson.SetRaw(pluginCtx.StreamCut.Template, "choices", "[]")
+	if err != nil {
+		return ""
+	}
+	if event, err = sjson.SetRaw(event, "usage", pluginCtx.StreamCut.LastUsage); err != nil {
+		return ""
+	}
+	pluginCtx.StreamCut.LastUsage = ""
+	return "data: " + event + "\n\n"
+}
+
+// DeniedStreamTail 处理流式响应被截断之后的 chunk：丢弃其中的内容，只记录上游的 usage，
+// 收到流结束标记或最后一个 chunk 时输出最后一次的 usage 并以 [DONE] 结束
+func DeniedStreamTail(pluginCtx *config.PluginContext, chunk []byte, isLastChunk bool) []byte {
+	if !pluginCtx.StreamCut.AwaitUsage {
+		return nil
+	}
+	ended := isLastChunk
+	for _, eventStr := range strings.Split(strings.TrimSpace(string(wrapper.UnifySSEChunk(chunk))), "\n\n") {
+		if isStreamEndEvent(eventStr) {
+			ended = true
+			break
+		}
+		recordStreamUsage(pluginCtx, gjson.Parse(sseEventData(eventStr)))
+	}
+	if !ended {
+		return nil
+	}
+	pluginCtx.StreamCut.AwaitUsage = false
+	return []byte(streamUsageEvent(pluginCtx) + "data: [DONE]\n\n")
+}
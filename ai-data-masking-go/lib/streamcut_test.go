@@ -0,0 +1,83 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+
+	"ai-data-masking/config"
+
+	"github.com/tidwall/gjson"
+)
+
+// streamCutChunks 缓冲区中的一个注释事件和一个 OpenAI 流式事件
+var streamCutChunks = []string{
+	": keep-alive\n\n",
+	`data: {"id":"chatcmpl-1","object":"chat.completion.chunk","created":1700000000,"model":"gpt-4o","choices":[{"index":0,"delta":{"content":"敏感"}}]}` + "\n\n",
+}
+
+// TestStreamDenyEvent 测试拒绝事件保留上游的 id、model、created，并以 content_filter 结束
+func TestStreamDenyEvent(t *testing.T) {
+	pluginCtx := createTestPluginContext(withRequest(config.OpenAIRequest{Model: "gpt", Stream: true, IncludeUsage: false}), withStreamChunks(streamCutChunks...))
+	data := sseEventData(streamDenyEvent(pluginCtx, `包含"引号"的消息`))
+	if gjson.Get(data, "id").String() != "chatcmpl-1" || gjson.Get(data, "model").String() != "gpt-4o" || gjson.Get(data, "created").Int() != 1700000000 {
+		t.Errorf("未保留上游字段: %s", data)
+	}
+	if gjson.Get(data, "choices.0.finish_reason").String() != "content_filter" || gjson.Get(data, "choices.0.delta.content").String() != `包含"引号"的消息` {
+		t.Errorf("拒绝事件不正确: %s", data)
+	}
+
+	// 缓冲区中没有可用的模板时按请求的模型构造
+	pluginCtx = &config.PluginContext{OpenAIRequest: &config.OpenAIRequest{Model: "gpt"}, Protocol: config.APIProtocolCompletions}
+	data = sseEventData(streamDenyEvent(pluginCtx, "已屏蔽"))
+	if gjson.Get(data, "object").String() != "text_completion" || gjson.Get(data, "model").String() != "gpt" ||
+		gjson.Get(data, "created").Int() == 0 || gjson.Get(data, "choices.0.text").String() != "已屏蔽" {
+		t.Errorf("拒绝事件不正确: %s", data)
+	}
+}
+
+// TestStreamDenyEnd 测试截断时补发 usage，以及请求 include_usage 时等待上游的 usage 后再结束
+func TestStreamDenyEnd(t *testing.T) {
+	t.Run("流已结束时补发缓冲区中的 usage", func(t *testing.T) {
+		pluginCtx := createTestPluginContext(withRequest(config.OpenAIRequest{Model: "gpt", Stream: true, IncludeUsage: true}), withStreamChunks(streamCutChunks...))
+		pluginCtx.StreamCut.LastUsage = `{"prompt_tokens":5,"completion_tokens":7,"total_tokens":12}`
+		streamDenyEvent(pluginCtx, "已屏蔽")
+		events := strings.Split(strings.TrimSpace(streamDenyEnd(pluginCtx, true)), "\n\n")
+		if len(events) != 2 || events[1] != "data: [DONE]" {
+			t.Fatalf("结束事件不正确: %q", events)
+		}
+		usage := sseEventData(events[0])
+		if gjson.Get(usage, "id").String() != "chatcmpl-1" || gjson.Get(usage, "choices.#").Int() != 0 || gjson.Get(usage, "usage.total_tokens").Int() != 12 {
+			t.Errorf("usage 事件不正确: %s", usage)
+		}
+	})
+
+	t.Run("未请求 usage 时立即结束", func(t *testing.T) {
+		pluginCtx := createTestPluginContext(withRequest(config.OpenAIRequest{Model: "gpt", Stream: true, IncludeUsage: false}), withStreamChunks(streamCutChunks...))
+		streamDenyEvent(pluginCtx, "已屏蔽")
+		if end := streamDenyEnd(pluginCtx, false); end != "data: [DONE]\n\n" || pluginCtx.StreamCut.AwaitUsage {
+			t.Errorf("应立即结束: %q", end)
+		}
+	})
+
+	t.Run("等待上游的 usage", func(t *testing.T) {
+		pluginCtx := createTestPluginContext(withRequest(config.OpenAIRequest{Model: "gpt", Stream: true, IncludeUsage: true}), withStreamChunks(streamCutChunks...))
+		streamDenyEvent(pluginCtx, "已屏蔽")
+		if end := streamDenyEnd(pluginCtx, false); end != "" || !pluginCtx.StreamCut.AwaitUsage {
+			t.Fatalf("应等待 usage: %q", end)
+		}
+		content := `data: {"id":"chatcmpl-1","choices":[{"index":0,"delta":{"content":"后续内容"}}]}` + "\n\n"
+		if output := DeniedStreamTail(pluginCtx, []byte(content), false); output != nil {
+			t.Errorf("截断后的内容应丢弃: %q", output)
+		}
+		tail := `data: {"id":"chatcmpl-1","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}` + "\n\n" +
+			`data: {"id":"chatcmpl-1","choices":[],"usage":{"prompt_tokens":5,"completion_tokens":30,"total_tokens":35}}` + "\n\n" +
+			"data: [DONE]\n\n"
+		output := string(DeniedStreamTail(pluginCtx, []byte(tail), false))
+		if strings.Contains(output, "finish_reason") || !strings.Contains(output, `"total_tokens":35`) || !strings.HasSuffix(output, "data: [DONE]\n\n") {
+			t.Errorf("应只转发 usage 并结束: %q", output)
+		}
+		if pluginCtx.StreamCut.AwaitUsage || DeniedStreamTail(pluginCtx, []byte(tail), true) != nil {
+			t.Errorf("结束后不应再输出")
+		}
+	})
+}
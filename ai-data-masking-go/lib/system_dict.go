@@ -0,0 +1,83 @@
+package lib
+
+import (
+	"ai-data-masking/config"
+	"ai-data-masking/wlog"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// RefreshSystemDenyWords 从远程来源拉取系统敏感词库，成功后重建系统匹配器并替换词库
+// 请求失败、状态码非 200 或解析结果为空时保留之前的词库
+func RefreshSystemDenyWords(cfg *config.AiDataMaskingConfig) {
+	source := &cfg.SystemDenySource
+	if !source.Enabled() {
+		return
+	}
+
+	err := source.Client.Get(source.Path, nil, func(statusCode int, responseHeaders http.Header, responseBody []byte) {
+		if statusCode != http.StatusOK {
+			wlog.LogWithLine("[%s] RefreshSystemDenyWords: unexpected status %d from %s, keep previous dictionary", pluginName, statusCode, source.Client.ClusterName())
+			return
+		}
+		words, err := ParseSystemDenyWords(responseBody, source.Format, source.JSONPath)
+		if err != nil {
+			wlog.LogWithLine("[%s] RefreshSystemDenyWords: %v, keep previous dictionary", pluginName, err)
+			return
+		}
+		if wordsEqual(config.GetSystemDenyWords(), words) {
+			return
+		}
+
+		// 替换词库时同时更新最长词的长度，流式重叠边界随之变化
+		rebuildSystemMatcher(words, &cfg.Normalize)
+		wlog.LogWithLine("[%s] RefreshSystemDenyWords: loaded %d system deny words", pluginName, len(words))
+	}, source.Timeout)
+	if err != nil {
+		wlog.LogWithLine("[%s] RefreshSystemDenyWords: failed to dispatch request: %v", pluginName, err)
+	}
+}
+
+// ParseSystemDenyWords 解析系统敏感词库响应
+// text 格式每行一个词，忽略空行和 # 开头的注释行；json 格式读取 jsonPath 指定的字符串数组
+func ParseSystemDenyWords(body []byte, format, jsonPath string) ([]string, error) {
+	var words []string
+	switch format {
+	case "", config.SystemDenyFormatText:
+		for _, line := range strings.Split(string(body), "\n") {
+			word := strings.TrimSpace(line)
+			if word == "" || strings.HasPrefix(word, "#") {
+				continue
+			}
+			words = append(words, word)
+		}
+	case config.SystemDenyFormatJSON:
+		if !gjson.ValidBytes(body) {
+			return nil, errors.New("invalid json dictionary")
+		}
+		result := gjson.ParseBytes(body)
+		if jsonPath != "" {
+			result = result.Get(jsonPath)
+		}
+		if !result.IsArray() {
+			return nil, fmt.Errorf("json dictionary path %q is not an array", jsonPath)
+		}
+		for _, item := range result.Array() {
+			word := strings.TrimSpace(item.String())
+			if word != "" {
+				words = append(words, word)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported dictionary format %q", format)
+	}
+
+	if len(words) == 0 {
+		return nil, errors.New("empty dictionary")
+	}
+	return words, nil
+}
@@ -0,0 +1,121 @@
+package lib
+
+import (
+	"ai-data-masking/config"
+	"testing"
+)
+
+// TestParseSystemDenyWords 测试系统词库的纯文本与 JSON 解析
+func TestParseSystemDenyWords(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		format   string
+		jsonPath string
+		expected []string
+		wantErr  bool
+	}{
+		{
+			name:     "纯文本",
+			body:     "# 注释\n系统词1\r\n\n  系统词2  \n",
+			format:   config.SystemDenyFormatText,
+			expected: []string{"系统词1", "系统词2"},
+		},
+		{
+			name:     "默认格式为纯文本",
+			body:     "系统词1",
+			expected: []string{"系统词1"},
+		},
+		{
+			name:     "JSON 顶层数组",
+			body:     `["系统词1", "", "系统词2"]`,
+			format:   config.SystemDenyFormatJSON,
+			expected: []string{"系统词1", "系统词2"},
+		},
+		{
+			name:     "JSON 指定路径",
+			body:     `{"data":{"words":["系统词1"]}}`,
+			format:   config.SystemDenyFormatJSON,
+			jsonPath: "data.words",
+			expected: []string{"系统词1"},
+		},
+		{
+			name:     "JSON 路径不是数组",
+			body:     `{"data":{"words":"系统词1"}}`,
+			format:   config.SystemDenyFormatJSON,
+			jsonPath: "data.words",
+			wantErr:  true,
+		},
+		{
+			name:    "非法 JSON",
+			body:    `["系统词1"`,
+			format:  config.SystemDenyFormatJSON,
+			wantErr: true,
+		},
+		{
+			name:    "空词库",
+			body:    "# 只有注释\n\n",
+			format:  config.SystemDenyFormatText,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			words, err := ParseSystemDenyWords([]byte(tt.body), tt.format, tt.jsonPath)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("期望返回错误，实际得到 %v", words)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("解析失败: %v", err)
+			}
+			if !wordsEqual(words, tt.expected) {
+				t.Errorf("期望 %v, 实际 %v", tt.expected, words)
+			}
+		})
+	}
+}
+
+// TestRebuildSystemMatcher 测试刷新系统词库后匹配器随之更新
+func TestRebuildSystemMatcher(t *testing.T) {
+	defer config.SetSystemDenyWords(nil)
+
+	cfg := createTestConfig()
+	rebuildSystemMatcher([]string{"远程词1"}, &cfg.Normalize)
+	if results := FindSensitiveWordMatches("包含远程词1", cfg, config.GetSystemDenyWords()); len(results) != 1 {
+		t.Fatalf("期望命中远程词1，实际 %v", results)
+	}
+
+	rebuildSystemMatcher([]string{"远程词2"}, &cfg.Normalize)
+	if results := FindSensitiveWordMatches("包含远程词1", cfg, config.GetSystemDenyWords()); len(results) != 0 {
+		t.Errorf("刷新后不应再命中远程词1，实际 %v", results)
+	}
+	if results := FindSensitiveWordMatches("包含远程词2", cfg, config.GetSystemDenyWords()); len(results) != 1 {
+		t.Errorf("期望命中远程词2，实际 %v", results)
+	}
+}
+
+// TestStreamOverlapLength_SystemDict 测试刷新系统词库后各配置的重叠边界按自身的 system_deny 计算，不影响其他配置
+func TestStreamOverlapLength_SystemDict(t *testing.T) {
+	defer config.SetSystemDenyWords(nil)
+
+	systemCfg := createTestConfig(withMaxSensitiveWordLength(30))
+	customCfg := createTestConfig(withMaxSensitiveWordLength(30))
+	customCfg.SystemDeny = false
+
+	rebuildSystemMatcher([]string{"一个比自定义敏感词长得多的远程系统敏感词"}, &systemCfg.Normalize)
+	if got := streamOverlapLength(systemCfg); got != 20*3*2 {
+		t.Errorf("开启 system_deny 时应按系统词库的最长词计算, 实际 %d", got)
+	}
+	if got := streamOverlapLength(customCfg); got != 30 {
+		t.Errorf("未开启 system_deny 时应保持配置解析时的长度, 实际 %d", got)
+	}
+
+	rebuildSystemMatcher([]string{"短词"}, &systemCfg.Normalize)
+	if got := streamOverlapLength(systemCfg); got != 30 {
+		t.Errorf("系统词库变短后应恢复为配置解析时的长度, 实际 %d", got)
+	}
+}
@@ -0,0 +1,224 @@
+package lib
+
+import (
+	"ai-data-masking/config"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// defaultTokenLabel token 类型规则未配置 label 时使用的占位符类型
+const defaultTokenLabel = "TOKEN"
+
+// fpeRounds 格式保留加密的 Feistel 轮数，与 FF1 一致
+const fpeRounds = 10
+
+// HMACToken 使用密钥计算 HMAC-SHA256，返回十六进制字符串
+// length 大于 0 时截断为前 length 个字符；label 不为空时返回 <LABEL_hex> 形式的类型化占位符
+func HMACToken(secret, value string, length int, label string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(value))
+	token := hex.EncodeToString(mac.Sum(nil))
+	if length > 0 && length < len(token) {
+		token = token[:length]
+	}
+	if label != "" {
+		return "<" + strings.ToUpper(label) + "_" + token + ">"
+	}
+	return token
+}
+
+// typedToken 为原始值分配类型化占位符，如 <PHONE_1>
+// 同一请求内相同的值复用同一个占位符，不同类型分别从 1 开始编号
+func typedToken(pluginCtx *config.PluginContext, label, value string) string {
+	if pluginCtx.TokenMap == nil {
+		pluginCtx.TokenMap = make(map[string]string)
+	}
+	if pluginCtx.TokenCount == nil {
+		pluginCtx.TokenCount = make(map[string]int)
+	}
+
+	label = strings.ToUpper(label)
+	if label == "" {
+		label = defaultTokenLabel
+	}
+	key := label + "\x00" + value
+	if token, ok := pluginCtx.TokenMap[key]; ok {
+		return token
+	}
+	pluginCtx.TokenCount[label]++
+	token := "<" + label + "_" + strconv.Itoa(pluginCtx.TokenCount[label]) + ">"
+	pluginCtx.TokenMap[key] = token
+	return token
+}
+
+// fpeClass 格式保留加密的字符类别
+type fpeClass struct {
+	name  byte // 类别标识，参与 tweak 计算
+	first rune // 类别中的第一个字符
+	radix int  // 类别中的字符个数
+}
+
+// fpeClasses 参与加密的字符类别，其余字符（中文、标点等）保持不变
+var fpeClasses = []fpeClass{
+	{name: 'd', first: '0', radix: 10},
+	{name: 'l', first: 'a', radix: 26},
+	{name: 'u', first: 'A', radix: 26},
+}
+
+// classOf 返回字符所属的类别下标，不参与加密的字符返回 -1
+func classOf(r rune) int {
+	for i, class := range fpeClasses {
+		if r >= class.first && r < class.first+rune(class.radix) {
+			return i
+		}
+	}
+	return -1
+}
+
+// FormatPreservingEncrypt 格式保留加密：数字仍为数字、小写字母仍为小写字母、大写字母仍为大写字母，
+// 长度和其他字符的位置不变。各类别的字符分别组成数字串，使用 FF1 风格的 Feistel 结构加密，
+// 轮函数为以 secret 为密钥的 HMAC-SHA256，字符布局作为 tweak 参与计算
+func FormatPreservingEncrypt(secret, value string) string {
+	return formatPreservingCipher(secret, value, false)
+}
+
+// FormatPreservingDecrypt 格式保留解密，FormatPreservingEncrypt 的逆运算
+func FormatPreservingDecrypt(secret, value string) string {
+	return formatPreservingCipher(secret, value, true)
+}
+
+// formatPreservingCipher 按字符类别拆分后分别加解密，再按原位置写回
+func formatPreservingCipher(secret, value string, decrypt bool) string {
+	runes := []rune(value)
+	layout := make([]byte, len(runes))
+	positions := make([][]int, len(fpeClasses))
+	for i, r := range runes {
+		class := classOf(r)
+		if class < 0 {
+			layout[i] = '.'
+			continue
+		}
+		layout[i] = fpeClasses[class].name
+		positions[class] = append(positions[class], i)
+	}
+
+	for class, indexes := range positions {
+		if len(indexes) == 0 {
+			continue
+		}
+		first := fpeClasses[class].first
+		numerals := make([]int, len(indexes))
+		for i, index := range indexes {
+			numerals[i] = int(runes[index] - first)
+		}
+		numerals = fpeFeistel([]byte(secret), layout, fpeClasses[class].radix, numerals, decrypt)
+		for i, index := range indexes {
+			runes[index] = first + rune(numerals[i])
+		}
+	}
+	return string(runes)
+}
+
+// fpeFeistel 对基数为 radix 的数字串做 Feistel 加解密，长度为 1 时退化为带密钥的移位
+func fpeFeistel(key, tweak []byte, radix int, numerals []int, decrypt bool) []int {
+	n := len(numerals)
+	if n == 1 {
+		shift := new(big.Int).Mod(fpeRound(key, tweak, radix, n, 0, nil), big.NewInt(int64(radix))).Int64()
+		if decrypt {
+			shift = int64(radix) - shift
+		}
+		return []int{(numerals[0] + int(shift)) % radix}
+	}
+
+	u := n / 2
+	v := n - u
+	a := append([]int(nil), numerals[:u]...)
+	b := append([]int(nil), numerals[u:]...)
+	bigRadix := big.NewInt(int64(radix))
+
+	for step := 0; step < fpeRounds; step++ {
+		round := step
+		if decrypt {
+			round = fpeRounds - 1 - step
+		}
+		m := u
+		if round%2 == 1 {
+			m = v
+		}
+		modulus := new(big.Int).Exp(bigRadix, big.NewInt(int64(m)), nil)
+
+		if !decrypt {
+			// C = (NUM(A) + F(B)) mod radix^m，A <- B，B <- C
+			c := new(big.Int).Add(numeralsToInt(a, radix), fpeRound(key, tweak, radix, n, round, b))
+			c.Mod(c, modulus)
+			a, b = b, intToNumerals(c, radix, m)
+		} else {
+			// C = (NUM(B) - F(A)) mod radix^m，B <- A，A <- C
+			c := new(big.Int).Sub(numeralsToInt(b, radix), fpeRound(key, tweak, radix, n, round, a))
+			c.Mod(c, modulus)
+			a, b = intToNumerals(c, radix, m), a
+		}
+	}
+	return append(a, b...)
+}
+
+// fpeRound Feistel 轮函数：HMAC-SHA256(key, tweak || radix || n || round || X)，按需拼接多个分组以覆盖 radix^m 的范围
+func fpeRound(key, tweak []byte, radix, n, round int, x []int) *big.Int {
+	header := make([]byte, 0, len(tweak)+12+len(x))
+	header = append(header, tweak...)
+	header = binary.BigEndian.AppendUint32(header, uint32(radix))
+	header = binary.BigEndian.AppendUint32(header, uint32(n))
+	header = append(header, byte(round))
+	for _, numeral := range x {
+		header = append(header, byte(numeral))
+	}
+
+	// 输出长度至少比 radix^n 多 8 字节，降低取模带来的偏差
+	need := (n*bitsPerNumeral(radix)+7)/8 + 8
+	var out []byte
+	for block := uint32(0); len(out) < need; block++ {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(header)
+		mac.Write(binary.BigEndian.AppendUint32(nil, block))
+		out = mac.Sum(out)
+	}
+	return new(big.Int).SetBytes(out[:need])
+}
+
+// bitsPerNumeral 表示一个 radix 进制数字所需的比特数
+func bitsPerNumeral(radix int) int {
+	bits := 0
+	for v := radix - 1; v > 0; v >>= 1 {
+		bits++
+	}
+	return bits
+}
+
+// numeralsToInt 将高位在前的数字串转换为整数
+func numeralsToInt(numerals []int, radix int) *big.Int {
+	result := new(big.Int)
+	bigRadix := big.NewInt(int64(radix))
+	for _, numeral := range numerals {
+		result.Mul(result, bigRadix)
+		result.Add(result, big.NewInt(int64(numeral)))
+	}
+	return result
+}
+
+// intToNumerals 将整数转换为长度为 m 的高位在前的数字串
+func intToNumerals(value *big.Int, radix, m int) []int {
+	numerals := make([]int, m)
+	rest := new(big.Int).Set(value)
+	bigRadix := big.NewInt(int64(radix))
+	digit := new(big.Int)
+	for i := m - 1; i >= 0; i-- {
+		rest.DivMod(rest, bigRadix, digit)
+		numerals[i] = int(digit.Int64())
+	}
+	return numerals
+}
@@ -0,0 +1,167 @@
+package lib
+
+import (
+	"ai-data-masking/config"
+	"regexp"
+	"strings"
+	"testing"
+	"unicode"
+)
+
+// TestHMACToken 测试带密钥的 HMAC、截断与类型前缀
+func TestHMACToken(t *testing.T) {
+	full := HMACToken("secret", "13812345678", 0, "")
+	if len(full) != 64 {
+		t.Fatalf("HMAC 长度不正确: %s", full)
+	}
+	if full == HMACToken("other", "13812345678", 0, "") {
+		t.Errorf("不同密钥的结果不应相同")
+	}
+	if got := HMACToken("secret", "13812345678", 8, ""); got != full[:8] {
+		t.Errorf("截断结果不正确: %s", got)
+	}
+	if got := HMACToken("secret", "13812345678", 8, "phone"); got != "<PHONE_"+full[:8]+">" {
+		t.Errorf("类型化结果不正确: %s", got)
+	}
+}
+
+// TestFormatPreservingEncrypt 测试格式保留加密保持长度和字符类别，且可以解密
+func TestFormatPreservingEncrypt(t *testing.T) {
+	values := []string{
+		"13812345678",
+		"6222021234567890128",
+		"11010519491231002X",
+		"sk-AbCdEf0123456789abcdefABCDEF",
+		"EMP-7",
+		"京A12345",
+		"a",
+	}
+
+	for _, value := range values {
+		t.Run(value, func(t *testing.T) {
+			encrypted := FormatPreservingEncrypt("secret", value)
+			if encrypted == value && len(value) > 2 {
+				t.Errorf("加密结果与原值相同: %s", encrypted)
+			}
+			original, masked := []rune(value), []rune(encrypted)
+			if len(original) != len(masked) {
+				t.Fatalf("长度不一致: %s -> %s", value, encrypted)
+			}
+			for i := range original {
+				if classOf(original[i]) != classOf(masked[i]) {
+					t.Fatalf("第 %d 个字符类别不一致: %s -> %s", i, value, encrypted)
+				}
+				if classOf(original[i]) < 0 && original[i] != masked[i] {
+					t.Fatalf("不参与加密的字符被修改: %s -> %s", value, encrypted)
+				}
+			}
+			if again := FormatPreservingEncrypt("secret", value); again != encrypted {
+				t.Errorf("相同密钥的结果应一致: %s != %s", again, encrypted)
+			}
+			if decrypted := FormatPreservingDecrypt("secret", encrypted); decrypted != value {
+				t.Errorf("解密结果不正确: %s -> %s -> %s", value, encrypted, decrypted)
+			}
+		})
+	}
+
+	if FormatPreservingEncrypt("secret", "13812345678") == FormatPreservingEncrypt("other", "13812345678") {
+		t.Errorf("不同密钥的结果不应相同")
+	}
+}
+
+// TestReplaceMessage_Tokenize 测试 hmac、token、fpe 规则类型以及还原
+func TestReplaceMessage_Tokenize(t *testing.T) {
+	phone := regexp.MustCompile(`1\d{10}`)
+	text := "张三 13812345678，李四 13900001111，再打 13812345678"
+
+	t.Run("token", func(t *testing.T) {
+		pluginCtx := &config.PluginContext{
+			Config: &config.AiDataMaskingConfig{
+				ReplaceRoles: []config.Rule{{Type: config.RuleTypeToken, Label: "phone", Restore: true, CompiledRegex: phone}},
+			},
+			MaskMap: make(map[string]*string),
+		}
+		got := ReplaceMessage(text, pluginCtx)
+		if got != "张三 <PHONE_1>，李四 <PHONE_2>，再打 <PHONE_1>" {
+			t.Fatalf("替换结果不正确: %q", got)
+		}
+		// 同一请求内的其他消息继续编号
+		if got := ReplaceMessage("还有 13700002222 和 13900001111", pluginCtx); got != "还有 <PHONE_3> 和 <PHONE_2>" {
+			t.Errorf("跨消息编号不正确: %q", got)
+		}
+		if restored := RestoreMessage("请联系<PHONE_2>", pluginCtx); restored != "请联系13900001111" {
+			t.Errorf("还原结果不正确: %q", restored)
+		}
+	})
+
+	t.Run("token 默认类型", func(t *testing.T) {
+		pluginCtx := &config.PluginContext{
+			Config: &config.AiDataMaskingConfig{
+				ReplaceRoles: []config.Rule{{Type: config.RuleTypeToken, CompiledRegex: phone}},
+			},
+			MaskMap: make(map[string]*string),
+		}
+		if got := ReplaceMessage("电话 13812345678", pluginCtx); got != "电话 <TOKEN_1>" {
+			t.Errorf("替换结果不正确: %q", got)
+		}
+	})
+
+	t.Run("hmac", func(t *testing.T) {
+		pluginCtx := &config.PluginContext{
+			Config: &config.AiDataMaskingConfig{
+				MaskSecret:   "secret",
+				ReplaceRoles: []config.Rule{{Type: config.RuleTypeHMAC, Label: "PHONE", Length: 8, Restore: true, CompiledRegex: phone}},
+			},
+			MaskMap: make(map[string]*string),
+		}
+		token := HMACToken("secret", "13812345678", 8, "PHONE")
+		got := ReplaceMessage("电话 13812345678", pluginCtx)
+		if got != "电话 "+token {
+			t.Fatalf("替换结果不正确: %q", got)
+		}
+		if restored := RestoreMessage(got, pluginCtx); restored != "电话 13812345678" {
+			t.Errorf("还原结果不正确: %q", restored)
+		}
+	})
+
+	t.Run("hash 截断", func(t *testing.T) {
+		pluginCtx := &config.PluginContext{
+			Config: &config.AiDataMaskingConfig{
+				ReplaceRoles: []config.Rule{{Type: config.RuleTypeHash, Length: 16, CompiledRegex: phone}},
+			},
+			MaskMap: make(map[string]*string),
+		}
+		got := ReplaceMessage("电话 13812345678", pluginCtx)
+		if len(strings.TrimPrefix(got, "电话 ")) != 16 {
+			t.Errorf("截断结果不正确: %q", got)
+		}
+	})
+
+	t.Run("fpe", func(t *testing.T) {
+		pluginCtx := &config.PluginContext{
+			Config: &config.AiDataMaskingConfig{
+				MaskSecret:   "secret",
+				ReplaceRoles: []config.Rule{{Type: config.RuleTypeFPE, Restore: true, CompiledRegex: phone}},
+			},
+			MaskMap: make(map[string]*string),
+		}
+		got := ReplaceMessage("电话 13812345678", pluginCtx)
+		masked := strings.TrimPrefix(got, "电话 ")
+		if len(masked) != 11 || masked == "13812345678" || strings.IndexFunc(masked, func(r rune) bool { return !unicode.IsDigit(r) }) >= 0 {
+			t.Fatalf("加密结果不正确: %q", got)
+		}
+		if restored := RestoreMessage(got, pluginCtx); restored != "电话 13812345678" {
+			t.Errorf("还原结果不正确: %q", restored)
+		}
+	})
+}
+
+// TestPrintConfig_MaskSecret 测试打印的配置中不包含 mask_secret
+func TestPrintConfig_MaskSecret(t *testing.T) {
+	cfg := createTestConfig()
+	cfg.MaskSecret = "top-secret-key"
+	output := string(PrintConfig(cfg))
+	if strings.Contains(output, "top-secret-key") || strings.Contains(output, "mask_secret") {
+		t.Errorf("配置输出中包含密钥: %s", output)
+	}
+}
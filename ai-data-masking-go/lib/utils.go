@@ -5,6 +5,7 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"sort"
 	"strings"
 	"unicode/utf8"
 )
@@ -20,22 +21,48 @@ func ReplaceMessage(message string, pluginCtx *config.PluginContext) string {
 		if rule.CompiledRegex == nil {
 			continue
 		}
+		// 识别器类型的规则只改写通过校验的值
+		validate := detectorValidator(rule.Type)
 
 		if rule.Type == "replace" && !rule.Restore {
 			// 简单替换，不还原
 			result = rule.CompiledRegex.ReplaceAllString(result, rule.Value)
 		} else {
-			// 需要还原的替换或 hash
-			matches := rule.CompiledRegex.FindAllString(result, -1)
-			for _, match := range matches {
+			// 需要还原的替换、hash、hmac、token、fpe 或掩码
+			// 按命中位置重建文本，只改写通过校验的片段，未命中或未通过校验的相同子串保持原样
+			locs := rule.CompiledRegex.FindAllStringSubmatchIndex(result, -1)
+			source := result
+			var builder strings.Builder
+			last := 0
+			for _, loc := range locs {
+				match := source[loc[0]:loc[1]]
+				if validate != nil && !validate(match) {
+					continue
+				}
 				var toWord string
-				if rule.Type == "hash" {
+				switch {
+				case rule.Type == config.RuleTypeHash:
 					// SHA256 hash
 					hash := sha256.Sum256([]byte(match))
 					toWord = hex.EncodeToString(hash[:])
-				} else {
-					// 替换
-					toWord = rule.CompiledRegex.ReplaceAllString(match, rule.Value)
+					if rule.Length > 0 && rule.Length < len(toWord) {
+						toWord = toWord[:rule.Length]
+					}
+				case rule.Type == config.RuleTypeHMAC:
+					// 带密钥的 HMAC-SHA256，如 <PHONE_3f2a9c1b>
+					toWord = HMACToken(pluginCtx.Config.MaskSecret, match, rule.Length, rule.Label)
+				case rule.Type == config.RuleTypeToken:
+					// 类型化占位符，如 <PHONE_1>
+					toWord = typedToken(pluginCtx, rule.Label, match)
+				case rule.Type == config.RuleTypeFPE:
+					// 格式保留加密，如 13812345678 -> 19046217395
+					toWord = FormatPreservingEncrypt(pluginCtx.Config.MaskSecret, match)
+				case rule.Type == config.RuleTypeMask || (validate != nil && rule.Value == ""):
+					// 部分掩码，如 138****5678
+					toWord = MaskValue(match, rule.MaskOptions)
+				default:
+					// 替换，value 中可以通过 $name 或 ${name} 引用 GROK 命名捕获组
+					toWord = string(rule.CompiledRegex.ExpandString(nil, rule.Value, source, loc))
 				}
 
 				// 记录映射关系用于还原
@@ -43,8 +70,12 @@ func ReplaceMessage(message string, pluginCtx *config.PluginContext) string {
 					pluginCtx.MaskMap[toWord] = &match
 				}
 
-				result = strings.ReplaceAll(result, match, toWord)
+				builder.WriteString(source[last:loc[0]])
+				builder.WriteString(toWord)
+				last = loc[1]
 			}
+			builder.WriteString(source[last:])
+			result = builder.String()
 		}
 	}
 
@@ -52,24 +83,18 @@ func ReplaceMessage(message string, pluginCtx *config.PluginContext) string {
 }
 
 // restoreMessage 还原消息中的脱敏数据
+// 占位符按长度从长到短匹配，避免较短的占位符截断较长的占位符
 func RestoreMessage(message string, pluginCtx *config.PluginContext) string {
-	if len(pluginCtx.MaskMap) == 0 {
+	if len(pluginCtx.MaskMap) == 0 || message == "" {
 		return message
 	}
-
-	result := message
-	for hash, original := range pluginCtx.MaskMap {
-		if original != nil {
-			result = strings.ReplaceAll(result, hash, *original)
-		}
-	}
-
-	return result
+	return restoreReplacer(restoreKeys(pluginCtx), pluginCtx).Replace(message)
 }
 
 // ReplaceSensitiveWordsWithValue 使用指定的 value 替换敏感词，保持字符数相等
 // 如果 value 长度不够，会重复 value 直到达到敏感词的长度
 // 如果 value 长度超过敏感词，会截断 value
+// 替换按匹配位置在原始文本上进行，归一化后命中的片段（如插入了分隔符的敏感词）整段替换
 func ReplaceSensitiveWordsWithValue(text string, config *config.AiDataMaskingConfig, systemDenyWords []string, replaceValue string) string {
 	if text == "" {
 		return text
@@ -80,94 +105,134 @@ func ReplaceSensitiveWordsWithValue(text string, config *config.AiDataMaskingCon
 		replaceValue = "*"
 	}
 
-	result := text
-	textBytes := []byte(text)
+	// 仅记录日志的字典项不做替换
+	matches := FindSensitiveWordMatches(text, config, systemDenyWords)
+	return replaceMatchSpans(text, maskableMatches(matches), sensitiveWordReplacer(&config.ResponseDenyPlot, replaceValue))
+}
 
-	// 检查自定义敏感词
-	if len(config.DenyWords) > 0 {
-		matcher := getOrBuildCustomMatcher(config.DenyWords)
-		matches := matcher.Match(textBytes)
-		if len(matches) > 0 {
-			// 使用 map 去重，避免重复处理同一个敏感词
-			processedWords := make(map[int]bool, len(matches))
-			for _, wordIdx := range matches {
-				if processedWords[wordIdx] {
-					continue
-				}
-				processedWords[wordIdx] = true
-				matchedWord := config.DenyWords[wordIdx]
-
-				// 计算敏感词的字符数（不是字节数）
-				wordRuneCount := utf8.RuneCountInString(matchedWord)
-				replaceValueRuneCount := utf8.RuneCountInString(replaceValue)
-
-				// 生成替换字符串，保持字符数相等
-				var replacement string
-				if replaceValueRuneCount == wordRuneCount {
-					// 长度相等，直接使用
-					replacement = replaceValue
-				} else if replaceValueRuneCount < wordRuneCount {
-					// value 长度不够，重复 value 直到达到敏感词的长度
-					repeatCount := (wordRuneCount + replaceValueRuneCount - 1) / replaceValueRuneCount // 向上取整
-					replacement = strings.Repeat(replaceValue, repeatCount)
-					// 截断到精确长度
-					replacementRunes := []rune(replacement)
-					replacement = string(replacementRunes[:wordRuneCount])
-				} else {
-					// value 长度超过敏感词，截断 value
-					replaceValueRunes := []rune(replaceValue)
-					replacement = string(replaceValueRunes[:wordRuneCount])
-				}
+// MaskReplaceActionWords 将动作为 replace 的自定义敏感词替换为掩码，保持字符数相等
+// 掩码值使用 deny_plot.value，未配置时使用 "*"
+func MaskReplaceActionWords(text string, cfg *config.AiDataMaskingConfig, systemDenyWords []string) string {
+	if text == "" || !cfg.HasDenyAction(config.DenyActionReplace) {
+		return text
+	}
 
-				// 替换所有匹配的敏感词
-				result = strings.ReplaceAll(result, matchedWord, replacement)
-			}
+	replaceValue := cfg.ResponseDenyPlot.Value
+	if replaceValue == "" {
+		replaceValue = "*"
+	}
+
+	matches := FindSensitiveWordMatches(text, cfg, systemDenyWords)
+	return replaceMatchSpans(text, filterMatchAction(matches, config.DenyActionReplace), sensitiveWordReplacer(&cfg.ResponseDenyPlot, replaceValue))
+}
+
+// ReplaceRequestDenyWords 将请求文本中需要拦截的敏感词替换为掩码（request_deny_plot 为 replace 时使用）
+// 掩码值使用 request_deny_plot.value，未配置时使用 "*"；动作为 replace 的敏感词仍由 MaskReplaceActionWords 处理
+func ReplaceRequestDenyWords(text string, cfg *config.AiDataMaskingConfig, systemDenyWords []string) string {
+	if text == "" {
+		return text
+	}
+
+	replaceValue := cfg.RequestDenyPlot.Value
+	if replaceValue == "" {
+		replaceValue = "*"
+	}
+
+	matches := FindSensitiveWordMatches(text, cfg, systemDenyWords)
+	return replaceMatchSpans(text, BlockingMatches(matches), sensitiveWordReplacer(&cfg.RequestDenyPlot, replaceValue))
+}
+
+// sensitiveWordReplacer 返回敏感词片段的替换函数，替换结果与原片段字符数相等
+// deny_plot.type 为 mask 时做部分掩码，否则使用 replaceValue 填充
+func sensitiveWordReplacer(plot *config.DenyPlot, replaceValue string) func(string) string {
+	if plot.Type == config.DenyPlotTypeMask {
+		return func(fragment string) string {
+			return MaskValue(fragment, plot.MaskOptions)
 		}
 	}
+	return func(fragment string) string {
+		// 计算敏感词的字符数（不是字节数）
+		return fitReplaceValue(replaceValue, utf8.RuneCountInString(fragment))
+	}
+}
 
-	// 检查系统敏感词
-	if config.SystemDeny && len(systemDenyWords) > 0 {
-		matcher := getOrBuildSystemMatcher(systemDenyWords)
-		matches := matcher.Match([]byte(result))
-		if len(matches) > 0 {
-			// 使用 map 去重，避免重复处理同一个敏感词
-			processedWords := make(map[int]bool, len(matches))
-			for _, wordIdx := range matches {
-				if processedWords[wordIdx] {
-					continue
-				}
-				processedWords[wordIdx] = true
-				matchedWord := systemDenyWords[wordIdx]
-
-				// 计算敏感词的字符数（不是字节数）
-				wordRuneCount := utf8.RuneCountInString(matchedWord)
-				replaceValueRuneCount := utf8.RuneCountInString(replaceValue)
-
-				// 生成替换字符串，保持字符数相等
-				var replacement string
-				if replaceValueRuneCount == wordRuneCount {
-					// 长度相等，直接使用
-					replacement = replaceValue
-				} else if replaceValueRuneCount < wordRuneCount {
-					// value 长度不够，重复 value 直到达到敏感词的长度
-					repeatCount := (wordRuneCount + replaceValueRuneCount - 1) / replaceValueRuneCount // 向上取整
-					replacement = strings.Repeat(replaceValue, repeatCount)
-					// 截断到精确长度
-					replacementRunes := []rune(replacement)
-					replacement = string(replacementRunes[:wordRuneCount])
-				} else {
-					// value 长度超过敏感词，截断 value
-					replaceValueRunes := []rune(replaceValue)
-					replacement = string(replaceValueRunes[:wordRuneCount])
-				}
+// MaskValue 保留左右两端指定数量的字符，其余字符替换为掩码字符，结果字符数与原值相等
+// 保留的字符数之和不小于原值长度时整体掩码，避免原样输出
+func MaskValue(value string, opts config.MaskOptions) string {
+	maskChar := '*'
+	if r, size := utf8.DecodeRuneInString(opts.MaskChar); size > 0 && r != utf8.RuneError {
+		maskChar = r
+	}
+
+	runes := []rune(value)
+	keepLeft, keepRight := max(opts.KeepLeft, 0), max(opts.KeepRight, 0)
+	if keepLeft+keepRight >= len(runes) {
+		keepLeft, keepRight = 0, 0
+	}
+	for i := keepLeft; i < len(runes)-keepRight; i++ {
+		runes[i] = maskChar
+	}
+	return string(runes)
+}
+
+// replaceMatchSpans 将命中片段替换为 replace 的返回值，replace 需保持片段的字符数不变
+func replaceMatchSpans(text string, matches []MatchResult, replace func(string) string) string {
+	if len(matches) == 0 {
+		return text
+	}
+
+	spans := mergeMatchSpans(matches)
+	var builder strings.Builder
+	builder.Grow(len(text))
+	last := 0
+	for _, span := range spans {
+		builder.WriteString(text[last:span[0]])
+		builder.WriteString(replace(text[span[0]:span[1]]))
+		last = span[1]
+	}
+	builder.WriteString(text[last:])
+
+	return builder.String()
+}
 
-				// 替换所有匹配的敏感词
-				result = strings.ReplaceAll(result, matchedWord, replacement)
+// mergeMatchSpans 将匹配位置按起始位置排序并合并重叠区间
+func mergeMatchSpans(matches []MatchResult) [][2]int {
+	spans := make([][2]int, 0, len(matches))
+	for _, match := range matches {
+		spans = append(spans, [2]int{match.StartPos, match.EndPos})
+	}
+	sort.Slice(spans, func(i, j int) bool {
+		return spans[i][0] < spans[j][0]
+	})
+	merged := spans[:0]
+	for _, span := range spans {
+		if n := len(merged); n > 0 && span[0] <= merged[n-1][1] {
+			if span[1] > merged[n-1][1] {
+				merged[n-1][1] = span[1]
 			}
+			continue
 		}
+		merged = append(merged, span)
 	}
+	return merged
+}
 
-	return result
+// fitReplaceValue 生成替换字符串，保持字符数与被替换文本相等
+func fitReplaceValue(replaceValue string, wordRuneCount int) string {
+	replaceValueRuneCount := utf8.RuneCountInString(replaceValue)
+	if replaceValueRuneCount == wordRuneCount {
+		// 长度相等，直接使用
+		return replaceValue
+	}
+	if replaceValueRuneCount < wordRuneCount {
+		// value 长度不够，重复 value 直到达到敏感词的长度
+		repeatCount := (wordRuneCount + replaceValueRuneCount - 1) / replaceValueRuneCount // 向上取整
+		replacementRunes := []rune(strings.Repeat(replaceValue, repeatCount))
+		// 截断到精确长度
+		return string(replacementRunes[:wordRuneCount])
+	}
+	// value 长度超过敏感词，截断 value
+	return string([]rune(replaceValue)[:wordRuneCount])
 }
 
 // calculateMaxSensitiveWordLength 计算最长敏感词的长度（字节数）
@@ -185,12 +250,8 @@ func CalculateMaxSensitiveWordLength(cfg *config.AiDataMaskingConfig) int {
 	}
 
 	// 检查系统敏感词
-	if cfg.SystemDeny && len(config.SystemDenyWords) > 0 {
-		for _, word := range config.SystemDenyWords {
-			if utf8.RuneCountInString(word) > maxWordLen {
-				maxWordLen = utf8.RuneCountInString(word)
-			}
-		}
+	if cfg.SystemDeny && config.GetSystemDenyWordMaxLength() > maxWordLen {
+		maxWordLen = config.GetSystemDenyWordMaxLength()
 	}
 
 	// 如果没有任何敏感词，返回一个默认值（比如 20 字节）
@@ -199,9 +260,43 @@ func CalculateMaxSensitiveWordLength(cfg *config.AiDataMaskingConfig) int {
 		maxLen = maxWordLen * 3 * 2 // byte 中文占3个字节，英文占1个字节，2倍冗余
 	}
 
+	// 拦截正则的命中同样可能跨越窗口边界，按正则单次命中的最大长度保留，2倍冗余
+	if patternLen := maxDenyPatternLength(cfg) * 2; patternLen > maxLen {
+		maxLen = patternLen
+	}
+
 	return maxLen
 }
 
+// streamOverlapLength 返回流式处理需要保留的重叠边界长度（字节数）
+// 配置解析时已按自定义敏感词和拦截正则计算，系统词库刷新后最长词可能变化，开启 system_deny 时按当前词库重新比较
+func streamOverlapLength(cfg *config.AiDataMaskingConfig) int {
+	maxLen := cfg.MaxSensitiveWordLength
+	if systemLen := config.GetSystemDenyWordMaxLength() * 3 * 2; cfg.SystemDeny && systemLen > maxLen {
+		maxLen = systemLen
+	}
+	return maxLen
+}
+
+// streamKeepStart 计算流式文本缓冲区保留末尾 keepLen 字节时的起始位置
+// 敏感词在归一化文本上匹配，原文中被剔除的分隔符、零宽字符不占用保留长度：
+// 按归一化文本的末尾 keepLen 字节映射回原文的起始位置，拦截正则在原文上匹配，同时保留原文的末尾 keepLen 字节
+func streamKeepStart(text string, keepLen int, opts *config.NormalizeConfig) int {
+	keepStart := len(text) - keepLen
+	if keepStart <= 0 {
+		return 0
+	}
+	normalized := NormalizeText(text, opts)
+	cut := len(normalized.Text) - keepLen
+	if cut <= 0 {
+		return 0
+	}
+	if start, _ := normalized.OriginalRange(cut, cut+1); start < keepStart {
+		keepStart = start
+	}
+	return keepStart
+}
+
 func PrintConfig(cfg *config.AiDataMaskingConfig) []byte {
 	b, _ := json.MarshalIndent(cfg, "", "  ")
 	return b
@@ -15,16 +15,16 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
-	"regexp"
+	"net/url"
+	"strconv"
 	"strings"
 
 	"ai-data-masking/config"
 	"ai-data-masking/lib"
 	"ai-data-masking/wlog"
 
-	"github.com/google/uuid"
 	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
 	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
 	"github.com/higress-group/wasm-go/pkg/wrapper"
@@ -48,6 +48,11 @@ func init() {
 
 const (
 	pluginName = "ai-data-masking"
+
+	defaultSystemDenyRefreshInterval = 5 * 60 * 1000 // 系统词库默认刷新间隔（毫秒）
+	defaultSystemDenyTimeout         = 3000          // 系统词库默认请求超时（毫秒）
+
+	requestModifiedHeader = "x-ai-data-masking-request-modified" // 请求中的拦截词被替换后转发时添加的响应头
 )
 
 func parseConfig(json gjson.Result, cfg *config.AiDataMaskingConfig) error {
@@ -57,7 +62,37 @@ func parseConfig(json gjson.Result, cfg *config.AiDataMaskingConfig) error {
 		cfg.DenyOpenAI = true // 默认值
 	}
 
+	cfg.DenyAnthropic = json.Get("deny_anthropic").Bool()
+	if !json.Get("deny_anthropic").Exists() {
+		cfg.DenyAnthropic = true // 默认值
+	}
+
+	cfg.DenyGemini = json.Get("deny_gemini").Bool()
+	if !json.Get("deny_gemini").Exists() {
+		cfg.DenyGemini = true // 默认值
+	}
+
+	cfg.StreamNDJSONPath = json.Get("stream_ndjson_path").String()
+	if cfg.StreamNDJSONPath == "" {
+		cfg.StreamNDJSONPath = "message.content" // 默认值，Ollama /api/chat
+	}
+
+	cfg.StripAcceptEncoding = json.Get("strip_accept_encoding").Bool()
+
+	// 解析 deny_image
+	cfg.DenyImage = json.Get("deny_image").String()
+	if cfg.DenyImage == "" {
+		cfg.DenyImage = config.DenyImageNone
+	} else if !config.IsValidDenyImage(cfg.DenyImage) {
+		proxywasm.LogWarnf("invalid deny_image %s, fallback to %s", cfg.DenyImage, config.DenyImageNone)
+		cfg.DenyImage = config.DenyImageNone
+	}
+
 	cfg.DenyRaw = json.Get("deny_raw").Bool()
+	cfg.RawPartMaxBytes = int(json.Get("raw_part_max_bytes").Int())
+	if cfg.RawPartMaxBytes <= 0 {
+		cfg.RawPartMaxBytes = config.DefaultRawPartMaxBytes // 默认值
+	}
 	cfg.SystemDeny = json.Get("system_deny").Bool()
 
 	// 解析 deny_code
@@ -76,7 +111,7 @@ func parseConfig(json gjson.Result, cfg *config.AiDataMaskingConfig) error {
 	// 解析 deny_raw_message
 	cfg.DenyRawMessage = json.Get("deny_raw_message").String()
 	if cfg.DenyRawMessage == "" {
-		cfg.DenyRawMessage = `{"errmsg":"提问或回答中包含敏感词，已被屏蔽"}`
+		cfg.DenyRawMessage = `{"errmsg":"${message}"}`
 	}
 
 	// 解析 deny_content_type
@@ -93,14 +128,106 @@ func parseConfig(json gjson.Result, cfg *config.AiDataMaskingConfig) error {
 		}
 	}
 
-	// 解析 deny_words
+	// 解析 response_jsonpath
+	for _, item := range json.Get("response_jsonpath").Array() {
+		path := item.String()
+		if path != "" {
+			cfg.ResponseJSONPath = append(cfg.ResponseJSONPath, path)
+		}
+	}
+
+	// 解析 deny_words，支持字符串或带分类、严重级别、动作的对象
 	for _, item := range json.Get("deny_words").Array() {
+		entry := config.DenyWordEntry{}
+		if item.IsObject() {
+			entry.Word = strings.TrimSpace(item.Get("word").String())
+			entry.Category = item.Get("category").String()
+			entry.Severity = int(item.Get("severity").Int())
+			entry.Action = item.Get("action").String()
+		} else {
+			entry.Word = strings.TrimSpace(item.String())
+		}
+		if entry.Word == "" {
+			continue
+		}
+		if entry.Action == "" {
+			entry.Action = config.DenyActionBlock
+		} else if !config.IsValidDenyAction(entry.Action) {
+			proxywasm.LogWarnf("invalid action %s for deny word %s, fallback to %s", entry.Action, entry.Word, config.DenyActionBlock)
+			entry.Action = config.DenyActionBlock
+		}
+		cfg.DenyWords = append(cfg.DenyWords, entry.Word)
+		cfg.DenyWordEntries = append(cfg.DenyWordEntries, entry)
+	}
+
+	// 解析 grok_patterns（自定义 GROK 规则，需在编译各类正则之前解析）
+	if grokJson := json.Get("grok_patterns"); grokJson.IsObject() {
+		cfg.GrokPatterns = make(map[string]string)
+		grokJson.ForEach(func(key, value gjson.Result) bool {
+			cfg.GrokPatterns[key.String()] = value.String()
+			return true
+		})
+		for name := range cfg.GrokPatterns {
+			if _, err := lib.CompileGrok("%{"+name+"}", cfg.GrokPatterns); err != nil {
+				proxywasm.LogWarnf("invalid grok pattern %s: %v", name, err)
+			}
+		}
+	}
+
+	// 解析 deny_patterns（支持 GROK 模式）
+	for _, item := range json.Get("deny_patterns").Array() {
+		pattern := item.String()
+		if pattern == "" {
+			continue
+		}
+		compiled, err := lib.CompileGrok(pattern, cfg.GrokPatterns)
+		if err != nil {
+			proxywasm.LogWarnf("failed to compile deny pattern %s: %v", pattern, err)
+			continue
+		}
+		cfg.DenyPatterns = append(cfg.DenyPatterns, pattern)
+		cfg.CompiledDenyPatterns = append(cfg.CompiledDenyPatterns, compiled)
+	}
+
+	// 解析 allow_words
+	for _, item := range json.Get("allow_words").Array() {
 		word := strings.TrimSpace(item.String())
 		if word != "" {
-			cfg.DenyWords = append(cfg.DenyWords, word)
+			cfg.AllowWords = append(cfg.AllowWords, word)
+		}
+	}
+
+	// 解析 allow_patterns（支持 GROK 模式）
+	for _, item := range json.Get("allow_patterns").Array() {
+		pattern := item.String()
+		if pattern == "" {
+			continue
+		}
+		compiled, err := lib.CompileGrok(pattern, cfg.GrokPatterns)
+		if err != nil {
+			proxywasm.LogWarnf("failed to compile allow pattern %s: %v", pattern, err)
+			continue
+		}
+		cfg.AllowPatterns = append(cfg.AllowPatterns, pattern)
+		cfg.CompiledAllowPatterns = append(cfg.CompiledAllowPatterns, compiled)
+	}
+
+	// 解析 normalize（匹配前的文本归一化）
+	normalizeJson := json.Get("normalize")
+	if normalizeJson.Exists() {
+		cfg.Normalize = config.NormalizeConfig{
+			NFKC:                    normalizeJson.Get("nfkc").Bool(),
+			CaseFold:                normalizeJson.Get("case_fold").Bool(),
+			FullWidth:               normalizeJson.Get("full_width").Bool(),
+			TraditionalToSimplified: normalizeJson.Get("traditional_to_simplified").Bool(),
+			StripSeparators:         normalizeJson.Get("strip_separators").Bool(),
+			StripZeroWidth:          normalizeJson.Get("strip_zero_width").Bool(),
 		}
 	}
 
+	// 解析 mask_secret（hmac、fpe 规则使用的密钥）
+	cfg.MaskSecret = json.Get("mask_secret").String()
+
 	// 解析 replace_roles
 	for _, item := range json.Get("replace_roles").Array() {
 		rule := config.Rule{
@@ -108,12 +235,19 @@ func parseConfig(json gjson.Result, cfg *config.AiDataMaskingConfig) error {
 			Type:    item.Get("type").String(),
 			Restore: item.Get("restore").Bool(),
 			Value:   item.Get("value").String(),
+			Label:   item.Get("label").String(),
+			Length:  int(item.Get("length").Int()),
+		}
+		rule.MaskOptions = parseMaskOptions(item)
+
+		// 识别器类型未配置 regex 时使用内置候选正则
+		if rule.Regex == "" {
+			rule.Regex = lib.DetectorPattern(rule.Type)
 		}
 
 		// 编译正则表达式（支持 GROK 模式）
 		if rule.Regex != "" {
-			pattern := convertGrokToRegex(rule.Regex)
-			compiled, err := regexp.Compile(pattern)
+			compiled, err := lib.CompileGrok(rule.Regex, cfg.GrokPatterns)
 			if err != nil {
 				proxywasm.LogWarnf("failed to compile regex %s: %v", rule.Regex, err)
 				continue
@@ -121,21 +255,31 @@ func parseConfig(json gjson.Result, cfg *config.AiDataMaskingConfig) error {
 			rule.CompiledRegex = compiled
 		}
 
+		// hmac、fpe 规则必须配置密钥：各 Worker 与每次配置加载使用相同的密钥，相同的值才能得到相同的结果
+		if (rule.Type == config.RuleTypeHMAC || rule.Type == config.RuleTypeFPE) && cfg.MaskSecret == "" {
+			return fmt.Errorf("mask_secret is required for %s rules", rule.Type)
+		}
+
 		cfg.ReplaceRoles = append(cfg.ReplaceRoles, rule)
 	}
 
 	// 解析 deny_plot
-	denyPlotJson := json.Get("deny_plot")
-	if denyPlotJson.Exists() {
-		cfg.ResponseDenyPlot.Plot = denyPlotJson.Get("plot").String()
-		if cfg.ResponseDenyPlot.Plot == "" {
-			cfg.ResponseDenyPlot.Plot = "stop" // 默认值
-		}
-		cfg.ResponseDenyPlot.Value = denyPlotJson.Get("value").String()
+	if denyPlotJson := json.Get("deny_plot"); denyPlotJson.Exists() {
+		cfg.ResponseDenyPlot = parseDenyPlot(denyPlotJson)
+	}
+
+	// 解析 request_deny_plot
+	if requestDenyPlotJson := json.Get("request_deny_plot"); requestDenyPlotJson.Exists() {
+		cfg.RequestDenyPlot = parseDenyPlot(requestDenyPlotJson)
+	}
+
+	// 解析 system_deny_source，启动后立即拉取系统词库并定时刷新
+	if err := parseSystemDenySource(json.Get("system_deny_source"), cfg); err != nil {
+		return err
 	}
 
 	// 计算最长敏感词长度（在启动时计算，避免流处理时重复计算）
-	config.MaxSensitiveWordLength = lib.CalculateMaxSensitiveWordLength(cfg)
+	cfg.MaxSensitiveWordLength = lib.CalculateMaxSensitiveWordLength(cfg)
 
 	MaxBufferChunkCount := json.Get("max_buffer_chunk_count").Uint()
 	if MaxBufferChunkCount == 0 {
@@ -144,6 +288,13 @@ func parseConfig(json gjson.Result, cfg *config.AiDataMaskingConfig) error {
 		cfg.MaxBufferChunkCount = uint32(MaxBufferChunkCount)
 	}
 
+	// 解析 stream_holdback（流式响应只暂缓输出末尾可能组成敏感词的文本）
+	cfg.StreamHoldback = json.Get("stream_holdback").Bool()
+	cfg.StreamHoldbackMaxDelay = uint32(json.Get("stream_holdback_max_delay").Uint())
+	if !json.Get("stream_holdback_max_delay").Exists() {
+		cfg.StreamHoldbackMaxDelay = config.DefaultStreamHoldbackMaxDelay
+	}
+
 	MaxStreamChunkBufferLen := json.Get("max_stream_chunk_buffer_len").Uint()
 	if MaxStreamChunkBufferLen == 0 {
 		cfg.MaxStreamChunkBufferLen = config.DefaultMaxStreamChunkBufferLen
@@ -153,39 +304,124 @@ func parseConfig(json gjson.Result, cfg *config.AiDataMaskingConfig) error {
 	// 打印所有配置的 JSON（使用 gjson 的 Raw 字段获取原始 JSON）
 
 	wlog.LogWithLine("[%s] Configuration:\n%s", pluginName, string(lib.PrintConfig(cfg)))
-	wlog.LogWithLine("[%s] 最大敏感词重叠边界长度: %d", pluginName, config.MaxSensitiveWordLength)
+	wlog.LogWithLine("[%s] 最大敏感词重叠边界长度: %d", pluginName, cfg.MaxSensitiveWordLength)
 	wlog.LogWithLine("[%s] 最长敏感词检测chunk个数: %d", pluginName, cfg.MaxBufferChunkCount)
 	wlog.LogWithLine("[%s] 最长敏感词检测chunk大小: %d", pluginName, cfg.MaxStreamChunkBufferLen)
 
 	return nil
 }
 
-// convertGrokToRegex 将 GROK 模式转换为正则表达式（简化版）
-func convertGrokToRegex(grokPattern string) string {
-	// 这里实现 GROK 到正则的转换
-	// 简化实现，支持常见的 GROK 模式
-	patterns := map[string]string{
-		"%{MOBILE}":                            `\d{8,11}`,
-		"%{IDCARD}":                            `\d{17}[0-9xX]|\d{15}`,
-		"%{IP}":                                `\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3}`,
-		"%{EMAILLOCALPART}":                    `[a-zA-Z0-9._%+-]+`,
-		"%{HOSTNAME:domain}":                   `([a-zA-Z0-9.-]+)`,
-		"%{EMAILLOCALPART}@%{HOSTNAME:domain}": `[a-zA-Z0-9._%+-]+@([a-zA-Z0-9.-]+)`,
+// parseDenyPlot 解析命中拦截词时的处理方式（plot、value、type 及部分掩码配置）
+func parseDenyPlot(json gjson.Result) config.DenyPlot {
+	plot := config.DenyPlot{
+		Plot:        json.Get("plot").String(),
+		Value:       json.Get("value").String(),
+		Type:        json.Get("type").String(),
+		MaskOptions: parseMaskOptions(json),
+	}
+	if plot.Plot == "" {
+		plot.Plot = "stop" // 默认值
+	}
+	if plot.Type == "" {
+		plot.Type = config.DenyPlotTypeValue
+	}
+	return plot
+}
+
+// parseMaskOptions 解析部分掩码配置（keep_left、keep_right、mask_char）
+func parseMaskOptions(json gjson.Result) config.MaskOptions {
+	return config.MaskOptions{
+		KeepLeft:  int(json.Get("keep_left").Int()),
+		KeepRight: int(json.Get("keep_right").Int()),
+		MaskChar:  json.Get("mask_char").String(),
 	}
+}
 
-	// 检查是否有预定义的模式
-	if pattern, ok := patterns[grokPattern]; ok {
-		return pattern
+// parseSystemDenySource 解析系统敏感词库远程来源，并注册定时刷新任务
+// 来源可以是 url，也可以是 service_name 与 path；未开启 system_deny 时系统词库不参与匹配，不注册刷新任务
+// 定时任务首次执行时间为插件启动后的第一个 tick，之后每 refresh_interval 毫秒刷新一次
+func parseSystemDenySource(json gjson.Result, cfg *config.AiDataMaskingConfig) error {
+	if !json.Exists() {
+		return nil
+	}
+	if !cfg.SystemDeny {
+		proxywasm.LogWarnf("system_deny_source is ignored because system_deny is disabled")
+		return nil
 	}
 
-	// 简单的 GROK 模式替换
-	result := grokPattern
-	for grok, regex := range patterns {
-		result = strings.ReplaceAll(result, grok, regex)
+	source := &cfg.SystemDenySource
+	source.URL = json.Get("url").String()
+	source.ServiceName = json.Get("service_name").String()
+	source.ServicePort = json.Get("service_port").Int()
+	source.ServiceHost = json.Get("service_host").String()
+	source.Path = json.Get("path").String()
+	source.Format = json.Get("format").String()
+	source.JSONPath = json.Get("json_path").String()
+	source.RefreshInterval = json.Get("refresh_interval").Int()
+	source.Timeout = uint32(json.Get("timeout").Uint())
+
+	if source.URL != "" {
+		if err := parseSystemDenyURL(source); err != nil {
+			return err
+		}
+	}
+	if source.ServiceName == "" || source.Path == "" {
+		return errors.New("system_deny_source: url, or service_name and path are required")
+	}
+	if source.ServicePort == 0 {
+		if strings.HasSuffix(source.ServiceName, ".static") {
+			source.ServicePort = 80
+		} else {
+			source.ServicePort = 443
+		}
+	}
+	if source.Format == "" {
+		source.Format = config.SystemDenyFormatText
+	} else if source.Format != config.SystemDenyFormatText && source.Format != config.SystemDenyFormatJSON {
+		return fmt.Errorf("system_deny_source: unsupported format %s", source.Format)
+	}
+	if source.RefreshInterval <= 0 {
+		source.RefreshInterval = defaultSystemDenyRefreshInterval
+	}
+	if source.Timeout == 0 {
+		source.Timeout = defaultSystemDenyTimeout
 	}
 
-	// 如果没有匹配，返回原始字符串（可能是标准正则）
-	return result
+	source.Client = wrapper.NewClusterClient(wrapper.FQDNCluster{
+		FQDN: source.ServiceName,
+		Host: source.ServiceHost,
+		Port: source.ServicePort,
+	})
+	wrapper.RegisterTickFunc(source.RefreshInterval, func() {
+		lib.RefreshSystemDenyWords(cfg)
+	})
+	return nil
+}
+
+// parseSystemDenyURL 按 url 补全系统词库来源：服务名默认为 url 的主机名，端口默认按协议为 80/443，
+// Host 为 url 的主机，路径为 url 的路径和查询参数；显式配置的 service_name、service_port、service_host 优先
+func parseSystemDenyURL(source *config.SystemDenySource) error {
+	u, err := url.Parse(source.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("system_deny_source: invalid url %s", source.URL)
+	}
+	if source.ServiceName == "" {
+		source.ServiceName = u.Hostname()
+	}
+	if source.ServicePort == 0 {
+		if port := u.Port(); port != "" {
+			source.ServicePort, _ = strconv.ParseInt(port, 10, 64)
+		} else if u.Scheme == "http" {
+			source.ServicePort = 80
+		} else {
+			source.ServicePort = 443
+		}
+	}
+	if source.ServiceHost == "" {
+		source.ServiceHost = u.Host
+	}
+	source.Path = u.RequestURI()
+	return nil
 }
 
 // getOrCreatePluginContext 获取或创建插件上下文
@@ -211,12 +447,46 @@ func getOrCreatePluginContext(ctx wrapper.HttpContext, cfg *config.AiDataMasking
 	ctx.SetContext(contextKey, pluginCtx)
 	return pluginCtx
 }
+
+// setMaskingAttributes 设置 x-ai-data-masking 及命中分类相关的用户属性
+// 命中的敏感词配置了分类时，x-ai-data-masking 的值追加 ";category=<分类>"
+func setMaskingAttributes(ctx wrapper.HttpContext, pluginCtx *config.PluginContext, modifyType config.DenyModifyType) {
+	maskingValue := string(modifyType)
+	if pluginCtx.DenyCategory != "" {
+		maskingValue = fmt.Sprintf("%s;category=%s", modifyType, pluginCtx.DenyCategory)
+		ctx.SetUserAttribute("deny_category", pluginCtx.DenyCategory)
+		ctx.SetUserAttribute("deny_severity", fmt.Sprintf("%d", pluginCtx.DenySeverity))
+	}
+	ctx.SetUserAttribute("x-ai-data-masking", maskingValue)
+}
+
 func onHttpRequestHeaders(ctx wrapper.HttpContext, cfg config.AiDataMaskingConfig) types.Action {
 	// 禁用重路由
 	ctx.DisableReroute()
 	pluginCtx := getOrCreatePluginContext(ctx, &cfg)
 	pluginCtx.Step = config.StepRequestHeader
 	wlog.LogWithLine("[%s] Process Step: %s", pluginName, pluginCtx.Step.String())
+	// 按请求路径判断 API 协议
+	if cfg.DenyAnthropic && lib.IsAnthropicPath(ctx.Path()) {
+		pluginCtx.Protocol = config.APIProtocolAnthropic
+	} else if cfg.DenyOpenAI && lib.IsResponsesPath(ctx.Path()) {
+		pluginCtx.Protocol = config.APIProtocolResponses
+	} else if cfg.DenyGemini && lib.IsGeminiPath(ctx.Path()) {
+		// Gemini 的模型与是否流式由请求路径决定
+		pluginCtx.Protocol = config.APIProtocolGemini
+		lib.SetGeminiRequestInfo(pluginCtx, ctx.Path())
+	} else if cfg.DenyOpenAI && lib.IsCompletionsPath(ctx.Path()) {
+		pluginCtx.Protocol = config.APIProtocolCompletions
+	} else if cfg.DenyOpenAI && lib.IsEmbeddingsPath(ctx.Path()) {
+		pluginCtx.Protocol = config.APIProtocolEmbeddings
+	}
+	pluginCtx.RequestContentType, _ = proxywasm.GetHttpRequestHeader("content-type")
+	// 拒绝消息模板中的 ${request_id}
+	pluginCtx.RequestID, _ = proxywasm.GetHttpRequestHeader("x-request-id")
+	// 要求上游返回未压缩的响应，上游仍然压缩时在响应阶段解压；不检查响应的请求保持原样
+	if cfg.StripAcceptEncoding && lib.ResponseInspected(pluginCtx) {
+		proxywasm.RemoveHttpRequestHeader("accept-encoding")
+	}
 	// 检查是否有请求体
 	contentLength, err := proxywasm.GetHttpRequestHeader("content-length")
 	if err == nil && contentLength != "0" && contentLength != "" {
@@ -237,18 +507,45 @@ func onHttpRequestBody(ctx wrapper.HttpContext, cfg config.AiDataMaskingConfig,
 	pluginCtx.Step = config.StepRequestBody
 	wlog.LogWithLine("[%s] Process Step: %s", pluginName, pluginCtx.Step.String())
 	ctx.SetRequestBodyBufferLimit(config.DEFAULT_MAX_BODY_BYTES)
-	// 如果配置了OpenAI拒绝，则处理OpenAI请求
-	if cfg.DenyOpenAI {
+	// 如果是 Anthropic Messages、OpenAI Responses 或 Gemini 请求，按对应协议处理；否则如果配置了OpenAI拒绝，则处理OpenAI请求
+	if pluginCtx.Protocol.HasAdapter() {
+		var modified bool
+		var denied bool
+		body, modified, denied = lib.ProcessProtocolRequest(ctx, pluginCtx, body)
+		if denied {
+			pluginCtx.IsDeny = true
+			pluginCtx.IsRequestDeny = true
+			pluginCtx.RequestDenyModifyType = lib.ProtocolModifyType(pluginCtx)
+			setMaskingAttributes(ctx, pluginCtx, pluginCtx.RequestDenyModifyType)
+			ctx.SetUserAttribute("deny_step", pluginCtx.Step.String())
+			ctx.SetUserAttribute("deny_code", fmt.Sprintf("%d", cfg.DenyCode))
+
+			// 设置标志，表示响应已在请求阶段发送，响应阶段的回调应该跳过处理
+			ctx.SetUserAttribute("response_sent_in_request", "true")
+			// 按请求协议构造拒绝响应，流式请求返回完整的 SSE 事件序列
+			ctx.SetUserAttribute("deny_message", lib.ProtocolDenyResponse(pluginCtx, lib.RenderDenyMessage(pluginCtx), pluginCtx.OpenAIRequest.Stream))
+			wlog.LogWithLine("[%s] onHttpRequestBody DenyModifyType:%s Stream:%v deny() called: deny_message=%s",
+				pluginName, pluginCtx.RequestDenyModifyType, pluginCtx.OpenAIRequest.Stream, cfg.DenyMessage)
+
+			return lib.DenyHandler(ctx, pluginCtx)
+		}
+
+		if modified {
+			pluginCtx.IsModified = true
+			pluginCtx.RequestDenyModifyType = lib.ProtocolModifyType(pluginCtx)
+			proxywasm.ReplaceHttpRequestBody(body)
+		}
+	} else if cfg.DenyOpenAI {
 		var modified bool
 		var denied bool
 		// 请求体阶段处理OpenAI请求
-		modified, denied = lib.ProcessOpenAIRequest(ctx, pluginCtx, body)
+		body, modified, denied = lib.ProcessOpenAIRequest(ctx, pluginCtx, body)
 		// 如果匹配到敏感词
 		if denied {
 			pluginCtx.IsDeny = true
 			pluginCtx.IsRequestDeny = true
 			pluginCtx.RequestDenyModifyType = config.DenyModifyTypeOpenAI
-			ctx.SetUserAttribute("x-ai-data-masking", string(pluginCtx.RequestDenyModifyType))
+			setMaskingAttributes(ctx, pluginCtx, pluginCtx.RequestDenyModifyType)
 			ctx.SetUserAttribute("deny_step", pluginCtx.Step.String())
 			ctx.SetUserAttribute("deny_code", fmt.Sprintf("%d", cfg.DenyCode))
 
@@ -257,50 +554,12 @@ func onHttpRequestBody(ctx wrapper.HttpContext, cfg config.AiDataMaskingConfig,
 
 			var openaiResponseJson []byte
 			// 根据是否为流式请求构造不同的响应格式
-			if pluginCtx.OpenAIRequest.Stream {
-				// 流式响应：使用 SSE 格式
-				streamResponse := config.OpenAIStreamCompletionResponse{
-					Id:      uuid.New().String(),
-					Object:  "chat.completion.chunk",
-					Created: 123,
-					Model:   pluginCtx.OpenAIRequest.Model,
-					Choices: []config.OpenAIStreamChoice{
-						{
-							Index: 0,
-							Delta: &config.OpenAIMessage{
-								Role:    "assistant",
-								Content: cfg.DenyMessage,
-							},
-							FinishReason: config.FINISH_REASON_STOP,
-						},
-					},
-				}
-				streamJson, _ := json.Marshal(streamResponse)
-				// SSE 格式：data: {...}\n\ndata:[DONE]\n\n
-				openaiResponseJson = []byte(fmt.Sprintf("data: %s\n\ndata: [DONE]\n\n", string(streamJson)))
+			if pluginCtx.Protocol.IsOpenAILegacy() {
+				// Completions、Embeddings：按对应接口的响应格式构造
+				openaiResponseJson = lib.ProtocolDenyResponse(pluginCtx, lib.RenderDenyMessage(pluginCtx), pluginCtx.OpenAIRequest.Stream)
 			} else {
-				// 非流式响应
-				openaiResponse := config.OpenAICompletionResponse{
-					Id:      uuid.New().String(),
-					Object:  "chat.completion",
-					Created: 123,
-					Model:   pluginCtx.OpenAIRequest.Model,
-					Choices: []config.OpenAICompletionChoice{
-						{
-							Index: 0,
-							Message: &config.OpenAIMessage{
-								Role:    "assistant",
-								Content: cfg.DenyMessage,
-							},
-						},
-					},
-					Usage: &config.OpenAIUsage{
-						PromptTokens:     0,
-						CompletionTokens: 0,
-						TotalTokens:      0,
-					},
-				}
-				openaiResponseJson, _ = json.Marshal(openaiResponse) // []byte
+				// 流式请求返回 SSE 格式（data: {...}\n\ndata: [DONE]\n\n）
+				openaiResponseJson = lib.OpenAIDenyResponse(pluginCtx.OpenAIRequest.Model, lib.RenderDenyMessage(pluginCtx), pluginCtx.OpenAIRequest.Stream)
 			}
 			ctx.SetUserAttribute("deny_message", openaiResponseJson)
 			wlog.LogWithLine("[%s] onHttpRequestBody: pluginCtx.OpenAIRequest.Model=%s", pluginName, pluginCtx.OpenAIRequest.Model)
@@ -322,23 +581,18 @@ func onHttpRequestBody(ctx wrapper.HttpContext, cfg config.AiDataMaskingConfig,
 		var modified bool
 		var denied bool
 
-		modified, denied = lib.ProcessJSONPathRequest(ctx, pluginCtx, body)
+		body, modified, denied = lib.ProcessJSONPathRequest(ctx, pluginCtx, body)
 		if denied {
 			pluginCtx.IsDeny = true
 			pluginCtx.IsRequestDeny = true
 			pluginCtx.RequestDenyModifyType = config.DenyModifyTypeJSONPath
 
-			ctx.SetUserAttribute("x-ai-data-masking", string(pluginCtx.RequestDenyModifyType))
+			setMaskingAttributes(ctx, pluginCtx, pluginCtx.RequestDenyModifyType)
 			ctx.SetUserAttribute("deny_step", pluginCtx.Step.String())
 			ctx.SetUserAttribute("deny_code", fmt.Sprintf("%d", cfg.DenyCode))
 
-			jsonPathResponse := config.JSONPathResponse{
-				Code:    cfg.DenyCode,
-				Message: cfg.DenyMessage,
-				Data:    map[string]interface{}{},
-			}
-			jsonPathResponseJson, _ := json.Marshal(jsonPathResponse)
-			ctx.SetUserAttribute("deny_message", []byte(jsonPathResponseJson))
+			// 按 deny_raw_message 模板构造拒绝响应
+			ctx.SetUserAttribute("deny_message", lib.RenderDenyRawMessage(pluginCtx))
 			// 设置标志，表示响应已在请求阶段发送，响应阶段的回调应该跳过处理
 			ctx.SetUserAttribute("response_sent_in_request", "true")
 			wlog.LogWithLine("[%s] onHttpRequestBody JSONPath:%b deny() called: deny_message=%s", pluginName, pluginCtx.RequestDenyModifyType, cfg.DenyMessage)
@@ -355,21 +609,16 @@ func onHttpRequestBody(ctx wrapper.HttpContext, cfg config.AiDataMaskingConfig,
 	if cfg.DenyRaw {
 		var modified bool
 		var denied bool
-		modified, denied = lib.ProcessRawRequest(ctx, pluginCtx, body)
+		body, modified, denied = lib.ProcessRawRequest(ctx, pluginCtx, body)
 		if denied {
 			pluginCtx.IsDeny = true
 			pluginCtx.IsRequestDeny = true
 			pluginCtx.RequestDenyModifyType = config.DenyModifyTypeRaw
-			ctx.SetUserAttribute("x-ai-data-masking", string(pluginCtx.RequestDenyModifyType))
+			setMaskingAttributes(ctx, pluginCtx, pluginCtx.RequestDenyModifyType)
 			ctx.SetUserAttribute("deny_step", pluginCtx.Step.String())
 			ctx.SetUserAttribute("deny_code", fmt.Sprintf("%d", cfg.DenyCode))
-			rawResponse := config.RawResponse{
-				Code:    cfg.DenyCode,
-				Message: cfg.DenyMessage,
-				Data:    map[string]interface{}{},
-			}
-			rawResponseJson, _ := json.Marshal(rawResponse)
-			ctx.SetUserAttribute("deny_message", []byte(rawResponseJson))
+			// 按 deny_raw_message 模板构造拒绝响应
+			ctx.SetUserAttribute("deny_message", lib.RenderDenyRawMessage(pluginCtx))
 			// 设置标志，表示响应已在请求阶段发送，响应阶段的回调应该跳过处理
 			ctx.SetUserAttribute("response_sent_in_request", "true")
 			wlog.LogWithLine("[%s] onHttpRequestBody Raw:%b deny() called: deny_message=%s", pluginName, pluginCtx.RequestDenyModifyType, cfg.DenyMessage)
@@ -381,6 +630,12 @@ func onHttpRequestBody(ctx wrapper.HttpContext, cfg config.AiDataMaskingConfig,
 			proxywasm.ReplaceHttpRequestBody(body)
 		}
 	}
+	// request_deny_plot 为 replace 时命中的拦截词已替换后转发，记录命中信息，响应头阶段告知客户端
+	if pluginCtx.IsRequestModified {
+		setMaskingAttributes(ctx, pluginCtx, pluginCtx.RequestDenyModifyType)
+		ctx.SetUserAttribute("deny_step", pluginCtx.Step.String())
+		ctx.SetUserAttribute("deny_plot", pluginCtx.Config.RequestDenyPlot.Plot)
+	}
 	// 同步处理完成，继续传递请求到下游
 	return types.ActionContinue
 }
@@ -390,6 +645,10 @@ func onHttpResponseHeaders(ctx wrapper.HttpContext, cfg config.AiDataMaskingConf
 	pluginCtx.Step = config.StepRespHeader
 
 	wlog.LogWithLine("[%s] Process Step: %s", pluginName, pluginCtx.Step.String())
+	// 请求中的拦截词已按 request_deny_plot 替换，告知客户端提问被改写
+	if pluginCtx.IsRequestModified {
+		proxywasm.AddHttpResponseHeader(requestModifiedHeader, "true")
+	}
 	// 检查响应是否来自上游（如果是在请求阶段通过 SendHttpResponse 发送的，则不是来自上游）
 	if !wrapper.IsResponseFromUpstream() {
 		// 响应不是来自上游（可能是我们在请求阶段发送的），直接跳过处理
@@ -412,17 +671,29 @@ func onHttpResponseHeaders(ctx wrapper.HttpContext, cfg config.AiDataMaskingConf
 	// Envoy 会根据以下条件判断是否为流式响应：
 	// 1. Transfer-Encoding: chunked 存在
 	// 2. Content-Length 不存在或为 0
-	// 3. Content-Type 为 text/event-stream (SSE)
+	// 3. Content-Type 为 text/event-stream (SSE) 或 application/x-ndjson (NDJSON)
 	isChunked := transferEncoding == "chunked"
 	isSSE := strings.Contains(contentType, "text/event-stream")
-	isStreaming := isSSE
+	isNDJSON := lib.IsNDJSONContentType(contentType)
+	isStreaming := isSSE || isNDJSON
 
 	// 设置流式响应标志，供 onHttpStreamingResponseBody 使用
 	ctx.SetUserAttribute("is_streaming_response", fmt.Sprintf("%v", isStreaming))
 	pluginCtx.RespIsSSE = isSSE
-
-	wlog.LogWithLine("[%s] onHttpResponseHeaders: Transfer-Encoding=%s, Content-Type=%s, isChunked=%v, isSSE=%v, isStreaming=%v",
-		pluginName, transferEncoding, contentType, isChunked, isSSE, isStreaming)
+	pluginCtx.RespIsNDJSON = isNDJSON
+
+	wlog.LogWithLine("[%s] onHttpResponseHeaders: Transfer-Encoding=%s, Content-Type=%s, isChunked=%v, isSSE=%v, isNDJSON=%v, isStreaming=%v",
+		pluginName, transferEncoding, contentType, isChunked, isSSE, isNDJSON, isStreaming)
+
+	// 需要检查的压缩响应解压后再检查，不支持的编码在响应体阶段按拦截处理
+	if contentEncoding, _ := proxywasm.GetHttpResponseHeader("content-encoding"); lib.ResponseInspected(pluginCtx) {
+		pluginCtx.RespContentEncoding = lib.NormalizeContentEncoding(contentEncoding)
+		if pluginCtx.RespContentEncoding != "" && isStreaming {
+			// 流式响应头先于响应体发出，输出解压后的内容或拒绝事件，在此移除压缩相关的响应头
+			proxywasm.RemoveHttpResponseHeader("content-encoding")
+			proxywasm.RemoveHttpResponseHeader("content-length")
+		}
+	}
 
 	// 如果不是流式响应，需要缓冲响应体，这样 wrapper 会调用 onHttpResponseBody 而不是 onHttpStreamingResponseBody
 	if !isStreaming {
@@ -452,9 +723,54 @@ func onHttpResponseBody(ctx wrapper.HttpContext, cfg config.AiDataMaskingConfig,
 		return types.ActionContinue
 	}
 
+	// 解压压缩的响应体，输出解压后的内容；不支持的编码或解压失败时无法检查，按拦截处理，不返回未经检查的响应
+	if pluginCtx.RespContentEncoding != "" {
+		decoded, err := lib.DecodeBody(pluginCtx.RespContentEncoding, body)
+		if err != nil {
+			wlog.LogWithLine("[%s] onHttpResponseBody: failed to decode %s response body: %v", pluginName, pluginCtx.RespContentEncoding, err)
+			pluginCtx.DenyCategory = config.DenyCategoryEncoding
+			modifyType := lib.ResponseModifyType(pluginCtx)
+			markResponseDenied(ctx, pluginCtx, modifyType, "stop")
+			return denyNonStreamResponse(ctx, pluginCtx, modifyType)
+		}
+		proxywasm.RemoveHttpResponseHeader("content-encoding")
+		proxywasm.RemoveHttpResponseHeader("content-length")
+		proxywasm.ReplaceHttpResponseBody(decoded)
+		body = decoded
+	}
+
 	return processNonStreamResponse(ctx, cfg, body)
 }
 
+// markResponseDenied 记录响应被拦截的标志和用户属性
+func markResponseDenied(ctx wrapper.HttpContext, pluginCtx *config.PluginContext, modifyType config.DenyModifyType, denyPlot string) {
+	// 先设置 ResponseDenyModifyType，然后再设置属性
+	pluginCtx.ResponseDenyModifyType = modifyType
+	pluginCtx.IsDeny = true
+	pluginCtx.IsResponseDeny = true
+
+	// 设置用户属性（必须在设置 ResponseDenyModifyType 之后）
+	setMaskingAttributes(ctx, pluginCtx, pluginCtx.ResponseDenyModifyType)
+	ctx.SetUserAttribute("deny_step", pluginCtx.Step.String())
+	ctx.SetUserAttribute("deny_code", fmt.Sprintf("%d", pluginCtx.Config.DenyCode))
+	ctx.SetUserAttribute("deny_plot", denyPlot)
+}
+
+// denyNonStreamResponse 按 stop 策略以拒绝消息替换非流式响应
+func denyNonStreamResponse(ctx wrapper.HttpContext, pluginCtx *config.PluginContext, modifyType config.DenyModifyType) types.Action {
+	switch {
+	case modifyType == config.DenyModifyTypeJSONPath || modifyType == config.DenyModifyTypeRaw:
+		// 按 deny_raw_message 模板构造拒绝响应
+		ctx.SetUserAttribute("deny_message", lib.RenderDenyRawMessage(pluginCtx))
+	case pluginCtx.Protocol.HasAdapter() || pluginCtx.Protocol.IsOpenAILegacy():
+		ctx.SetUserAttribute("deny_message", lib.ProtocolDenyResponse(pluginCtx, lib.RenderDenyMessage(pluginCtx), false))
+	default:
+		ctx.SetUserAttribute("deny_message", lib.OpenAIDenyResponse(pluginCtx.OpenAIRequest.Model, lib.RenderDenyMessage(pluginCtx), false))
+	}
+	wlog.LogWithLine("[%s] denyNonStreamResponse: %s Response Denied (stop strategy)", pluginName, modifyType)
+	return lib.DenyHandler(ctx, pluginCtx)
+}
+
 // processNonStreamResponse 处理非流式响应
 func processNonStreamResponse(ctx wrapper.HttpContext, cfg config.AiDataMaskingConfig, body []byte) types.Action {
 	pluginCtx := getOrCreatePluginContext(ctx, &cfg)
@@ -462,10 +778,22 @@ func processNonStreamResponse(ctx wrapper.HttpContext, cfg config.AiDataMaskingC
 	wlog.LogWithLine("[%s] processNonStreamResponse: body length=%d, RequestDenyType=%v, RespIsSSE=%v, DenyOpenAI=%v, DenyRaw=%v",
 		pluginName, len(body), pluginCtx.RequestDenyModifyType, pluginCtx.RespIsSSE, pluginCtx.Config.DenyOpenAI, pluginCtx.Config.DenyRaw)
 
-	// 先处理 OpenAI JSON 响应（如果启用）,并且请求阶段是openai格式
-	if pluginCtx.Config.DenyOpenAI && pluginCtx.OpenAIRequest != nil {
-		wlog.LogWithLine("[%s] processNonStreamResponse: processing OpenAI response", pluginName)
-		modified, denied := lib.ProcessOpenAIResponse(ctx, pluginCtx, bodyStr, body)
+	// 请求未按 LLM 协议识别时，按 response_jsonpath 或 deny_raw 检查响应
+	modifyType := lib.ResponseModifyType(pluginCtx)
+
+	// 处理 OpenAI / Anthropic / Responses / Gemini JSON 响应（如果启用）,并且请求阶段是对应协议格式
+	if lib.ResponseInspected(pluginCtx) {
+		wlog.LogWithLine("[%s] processNonStreamResponse: processing %s response", pluginName, modifyType)
+		var newBody []byte
+		var modified, denied bool
+		switch modifyType {
+		case config.DenyModifyTypeJSONPath:
+			newBody, modified, denied = lib.ProcessJSONPathResponse(ctx, pluginCtx, bodyStr, body)
+		case config.DenyModifyTypeRaw:
+			newBody, modified, denied = lib.ProcessRawResponse(ctx, pluginCtx, bodyStr, body)
+		default:
+			newBody, modified, denied = lib.ProcessProtocolResponse(ctx, pluginCtx, bodyStr, body)
+		}
 
 		if denied {
 			// 根据拒绝策略处理
@@ -473,16 +801,7 @@ func processNonStreamResponse(ctx wrapper.HttpContext, cfg config.AiDataMaskingC
 			if denyPlot == "" {
 				denyPlot = "stop" // 默认值
 			}
-			// 先设置 ResponseDenyModifyType，然后再设置属性
-			pluginCtx.ResponseDenyModifyType = config.DenyModifyTypeOpenAI
-			pluginCtx.IsDeny = true
-			pluginCtx.IsResponseDeny = true
-
-			// 设置用户属性（必须在设置 ResponseDenyModifyType 之后）
-			ctx.SetUserAttribute("x-ai-data-masking", string(pluginCtx.ResponseDenyModifyType))
-			ctx.SetUserAttribute("deny_step", pluginCtx.Step.String())
-			ctx.SetUserAttribute("deny_code", fmt.Sprintf("%d", pluginCtx.Config.DenyCode))
-			ctx.SetUserAttribute("deny_plot", denyPlot)
+			markResponseDenied(ctx, pluginCtx, modifyType, denyPlot)
 
 			if denyPlot == "replace" {
 				wlog.LogWithLine("[%s] processNonStreamResponse: replaced sensitive words with value, continuing", pluginName)
@@ -490,60 +809,16 @@ func processNonStreamResponse(ctx wrapper.HttpContext, cfg config.AiDataMaskingC
 			}
 
 			// stop 策略：返回拒绝消息（默认行为，或 replace 策略解析失败时）
-			if denyPlot != "replace" {
-				// 设置 deny 相关标志和属性
-				pluginCtx.IsDeny = true
-				pluginCtx.IsResponseDeny = true
-				pluginCtx.ResponseDenyModifyType = config.DenyModifyTypeOpenAI
-
-				// stop 策略：返回拒绝消息（默认行为）
-				openaiResponse := config.OpenAICompletionResponse{
-					Id:      uuid.New().String(),
-					Object:  "chat.completion",
-					Created: 123,
-					Model:   pluginCtx.OpenAIRequest.Model,
-					Choices: []config.OpenAICompletionChoice{
-						{
-							Index: 0,
-							Message: &config.OpenAIMessage{
-								Role:    "assistant",
-								Content: cfg.DenyMessage,
-							},
-						},
-					},
-					Usage: &config.OpenAIUsage{
-						PromptTokens:     0,
-						CompletionTokens: 0,
-						TotalTokens:      0,
-					},
-				}
-				openaiResponseJson, _ := json.Marshal(openaiResponse)
-				ctx.SetUserAttribute("deny_message", openaiResponseJson)
-
-				wlog.LogWithLine("[%s] processNonStreamResponse: OpenAI Response Denied (stop strategy), denied=%v", pluginName, denied)
-
-				return lib.DenyHandler(ctx, pluginCtx)
-			}
+			return denyNonStreamResponse(ctx, pluginCtx, modifyType)
 		}
 		if modified {
 			pluginCtx.IsModified = true
-			pluginCtx.ResponseDenyModifyType = config.DenyModifyTypeOpenAI
+			pluginCtx.ResponseDenyModifyType = modifyType
 
-			proxywasm.ReplaceHttpResponseBody(body)
+			proxywasm.ReplaceHttpResponseBody(newBody)
 		}
 	}
 
-	// // 再处理 Raw 响应体（如果启用）
-	// if pluginCtx.Config.DenyRaw {
-	// 	wlog.LogWithLine("[%s] processNonStreamResponse: processing Raw response", pluginName)
-	// 	action := lib.ProcessRawResponse(ctx, pluginCtx, bodyStr)
-	// 	if action != types.ActionContinue {
-	// 		wlog.LogWithLine("[%s] processNonStreamResponse: Raw Response Denied, action=%v", pluginName, action)
-	// 		return action
-	// 	}
-	// 	wlog.LogWithLine("[%s] processNonStreamResponse: Raw response processed, continuing", pluginName)
-	// }
-
 	// wlog.LogWithLine("[%s] processNonStreamResponse: all checks passed, returning ActionContinue", pluginName)
 	return types.ActionContinue
 }
@@ -553,57 +828,100 @@ func onHttpStreamingResponseBody(ctx wrapper.HttpContext, cfg config.AiDataMaski
 	pluginCtx.Step = config.StepStreamRespBody
 	// wlog.LogWithLine("[%s] Process Step: %s", pluginName, pluginCtx.Step.String())
 
+	// 响应头阶段已移除 content-encoding，输出解压后的内容
+	if pluginCtx.RespContentEncoding != "" {
+		if pluginCtx.StreamDecodeFailed {
+			return nil
+		}
+		decoded, err := lib.DecodeStreamChunk(pluginCtx, chunk, isLastChunk)
+		if err != nil {
+			// 不支持的编码或解压失败，后续内容无法检查，以拒绝事件结束流
+			wlog.LogWithLine("[%s] onHttpStreamingResponseBody: failed to decode %s chunk: %v", pluginName, pluginCtx.RespContentEncoding, err)
+			pluginCtx.StreamDecodeFailed = true
+			return denyUndecodableStream(ctx, pluginCtx)
+		}
+		chunk = decoded
+	}
+
+	if !pluginCtx.RespIsNDJSON {
+		return processStreamingResponseBody(ctx, pluginCtx, chunk, isLastChunk)
+	}
+	// NDJSON 按行转换为 SSE 事件，与 SSE 共用流式处理逻辑，处理结果再转换回 NDJSON
+	return lib.SSEToNDJSON(processStreamingResponseBody(ctx, pluginCtx, lib.NDJSONToSSE(pluginCtx, chunk, isLastChunk), isLastChunk))
+}
+
+// denyUndecodableStream 以拒绝事件结束无法解压的流式响应，流已被拦截时不再输出
+func denyUndecodableStream(ctx wrapper.HttpContext, pluginCtx *config.PluginContext) []byte {
+	if pluginCtx.StreamDenied {
+		return nil
+	}
+	pluginCtx.DenyCategory = config.DenyCategoryEncoding
+	markResponseDenied(ctx, pluginCtx, lib.ResponseModifyType(pluginCtx), "stop")
+	events := lib.DenyStream(pluginCtx)
+	if pluginCtx.RespIsNDJSON {
+		return lib.SSEToNDJSON(events)
+	}
+	return events
+}
+
+// processStreamingResponseBody 按拒绝策略处理 SSE 格式的流式响应 chunk
+func processStreamingResponseBody(ctx wrapper.HttpContext, pluginCtx *config.PluginContext, chunk []byte, isLastChunk bool) []byte {
 	// 根据拒绝策略处理
 	denyPlot := pluginCtx.Config.ResponseDenyPlot.Plot
 	if denyPlot == "" {
 		denyPlot = "stop" // 默认值
 	}
 
-	if denyPlot == "replace" && pluginCtx.Config.DenyOpenAI && pluginCtx.OpenAIRequest != nil {
-		if pluginCtx.Config.DenyOpenAI && pluginCtx.OpenAIRequest != nil {
-
-			processedChunk := lib.ProcessOpenAIStreamReplaceResponse(ctx, pluginCtx, chunk, isLastChunk)
-			wlog.LogWithLine("[%s] onHttpStreamingResponseBody: processing OpenAI response, chunk:%s, processedChunk:%s",
-				pluginName, string(chunk), string(processedChunk))
-			return processedChunk
-		}
+	// Anthropic、Responses、Gemini 请求在请求阶段已按协议识别，与 OpenAI 共用流式处理逻辑；
+	// 未识别协议时按 response_jsonpath 或 deny_raw 检查各事件
+	modifyType := lib.ResponseModifyType(pluginCtx)
+	streamEnabled := lib.ResponseInspected(pluginCtx)
+	if denyPlot == "replace" && streamEnabled && pluginCtx.OpenAIRequest != nil {
+		processedChunk := lib.ProcessOpenAIStreamReplaceResponse(ctx, pluginCtx, chunk, isLastChunk)
+		wlog.LogWithLine("[%s] onHttpStreamingResponseBody: processing OpenAI response, chunk:%s, processedChunk:%s",
+			pluginName, string(chunk), string(processedChunk))
+		// 还原请求阶段脱敏的数据
+		return lib.RestoreStreamResponse(pluginCtx, processedChunk, isLastChunk)
 	}
 
 	if denyPlot == "stop" {
 		// 如果已经检测到敏感词并拒绝，后续的chunk直接返回 [DONE] 或空，不再处理
 		if pluginCtx.StreamDenied {
 			// wlog.LogWithLine("[%s] onHttpStreamingResponseBody: stream already denied, returning [DONE]", pluginName)
-			// 如果是最后一个chunk，返回 [DONE]，否则返回空（丢弃后续chunk）
-			if isLastChunk {
-				// return []byte("data: [DONE]\n\n")
-				return nil
-			}
-			return nil
+			// 丢弃后续chunk，只转发上游的 usage，收到流结束标记时返回 [DONE]
+			return lib.DeniedStreamTail(pluginCtx, chunk, isLastChunk)
 		}
 
 		// 先处理 OpenAI JSON 响应（如果启用）,并且请求阶段是openai格式
-		if pluginCtx.Config.DenyOpenAI && pluginCtx.OpenAIRequest != nil {
+		if streamEnabled && pluginCtx.OpenAIRequest != nil {
 
 			processedChunk, denied := lib.ProcessOpenAIStreamDenyResponse(ctx, pluginCtx, chunk, isLastChunk)
 			if denied {
 				// 检测到敏感词，标记为拒绝并返回截断的响应
 				pluginCtx.IsDeny = true
 				pluginCtx.IsResponseDeny = true
-				pluginCtx.ResponseDenyModifyType = config.DenyModifyTypeOpenAI
+				pluginCtx.ResponseDenyModifyType = modifyType
+				// 响应头已发出，命中分类仅记录到用户属性
+				setMaskingAttributes(ctx, pluginCtx, pluginCtx.ResponseDenyModifyType)
 				// 返回截断的响应（包含拒绝消息和 [DONE]）
-				if processedChunk != nil {
+				if processedChunk != nil && pluginCtx.StreamDenied {
 					wlog.LogWithLine("[%s] onHttpStreamingResponseBody: processing OpenAI response,  processedChunk=%s", pluginName, string(processedChunk))
 					return processedChunk
 				}
 				// // 如果没有返回chunk，返回 [DONE] 结束流
 				// return []byte("data: [DONE]\n\n")
 			}
-			// 没有 deny，返回处理后的 chunk（可能是原样或修改后的）
+			// 没有 deny 或只有部分 choice 被拒绝，还原请求阶段脱敏的数据后返回处理后的 chunk（可能是原样或修改后的）
+			processedChunk = lib.RestoreStreamResponse(pluginCtx, processedChunk, isLastChunk)
 			if processedChunk != nil {
 				wlog.LogWithLine("[%s] onHttpStreamingResponseBody: processing OpenAI response, processedChunk=%s", pluginName, string(processedChunk))
 				return processedChunk
 			}
 		}
 	}
+	// holdback 模式本次没有可以输出的内容时返回空 body，不插入占位注释
+	if pluginCtx.Config.StreamHoldback {
+		return []byte{}
+	}
 	return []byte(": HIGRESS AI DATA PROCESSING \n\n")
 }